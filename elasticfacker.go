@@ -5,7 +5,9 @@ import (
 	"github.com/gorilla/mux"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -17,7 +19,15 @@ func NewInMemoryElasticsearch() *InMemoryElasticsearch {
 	return &InMemoryElasticsearch{
 		indicesAlias:     make(map[string]map[string]interface{}),
 		indicesDocuments: make(map[string][]Document),
+		indicesSeqNo:     make(map[string]int64),
+		indicesMappings:  make(map[string]*fieldMapping),
+		indicesSettings:  make(map[string]map[string]interface{}),
+		indicesAnalysis:  make(map[string]*indexAnalysis),
+		indicesInverted:  make(map[string]*invertedIndex),
 		aliases:          make(map[string]interface{}),
+		pointsInTime:     make(map[string]*searchContext),
+		scrollContexts:   make(map[string]*scrollContext),
+		storedScripts:    make(map[string]*storedScript),
 	}
 }
 
@@ -25,13 +35,31 @@ func (es *InMemoryElasticsearch) SetMockMethods(mock *MockMethods) {
 	es.mock = mock
 }
 
+// Start binds the address before returning, so requests sent right after it are not refused.
 func (es *InMemoryElasticsearch) Start(address string) {
-	go es.startServer(address)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Could not start server: %s\n", err)
+	}
+
+	go es.startServer(listener)
 }
 
-func (es *InMemoryElasticsearch) startServer(address string) {
+func (es *InMemoryElasticsearch) startServer(listener net.Listener) {
 	r := mux.NewRouter()
+	r.Use(es.serializeRequests)
 	r.HandleFunc("/", es.handleRoot).Methods("GET")
+	r.HandleFunc("/_pit", es.handleClosePointInTime).Methods("DELETE")                               //esapi.ClosePointInTimeRequest
+	r.HandleFunc("/_search", es.handleSearch).Methods("GET", "POST")                                 //esapi.SearchRequest
+	r.HandleFunc("/_search/scroll", es.handleScroll).Methods("GET", "POST")                          //esapi.ScrollRequest
+	r.HandleFunc("/_search/scroll/{scrollId}", es.handleScroll).Methods("GET", "POST")               //esapi.ScrollRequest
+	r.HandleFunc("/_search/scroll", es.handleClearScroll).Methods("DELETE")                          //esapi.ClearScrollRequest
+	r.HandleFunc("/_search/scroll/{scrollId}", es.handleClearScroll).Methods("DELETE")               //esapi.ClearScrollRequest
+	r.HandleFunc("/_msearch", es.handleMultiSearch).Methods("GET", "POST")                           //esapi.MsearchRequest
+	r.HandleFunc("/_msearch/template", es.handleMultiSearchTemplate).Methods("GET", "POST")          //esapi.MsearchTemplateRequest
+	r.HandleFunc("/_mget", es.handleMultiGet).Methods("GET", "POST")                                 //esapi.MgetRequest
+	r.HandleFunc("/_bulk", es.handleBulk).Methods("POST", "PUT")                                     //esapi.BulkRequest
+	r.HandleFunc("/_mapping", es.handleGetMapping).Methods("GET")                                    //esapi.IndicesGetMappingRequest
 	r.HandleFunc("/{indexName}", es.handleIndicesExists).Methods("HEAD")                             //esapi.IndicesExistsRequest
 	r.HandleFunc("/{indexName}", es.handleIndicesCreate).Methods("PUT")                              //esapi.IndicesCreateRequest
 	r.HandleFunc("/_cat/indices/{indexNamePattern}", es.handleCatIndices).Methods("GET")             //esapi.CatIndicesRequest
@@ -41,18 +69,47 @@ func (es *InMemoryElasticsearch) startServer(address string) {
 	r.HandleFunc("/{indexName}/_aliases/{aliasName}", es.handleIndicesDeleteAlias).Methods("DELETE") //esapi.IndicesDeleteAliasRequest
 	r.HandleFunc("/{indexName}/_aliases/{aliasName}", es.handleIndicesPutAlias).Methods("PUT")       //esapi.IndicesPutAliasRequest
 
-	r.HandleFunc("/{indexName}/_search/template", es.handleSearchTemplate).Methods("POST") //esapi.SearchTemplateRequest
-	r.HandleFunc("/{indexName}/_search", es.handleSearch).Methods("POST")                  //esapi.SearchRequest
-	r.HandleFunc("/{indexName}/_count", es.handleCount).Methods("POST")                    //esapi.CountRequest
+	r.HandleFunc("/{indexName}/_bulk", es.handleBulk).Methods("POST", "PUT")                  //esapi.BulkRequest
+	r.HandleFunc("/{indexName}/_doc", es.handleIndex).Methods("POST")                         //esapi.IndexRequest
+	r.HandleFunc("/{indexName}/_doc/{documentId}", es.handleIndex).Methods("PUT", "POST")     //esapi.IndexRequest
+	r.HandleFunc("/{indexName}/_create/{documentId}", es.handleCreate).Methods("PUT", "POST") //esapi.CreateRequest
+	r.HandleFunc("/{indexName}/_doc/{documentId}", es.handleGet).Methods("GET")               //esapi.GetRequest
+	r.HandleFunc("/{indexName}/_doc/{documentId}", es.handleExists).Methods("HEAD")           //esapi.ExistsRequest
+	r.HandleFunc("/{indexName}/_source/{documentId}", es.handleGetSource).Methods("GET")      //esapi.GetSourceRequest
+	r.HandleFunc("/{indexName}/_source/{documentId}", es.handleExists).Methods("HEAD")        //esapi.ExistsSourceRequest
+	r.HandleFunc("/{indexName}/_update/{documentId}", es.handleUpdate).Methods("POST")        //esapi.UpdateRequest
+	r.HandleFunc("/{indexName}/_doc/{documentId}", es.handleDelete).Methods("DELETE")         //esapi.DeleteRequest
+	r.HandleFunc("/{indexName}/_mget", es.handleMultiGet).Methods("GET", "POST")              //esapi.MgetRequest
+
+	r.HandleFunc("/{indexName}/_mapping", es.handleGetMapping).Methods("GET")                     //esapi.IndicesGetMappingRequest
+	r.HandleFunc("/{indexName}/_mapping", es.handlePutMapping).Methods("PUT", "POST")             //esapi.IndicesPutMappingRequest
+	r.HandleFunc("/{indexName}/_mapping/field/{fields}", es.handleGetFieldMapping).Methods("GET") //esapi.IndicesGetFieldMappingRequest
+
+	r.HandleFunc("/_analyze", es.handleAnalyze).Methods("GET", "POST")             //esapi.IndicesAnalyzeRequest
+	r.HandleFunc("/{indexName}/_analyze", es.handleAnalyze).Methods("GET", "POST") //esapi.IndicesAnalyzeRequest
+
+	r.HandleFunc("/_scripts/{scriptId}", es.handlePutScript).Methods("PUT", "POST") //esapi.PutScriptRequest
+	r.HandleFunc("/_scripts/{scriptId}", es.handleGetScript).Methods("GET")         //esapi.GetScriptRequest
+	r.HandleFunc("/_scripts/{scriptId}", es.handleDeleteScript).Methods("DELETE")   //esapi.DeleteScriptRequest
+
+	r.HandleFunc("/_render/template", es.handleRenderSearchTemplate).Methods("GET", "POST")              //esapi.RenderSearchTemplateRequest
+	r.HandleFunc("/_render/template/{templateId}", es.handleRenderSearchTemplate).Methods("GET", "POST") //esapi.RenderSearchTemplateRequest
+
+	r.HandleFunc("/{indexName}/_search/template", es.handleSearchTemplate).Methods("GET", "POST")       //esapi.SearchTemplateRequest
+	r.HandleFunc("/{indexName}/_pit", es.handleOpenPointInTime).Methods("POST")                         //esapi.OpenPointInTimeRequest
+	r.HandleFunc("/{indexName}/_search", es.handleSearch).Methods("GET", "POST")                        //esapi.SearchRequest
+	r.HandleFunc("/{indexName}/_msearch", es.handleMultiSearch).Methods("GET", "POST")                  //esapi.MsearchRequest
+	r.HandleFunc("/{indexName}/_msearch/template", es.handleMultiSearchTemplate).Methods("GET", "POST") //esapi.MsearchTemplateRequest
+	r.HandleFunc("/{indexName}/_count", es.handleCount).Methods("GET", "POST")                          //esapi.CountRequest
 
 	es.server = &http.Server{
-		Addr:    address,
+		Addr:    listener.Addr().String(),
 		Handler: r,
 	}
 
 	log.Println("Starting the server on port 9200")
 
-	err := es.server.ListenAndServe()
+	err := es.server.Serve(listener)
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Could not start server: %s\n", err)
 	}
@@ -84,7 +141,56 @@ func (es *InMemoryElasticsearch) handleIndicesExists(w http.ResponseWriter, r *h
 
 func (es *InMemoryElasticsearch) handleIndicesCreate(w http.ResponseWriter, r *http.Request) {
 	indexName := mux.Vars(r)["indexName"]
-	response := es.CreateIndex(indexName)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.CreateIndexWithBody(indexName, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleGetMapping(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	response := es.GetMapping(indexName)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handlePutMapping(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.PutMapping(indexName, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleGetFieldMapping(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	fields := strings.Split(mux.Vars(r)["fields"], ",")
+	response := es.GetFieldMapping(indexName, fields)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.Analyze(indexName, body)
 	es.writeResponse(w, response)
 }
 
@@ -126,6 +232,149 @@ func (es *InMemoryElasticsearch) handleIndicesPutAlias(w http.ResponseWriter, r
 	es.writeResponse(w, response)
 }
 
+func (es *InMemoryElasticsearch) handleIndex(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	opType := r.URL.Query().Get("op_type")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.IndexDocument(indexName, documentId, opType, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleCreate(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.CreateDocument(indexName, documentId, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleGet(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	response := es.GetDocument(indexName, documentId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleExists(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	response := es.DocumentExists(indexName, documentId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleMultiGet(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.MultiGet(indexName, r.URL.Query(), body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleGetSource(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	response := es.GetDocumentSource(indexName, documentId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleDelete(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	response := es.DeleteDocument(indexName, documentId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	documentId := mux.Vars(r)["documentId"]
+	retryOnConflict := r.URL.Query().Get("retry_on_conflict")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.UpdateDocument(indexName, documentId, retryOnConflict, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleBulk(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.Bulk(indexName, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handlePutScript(w http.ResponseWriter, r *http.Request) {
+	scriptId := mux.Vars(r)["scriptId"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.PutScript(scriptId, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleGetScript(w http.ResponseWriter, r *http.Request) {
+	scriptId := mux.Vars(r)["scriptId"]
+	response := es.GetScript(scriptId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleDeleteScript(w http.ResponseWriter, r *http.Request) {
+	scriptId := mux.Vars(r)["scriptId"]
+	response := es.DeleteScript(scriptId)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleRenderSearchTemplate(w http.ResponseWriter, r *http.Request) {
+	templateId := mux.Vars(r)["templateId"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.RenderSearchTemplate(templateId, body)
+	es.writeResponse(w, response)
+}
+
 func (es *InMemoryElasticsearch) handleSearchTemplate(w http.ResponseWriter, r *http.Request) {
 	indexName := mux.Vars(r)["indexName"]
 	body, err := io.ReadAll(r.Body)
@@ -150,7 +399,89 @@ func (es *InMemoryElasticsearch) handleSearch(w http.ResponseWriter, r *http.Req
 	}
 	defer r.Body.Close()
 
-	response := es.Search(indexName, body)
+	response := es.SearchWithParams(indexName, r.URL.Query(), body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleMultiSearch(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.MultiSearch(indexName, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleMultiSearchTemplate(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.MultiSearchTemplate(indexName, body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleOpenPointInTime(w http.ResponseWriter, r *http.Request) {
+	indexName := mux.Vars(r)["indexName"]
+	keepAlive := r.URL.Query().Get("keep_alive")
+	response := es.OpenPointInTime(indexName, keepAlive)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleClosePointInTime(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.ClosePointInTime(body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleScroll(w http.ResponseWriter, r *http.Request) {
+	scrollId := mux.Vars(r)["scrollId"]
+	if scrollId == "" {
+		scrollId = r.URL.Query().Get("scroll_id")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.Scroll(scrollId, r.URL.Query().Get("scroll"), body)
+	es.writeResponse(w, response)
+}
+
+func (es *InMemoryElasticsearch) handleClearScroll(w http.ResponseWriter, r *http.Request) {
+	var scrollIds []string
+	if scrollId := mux.Vars(r)["scrollId"]; scrollId != "" {
+		scrollIds = strings.Split(scrollId, ",")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	response := es.ClearScroll(scrollIds, body)
 	es.writeResponse(w, response)
 }
 
@@ -168,8 +499,45 @@ func (es *InMemoryElasticsearch) handleCount(w http.ResponseWriter, r *http.Requ
 	es.writeResponse(w, response)
 }
 
+// serializeRequests handles one request at a time, clients like esutil.BulkIndexer send requests
+// from several workers concurrently and the in memory indices are plain maps.
+func (es *InMemoryElasticsearch) serializeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		es.mutex.Lock()
+		defer es.mutex.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (es *InMemoryElasticsearch) writeResponse(w http.ResponseWriter, response *MockMethods) {
 	w.Header().Set(HeaderXElasticProduct, "Elasticsearch")
 	w.WriteHeader(response.StatusCode)
 	_, _ = w.Write([]byte(response.BodyAsString))
 }
+
+func newErrorResponse(statusCode int, errorType string, reason string, index string) *MockMethods {
+	errorResponse := ElasticSearchErrorResponseFake{
+		Error: ElasticSearchErrorFake{
+			RootCause: []ElasticSearchErrorCauseFake{
+				{
+					Type:   errorType,
+					Reason: reason,
+					Index:  index,
+				},
+			},
+			Type:   errorType,
+			Reason: reason,
+			Index:  index,
+		},
+		Status: statusCode,
+	}
+
+	jsonData, _ := json.Marshal(errorResponse)
+
+	return &MockMethods{
+		StatusCode:   statusCode,
+		Status:       http.StatusText(statusCode),
+		BodyAsString: string(jsonData),
+	}
+}
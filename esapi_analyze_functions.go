@@ -0,0 +1,135 @@
+package elasticfacker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Analyze returns the tokens the text of the body is analysed into, with the analyzer, the field or the
+// tokenizer, filters and char filters of the body. Without an index only the built in analyzers are known.
+func (es *InMemoryElasticsearch) Analyze(indexName string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	var request ElasticSearchAnalyzeRequestFake
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return newErrorResponse(400, "x_content_parse_exception", err.Error(), indexName)
+	}
+
+	texts := stringList(request.Text)
+	if len(texts) == 0 {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: text is missing;", "")
+	}
+
+	var analysis *indexAnalysis
+	if indexName != "" {
+		indices, errorResponse := es.resolveIndices(indexName)
+		if errorResponse != nil {
+			return errorResponse
+		}
+		if len(indices) != 1 {
+			return newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("analyze requires a single index, [%s] resolves to [%d]", indexName, len(indices)), "")
+		}
+		indexName = indices[0]
+		analysis = es.indicesAnalysis[indexName]
+	}
+
+	textAnalyzer, err := es.requestAnalyzer(indexName, analysis, request)
+	if err != nil {
+		return queryErrorResponse(err, indexName)
+	}
+
+	// The texts are analysed as the values of a multi-valued field.
+	joinedText := strings.Join(texts, " ")
+	tokens := make([]ElasticSearchAnalyzeTokenFake, 0)
+	for _, token := range textAnalyzer.analyzeValues(texts) {
+		tokens = append(tokens, ElasticSearchAnalyzeTokenFake{
+			Token:       token.term,
+			StartOffset: characterOffset(joinedText, token.startOffset),
+			EndOffset:   characterOffset(joinedText, token.endOffset),
+			Type:        token.tokenType,
+			Position:    token.position,
+		})
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchAnalyzeResponseFake{Tokens: tokens})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// requestAnalyzer returns the analyzer an analyze request asks for, in the order Elasticsearch looks for
+// it: a tokenizer with filters, an analyzer by name, the analyzer of a field, or the default analyzer.
+func (es *InMemoryElasticsearch) requestAnalyzer(indexName string, analysis *indexAnalysis, request ElasticSearchAnalyzeRequestFake) (*analyzer, error) {
+	switch {
+	case request.Tokenizer != nil || len(request.Filter) > 0 || len(request.CharFilter) > 0:
+		tokenizerComponent := request.Tokenizer
+		if tokenizerComponent == nil {
+			tokenizerComponent = "keyword"
+		}
+		tokenizer, err := analysis.tokenizer(tokenizerComponent)
+		if err != nil {
+			return nil, err
+		}
+
+		built := &analyzer{tokenizer: tokenizer}
+		for _, component := range request.CharFilter {
+			charFilter, err := analysis.charFilter(component)
+			if err != nil {
+				return nil, err
+			}
+			built.charFilters = append(built.charFilters, charFilter)
+		}
+		for _, component := range request.Filter {
+			filter, err := analysis.tokenFilter(component, built)
+			if err != nil {
+				return nil, err
+			}
+			built.filters = append(built.filters, filter)
+		}
+		return built, nil
+	case request.Analyzer != "":
+		requested, err := analysis.analyzer(request.Analyzer)
+		if err != nil && analysis == nil {
+			return nil, newAnalysisError("failed to find global analyzer [%s]", request.Analyzer)
+		}
+		return requested, err
+	case request.Field != "":
+		if analysis == nil {
+			return nil, newAnalysisError("analysis of a field requires an index")
+		}
+		field := es.mappedField(indexName, request.Field)
+		if field.analyzer == nil {
+			return builtInAnalyzer("keyword", nil), nil
+		}
+		return field.analyzer, nil
+	}
+
+	return analysis.defaultAnalyzer(false), nil
+}
+
+// characterOffset returns the offset, in UTF-16 code units like the ones of Elasticsearch, of a byte offset
+// of the text.
+func characterOffset(text string, byteOffset int) int {
+	if byteOffset > len(text) {
+		byteOffset = len(text)
+	}
+
+	offset := 0
+	for _, r := range text[:byteOffset] {
+		offset++
+		if r >= 0x10000 && r != utf8.RuneError {
+			offset++
+		}
+	}
+
+	return offset
+}
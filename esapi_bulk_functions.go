@@ -0,0 +1,168 @@
+package elasticfacker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	BulkActionIndex  = "index"
+	BulkActionCreate = "create"
+	BulkActionUpdate = "update"
+	BulkActionDelete = "delete"
+)
+
+type bulkOperation struct {
+	action     string
+	indexName  string
+	documentId string
+	source     []byte
+}
+
+func (es *InMemoryElasticsearch) Bulk(indexName string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	operations, errorResponse := parseBulkOperations(indexName, body)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	hasErrors := false
+	items := make([]map[string]ElasticSearchBulkResponseItemFake, 0, len(operations))
+	for _, operation := range operations {
+		var response *MockMethods
+		switch operation.action {
+		case BulkActionIndex:
+			response = es.IndexDocument(operation.indexName, operation.documentId, OpTypeIndex, operation.source)
+		case BulkActionCreate:
+			response = es.CreateDocument(operation.indexName, operation.documentId, operation.source)
+		case BulkActionUpdate:
+			response = es.updateDocument(operation.indexName, operation.documentId, operation.source)
+		case BulkActionDelete:
+			response = es.DeleteDocument(operation.indexName, operation.documentId)
+		}
+
+		item := bulkResponseItem(operation, response)
+		if item.Error != nil {
+			hasErrors = true
+		}
+		items = append(items, map[string]ElasticSearchBulkResponseItemFake{operation.action: item})
+	}
+
+	bulkResponse := ElasticSearchBulkResponseFake{
+		Took:   rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20),
+		Errors: hasErrors,
+		Items:  items,
+	}
+
+	jsonData, _ := json.Marshal(bulkResponse)
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// parseBulkOperations reads the whole NDJSON body before anything is applied, so a malformed request
+// does not leave the index half written, as Elasticsearch does.
+func parseBulkOperations(indexName string, body []byte) ([]bulkOperation, *MockMethods) {
+	lines := strings.Split(string(body), "\n")
+	operations := make([]bulkOperation, 0)
+
+	for lineNumber := 0; lineNumber < len(lines); lineNumber++ {
+		line := strings.TrimSpace(lines[lineNumber])
+		if line == "" {
+			continue
+		}
+
+		var actionLine map[string]ElasticSearchBulkActionFake
+		err := json.Unmarshal([]byte(line), &actionLine)
+		if err != nil || len(actionLine) != 1 {
+			return nil, newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("Malformed action/metadata line [%d], expected START_OBJECT", lineNumber+1), "")
+		}
+
+		var operation bulkOperation
+		for action, metadata := range actionLine {
+			operation = bulkOperation{
+				action:     action,
+				indexName:  metadata.Index,
+				documentId: metadata.Id,
+			}
+		}
+
+		switch operation.action {
+		case BulkActionIndex, BulkActionCreate, BulkActionUpdate:
+			lineNumber++
+			if lineNumber >= len(lines) || strings.TrimSpace(lines[lineNumber]) == "" {
+				return nil, newErrorResponse(400, "illegal_argument_exception",
+					fmt.Sprintf("Malformed action/metadata line [%d], expected a source line after it", lineNumber), "")
+			}
+			operation.source = []byte(lines[lineNumber])
+		case BulkActionDelete:
+		default:
+			return nil, newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("Malformed action/metadata line [%d], expected field [create], [delete], [index] or [update] but found [%s]",
+					lineNumber+1, operation.action), "")
+		}
+
+		if operation.indexName == "" {
+			operation.indexName = indexName
+		}
+		if operation.indexName == "" {
+			return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: index is missing;", "")
+		}
+		if operation.documentId == "" && (operation.action == BulkActionUpdate || operation.action == BulkActionDelete) {
+			return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: id is missing;", "")
+		}
+
+		operations = append(operations, operation)
+	}
+
+	if len(operations) == 0 {
+		return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: no requests added;", "")
+	}
+
+	return operations, nil
+}
+
+func bulkResponseItem(operation bulkOperation, response *MockMethods) ElasticSearchBulkResponseItemFake {
+	var errorResponse ElasticSearchErrorResponseFake
+	_ = json.Unmarshal([]byte(response.BodyAsString), &errorResponse)
+	if errorResponse.Error.Type != "" {
+		// Failed actions without an id, whose id was never generated, have a null id.
+		var documentId *string
+		if operation.documentId != "" {
+			documentId = &operation.documentId
+		}
+		return ElasticSearchBulkResponseItemFake{
+			Index:  operation.indexName,
+			Id:     documentId,
+			Status: response.StatusCode,
+			Error: &ElasticSearchErrorCauseFake{
+				Type:   errorResponse.Error.Type,
+				Reason: errorResponse.Error.Reason,
+				Index:  errorResponse.Error.Index,
+			},
+		}
+	}
+
+	var document ElasticSearchDocumentResponseFake
+	_ = json.Unmarshal([]byte(response.BodyAsString), &document)
+
+	return ElasticSearchBulkResponseItemFake{
+		Index:       document.Index,
+		Id:          &document.Id,
+		Version:     document.Version,
+		Result:      document.Result,
+		Shards:      &document.Shards,
+		SeqNo:       &document.SeqNo,
+		PrimaryTerm: document.PrimaryTerm,
+		Status:      response.StatusCode,
+	}
+}
@@ -0,0 +1,361 @@
+package elasticfacker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+const (
+	OpTypeIndex  = "index"
+	OpTypeCreate = "create"
+)
+
+func (es *InMemoryElasticsearch) IndexDocument(indexName string, documentId string, opType string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if opType == "" {
+		opType = OpTypeIndex
+	}
+	if opType != OpTypeIndex && opType != OpTypeCreate {
+		return newErrorResponse(400, "illegal_argument_exception",
+			fmt.Sprintf("opType must be 'create' or 'index', found: [%s]", opType), "")
+	}
+
+	var source map[string]interface{}
+	err := json.Unmarshal(body, &source)
+	if err != nil || source == nil {
+		return newErrorResponse(400, "mapper_parsing_exception", "failed to parse", indexName)
+	}
+
+	_, exists := es.indicesAlias[indexName]
+	if !exists {
+		es.CreateIndex(indexName)
+	}
+
+	if documentId == "" {
+		documentId = generateDocumentId()
+	}
+
+	position := es.findDocument(indexName, documentId)
+	if position >= 0 && opType == OpTypeCreate {
+		current := es.indicesDocuments[indexName][position]
+		return newErrorResponse(409, "version_conflict_engine_exception",
+			fmt.Sprintf("[%s]: version conflict, document already exists (current version [%d])", documentId, current.Version),
+			indexName)
+	}
+
+	mapping, err := es.indicesMappings[indexName].mapDocument(documentId, body)
+	if err != nil {
+		return queryErrorResponse(err, indexName)
+	}
+	ignored, err := parseDocumentFields(mapping, es.indicesSettings[indexName], es.indicesAnalysis[indexName], Document{Id: documentId, Source: source})
+	if err != nil {
+		return queryErrorResponse(err, indexName)
+	}
+	es.indicesMappings[indexName] = mapping
+
+	document := Document{
+		Index:       indexName,
+		Id:          documentId,
+		Source:      source,
+		Ignored:     ignored,
+		Version:     1,
+		SeqNo:       es.nextSeqNo(indexName),
+		PrimaryTerm: 1,
+	}
+
+	es.indicesInverted[indexName].add(documentId,
+		documentTokens(mapping, es.indicesSettings[indexName], es.indicesAnalysis[indexName], document))
+
+	if position >= 0 {
+		document.Version = es.indicesDocuments[indexName][position].Version + 1
+		es.indicesDocuments[indexName][position] = document
+
+		return documentResponse(document, "updated", 200)
+	}
+
+	es.indicesDocuments[indexName] = append(es.indicesDocuments[indexName], document)
+
+	return documentResponse(document, "created", 201)
+}
+
+func (es *InMemoryElasticsearch) CreateDocument(indexName string, documentId string, body []byte) *MockMethods {
+	return es.IndexDocument(indexName, documentId, OpTypeCreate, body)
+}
+
+func (es *InMemoryElasticsearch) findDocument(indexName string, documentId string) int {
+	for position, document := range es.indicesDocuments[indexName] {
+		if document.Id == documentId {
+			return position
+		}
+	}
+
+	return -1
+}
+
+func (es *InMemoryElasticsearch) nextSeqNo(indexName string) int64 {
+	seqNo := es.indicesSeqNo[indexName]
+	es.indicesSeqNo[indexName] = seqNo + 1
+
+	return seqNo
+}
+
+// generateDocumentId returns a random 20 characters id, the same length as the ones generated by Elasticsearch.
+func generateDocumentId() string {
+	randomBytes := make([]byte, 15)
+	_, _ = rand.Read(randomBytes)
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
+
+func documentResponse(document Document, result string, statusCode int) *MockMethods {
+	response := ElasticSearchDocumentResponseFake{
+		Index:   document.Index,
+		Id:      document.Id,
+		Version: document.Version,
+		Result:  result,
+		Shards: ElasticSearchResponseFakeShards{
+			Total:      1,
+			Successful: 1,
+			Skipped:    0,
+			Failed:     0,
+		},
+		SeqNo:       document.SeqNo,
+		PrimaryTerm: document.PrimaryTerm,
+	}
+
+	jsonData, _ := json.Marshal(response)
+
+	return &MockMethods{
+		StatusCode:   statusCode,
+		Status:       http.StatusText(statusCode),
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) GetDocument(indexName string, documentId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	_, exists := es.indicesDocuments[indexName]
+	if !exists {
+		return indexNotFoundResponse(indexName)
+	}
+
+	position := es.findDocument(indexName, documentId)
+	if position < 0 {
+		jsonData, _ := json.Marshal(ElasticSearchGetResponseFake{
+			Index: indexName,
+			Id:    documentId,
+			Found: false,
+		})
+
+		return &MockMethods{
+			StatusCode:   404,
+			Status:       "Not Found",
+			BodyAsString: string(jsonData),
+		}
+	}
+
+	document := es.indicesDocuments[indexName][position]
+	jsonData, _ := json.Marshal(ElasticSearchGetResponseFake{
+		Index:       document.Index,
+		Id:          document.Id,
+		Version:     document.Version,
+		SeqNo:       &document.SeqNo,
+		PrimaryTerm: document.PrimaryTerm,
+		Found:       true,
+		Source:      document.Source,
+	})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) DocumentExists(indexName string, documentId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if es.findDocument(indexName, documentId) < 0 {
+		return &MockMethods{
+			StatusCode: 404,
+			Status:     "Not Found",
+		}
+	}
+
+	return &MockMethods{
+		StatusCode: 200,
+		Status:     "OK",
+	}
+}
+
+func (es *InMemoryElasticsearch) GetDocumentSource(indexName string, documentId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	_, exists := es.indicesDocuments[indexName]
+	if !exists {
+		return indexNotFoundResponse(indexName)
+	}
+
+	position := es.findDocument(indexName, documentId)
+	if position < 0 {
+		return newErrorResponse(404, "resource_not_found_exception",
+			fmt.Sprintf("Document not found [%s]/[%s]", indexName, documentId), "")
+	}
+
+	jsonData, _ := json.Marshal(es.indicesDocuments[indexName][position].Source)
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) DeleteDocument(indexName string, documentId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	_, exists := es.indicesDocuments[indexName]
+	if !exists {
+		return indexNotFoundResponse(indexName)
+	}
+
+	position := es.findDocument(indexName, documentId)
+	if position < 0 {
+		document := Document{
+			Index:       indexName,
+			Id:          documentId,
+			Version:     1,
+			SeqNo:       es.nextSeqNo(indexName),
+			PrimaryTerm: 1,
+		}
+
+		return documentResponse(document, "not_found", 404)
+	}
+
+	documents := es.indicesDocuments[indexName]
+	document := documents[position]
+	document.Version++
+	document.SeqNo = es.nextSeqNo(indexName)
+
+	es.indicesDocuments[indexName] = append(documents[:position], documents[position+1:]...)
+	es.indicesInverted[indexName].remove(documentId)
+
+	return documentResponse(document, "deleted", 200)
+}
+
+func indexNotFoundResponse(indexName string) *MockMethods {
+	return newErrorResponse(404, "index_not_found_exception", fmt.Sprintf("no such index [%s]", indexName), indexName)
+}
+
+func (es *InMemoryElasticsearch) UpdateDocument(indexName string, documentId string, retryOnConflict string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if retryOnConflict != "" {
+		retries, err := strconv.Atoi(retryOnConflict)
+		if err != nil || retries < 0 {
+			return newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("Failed to parse int parameter [retry_on_conflict] with value [%s]", retryOnConflict), "")
+		}
+	}
+
+	// Requests are applied one at a time, so the document can not change between reading and writing it
+	// and retry_on_conflict never needs to retry.
+	return es.updateDocument(indexName, documentId, body)
+}
+
+func (es *InMemoryElasticsearch) updateDocument(indexName string, documentId string, body []byte) *MockMethods {
+	var updateRequest ElasticSearchUpdateRequestFake
+	err := json.Unmarshal(body, &updateRequest)
+	if err != nil {
+		return newErrorResponse(400, "x_content_parse_exception", err.Error(), indexName)
+	}
+
+	// Scripted updates are not supported, so every update needs a partial document, with or without an upsert.
+	if updateRequest.Doc == nil {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: script or doc is missing;", "")
+	}
+
+	position := es.findDocument(indexName, documentId)
+	if position < 0 {
+		var upsert map[string]interface{}
+		if updateRequest.DocAsUpsert {
+			upsert = updateRequest.Doc
+		} else {
+			upsert = updateRequest.Upsert
+		}
+
+		if upsert == nil {
+			return newErrorResponse(404, "document_missing_exception",
+				fmt.Sprintf("[%s]: document missing", documentId), indexName)
+		}
+
+		jsonData, _ := json.Marshal(upsert)
+		return es.IndexDocument(indexName, documentId, OpTypeCreate, jsonData)
+	}
+
+	current := es.indicesDocuments[indexName][position]
+	source := mergeSource(current.Source, updateRequest.Doc)
+
+	detectNoop := updateRequest.DetectNoop == nil || *updateRequest.DetectNoop
+	if detectNoop && reflect.DeepEqual(source, current.Source) {
+		response := ElasticSearchDocumentResponseFake{
+			Index:       current.Index,
+			Id:          current.Id,
+			Version:     current.Version,
+			Result:      "noop",
+			SeqNo:       current.SeqNo,
+			PrimaryTerm: current.PrimaryTerm,
+		}
+
+		jsonData, _ := json.Marshal(response)
+		return &MockMethods{
+			StatusCode:   200,
+			Status:       "OK",
+			BodyAsString: string(jsonData),
+		}
+	}
+
+	jsonData, _ := json.Marshal(source)
+
+	return es.IndexDocument(indexName, documentId, OpTypeIndex, jsonData)
+}
+
+// mergeSource returns a copy of source with changes deep merged into it, objects are merged key by key
+// and any other value is replaced.
+func mergeSource(source map[string]interface{}, changes map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(source))
+	for key, value := range source {
+		merged[key] = value
+	}
+
+	for key, change := range changes {
+		changeObject, changeIsObject := change.(map[string]interface{})
+		currentObject, currentIsObject := merged[key].(map[string]interface{})
+		if changeIsObject && currentIsObject {
+			merged[key] = mergeSource(currentObject, changeObject)
+		} else {
+			merged[key] = change
+		}
+	}
+
+	return merged
+}
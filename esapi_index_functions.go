@@ -1,7 +1,9 @@
 package elasticfacker
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"regexp"
 )
 
@@ -60,28 +62,75 @@ func (es *InMemoryElasticsearch) GetIndex(indexPattern string) *MockMethods {
 
 }
 
+// CreateIndex creates the index with a dynamic mapping and the default settings.
 func (es *InMemoryElasticsearch) CreateIndex(index string) *MockMethods {
+	return es.CreateIndexWithBody(index, nil)
+}
+
+// CreateIndexWithBody creates the index with the mappings, settings and aliases of the body, if any.
+func (es *InMemoryElasticsearch) CreateIndexWithBody(index string, body []byte) *MockMethods {
 	if es.mock != nil {
 		return es.mock
 	}
 
 	_, exists := es.indicesAlias[index]
-
-	var responseStatusCode int
-	var responseStatus string
 	if exists {
-		responseStatusCode = 409
-		responseStatus = "Conflict"
-	} else {
-		es.indicesAlias[index] = make(map[string]interface{})
-		es.indicesDocuments[index] = make([]Document, 0)
+		return &MockMethods{
+			StatusCode: 409,
+			Status:     "Conflict",
+		}
+	}
 
-		responseStatusCode = 200
-		responseStatus = "OK"
+	var request ElasticSearchCreateIndexRequestFake
+	if len(bytes.TrimSpace(body)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			return newErrorResponse(400, "parse_exception", fmt.Sprintf("Failed to parse content to map: %s", err.Error()), index)
+		}
+	}
+
+	mapping := newObjectMapping()
+	if request.Mappings != nil {
+		var err error
+		mapping, err = parseMapping(request.Mappings)
+		if err != nil {
+			return queryErrorResponse(err, index)
+		}
+	}
+
+	settings := normalizeIndexSettings(request.Settings)
+	analysis, err := newIndexAnalysis(settings)
+	if err != nil {
+		return queryErrorResponse(err, index)
+	}
+	err = mapping.validateAnalyzers(analysis)
+	if err != nil {
+		return queryErrorResponse(err, index)
+	}
+
+	es.indicesAlias[index] = make(map[string]interface{})
+	es.indicesDocuments[index] = make([]Document, 0)
+	es.indicesSeqNo[index] = 0
+	es.indicesMappings[index] = mapping
+	es.indicesSettings[index] = settings
+	es.indicesAnalysis[index] = analysis
+	es.indicesInverted[index] = newInvertedIndex()
+	for aliasName := range request.Aliases {
+		es.PutAlias(index, aliasName)
 	}
+
+	jsonData, _ := json.Marshal(ElasticSearchCreateIndexResponseFake{
+		Acknowledged:       true,
+		ShardsAcknowledged: true,
+		Index:              index,
+	})
+
 	return &MockMethods{
-		StatusCode: responseStatusCode,
-		Status:     responseStatus,
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
 	}
 }
 
@@ -100,6 +149,11 @@ func (es *InMemoryElasticsearch) DeleteIndex(index string) *MockMethods {
 
 	delete(es.indicesAlias, index)
 	delete(es.indicesDocuments, index)
+	delete(es.indicesSeqNo, index)
+	delete(es.indicesMappings, index)
+	delete(es.indicesSettings, index)
+	delete(es.indicesAnalysis, index)
+	delete(es.indicesInverted, index)
 
 	return &MockMethods{
 		StatusCode: 200,
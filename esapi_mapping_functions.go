@@ -0,0 +1,221 @@
+package elasticfacker
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+func (es *InMemoryElasticsearch) GetMapping(indexName string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	indices, errorResponse := es.resolveIndices(indexName)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	mappings := make(map[string]ElasticSearchMappingsFake, len(indices))
+	for _, index := range indices {
+		mappings[index] = ElasticSearchMappingsFake{Mappings: es.indicesMappings[index].toJSON()}
+	}
+
+	jsonData, _ := json.Marshal(mappings)
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// PutMapping merges the mapping of the body into the one of the indices, an update that is not compatible
+// with the current mapping of any of them changes none.
+func (es *InMemoryElasticsearch) PutMapping(indexName string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	indices, errorResponse := es.resolveIndices(indexName)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	var definition map[string]interface{}
+	err := json.Unmarshal(body, &definition)
+	if err != nil || definition == nil {
+		return newErrorResponse(400, "parse_exception", "request body is required", "")
+	}
+
+	update, err := parseMapping(definition)
+	if err != nil {
+		return queryErrorResponse(err, "")
+	}
+
+	merged := make(map[string]*fieldMapping, len(indices))
+	for _, index := range indices {
+		mapping := es.indicesMappings[index].clone()
+		err := mapping.merge("", update.clone())
+		if err == nil {
+			err = mapping.validateAnalyzers(es.indicesAnalysis[index])
+		}
+		if err != nil {
+			return queryErrorResponse(err, "")
+		}
+		merged[index] = mapping
+	}
+	for index, mapping := range merged {
+		es.indicesMappings[index] = mapping
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchAcknowledgedResponseFake{Acknowledged: true})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// GetFieldMapping returns the mapping of the fields matching the names, which can hold wildcards.
+func (es *InMemoryElasticsearch) GetFieldMapping(indexName string, fields []string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	indices, errorResponse := es.resolveIndices(indexName)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	response := make(map[string]ElasticSearchMappingsFake, len(indices))
+	for _, index := range indices {
+		mapping := es.indicesMappings[index]
+
+		fieldMappings := make(map[string]interface{})
+		for _, fullName := range mapping.fieldNames("") {
+			if !matchesAnyPattern(fields, fullName) {
+				continue
+			}
+
+			field, _ := mapping.lookupField(fullName)
+			leafName := fullName[strings.LastIndex(fullName, ".")+1:]
+			leaf := field.toJSON()
+			// The properties of objects are listed as fields of their own.
+			delete(leaf, "properties")
+			fieldMappings[fullName] = ElasticSearchFieldMappingFake{
+				FullName: fullName,
+				Mapping:  map[string]interface{}{leafName: leaf},
+			}
+		}
+
+		response[index] = ElasticSearchMappingsFake{Mappings: fieldMappings}
+	}
+
+	jsonData, _ := json.Marshal(response)
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// resolveIndices returns the indices of a comma separated list of index names, aliases and wildcard
+// patterns, every index when it is empty, _all or *.
+func (es *InMemoryElasticsearch) resolveIndices(indexName string) ([]string, *MockMethods) {
+	if indexName == "" || indexName == "_all" {
+		indexName = "*"
+	}
+
+	resolved := make(map[string]bool)
+	for _, expression := range strings.Split(indexName, ",") {
+		if !strings.Contains(expression, "*") {
+			if _, exists := es.indicesAlias[expression]; exists {
+				resolved[expression] = true
+				continue
+			}
+
+			aliased := false
+			for index, aliases := range es.indicesAlias {
+				if _, exists := aliases[expression]; exists {
+					resolved[index] = true
+					aliased = true
+				}
+			}
+			if !aliased {
+				return nil, newErrorResponse(404, "index_not_found_exception",
+					fmt.Sprintf("no such index [%s]", expression), expression)
+			}
+			continue
+		}
+
+		for index := range es.indicesAlias {
+			if matched, _ := path.Match(expression, index); matched {
+				resolved[index] = true
+			}
+		}
+	}
+
+	indices := make([]string, 0, len(resolved))
+	for index := range resolved {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	return indices, nil
+}
+
+func matchesAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeIndexSettings returns the settings of an index without their index prefix, whether they are
+// given as {"index": {...}}, as "index.name" or as plain names.
+func normalizeIndexSettings(settings map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+	for name, value := range settings {
+		if name == "index" {
+			if indexSettings, isObject := value.(map[string]interface{}); isObject {
+				for indexName, indexValue := range normalizeIndexSettings(indexSettings) {
+					normalized[indexName] = indexValue
+				}
+				continue
+			}
+		}
+
+		normalized[strings.TrimPrefix(name, "index.")] = value
+	}
+
+	return normalized
+}
+
+// indexSetting returns a setting of an index by its dotted name, whether it was given nested, like
+// {"mapping": {"coerce": false}}, or flat, like {"mapping.coerce": false}.
+func indexSetting(settings map[string]interface{}, name string) interface{} {
+	if value, exists := settings[name]; exists {
+		return value
+	}
+
+	segments := strings.Split(name, ".")
+	for length := 1; length < len(segments); length++ {
+		nested, isObject := settings[strings.Join(segments[:length], ".")].(map[string]interface{})
+		if !isObject {
+			continue
+		}
+		if value := indexSetting(nested, strings.Join(segments[length:], ".")); value != nil {
+			return value
+		}
+	}
+
+	return nil
+}
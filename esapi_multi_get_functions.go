@@ -0,0 +1,186 @@
+package elasticfacker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var multiGetDocumentKeys = map[string]bool{
+	"_index": true, "_id": true, "_source": true, "routing": true, "_routing": true, "stored_fields": true,
+	"version": true, "version_type": true,
+}
+
+// multiGetDocument is a document of a multi get request, with the source filter it is returned with.
+type multiGetDocument struct {
+	indexName  string
+	documentId string
+	source     *sourceFilter
+}
+
+// MultiGet returns the documents of the docs of the body, each with its index or the one of the path, or of
+// the ids of the body in the index of the path. Missing documents are returned as not found and missing
+// indices as the error of their entry. Routing is accepted but there is a single shard to route to.
+func (es *InMemoryElasticsearch) MultiGet(indexName string, params url.Values, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	var request map[string]interface{}
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return newErrorResponse(400, "x_content_parse_exception", err.Error(), "")
+	}
+
+	defaultSource, err := parseSourceFilter(sourceParams(params))
+	if err != nil {
+		return queryErrorResponse(err, "")
+	}
+
+	documents, errorResponse := parseMultiGet(indexName, defaultSource, request)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	items := make([]json.RawMessage, 0, len(documents))
+	for _, document := range documents {
+		item, _ := json.Marshal(es.multiGetItem(document))
+		items = append(items, item)
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchMultiGetResponseFake{Docs: items})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func parseMultiGet(indexName string, defaultSource *sourceFilter, request map[string]interface{}) ([]multiGetDocument, *MockMethods) {
+	for key := range request {
+		if key != "docs" && key != "ids" {
+			return nil, newErrorResponse(400, "parsing_exception",
+				fmt.Sprintf("unknown parameter [%s] in request body or parameter is of the wrong type[START_ARRAY] ", key), "")
+		}
+	}
+
+	documents := make([]multiGetDocument, 0)
+	for _, id := range stringList(request["ids"]) {
+		documents = append(documents, multiGetDocument{indexName: indexName, documentId: id, source: defaultSource})
+	}
+
+	docs, isList := request["docs"].([]interface{})
+	if request["docs"] != nil && !isList {
+		return nil, newErrorResponse(400, "parsing_exception", "docs array element should include an object", "")
+	}
+	for _, item := range docs {
+		doc, isObject := item.(map[string]interface{})
+		if !isObject {
+			return nil, newErrorResponse(400, "parsing_exception", "docs array element should include an object", "")
+		}
+		for key := range doc {
+			if !multiGetDocumentKeys[key] {
+				return nil, newErrorResponse(400, "parsing_exception", fmt.Sprintf("unknown key [%s] for a mget document", key), "")
+			}
+		}
+
+		document := multiGetDocument{indexName: indexName, source: defaultSource}
+		if docIndex, isString := doc["_index"].(string); isString {
+			document.indexName = docIndex
+		}
+		if doc["_id"] != nil {
+			document.documentId = fmt.Sprint(doc["_id"])
+		}
+		if doc["_source"] != nil {
+			source, err := parseSourceFilter(doc["_source"])
+			if err != nil {
+				return nil, queryErrorResponse(err, "")
+			}
+			document.source = source
+		}
+		documents = append(documents, document)
+	}
+
+	if len(documents) == 0 {
+		return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: no documents to get;", "")
+	}
+
+	failures := make([]string, 0)
+	for position, document := range documents {
+		if document.indexName == "" {
+			failures = append(failures, fmt.Sprintf("%d: index is missing for doc %d;", len(failures)+1, position))
+		}
+		if document.documentId == "" {
+			failures = append(failures, fmt.Sprintf("%d: id is missing for doc %d;", len(failures)+1, position))
+		}
+	}
+	if len(failures) > 0 {
+		return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: "+strings.Join(failures, ""), "")
+	}
+
+	return documents, nil
+}
+
+// multiGetItem returns the get response of a document of a multi get, its index resolved like the ones of
+// a search. Indices that do not exist, or aliases of more than one index, are the error of the entry.
+func (es *InMemoryElasticsearch) multiGetItem(document multiGetDocument) interface{} {
+	indices, errorResponse := es.resolveIndices(document.indexName)
+	if errorResponse != nil {
+		var resolveError ElasticSearchErrorResponseFake
+		_ = json.Unmarshal([]byte(errorResponse.BodyAsString), &resolveError)
+		return multiGetFailure(document, resolveError.Error.Type, resolveError.Error.Reason, resolveError.Error.Index)
+	}
+	if len(indices) == 0 {
+		return multiGetFailure(document, "index_not_found_exception",
+			fmt.Sprintf("no such index [%s]", document.indexName), document.indexName)
+	}
+	if len(indices) > 1 {
+		return multiGetFailure(document, "illegal_argument_exception",
+			fmt.Sprintf("alias [%s] has more than one index associated with it [%s], can't execute a single index op",
+				document.indexName, strings.Join(indices, ", ")), "")
+	}
+
+	indexName := indices[0]
+	position := es.findDocument(indexName, document.documentId)
+	if position < 0 {
+		return ElasticSearchGetResponseFake{
+			Index: indexName,
+			Id:    document.documentId,
+			Found: false,
+		}
+	}
+
+	stored := es.indicesDocuments[indexName][position]
+
+	return ElasticSearchGetResponseFake{
+		Index:       stored.Index,
+		Id:          stored.Id,
+		Version:     stored.Version,
+		SeqNo:       &stored.SeqNo,
+		PrimaryTerm: stored.PrimaryTerm,
+		Found:       true,
+		Source:      document.source.apply(stored.Source),
+	}
+}
+
+// multiGetFailure returns the entry of a document that could not be looked up, which has no found flag.
+func multiGetFailure(document multiGetDocument, errorType string, reason string, index string) ElasticSearchMultiGetFailureFake {
+	return ElasticSearchMultiGetFailureFake{
+		Index: document.indexName,
+		Id:    document.documentId,
+		Error: ElasticSearchErrorFake{
+			RootCause: []ElasticSearchErrorCauseFake{
+				{
+					Type:   errorType,
+					Reason: reason,
+					Index:  index,
+				},
+			},
+			Type:   errorType,
+			Reason: reason,
+			Index:  index,
+		},
+	}
+}
@@ -0,0 +1,128 @@
+package elasticfacker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var multiSearchHeaderKeys = map[string]bool{
+	"index": true, "indices": true, "search_type": true, "preference": true, "routing": true, "request_cache": true,
+	"ignore_unavailable": true, "allow_no_indices": true, "expand_wildcards": true, "ccs_minimize_roundtrips": true,
+	"allow_partial_search_results": true, "ignore_throttled": true,
+}
+
+// multiSearchEntry is a search of a multi search request: the index of its header, or the one of the path,
+// and its body.
+type multiSearchEntry struct {
+	indexName string
+	body      []byte
+}
+
+// MultiSearch runs each search of the NDJSON body, a header line followed by a search request line, and
+// returns their responses in order. A failing search is reported in its own entry with its status.
+func (es *InMemoryElasticsearch) MultiSearch(indexName string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	return es.multiSearch(indexName, body, func(entry multiSearchEntry) *MockMethods {
+		return es.Search(entry.indexName, entry.body)
+	})
+}
+
+// MultiSearchTemplate runs each search template of the NDJSON body, like MultiSearch does with searches.
+func (es *InMemoryElasticsearch) MultiSearchTemplate(indexName string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	return es.multiSearch(indexName, body, func(entry multiSearchEntry) *MockMethods {
+		return es.SearchTemplate(entry.indexName, entry.body)
+	})
+}
+
+func (es *InMemoryElasticsearch) multiSearch(indexName string, body []byte, search func(entry multiSearchEntry) *MockMethods) *MockMethods {
+	entries, errorResponse := parseMultiSearch(indexName, body)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	responses := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, multiSearchItem(search(entry)))
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchMultiSearchResponseFake{
+		Took:      rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20),
+		Responses: responses,
+	})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// parseMultiSearch reads the header and body pairs of a multi search. An empty header line searches the
+// index of the path.
+func parseMultiSearch(indexName string, body []byte) ([]multiSearchEntry, *MockMethods) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: no requests added;", "")
+	}
+	lines := strings.Split(strings.TrimRight(string(body), "\r\n"), "\n")
+
+	entries := make([]multiSearchEntry, 0, len(lines)/2)
+	for position := 0; position < len(lines); position += 2 {
+		header := map[string]interface{}{}
+		if headerLine := strings.TrimSpace(lines[position]); headerLine != "" {
+			err := json.Unmarshal([]byte(headerLine), &header)
+			if err != nil {
+				return nil, newErrorResponse(400, "x_content_parse_exception",
+					fmt.Sprintf("[%d:1] failed to parse the msearch header: %s", position+1, err.Error()), "")
+			}
+		}
+		for key := range header {
+			if !multiSearchHeaderKeys[key] {
+				return nil, newErrorResponse(400, "illegal_argument_exception",
+					fmt.Sprintf("key [%s] is not supported in the metadata section", key), "")
+			}
+		}
+		if position+1 >= len(lines) {
+			return nil, newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("the msearch header at line [%d] is not followed by a search request", position+1), "")
+		}
+
+		entry := multiSearchEntry{
+			indexName: indexName,
+			body:      []byte(strings.TrimSpace(lines[position+1])),
+		}
+		for _, key := range []string{"index", "indices"} {
+			if indices := stringList(header[key]); len(indices) > 0 {
+				entry.indexName = strings.Join(indices, ",")
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// multiSearchItem returns the body of a search response as an entry of a multi search response, with the
+// status of the search.
+func multiSearchItem(response *MockMethods) json.RawMessage {
+	item := map[string]interface{}{}
+	err := json.Unmarshal([]byte(response.BodyAsString), &item)
+	if err != nil {
+		item = map[string]interface{}{"error": response.BodyAsString}
+	}
+	item["status"] = response.StatusCode
+
+	jsonData, _ := json.Marshal(item)
+
+	return jsonData
+}
@@ -0,0 +1,256 @@
+package elasticfacker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchContext is a frozen copy of an index, its documents, mapping and inverted index: later writes to the
+// index change neither the hits nor the scores of the searches over it.
+type searchContext struct {
+	indexName string
+	snapshot  *InMemoryElasticsearch
+	keepAlive time.Duration
+	expiresAt time.Time
+}
+
+func (es *InMemoryElasticsearch) newSearchContext(indexName string, keepAlive time.Duration) (*searchContext, *MockMethods) {
+	if _, exists := es.indicesDocuments[indexName]; !exists {
+		return nil, indexNotFoundResponse(indexName)
+	}
+
+	return &searchContext{
+		indexName: indexName,
+		snapshot:  es.snapshotIndex(indexName),
+		keepAlive: keepAlive,
+		expiresAt: time.Now().Add(keepAlive),
+	}, nil
+}
+
+// snapshotIndex returns a copy of the state of the index searches read, holding only that index. Writes
+// replace documents and mappings rather than changing them, so copying the collections is enough.
+func (es *InMemoryElasticsearch) snapshotIndex(indexName string) *InMemoryElasticsearch {
+	documents := make([]Document, len(es.indicesDocuments[indexName]))
+	copy(documents, es.indicesDocuments[indexName])
+
+	settings := make(map[string]interface{}, len(es.indicesSettings[indexName]))
+	for key, value := range es.indicesSettings[indexName] {
+		settings[key] = value
+	}
+
+	return &InMemoryElasticsearch{
+		indicesDocuments: map[string][]Document{indexName: documents},
+		indicesMappings:  map[string]*fieldMapping{indexName: es.indicesMappings[indexName].clone()},
+		indicesSettings:  map[string]map[string]interface{}{indexName: settings},
+		indicesAnalysis:  map[string]*indexAnalysis{indexName: es.indicesAnalysis[indexName]},
+		indicesInverted:  map[string]*invertedIndex{indexName: es.indicesInverted[indexName].clone()},
+	}
+}
+
+func (context *searchContext) expired() bool {
+	return time.Now().After(context.expiresAt)
+}
+
+func (es *InMemoryElasticsearch) OpenPointInTime(indexName string, keepAlive string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if keepAlive == "" {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: [keep_alive] is not specified;", "")
+	}
+	keepAliveDuration, err := parseTimeValue("keep_alive", keepAlive)
+	if err != nil {
+		return newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+	}
+
+	context, errorResponse := es.newSearchContext(indexName, keepAliveDuration)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	pitId := generateContextId()
+	es.pointsInTime[pitId] = context
+
+	jsonData, _ := json.Marshal(ElasticSearchPointInTimeFake{Id: pitId})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) ClosePointInTime(body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	var pit ElasticSearchPointInTimeFake
+	err := json.Unmarshal(body, &pit)
+	if err != nil || pit.Id == "" {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: [id] is not specified;", "")
+	}
+
+	context, exists := es.pointsInTime[pit.Id]
+	delete(es.pointsInTime, pit.Id)
+
+	if !exists || context.expired() {
+		return clearContextResponse(404, 0)
+	}
+
+	return clearContextResponse(200, 1)
+}
+
+// searchTarget is what a search runs over. The query and the sort run on each index, the way Elasticsearch runs
+// them on each shard, while aggregations, highlights and the fields of the hits read the index named after the
+// target, the index itself or, for several indices, their documents with the fields of all their mappings.
+type searchTarget struct {
+	name       string
+	es         *InMemoryElasticsearch
+	indexNames []string
+}
+
+// resolveSearchTarget returns the indices a search runs over, the live indices of the request, every index
+// when it names none, or the copy of an index frozen by the point in time of the request.
+func (es *InMemoryElasticsearch) resolveSearchTarget(indexName string, pit *ElasticSearchPointInTimeFake) (*searchTarget, *MockMethods) {
+	if pit == nil {
+		indexNames, errorResponse := es.resolveIndices(indexName)
+		if errorResponse != nil {
+			return nil, errorResponse
+		}
+		if len(indexNames) == 1 {
+			return &searchTarget{name: indexNames[0], es: es, indexNames: indexNames}, nil
+		}
+
+		return es.combineIndices(indexNames), nil
+	}
+
+	if indexName != "" {
+		return nil, newErrorResponse(400, "action_request_validation_exception",
+			"Validation Failed: 1: [indices] cannot be used with point in time. Do not specify any index with point in time.;", "")
+	}
+
+	context, exists := es.pointsInTime[pit.Id]
+	if !exists || context.expired() {
+		delete(es.pointsInTime, pit.Id)
+		return nil, newErrorResponse(404, "search_context_missing_exception",
+			fmt.Sprintf("No search context found for id [%s]", pit.Id), "")
+	}
+
+	if pit.KeepAlive != "" {
+		keepAlive, err := parseTimeValue("keep_alive", pit.KeepAlive)
+		if err != nil {
+			return nil, newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+		}
+		context.keepAlive = keepAlive
+	}
+	context.expiresAt = time.Now().Add(context.keepAlive)
+
+	return &searchTarget{name: context.indexName, es: context.snapshot, indexNames: []string{context.indexName}}, nil
+}
+
+// combineIndices returns the target of a search over several indices: a view of the live indices with one more
+// index named after all of them, holding their documents and the fields of all their mappings.
+func (es *InMemoryElasticsearch) combineIndices(indexNames []string) *searchTarget {
+	name := strings.Join(indexNames, ",")
+	view := &InMemoryElasticsearch{
+		indicesDocuments: map[string][]Document{name: make([]Document, 0)},
+		indicesMappings:  map[string]*fieldMapping{name: newObjectMapping()},
+		indicesSettings:  make(map[string]map[string]interface{}),
+		indicesAnalysis:  make(map[string]*indexAnalysis),
+		indicesInverted:  map[string]*invertedIndex{name: newInvertedIndex()},
+	}
+	for _, indexName := range indexNames {
+		view.indicesDocuments[indexName] = es.indicesDocuments[indexName]
+		view.indicesMappings[indexName] = es.indicesMappings[indexName]
+		view.indicesSettings[indexName] = es.indicesSettings[indexName]
+		view.indicesAnalysis[indexName] = es.indicesAnalysis[indexName]
+		view.indicesInverted[indexName] = es.indicesInverted[indexName]
+
+		view.indicesDocuments[name] = append(view.indicesDocuments[name], es.indicesDocuments[indexName]...)
+		view.indicesMappings[name].combine(es.indicesMappings[indexName])
+	}
+	if len(indexNames) > 0 {
+		view.indicesSettings[name] = es.indicesSettings[indexNames[0]]
+		view.indicesAnalysis[name] = es.indicesAnalysis[indexNames[0]]
+	}
+
+	return &searchTarget{name: name, es: view, indexNames: indexNames}
+}
+
+// search returns the hits of the query in the indices of the target, sorted.
+func (target *searchTarget) search(query interface{}, sortFields []sortField) ([]searchHit, *MockMethods) {
+	hits := make([]searchHit, 0)
+	for _, indexName := range target.indexNames {
+		indexHits, errorResponse := target.es.searchDocuments(indexName, target.es.indicesDocuments[indexName], query)
+		if errorResponse != nil {
+			return nil, errorResponse
+		}
+		err := target.es.sortHits(indexName, indexHits, sortFields)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+		hits = append(hits, indexHits...)
+	}
+	if len(target.indexNames) > 1 {
+		orderHits(hits, sortFields)
+	}
+
+	return hits, nil
+}
+
+func clearContextResponse(statusCode int, numFreed int) *MockMethods {
+	jsonData, _ := json.Marshal(ElasticSearchClearContextResponseFake{
+		Succeeded: true,
+		NumFreed:  numFreed,
+	})
+
+	return &MockMethods{
+		StatusCode:   statusCode,
+		Status:       http.StatusText(statusCode),
+		BodyAsString: string(jsonData),
+	}
+}
+
+// generateContextId returns a random id for a point in time or a scroll, as long and opaque as the Elasticsearch ones.
+func generateContextId() string {
+	randomBytes := make([]byte, 60)
+	_, _ = rand.Read(randomBytes)
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
+
+// parseTimeValue parses Elasticsearch time units, like 30s, 1m or 1d.
+func parseTimeValue(name string, value string) (time.Duration, error) {
+	units := []struct {
+		suffix   string
+		duration time.Duration
+	}{
+		{"nanos", time.Nanosecond},
+		{"micros", time.Microsecond},
+		{"ms", time.Millisecond},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+	}
+
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			number, err := strconv.ParseInt(strings.TrimSuffix(value, unit.suffix), 10, 64)
+			if err == nil && number >= 0 {
+				return time.Duration(number) * unit.duration, nil
+			}
+			break
+		}
+	}
+
+	return 0, fmt.Errorf("failed to parse setting [%s] with value [%s] as a time value: unit is missing or unrecognized", name, value)
+}
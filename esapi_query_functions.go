@@ -1,46 +1,107 @@
 package elasticfacker
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	DefaultSearchSize     = 10
+	DefaultTrackTotalHits = 10000
+	MaxResultWindow       = 10000
+)
+
+// SearchTemplate renders the stored or inline mustache template of the request with its params and runs
+// the rendered search request.
 func (es *InMemoryElasticsearch) SearchTemplate(indexName string, body []byte) *MockMethods {
 	if es.mock != nil {
 		return es.mock
 	}
 
-	var searchTemplateRequest ElasticSearchRequest
-	err := json.Unmarshal(body, &searchTemplateRequest)
-	if err != nil {
-		return &MockMethods{
-			StatusCode:   400,
-			Status:       "Bad Request",
-			BodyAsString: fmt.Sprintf("{\"error\":\"%s\"}", err.Error()),
+	rendered, errorResponse := es.renderSearchTemplate("", body)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	var searchRequest ElasticSearchRequestScriptQuery
+	if len(strings.TrimSpace(rendered)) > 0 {
+		err := json.Unmarshal([]byte(rendered), &searchRequest)
+		if err != nil {
+			return newErrorResponse(400, "x_content_parse_exception", err.Error(), indexName)
 		}
 	}
 
-	return response(es, indexName)
+	return response(es, indexName, searchRequest)
 }
 
+// Search runs the search request body over the index.
 func (es *InMemoryElasticsearch) Search(indexName string, body []byte) *MockMethods {
+	return es.SearchWithParams(indexName, url.Values{}, body)
+}
+
+// SearchWithParams runs the search request body over the index, the query string params take precedence over
+// the body the same way they do in Elasticsearch.
+func (es *InMemoryElasticsearch) SearchWithParams(indexName string, params url.Values, body []byte) *MockMethods {
 	if es.mock != nil {
 		return es.mock
 	}
 
 	var searchRequest ElasticSearchRequestScriptQuery
-	err := json.Unmarshal(body, &searchRequest)
-	if err != nil {
-		return &MockMethods{
-			StatusCode:   400,
-			Status:       "Bad Request",
-			BodyAsString: fmt.Sprintf("{\"error\":\"%s\"}", err.Error()),
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, &searchRequest)
+		if err != nil {
+			return &MockMethods{
+				StatusCode:   400,
+				Status:       "Bad Request",
+				BodyAsString: fmt.Sprintf("{\"error\":\"%s\"}", err.Error()),
+			}
+		}
+	}
+
+	if params.Has("size") {
+		searchRequest.Size = params.Get("size")
+	}
+	if params.Has("from") {
+		searchRequest.From = params.Get("from")
+	}
+	if params.Has("sort") {
+		searchRequest.Sort = parseSortParam(params.Get("sort"))
+	}
+	if params.Has("track_total_hits") {
+		trackTotalHits := params.Get("track_total_hits")
+		trackTotalHitsBool, isBool := toBool(trackTotalHits)
+		if isBool {
+			searchRequest.TrackTotalHits = trackTotalHitsBool
+		} else {
+			searchRequest.TrackTotalHits = trackTotalHits
+		}
+	}
+
+	if source := sourceParams(params); source != nil {
+		searchRequest.Source = source
+	}
+	if params.Has("stored_fields") {
+		searchRequest.StoredFields = strings.Split(params.Get("stored_fields"), ",")
+	}
+	if params.Has("docvalue_fields") {
+		searchRequest.DocvalueFields = make([]interface{}, 0)
+		for _, field := range strings.Split(params.Get("docvalue_fields"), ",") {
+			searchRequest.DocvalueFields = append(searchRequest.DocvalueFields, field)
 		}
 	}
 
-	return response(es, indexName)
+	if params.Has("scroll") {
+		return es.openScroll(indexName, params.Get("scroll"), searchRequest)
+	}
+
+	return response(es, indexName, searchRequest)
 }
 
 func (es *InMemoryElasticsearch) Count(indexName string, body []byte) *MockMethods {
@@ -49,50 +110,227 @@ func (es *InMemoryElasticsearch) Count(indexName string, body []byte) *MockMetho
 	}
 
 	var searchRequest ElasticSearchRequestScriptQuery
-	err := json.Unmarshal(body, &searchRequest)
-	if err != nil {
-		return &MockMethods{
-			StatusCode:   400,
-			Status:       "Bad Request",
-			BodyAsString: fmt.Sprintf("{\"error\":\"%s\"}", err.Error()),
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, &searchRequest)
+		if err != nil {
+			return &MockMethods{
+				StatusCode:   400,
+				Status:       "Bad Request",
+				BodyAsString: fmt.Sprintf("{\"error\":\"%s\"}", err.Error()),
+			}
 		}
 	}
 
-	return responseCount(es, indexName)
+	return responseCount(es, indexName, searchRequest.Query)
 }
 
-func response(es *InMemoryElasticsearch, indexName string) *MockMethods {
-	indexDocuments, exists := es.indicesDocuments[indexName]
-	if !exists {
-		return &MockMethods{
-			StatusCode:   404,
-			Status:       "Not Found",
-			BodyAsString: fmt.Sprintf("{\"error\":\"Index %s does not exist\"}", indexName),
-		}
+func response(es *InMemoryElasticsearch, indexName string, searchRequest ElasticSearchRequestScriptQuery) *MockMethods {
+	result, errorResponse := es.executeSearch(indexName, searchRequest)
+	if errorResponse != nil {
+		return errorResponse
 	}
 
-	var total int
-	if len(indexDocuments) > 10 {
-		total = 10
-	} else {
-		total = len(indexDocuments)
+	var pitId string
+	if searchRequest.Pit != nil {
+		pitId = searchRequest.Pit.Id
 	}
 
-	searchResponse := ElasticSearchResponseFake{
-		Took: rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20),
-		Shards: ElasticSearchResponseFakeShards{
-			Total:      total,
-			Successful: 1,
-			Skipped:    0,
-			Failed:     0,
-		},
+	documents := pageDocuments(result.hits, result.from, result.size, result.scored)
+	if result.highlighter != nil {
+		result.highlighter.highlight(documents)
+	}
+	if result.format != nil {
+		result.format.apply(documents)
+	}
+
+	return newSearchResponse(ElasticSearchResponseFake{
 		Hits: ElasticSearchResponseFakeHits{
-			Total: ElasticSearchResponseFakeHitsTotal{
-				Value:    len(indexDocuments),
-				Relation: "eq",
-			},
-			Hits: indexDocuments,
+			Total:    result.hitsTotal,
+			MaxScore: maxScore(result.hits, result.scored),
+			Hits:     documents,
 		},
+		PitId:        pitId,
+		Aggregations: result.aggregations,
+	})
+}
+
+// searchResult holds every hit of a search, sorted, the window of them the request asked for and the
+// aggregations computed over all the matching documents. Hits sorted by fields have no score, unless
+// the request tracks scores. The highlighter, when the request asks for highlights, highlights a page of them,
+// and the format shapes the documents of the page the way the request asks for.
+type searchResult struct {
+	hits         []searchHit
+	scored       bool
+	hitsTotal    *ElasticSearchResponseFakeHitsTotal
+	from         int
+	size         int
+	aggregations map[string]interface{}
+	highlighter  *highlighter
+	format       *hitFormat
+}
+
+func (es *InMemoryElasticsearch) executeSearch(indexName string, searchRequest ElasticSearchRequestScriptQuery) (*searchResult, *MockMethods) {
+	from, err := searchWindowParam("from", searchRequest.From, 0)
+	if err != nil {
+		return nil, newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+	}
+	size, err := searchWindowParam("size", searchRequest.Size, DefaultSearchSize)
+	if err != nil {
+		return nil, newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+	}
+
+	var sortFields []sortField
+	if searchRequest.Sort != nil {
+		sortFields, err = parseSort(searchRequest.Sort)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+	}
+
+	target, errorResponse := es.resolveSearchTarget(indexName, searchRequest.Pit)
+	if errorResponse != nil {
+		return nil, errorResponse
+	}
+	indexName = target.name
+
+	// Searches over a point in time get an implicit tiebreaker, so search_after can resume between equal sort values.
+	if searchRequest.Pit != nil && len(sortFields) > 0 && sortFields[len(sortFields)-1].field != "_shard_doc" {
+		sortFields = append(sortFields, newSortField("_shard_doc"))
+	}
+
+	var aggs aggregations
+	if searchRequest.Aggregations != nil {
+		aggs, err = target.es.parseAggregations(indexName, target.es.indicesDocuments[indexName], searchRequest.Aggregations)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+	}
+
+	hits, errorResponse := target.search(searchRequest.Query, sortFields)
+	if errorResponse != nil {
+		return nil, errorResponse
+	}
+
+	var hitsHighlighter *highlighter
+	if searchRequest.Highlight != nil {
+		hitsHighlighter, err = target.es.parseHighlight(indexName, searchRequest.Highlight, searchRequest.Query)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+	}
+
+	hitsFormat, err := target.es.parseHitFormat(indexName, map[string]interface{}{
+		"_source":         searchRequest.Source,
+		"fields":          searchRequest.Fields,
+		"docvalue_fields": searchRequest.DocvalueFields,
+		"stored_fields":   searchRequest.StoredFields,
+	})
+	if err != nil {
+		return nil, queryErrorResponse(err, indexName)
+	}
+
+	hitsTotal, err := searchHitsTotal(len(hits), searchRequest.TrackTotalHits)
+	if err != nil {
+		return nil, newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+	}
+
+	var aggregationResults map[string]interface{}
+	if aggs != nil {
+		aggregationResults, err = aggs.aggregate(hits)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+	}
+
+	if searchRequest.SearchAfter != nil {
+		if len(sortFields) == 0 {
+			return nil, newErrorResponse(400, "illegal_argument_exception", "Sort must contain at least one field.", "")
+		}
+		if from > 0 {
+			return nil, newErrorResponse(400, "illegal_argument_exception", "[from] parameter must be set to 0 when [search_after] is used", "")
+		}
+		if len(searchRequest.SearchAfter) != len(sortFields) {
+			return nil, newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("search_after has %d value(s) but sort has %d.", len(searchRequest.SearchAfter), len(sortFields)), "")
+		}
+		searchAfter, err := target.es.parseSearchAfter(indexName, searchRequest.SearchAfter, sortFields)
+		if err != nil {
+			return nil, queryErrorResponse(err, indexName)
+		}
+		hits = hitsAfter(hits, searchAfter, sortFields)
+	}
+
+	if from+size > MaxResultWindow {
+		return nil, newErrorResponse(400, "illegal_argument_exception", fmt.Sprintf("Result window is too large, from + size must be "+
+			"less than or equal to: [%d] but was [%d]. See the scroll api for a more efficient way to request large data sets. "+
+			"This limit can be set by changing the [index.max_result_window] index level setting.", MaxResultWindow, from+size), indexName)
+	}
+
+	return &searchResult{
+		hits:         hits,
+		scored:       scoresTracked(sortFields, searchRequest.TrackScores),
+		hitsTotal:    hitsTotal,
+		from:         from,
+		size:         size,
+		aggregations: aggregationResults,
+		highlighter:  hitsHighlighter,
+		format:       hitsFormat,
+	}, nil
+}
+
+// pageDocuments returns the documents of the hits in the window, with their sort values and their score
+// when the hits are scored.
+func pageDocuments(hits []searchHit, from int, size int, scored bool) []Document {
+	documents := make([]Document, 0, size)
+	for position := from; position < len(hits) && position < from+size; position++ {
+		document := hits[position].document
+		document.Sort = hits[position].sortValues
+		if scored {
+			score := hits[position].score
+			document.Score = &score
+		}
+		documents = append(documents, document)
+	}
+
+	return documents
+}
+
+// scoresTracked reports whether the hits of a search are scored: they are when they are sorted by score, the
+// default, and when the request asks to track scores.
+func scoresTracked(sortFields []sortField, trackScores bool) bool {
+	if len(sortFields) == 0 || trackScores {
+		return true
+	}
+	for _, field := range sortFields {
+		if field.field == "_score" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxScore returns the best score of the hits, none when there are no hits or they are not scored.
+func maxScore(hits []searchHit, scored bool) *float64 {
+	if !scored || len(hits) == 0 {
+		return nil
+	}
+
+	best := hits[0].score
+	for _, hit := range hits[1:] {
+		best = math.Max(best, hit.score)
+	}
+
+	return &best
+}
+
+func newSearchResponse(searchResponse ElasticSearchResponseFake) *MockMethods {
+	searchResponse.Took = rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20)
+	searchResponse.Shards = ElasticSearchResponseFakeShards{
+		Total:      1,
+		Successful: 1,
+		Skipped:    0,
+		Failed:     0,
 	}
 
 	jsonData, _ := json.Marshal(searchResponse)
@@ -103,17 +341,67 @@ func response(es *InMemoryElasticsearch, indexName string) *MockMethods {
 	}
 }
 
-func responseCount(es *InMemoryElasticsearch, indexName string) *MockMethods {
-	indexDocuments, exists := es.indicesDocuments[indexName]
-	if !exists {
-		return &MockMethods{
-			StatusCode:   404,
-			Status:       "Not Found",
-			BodyAsString: fmt.Sprintf("{\"error\":\"Index %s does not exist\"}", indexName),
+// searchWindowParam parses the from and size values of a search, that can be sent as numbers or strings.
+func searchWindowParam(name string, value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse [%s] parameter with value [%s]", name, value)
+	}
+	if number < 0 {
+		return 0, fmt.Errorf("[%s] parameter cannot be negative, found [%d]", name, number)
+	}
+
+	return number, nil
+}
+
+// searchHitsTotal applies track_total_hits: true counts every hit, false leaves the total out and a number
+// counts accurately up to it. By default hits are counted up to 10,000.
+func searchHitsTotal(total int, trackTotalHits interface{}) (*ElasticSearchResponseFakeHitsTotal, error) {
+	limit := DefaultTrackTotalHits
+	switch typedValue := trackTotalHits.(type) {
+	case nil:
+	case bool:
+		if !typedValue {
+			return nil, nil
 		}
+		limit = total
+	default:
+		number, ok := toFloat(typedValue)
+		if !ok || number < 0 || number != float64(int(number)) {
+			return nil, fmt.Errorf("[track_total_hits] parameter must be a boolean or a positive integer, found [%v]", typedValue)
+		}
+		limit = int(number)
+	}
+
+	if total > limit {
+		return &ElasticSearchResponseFakeHitsTotal{
+			Value:    limit,
+			Relation: "gte",
+		}, nil
+	}
+
+	return &ElasticSearchResponseFakeHitsTotal{
+		Value:    total,
+		Relation: "eq",
+	}, nil
+}
+
+func responseCount(es *InMemoryElasticsearch, indexName string, query interface{}) *MockMethods {
+	target, errorResponse := es.resolveSearchTarget(indexName, nil)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	hits, errorResponse := target.search(query, nil)
+	if errorResponse != nil {
+		return errorResponse
 	}
 
-	total := len(indexDocuments)
+	total := len(hits)
 
 	countResponse := ElasticSearchCountResponseFake{
 		Count: total,
@@ -132,3 +420,87 @@ func responseCount(es *InMemoryElasticsearch, indexName string) *MockMethods {
 		BodyAsString: string(jsonData),
 	}
 }
+
+// searchHit is a document matching a search. Hits of nested aggregations are the nested objects at
+// nestedPath, with the hit of the object that holds them as parent.
+type searchHit struct {
+	document   Document
+	score      float64
+	sortValues []interface{}
+	nestedPath string
+	parent     *searchHit
+}
+
+// searchDocuments returns the documents matching the query, in the order they were indexed.
+func (es *InMemoryElasticsearch) searchDocuments(indexName string, documents []Document, query interface{}) ([]searchHit, *MockMethods) {
+	parsedQuery, err := es.parseSearchQuery(indexName, query)
+	if err != nil {
+		return nil, queryErrorResponse(err, indexName)
+	}
+
+	hits := make([]searchHit, 0)
+	for _, document := range documents {
+		matched, score := parsedQuery.evaluate(document)
+		if matched {
+			hits = append(hits, searchHit{document: document, score: score})
+		}
+	}
+
+	return hits, nil
+}
+
+func queryErrorResponse(err error, indexName string) *MockMethods {
+	errorType := "parsing_exception"
+	if typedError, ok := err.(*queryError); ok {
+		errorType = typedError.errorType
+	}
+
+	return newErrorResponse(400, errorType, err.Error(), indexName)
+}
+
+// UnmarshalJSON accepts size and from either as numbers or as strings, both are valid for Elasticsearch,
+// and the aggregations under aggs or under aggregations.
+func (searchRequest *ElasticSearchRequestScriptQuery) UnmarshalJSON(data []byte) error {
+	type searchRequestFields ElasticSearchRequestScriptQuery
+	request := struct {
+		*searchRequestFields
+		Size json.RawMessage        `json:"size"`
+		From json.RawMessage        `json:"from"`
+		Aggs map[string]interface{} `json:"aggs"`
+	}{
+		searchRequestFields: (*searchRequestFields)(searchRequest),
+	}
+
+	err := json.Unmarshal(data, &request)
+	if err != nil {
+		return err
+	}
+
+	searchRequest.Size, err = rawNumberAsString(request.Size)
+	if err != nil {
+		return err
+	}
+	searchRequest.From, err = rawNumberAsString(request.From)
+	if request.Aggs != nil {
+		searchRequest.Aggregations = request.Aggs
+	}
+
+	return err
+}
+
+func rawNumberAsString(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var value string
+	if raw[0] == '"' {
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+
+	var number json.Number
+	err := json.Unmarshal(raw, &number)
+
+	return number.String(), err
+}
@@ -0,0 +1,218 @@
+package elasticfacker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// storedScript is a script saved in the cluster state, mustache templates are searched with and the
+// other languages only kept. Template sources given as objects are stored as their JSON.
+type storedScript struct {
+	lang    string
+	source  string
+	options map[string]string
+}
+
+// searchTemplateRequest is the body of a search template request, a stored template id or an inline
+// source, and the params the template is rendered with.
+type searchTemplateRequest struct {
+	Id     string                 `json:"id"`
+	Source interface{}            `json:"source"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func (es *InMemoryElasticsearch) PutScript(scriptId string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	var request ElasticSearchStoredScriptRequestFake
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return newErrorResponse(400, "x_content_parse_exception", err.Error(), "")
+	}
+	if request.Script == nil {
+		return newErrorResponse(400, "illegal_argument_exception", "must specify a script", "")
+	}
+	if request.Script.Lang == "" {
+		return newErrorResponse(400, "illegal_argument_exception", "must specify lang for stored script", "")
+	}
+
+	var source string
+	switch typedSource := request.Script.Source.(type) {
+	case string:
+		source = typedSource
+	case map[string]interface{}:
+		if request.Script.Lang != "mustache" {
+			return newErrorResponse(400, "illegal_argument_exception", "must specify source for stored script", "")
+		}
+		sourceJSON, _ := json.Marshal(typedSource)
+		source = string(sourceJSON)
+	}
+	if source == "" {
+		return newErrorResponse(400, "illegal_argument_exception", "must specify source for stored script", "")
+	}
+
+	switch request.Script.Lang {
+	case "mustache":
+		if _, err := parseMustache(source); err != nil {
+			return queryErrorResponse(err, "")
+		}
+	case "painless", "expression":
+	default:
+		return newErrorResponse(400, "illegal_argument_exception",
+			fmt.Sprintf("unable to put stored script with unsupported lang [%s]", request.Script.Lang), "")
+	}
+
+	es.storedScripts[scriptId] = &storedScript{
+		lang:    request.Script.Lang,
+		source:  source,
+		options: request.Script.Options,
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchAcknowledgedResponseFake{Acknowledged: true})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) GetScript(scriptId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	script, exists := es.storedScripts[scriptId]
+	if !exists {
+		jsonData, _ := json.Marshal(ElasticSearchGetScriptResponseFake{Id: scriptId, Found: false})
+		return &MockMethods{
+			StatusCode:   404,
+			Status:       "Not Found",
+			BodyAsString: string(jsonData),
+		}
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchGetScriptResponseFake{
+		Id:    scriptId,
+		Found: true,
+		Script: &ElasticSearchStoredScriptFake{
+			Lang:    script.lang,
+			Source:  script.source,
+			Options: script.options,
+		},
+	})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+func (es *InMemoryElasticsearch) DeleteScript(scriptId string) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if _, exists := es.storedScripts[scriptId]; !exists {
+		return newErrorResponse(404, "resource_not_found_exception", fmt.Sprintf("stored script [%s] does not exist", scriptId), "")
+	}
+	delete(es.storedScripts, scriptId)
+
+	jsonData, _ := json.Marshal(ElasticSearchAcknowledgedResponseFake{Acknowledged: true})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// RenderSearchTemplate returns the search request a template renders to with the params of the body, the
+// stored template of the id or the inline source of the body.
+func (es *InMemoryElasticsearch) RenderSearchTemplate(templateId string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	rendered, errorResponse := es.renderSearchTemplate(templateId, body)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	var output bytes.Buffer
+	err := json.Compact(&output, []byte(rendered))
+	if err != nil {
+		return newErrorResponse(400, "x_content_parse_exception", fmt.Sprintf("failed to parse the rendered template: %s", err.Error()), "")
+	}
+
+	jsonData, _ := json.Marshal(ElasticSearchRenderTemplateResponseFake{TemplateOutput: output.Bytes()})
+
+	return &MockMethods{
+		StatusCode:   200,
+		Status:       "OK",
+		BodyAsString: string(jsonData),
+	}
+}
+
+// renderSearchTemplate renders the template of a search template request, the stored one of its id or its
+// inline source, with its params. An id given apart from the body, in the path, replaces the one of the body.
+func (es *InMemoryElasticsearch) renderSearchTemplate(templateId string, body []byte) (string, *MockMethods) {
+	var request searchTemplateRequest
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			return "", newErrorResponse(400, "x_content_parse_exception", err.Error(), "")
+		}
+	}
+	if templateId != "" {
+		request.Id = templateId
+	}
+
+	var source string
+	switch {
+	case request.Id != "" && request.Source != nil:
+		return "", newErrorResponse(400, "illegal_argument_exception",
+			"must specify either [source] for an inline template or [id] for a stored template, not both", "")
+	case request.Id != "":
+		script, exists := es.storedScripts[request.Id]
+		if !exists {
+			return "", newErrorResponse(404, "resource_not_found_exception",
+				fmt.Sprintf("unable to find script [%s] in cluster state", request.Id), "")
+		}
+		if script.lang != "mustache" {
+			return "", newErrorResponse(400, "illegal_argument_exception",
+				fmt.Sprintf("stored script [%s] is a [%s] script, not a mustache template", request.Id, script.lang), "")
+		}
+		source = script.source
+	case request.Source != nil:
+		if text, isString := request.Source.(string); isString {
+			source = text
+		} else {
+			sourceJSON, _ := json.Marshal(request.Source)
+			source = string(sourceJSON)
+		}
+	default:
+		return "", newErrorResponse(400, "action_request_validation_exception",
+			"Validation Failed: 1: template's script must be specified;", "")
+	}
+
+	nodes, err := parseMustache(source)
+	if err != nil {
+		return "", queryErrorResponse(err, "")
+	}
+
+	params := request.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	var builder strings.Builder
+	renderMustache(nodes, []interface{}{params}, &builder)
+
+	return builder.String(), nil
+}
@@ -0,0 +1,215 @@
+package elasticfacker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// scrollContext keeps the sorted hits of the search that opened the scroll, every scroll request returns
+// the next page of them, so later writes to the index do not change the results.
+type scrollContext struct {
+	hits        []searchHit
+	scored      bool
+	hitsTotal   *ElasticSearchResponseFakeHitsTotal
+	size        int
+	cursor      int
+	highlighter *highlighter
+	format      *hitFormat
+	keepAlive   time.Duration
+	expiresAt   time.Time
+}
+
+func (context *scrollContext) expired() bool {
+	return time.Now().After(context.expiresAt)
+}
+
+func (context *scrollContext) nextPage() []Document {
+	documents := pageDocuments(context.hits, context.cursor, context.size, context.scored)
+	context.cursor += len(documents)
+	if context.highlighter != nil {
+		context.highlighter.highlight(documents)
+	}
+	if context.format != nil {
+		context.format.apply(documents)
+	}
+
+	return documents
+}
+
+// openScroll runs a search with the scroll param, returning its first page and the id to fetch the next ones.
+func (es *InMemoryElasticsearch) openScroll(indexName string, scroll string, searchRequest ElasticSearchRequestScriptQuery) *MockMethods {
+	keepAlive, err := parseTimeValue("scroll", scroll)
+	if err != nil {
+		return newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+	}
+
+	if searchRequest.From != "" && searchRequest.From != "0" {
+		return newErrorResponse(400, "action_request_validation_exception",
+			"Validation Failed: 1: using [from] is not allowed in a scroll context;", "")
+	}
+	if searchRequest.SearchAfter != nil {
+		return newErrorResponse(400, "action_request_validation_exception",
+			"Validation Failed: 1: [search_after] cannot be used in a scroll context;", "")
+	}
+	if searchRequest.Pit != nil {
+		return newErrorResponse(400, "action_request_validation_exception",
+			"Validation Failed: 1: using [point in time] is not allowed in a scroll context;", "")
+	}
+
+	result, errorResponse := es.executeSearch(indexName, searchRequest)
+	if errorResponse != nil {
+		return errorResponse
+	}
+
+	context := &scrollContext{
+		hits:        result.hits,
+		scored:      result.scored,
+		hitsTotal:   result.hitsTotal,
+		size:        result.size,
+		highlighter: result.highlighter,
+		format:      result.format,
+		keepAlive:   keepAlive,
+		expiresAt:   time.Now().Add(keepAlive),
+	}
+	scrollId := generateContextId()
+	es.scrollContexts[scrollId] = context
+
+	return newSearchResponse(ElasticSearchResponseFake{
+		Hits: ElasticSearchResponseFakeHits{
+			Total:    context.hitsTotal,
+			MaxScore: maxScore(context.hits, context.scored),
+			Hits:     context.nextPage(),
+		},
+		ScrollId:     scrollId,
+		Aggregations: result.aggregations,
+	})
+}
+
+// Scroll returns the next page of an open scroll. The scroll id and keep alive can be sent as params or in the body,
+// a new keep alive replaces the one the scroll was opened with.
+func (es *InMemoryElasticsearch) Scroll(scrollId string, scroll string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	var scrollRequest ElasticSearchScrollRequestFake
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, &scrollRequest)
+		if err != nil {
+			return newErrorResponse(400, "x_content_parse_exception", err.Error(), "")
+		}
+	}
+	if scrollId != "" {
+		scrollRequest.ScrollId = scrollId
+	}
+	if scroll != "" {
+		scrollRequest.Scroll = scroll
+	}
+
+	if scrollRequest.ScrollId == "" {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: scrollId is missing;", "")
+	}
+
+	context, exists := es.scrollContexts[scrollRequest.ScrollId]
+	if !exists || context.expired() {
+		delete(es.scrollContexts, scrollRequest.ScrollId)
+		return newErrorResponse(404, "search_context_missing_exception",
+			fmt.Sprintf("No search context found for id [%s]", scrollRequest.ScrollId), "")
+	}
+
+	if scrollRequest.Scroll != "" {
+		keepAlive, err := parseTimeValue("scroll", scrollRequest.Scroll)
+		if err != nil {
+			return newErrorResponse(400, "illegal_argument_exception", err.Error(), "")
+		}
+		context.keepAlive = keepAlive
+	}
+	context.expiresAt = time.Now().Add(context.keepAlive)
+
+	return newSearchResponse(ElasticSearchResponseFake{
+		Hits: ElasticSearchResponseFakeHits{
+			Total:    context.hitsTotal,
+			MaxScore: maxScore(context.hits, context.scored),
+			Hits:     context.nextPage(),
+		},
+		ScrollId: scrollRequest.ScrollId,
+	})
+}
+
+// ClearScroll frees the scrolls of the ids in the path or the body, a single id or an array of them.
+// The _all id frees every open scroll.
+func (es *InMemoryElasticsearch) ClearScroll(scrollIds []string, body []byte) *MockMethods {
+	if es.mock != nil {
+		return es.mock
+	}
+
+	if len(scrollIds) == 0 && len(bytes.TrimSpace(body)) > 0 {
+		var clearScrollRequest ElasticSearchClearScrollRequestFake
+		err := json.Unmarshal(body, &clearScrollRequest)
+		if err != nil {
+			return newErrorResponse(400, "x_content_parse_exception", err.Error(), "")
+		}
+
+		switch typedScrollId := clearScrollRequest.ScrollId.(type) {
+		case string:
+			scrollIds = []string{typedScrollId}
+		case []interface{}:
+			for _, scrollId := range typedScrollId {
+				scrollIdString, isString := scrollId.(string)
+				if !isString {
+					return newErrorResponse(400, "illegal_argument_exception",
+						fmt.Sprintf("scroll_id array element should only contain scroll_id, found [%v]", scrollId), "")
+				}
+				scrollIds = append(scrollIds, scrollIdString)
+			}
+		}
+	}
+
+	if len(scrollIds) == 0 {
+		return newErrorResponse(400, "action_request_validation_exception", "Validation Failed: 1: no scroll ids specified;", "")
+	}
+
+	numFreed := 0
+	clearedAll := false
+	for _, scrollId := range scrollIds {
+		if scrollId == "_all" {
+			clearedAll = true
+			for id, context := range es.scrollContexts {
+				if !context.expired() {
+					numFreed++
+				}
+				delete(es.scrollContexts, id)
+			}
+			continue
+		}
+
+		context, exists := es.scrollContexts[scrollId]
+		if exists && !context.expired() {
+			numFreed++
+		}
+		delete(es.scrollContexts, scrollId)
+	}
+
+	if numFreed == 0 && !clearedAll {
+		return clearContextResponse(404, 0)
+	}
+
+	return clearContextResponse(200, numFreed)
+}
+
+// OpenScrollContexts returns how many scrolls are open, the ones that expired are dropped first.
+// Tests can use it to check a client clears its scrolls once done.
+func (es *InMemoryElasticsearch) OpenScrollContexts() int {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	for scrollId, context := range es.scrollContexts {
+		if context.expired() {
+			delete(es.scrollContexts, scrollId)
+		}
+	}
+
+	return len(es.scrollContexts)
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/gabrielsaiz/elasticfacker"
 	"github.com/stretchr/testify/assert"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -407,6 +409,17 @@ func TestSearchTemplateRequest(t *testing.T) {
 
 	assert.True(t, res.StatusCode == 200)
 
+	scriptReq := esapi.PutScriptRequest{
+		ScriptID: "templateId",
+		Body:     strings.NewReader(`{"script": {"lang": "mustache", "source": {"query": {"match": {"name": "{{search_term}}"}}, "size": "{{size}}"}}}`),
+	}
+
+	scriptRes, err := scriptReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer scriptRes.Body.Close()
+
+	assert.Equal(t, 200, scriptRes.StatusCode)
+
 	for _, subtest := range subtests {
 		time.Sleep(1 * time.Second)
 
@@ -540,6 +553,15 @@ func TestCountRequest(t *testing.T) {
 			indexName: "products-test",
 			body:      buildScriptQueryBody("test", 10),
 		},
+		{
+			name:      "CountWithoutBody",
+			indexName: "products-test",
+		},
+		{
+			name:      "CountAlias",
+			indexName: "products-alias-test",
+			body:      strings.NewReader(`{"query": {"match": {"name": "sofa"}}}`),
+		},
 	}
 
 	esClient, error := elasticsearch.NewDefaultClient()
@@ -553,6 +575,7 @@ func TestCountRequest(t *testing.T) {
 
 	req := esapi.IndicesCreateRequest{
 		Index: "products-test",
+		Body:  strings.NewReader(`{"aliases": {"products-alias-test": {}}}`),
 	}
 
 	fmt.Printf("Req: %v \n", req)
@@ -565,13 +588,25 @@ func TestCountRequest(t *testing.T) {
 
 	assert.True(t, res.StatusCode == 200)
 
+	indexReq := esapi.IndexRequest{
+		Index:      "products-test",
+		DocumentID: "001",
+		Body:       strings.NewReader(`{"name": "sofa"}`),
+	}
+
+	indexRes, err := indexReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer indexRes.Body.Close()
+
 	for _, subtest := range subtests {
 		time.Sleep(1 * time.Second)
 
 		t.Run(subtest.name, func(t *testing.T) {
 			req := esapi.CountRequest{
 				Index: []string{subtest.indexName},
-				Body:  subtest.body,
+			}
+			if subtest.body != nil {
+				req.Body = subtest.body
 			}
 
 			switch subtest.name {
@@ -593,8 +628,3852 @@ func TestCountRequest(t *testing.T) {
 				defer res.Body.Close()
 
 				assert.Equal(t, 200, res.StatusCode)
+			case "CountWithoutBody", "CountAlias":
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var countResponse elasticfacker.ElasticSearchCountResponseFake
+				err = json.NewDecoder(res.Body).Decode(&countResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, 1, countResponse.Count)
+			}
+
+		})
+	}
+}
+
+func TestDocumentRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name       string
+		indexName  string
+		documentId string
+		body       *strings.Reader
+	}{
+		{
+			name:      "IndexDocumentGeneratedId",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"code": "001681000201", "name": "sofa"}`),
+		},
+		{
+			name:       "IndexDocumentCreated",
+			indexName:  "products-test",
+			documentId: "001136003701",
+			body:       strings.NewReader(`{"code": "001136003701", "name": "table"}`),
+		},
+		{
+			name:       "IndexDocumentUpdated",
+			indexName:  "products-test",
+			documentId: "001136003701",
+			body:       strings.NewReader(`{"code": "001136003701", "name": "round table"}`),
+		},
+		{
+			name:       "CreateDocumentConflict",
+			indexName:  "products-test",
+			documentId: "001136003701",
+			body:       strings.NewReader(`{"code": "001136003701", "name": "chair"}`),
+		},
+		{
+			name:       "IndexDocumentOpTypeCreateConflict",
+			indexName:  "products-test",
+			documentId: "001136003701",
+			body:       strings.NewReader(`{"code": "001136003701", "name": "chair"}`),
+		},
+		{
+			name:      "SearchIndexedDocuments",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"query": {"match_all": {}}}`),
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	for _, subtest := range subtests {
+		time.Sleep(1 * time.Second)
+
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "IndexDocumentGeneratedId":
+				req := esapi.IndexRequest{
+					Index: subtest.indexName,
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 201, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchDocumentResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.Len(t, document.Id, 20)
+				assert.Equal(t, "created", document.Result)
+				assert.Equal(t, int64(1), document.Version)
+				assert.Equal(t, int64(0), document.SeqNo)
+			case "IndexDocumentCreated":
+				req := esapi.IndexRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+					Body:       subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 201, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchDocumentResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.Equal(t, subtest.documentId, document.Id)
+				assert.Equal(t, "created", document.Result)
+				assert.Equal(t, int64(1), document.SeqNo)
+				assert.Equal(t, int64(1), document.PrimaryTerm)
+			case "IndexDocumentUpdated":
+				req := esapi.IndexRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+					Body:       subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchDocumentResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.Equal(t, "updated", document.Result)
+				assert.Equal(t, int64(2), document.Version)
+			case "CreateDocumentConflict":
+				req := esapi.CreateRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+					Body:       subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 409, res.StatusCode)
+			case "IndexDocumentOpTypeCreateConflict":
+				req := esapi.IndexRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+					OpType:     "create",
+					Body:       subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 409, res.StatusCode)
+			case "SearchIndexedDocuments":
+				req := esapi.SearchRequest{
+					Index: []string{subtest.indexName},
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, 2, searchResponse.Hits.Total.Value)
+			}
+		})
+	}
+}
+
+func TestGetDocumentRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name       string
+		indexName  string
+		documentId string
+	}{
+		{
+			name:       "GetDocumentIndexNotFound",
+			indexName:  "products-test-not-found",
+			documentId: "001136003701",
+		},
+		{
+			name:       "GetDocumentNotFound",
+			indexName:  "products-test",
+			documentId: "001136003799",
+		},
+		{
+			name:       "GetDocumentFound",
+			indexName:  "products-test",
+			documentId: "001136003701",
+		},
+		{
+			name:       "DocumentExists",
+			indexName:  "products-test",
+			documentId: "001136003701",
+		},
+		{
+			name:       "GetDocumentSource",
+			indexName:  "products-test",
+			documentId: "001136003701",
+		},
+		{
+			name:       "DeleteDocumentNotFound",
+			indexName:  "products-test",
+			documentId: "001136003799",
+		},
+		{
+			name:       "DeleteDocument",
+			indexName:  "products-test",
+			documentId: "001136003701",
+		},
+		{
+			name:       "DocumentDoesNotExist",
+			indexName:  "products-test",
+			documentId: "001136003701",
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.IndexRequest{
+		Index:      "products-test",
+		DocumentID: "001136003701",
+		Body:       strings.NewReader(`{"code": "001136003701", "name": "table"}`),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 201, res.StatusCode)
+
+	for _, subtest := range subtests {
+		time.Sleep(1 * time.Second)
+
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "GetDocumentIndexNotFound", "GetDocumentNotFound":
+				req := esapi.GetRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 404, res.StatusCode)
+			case "GetDocumentFound":
+				req := esapi.GetRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchGetResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.True(t, document.Found)
+				assert.Equal(t, int64(1), document.Version)
+				assert.Equal(t, "table", document.Source["name"])
+			case "DocumentExists":
+				req := esapi.ExistsRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+			case "GetDocumentSource":
+				req := esapi.GetSourceRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var source map[string]interface{}
+				err = json.NewDecoder(res.Body).Decode(&source)
+				assert.Nil(t, err)
+				assert.Equal(t, "001136003701", source["code"])
+			case "DeleteDocumentNotFound":
+				req := esapi.DeleteRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 404, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchDocumentResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.Equal(t, "not_found", document.Result)
+			case "DeleteDocument":
+				req := esapi.DeleteRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var document elasticfacker.ElasticSearchDocumentResponseFake
+				err = json.NewDecoder(res.Body).Decode(&document)
+				assert.Nil(t, err)
+				assert.Equal(t, "deleted", document.Result)
+				assert.Equal(t, int64(2), document.Version)
+			case "DocumentDoesNotExist":
+				req := esapi.ExistsRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 404, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestBulkRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+		body      *strings.Reader
+	}{
+		{
+			name:      "BulkMalformedAction",
+			indexName: "products-test",
+			body:      strings.NewReader("{\"upsert\": {\"_id\": \"1\"}}\n{\"code\": \"1\"}\n"),
+		},
+		{
+			name:      "BulkPartialFailure",
+			indexName: "products-test",
+			body: strings.NewReader(
+				"{\"index\": {\"_id\": \"001681000201\"}}\n{\"code\": \"001681000201\", \"stock\": {\"units\": 3}}\n" +
+					"{\"create\": {\"_index\": \"products-test\", \"_id\": \"001136003701\"}}\n{\"code\": \"001136003701\"}\n" +
+					"{\"update\": {\"_id\": \"001681000201\"}}\n{\"doc\": {\"stock\": {\"warehouse\": \"north\"}}}\n" +
+					"{\"create\": {\"_id\": \"001681000201\"}}\n{\"code\": \"001681000201\"}\n" +
+					"{\"update\": {\"_id\": \"002026002002\"}}\n{\"doc\": {\"code\": \"002026002002\"}}\n" +
+					"{\"delete\": {\"_id\": \"001136003701\"}}\n" +
+					"{\"index\": {}}\n{\"stock\": \"many\"}\n"),
+		},
+		{
+			name:      "BulkIndexer",
+			indexName: "products-test-indexer",
+		},
+	}
+
+	esClient, err := elasticsearch.NewDefaultClient()
+	if err != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", err)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	for _, subtest := range subtests {
+		time.Sleep(1 * time.Second)
+
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "BulkMalformedAction":
+				req := esapi.BulkRequest{
+					Index: subtest.indexName,
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 400, res.StatusCode)
+			case "BulkPartialFailure":
+				req := esapi.BulkRequest{
+					Index: subtest.indexName,
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var bulkResponse elasticfacker.ElasticSearchBulkResponseFake
+				err = json.NewDecoder(res.Body).Decode(&bulkResponse)
+				assert.Nil(t, err)
+				assert.True(t, bulkResponse.Errors)
+				assert.Len(t, bulkResponse.Items, 7)
+				assert.Equal(t, 201, bulkResponse.Items[0]["index"].Status)
+				assert.Equal(t, 201, bulkResponse.Items[1]["create"].Status)
+				assert.Equal(t, "updated", bulkResponse.Items[2]["update"].Result)
+				assert.Equal(t, 409, bulkResponse.Items[3]["create"].Status)
+				assert.Equal(t, "version_conflict_engine_exception", bulkResponse.Items[3]["create"].Error.Type)
+				assert.Equal(t, "document_missing_exception", bulkResponse.Items[4]["update"].Error.Type)
+				assert.Equal(t, "deleted", bulkResponse.Items[5]["delete"].Result)
+				assert.Equal(t, 400, bulkResponse.Items[6]["index"].Status)
+				assert.Nil(t, bulkResponse.Items[6]["index"].Id)
+				assert.Equal(t, "001681000201", *bulkResponse.Items[0]["index"].Id)
+
+				getReq := esapi.GetSourceRequest{
+					Index:      subtest.indexName,
+					DocumentID: "001681000201",
+				}
+
+				getRes, err := getReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer getRes.Body.Close()
+
+				var source map[string]interface{}
+				err = json.NewDecoder(getRes.Body).Decode(&source)
+				assert.Nil(t, err)
+				assert.Equal(t, map[string]interface{}{"units": float64(3), "warehouse": "north"}, source["stock"])
+			case "BulkIndexer":
+				indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+					Client:     esClient,
+					Index:      subtest.indexName,
+					NumWorkers: 4,
+				})
+				assert.Nil(t, err)
+
+				var failed int64
+				for i := 0; i < 100; i++ {
+					action := "index"
+					if i%10 == 0 {
+						action = "update"
+					}
+					err = indexer.Add(context.Background(), esutil.BulkIndexerItem{
+						Action:     action,
+						DocumentID: fmt.Sprintf("%06d", i),
+						Body:       strings.NewReader(fmt.Sprintf(`{"doc": {"code": "%06d"}}`, i)),
+						OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+							atomic.AddInt64(&failed, 1)
+						},
+					})
+					assert.Nil(t, err)
+				}
+
+				err = indexer.Close(context.Background())
+				assert.Nil(t, err)
+
+				stats := indexer.Stats()
+				assert.Equal(t, uint64(90), stats.NumIndexed)
+				assert.Equal(t, uint64(10), stats.NumFailed)
+				assert.Equal(t, int64(10), atomic.LoadInt64(&failed))
+			}
+		})
+	}
+}
+
+func TestUpdateDocumentRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name       string
+		indexName  string
+		documentId string
+		body       *strings.Reader
+		expected   int
+		result     string
+	}{
+		{
+			name:       "UpdateDocumentMissing",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"doc": {"stock": {"units": 1}}}`),
+			expected:   404,
+		},
+		{
+			name:       "UpdateDocumentUpsert",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"doc": {"stock": {"units": 1}}, "upsert": {"code": "001681000201", "stock": {"units": 5, "warehouse": "north"}}}`),
+			expected:   201,
+			result:     "created",
+		},
+		{
+			name:       "UpdateDocumentDocAsUpsert",
+			indexName:  "products-test",
+			documentId: "001136003701",
+			body:       strings.NewReader(`{"doc": {"code": "001136003701"}, "doc_as_upsert": true}`),
+			expected:   201,
+			result:     "created",
+		},
+		{
+			name:       "UpdateDocumentMerge",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"doc": {"stock": {"units": 4}}}`),
+			expected:   200,
+			result:     "updated",
+		},
+		{
+			name:       "UpdateDocumentNoop",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"doc": {"stock": {"units": 4}}}`),
+			expected:   200,
+			result:     "noop",
+		},
+		{
+			name:       "UpdateDocumentDetectNoopDisabled",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"doc": {"stock": {"units": 4}}, "detect_noop": false}`),
+			expected:   200,
+			result:     "updated",
+		},
+		{
+			name:       "UpdateDocumentUpsertWithoutDoc",
+			indexName:  "products-test",
+			documentId: "001681000201",
+			body:       strings.NewReader(`{"upsert": {"code": "001681000201"}}`),
+			expected:   400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.IndicesCreateRequest{
+		Index: "products-test",
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.True(t, res.StatusCode == 200)
+
+	for _, subtest := range subtests {
+		time.Sleep(1 * time.Second)
+
+		t.Run(subtest.name, func(t *testing.T) {
+			retryOnConflict := 3
+			req := esapi.UpdateRequest{
+				Index:           subtest.indexName,
+				DocumentID:      subtest.documentId,
+				Body:            subtest.body,
+				RetryOnConflict: &retryOnConflict,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.result == "" {
+				return
+			}
+
+			var document elasticfacker.ElasticSearchDocumentResponseFake
+			err = json.NewDecoder(res.Body).Decode(&document)
+			assert.Nil(t, err)
+			assert.Equal(t, subtest.result, document.Result)
+
+			switch subtest.name {
+			case "UpdateDocumentMerge":
+				assert.Equal(t, int64(2), document.Version)
+
+				getReq := esapi.GetSourceRequest{
+					Index:      subtest.indexName,
+					DocumentID: subtest.documentId,
+				}
+
+				getRes, err := getReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer getRes.Body.Close()
+
+				var source map[string]interface{}
+				err = json.NewDecoder(getRes.Body).Decode(&source)
+				assert.Nil(t, err)
+				assert.Equal(t, map[string]interface{}{"units": float64(4), "warehouse": "north"}, source["stock"])
+			case "UpdateDocumentNoop":
+				assert.Equal(t, int64(2), document.Version)
+			case "UpdateDocumentDetectNoopDisabled":
+				assert.Equal(t, int64(3), document.Version)
+			}
+		})
+	}
+}
+
+func TestSearchQueryRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		hits     int
+	}{
+		{
+			name:     "MatchAll",
+			body:     strings.NewReader(`{"query": {"match_all": {}}}`),
+			expected: 200,
+			hits:     4,
+		},
+		{
+			name:     "Match",
+			body:     strings.NewReader(`{"query": {"match": {"name": "red sofa"}}}`),
+			expected: 200,
+			hits:     3,
+		},
+		{
+			name:     "MatchOperatorAnd",
+			body:     strings.NewReader(`{"query": {"match": {"name": {"query": "red sofa", "operator": "and"}}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "MatchFromQueryFragment",
+			body:     buildScriptQueryBody("sofa", 10),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "Term",
+			body:     strings.NewReader(`{"query": {"term": {"category": {"value": "sofas"}}}}`),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "TermOnObjectField",
+			body:     strings.NewReader(`{"query": {"term": {"stock.units": 12}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "Terms",
+			body:     strings.NewReader(`{"query": {"terms": {"tags": ["leather", "wood"]}}}`),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "Range",
+			body:     strings.NewReader(`{"query": {"range": {"price": {"gte": 100, "lt": 700}}}}`),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "Exists",
+			body:     strings.NewReader(`{"query": {"exists": {"field": "stock"}}}`),
+			expected: 200,
+			hits:     3,
+		},
+		{
+			name:     "Ids",
+			body:     strings.NewReader(`{"query": {"ids": {"values": ["001", "003"]}}}`),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "Prefix",
+			body:     strings.NewReader(`{"query": {"prefix": {"code": "00"}}}`),
+			expected: 200,
+			hits:     4,
+		},
+		{
+			name: "BoolMustMustNot",
+			body: strings.NewReader(`{"query": {"bool": {
+				"must": [{"match": {"name": "sofa"}}],
+				"filter": {"range": {"price": {"gt": 0}}},
+				"must_not": [{"term": {"tags": "fabric"}}]
+			}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name: "BoolShouldMinimumShouldMatch",
+			body: strings.NewReader(`{"query": {"bool": {
+				"should": [
+					{"match": {"name": "red"}},
+					{"term": {"category": "sofas"}},
+					{"range": {"price": {"lt": 100}}}
+				],
+				"minimum_should_match": 2
+			}}}`),
+			expected: 200,
+			hits:     2,
+		},
+		{
+			name:     "UnknownQuery",
+			body:     strings.NewReader(`{"query": {"unknown": {"name": "sofa"}}}`),
+			expected: 400,
+		},
+		{
+			name:     "TermArrayOfValues",
+			body:     strings.NewReader(`{"query": {"term": {"tags": ["leather", "red"]}}}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body: strings.NewReader(
+			"{\"index\": {\"_id\": \"001\"}}\n" +
+				"{\"code\": \"001\", \"name\": \"Red leather sofa\", \"category\": \"sofas\", \"price\": 899.5, \"tags\": [\"leather\", \"red\"], \"stock\": {\"units\": 3}}\n" +
+				"{\"index\": {\"_id\": \"002\"}}\n" +
+				"{\"code\": \"002\", \"name\": \"Blue fabric sofa\", \"category\": \"sofas\", \"price\": 499, \"tags\": [\"fabric\"], \"stock\": {\"units\": 0}}\n" +
+				"{\"index\": {\"_id\": \"003\"}}\n" +
+				"{\"code\": \"003\", \"name\": \"Oak dining table\", \"category\": \"tables\", \"price\": 650, \"tags\": [\"wood\"]}\n" +
+				"{\"index\": {\"_id\": \"004\"}}\n" +
+				"{\"code\": \"004\", \"name\": \"Red plastic chair\", \"category\": \"chairs\", \"price\": \"45\", \"stock\": {\"units\": 12}}\n"),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"products-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+			assert.Equal(t, subtest.hits, searchResponse.Hits.Total.Value)
+		})
+	}
+
+	countReq := esapi.CountRequest{
+		Index: []string{"products-test"},
+		Body:  strings.NewReader(`{"query": {"term": {"category": "sofas"}}}`),
+	}
+
+	countRes, err := countReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer countRes.Body.Close()
+
+	var countResponse elasticfacker.ElasticSearchCountResponseFake
+	err = json.NewDecoder(countRes.Body).Decode(&countResponse)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, countResponse.Count)
+}
+
+func TestSearchPaginationRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		from     *int
+		size     *int
+		expected int
+		hits     int
+		total    *elasticfacker.ElasticSearchResponseFakeHitsTotal
+		firstId  string
+	}{
+		{
+			name:     "DefaultSize",
+			body:     strings.NewReader(`{"query": {"match_all": {}}}`),
+			expected: 200,
+			hits:     10,
+			total:    &elasticfacker.ElasticSearchResponseFakeHitsTotal{Value: 25, Relation: "eq"},
+			firstId:  "000",
+		},
+		{
+			name:     "FromAndSize",
+			body:     strings.NewReader(`{"from": 20, "size": 10}`),
+			expected: 200,
+			hits:     5,
+			total:    &elasticfacker.ElasticSearchResponseFakeHitsTotal{Value: 25, Relation: "eq"},
+			firstId:  "020",
+		},
+		{
+			name:     "SizeAsString",
+			body:     strings.NewReader(`{"size": "3"}`),
+			expected: 200,
+			hits:     3,
+			total:    &elasticfacker.ElasticSearchResponseFakeHitsTotal{Value: 25, Relation: "eq"},
+			firstId:  "000",
+		},
+		{
+			name:     "QueryStringParams",
+			body:     strings.NewReader(`{"from": 0, "size": 10}`),
+			from:     esapi.IntPtr(5),
+			size:     esapi.IntPtr(2),
+			expected: 200,
+			hits:     2,
+			total:    &elasticfacker.ElasticSearchResponseFakeHitsTotal{Value: 25, Relation: "eq"},
+			firstId:  "005",
+		},
+		{
+			name:     "TrackTotalHitsLimit",
+			body:     strings.NewReader(`{"track_total_hits": 10}`),
+			expected: 200,
+			hits:     10,
+			total:    &elasticfacker.ElasticSearchResponseFakeHitsTotal{Value: 10, Relation: "gte"},
+			firstId:  "000",
+		},
+		{
+			name:     "TrackTotalHitsDisabled",
+			body:     strings.NewReader(`{"track_total_hits": false}`),
+			expected: 200,
+			hits:     10,
+			firstId:  "000",
+		},
+		{
+			name:     "ResultWindowTooLarge",
+			body:     strings.NewReader(`{"from": 9995, "size": 10}`),
+			expected: 400,
+		},
+		{
+			name:     "NegativeSize",
+			body:     strings.NewReader(`{"size": -1}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	var bulkBody strings.Builder
+	for i := 0; i < 25; i++ {
+		bulkBody.WriteString(fmt.Sprintf("{\"index\": {\"_id\": \"%03d\"}}\n{\"code\": \"%03d\"}\n", i, i))
+	}
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body:  strings.NewReader(bulkBody.String()),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"products-test"},
+				Body:  subtest.body,
+				From:  subtest.from,
+				Size:  subtest.size,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+			assert.Len(t, searchResponse.Hits.Hits, subtest.hits)
+			assert.Equal(t, subtest.total, searchResponse.Hits.Total)
+			assert.Equal(t, subtest.firstId, searchResponse.Hits.Hits[0].Id)
+			assert.Equal(t, 1, searchResponse.Shards.Total)
+		})
+	}
+}
+
+func TestSearchSortRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		sort     []string
+		expected int
+		ids      []string
+		sortKeys []interface{}
+	}{
+		{
+			name:     "SortByFieldAsc",
+			body:     strings.NewReader(`{"sort": ["price"]}`),
+			expected: 200,
+			ids:      []string{"004", "002", "003", "001"},
+			sortKeys: []interface{}{float64(45)},
+		},
+		{
+			name:     "SortByFieldDesc",
+			body:     strings.NewReader(`{"sort": [{"price": "desc"}]}`),
+			expected: 200,
+			ids:      []string{"001", "003", "002", "004"},
+			sortKeys: []interface{}{899.5},
+		},
+		{
+			name:     "SortByTwoFields",
+			body:     strings.NewReader(`{"sort": [{"category.keyword": {"order": "asc"}}, {"price": {"order": "desc"}}]}`),
+			expected: 200,
+			ids:      []string{"004", "001", "002", "003"},
+			sortKeys: []interface{}{"chairs", float64(45)},
+		},
+		{
+			name:     "SortMissingFirst",
+			body:     strings.NewReader(`{"sort": [{"stock.units": {"order": "desc", "missing": "_first"}}]}`),
+			expected: 200,
+			ids:      []string{"003", "004", "001", "002"},
+			sortKeys: []interface{}{nil},
+		},
+		{
+			name:     "SortMode",
+			body:     strings.NewReader(`{"sort": [{"ratings": {"order": "desc", "mode": "avg"}}, "_doc"]}`),
+			expected: 200,
+			ids:      []string{"002", "001", "003", "004"},
+			sortKeys: []interface{}{float64(4), float64(1)},
+		},
+		{
+			name:     "SortByScoreAndDoc",
+			body:     strings.NewReader(`{"query": {"match": {"name": "red sofa"}}, "sort": ["_score", {"_doc": "desc"}]}`),
+			expected: 200,
+			ids:      []string{"001", "004", "002"},
+			sortKeys: []interface{}{0.6301337480545044, float64(0)},
+		},
+		{
+			name:     "SortQueryStringParam",
+			body:     strings.NewReader(`{}`),
+			sort:     []string{"category.keyword:desc", "price:asc"},
+			expected: 200,
+			ids:      []string{"003", "002", "001", "004"},
+			sortKeys: []interface{}{"tables", float64(650)},
+		},
+		{
+			name:     "SortTextField",
+			body:     strings.NewReader(`{"sort": [{"category": "asc"}]}`),
+			expected: 400,
+		},
+		{
+			name:     "SortDateField",
+			body:     strings.NewReader(`{"sort": [{"created": "desc"}]}`),
+			expected: 200,
+			ids:      []string{"002", "001", "003", "004"},
+			sortKeys: []interface{}{float64(1675209600000)},
+		},
+		{
+			name:     "SearchAfterDate",
+			body:     strings.NewReader(`{"sort": [{"created": "desc"}], "search_after": ["2023-01-15"]}`),
+			expected: 200,
+			ids:      []string{"003", "004"},
+			sortKeys: []interface{}{float64(1668902400000)},
+		},
+		{
+			name:     "SearchAfterMalformedDate",
+			body:     strings.NewReader(`{"sort": [{"created": "desc"}], "search_after": ["yesterday"]}`),
+			expected: 400,
+		},
+		{
+			name:     "SortUnmappedField",
+			body:     strings.NewReader(`{"sort": [{"discount": "desc"}]}`),
+			expected: 400,
+		},
+		{
+			name:     "SortUnmappedFieldWithUnmappedType",
+			body:     strings.NewReader(`{"sort": [{"discount": {"order": "desc", "unmapped_type": "long"}}]}`),
+			expected: 200,
+			ids:      []string{"001", "002", "003", "004"},
+			sortKeys: []interface{}{nil},
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body: strings.NewReader(
+			"{\"index\": {\"_id\": \"001\"}}\n" +
+				"{\"name\": \"Red leather sofa\", \"category\": \"sofas\", \"price\": 899.5, \"created\": \"2023-01-15\", \"ratings\": [5, 1], \"stock\": {\"units\": 3}}\n" +
+				"{\"index\": {\"_id\": \"002\"}}\n" +
+				"{\"name\": \"Blue fabric sofa\", \"category\": \"sofas\", \"price\": 499, \"created\": \"2023-02-01\", \"ratings\": [4, 4], \"stock\": {\"units\": 0}}\n" +
+				"{\"index\": {\"_id\": \"003\"}}\n" +
+				"{\"name\": \"Oak dining table\", \"category\": \"tables\", \"price\": 650, \"created\": \"2022-11-20\", \"ratings\": [2]}\n" +
+				"{\"index\": {\"_id\": \"004\"}}\n" +
+				"{\"name\": \"Red plastic chair\", \"category\": \"chairs\", \"price\": 45, \"created\": \"2022-06-30\", \"stock\": {\"units\": 12}}\n"),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"products-test"},
+				Body:  subtest.body,
+				Sort:  subtest.sort,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			ids := make([]string, 0)
+			for _, hit := range searchResponse.Hits.Hits {
+				ids = append(ids, hit.Id)
+			}
+			assert.Equal(t, subtest.ids, ids)
+			assert.Equal(t, subtest.sortKeys, searchResponse.Hits.Hits[0].Sort)
+		})
+	}
+
+	t.Run("SortMappedFieldOfEmptyIndex", func(t *testing.T) {
+		createReq := esapi.IndicesCreateRequest{
+			Index: "empty-products-test",
+			Body:  strings.NewReader(`{"mappings": {"properties": {"price": {"type": "long"}}}}`),
+		}
+
+		createRes, err := createReq.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		defer createRes.Body.Close()
+
+		assert.Equal(t, 200, createRes.StatusCode)
+
+		req := esapi.SearchRequest{
+			Index: []string{"empty-products-test"},
+			Body:  strings.NewReader(`{"sort": [{"price": "asc"}]}`),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, 200, res.StatusCode)
+	})
+}
+
+func TestPointInTimeRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+	}{
+		{
+			name:      "OpenPointInTimeIndexNotFound",
+			indexName: "products-test-not-found",
+		},
+		{
+			name:      "SearchAfterWithoutPointInTime",
+			indexName: "products-test",
+		},
+		{
+			name:      "SearchAfterWithoutSort",
+			indexName: "products-test",
+		},
+		{
+			name:      "PointInTimeWithIndex",
+			indexName: "products-test",
+		},
+		{
+			name: "SearchAfterWithPointInTime",
+		},
+		{
+			name: "MatchWithPointInTime",
+		},
+		{
+			name: "ClosePointInTime",
+		},
+		{
+			name: "SearchClosedPointInTime",
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	var bulkBody strings.Builder
+	for i := 0; i < 25; i++ {
+		bulkBody.WriteString(fmt.Sprintf("{\"index\": {\"_id\": \"%03d\"}}\n{\"code\": \"%03d\", \"family\": %d}\n", i, i, i%3))
+	}
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body:  strings.NewReader(bulkBody.String()),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	pitReq := esapi.OpenPointInTimeRequest{
+		Index:     []string{"products-test"},
+		KeepAlive: "1m",
+	}
+
+	pitRes, err := pitReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer pitRes.Body.Close()
+
+	assert.Equal(t, 200, pitRes.StatusCode)
+
+	var pit elasticfacker.ElasticSearchPointInTimeFake
+	err = json.NewDecoder(pitRes.Body).Decode(&pit)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, pit.Id)
+
+	req = esapi.BulkRequest{
+		Index: "products-test",
+		Body:  strings.NewReader("{\"index\": {\"_id\": \"100\"}}\n{\"code\": \"100\", \"family\": 0}\n{\"delete\": {\"_id\": \"000\"}}\n"),
+	}
+
+	res, err = req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "OpenPointInTimeIndexNotFound":
+				req := esapi.OpenPointInTimeRequest{
+					Index:     []string{subtest.indexName},
+					KeepAlive: "1m",
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 404, res.StatusCode)
+			case "SearchAfterWithoutPointInTime":
+				req := esapi.SearchRequest{
+					Index: []string{subtest.indexName},
+					Body:  strings.NewReader(`{"size": 3, "sort": [{"family": "desc"}, {"code.keyword": "asc"}], "search_after": [2, "014"]}`),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, "017", searchResponse.Hits.Hits[0].Id)
+				assert.Equal(t, "020", searchResponse.Hits.Hits[1].Id)
+				assert.Equal(t, "023", searchResponse.Hits.Hits[2].Id)
+			case "SearchAfterWithoutSort":
+				req := esapi.SearchRequest{
+					Index: []string{subtest.indexName},
+					Body:  strings.NewReader(`{"search_after": ["014"]}`),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 400, res.StatusCode)
+			case "PointInTimeWithIndex":
+				req := esapi.SearchRequest{
+					Index: []string{subtest.indexName},
+					Body:  strings.NewReader(fmt.Sprintf(`{"pit": {"id": "%s"}}`, pit.Id)),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 400, res.StatusCode)
+			case "SearchAfterWithPointInTime":
+				ids := make([]string, 0)
+				var searchAfter []interface{}
+				for page := 0; page < 10; page++ {
+					body := map[string]interface{}{
+						"size": 10,
+						"sort": []interface{}{map[string]interface{}{"family": "asc"}},
+						"pit":  map[string]interface{}{"id": pit.Id, "keep_alive": "1m"},
+					}
+					if searchAfter != nil {
+						body["search_after"] = searchAfter
+					}
+					bodyJSON, _ := json.Marshal(body)
+
+					req := esapi.SearchRequest{
+						Body: strings.NewReader(string(bodyJSON)),
+					}
+
+					res, err := req.Do(context.Background(), esClient)
+					assert.Nil(t, err)
+					defer res.Body.Close()
+
+					assert.Equal(t, 200, res.StatusCode)
+
+					var searchResponse elasticfacker.ElasticSearchResponseFake
+					err = json.NewDecoder(res.Body).Decode(&searchResponse)
+					assert.Nil(t, err)
+					assert.Equal(t, pit.Id, searchResponse.PitId)
+					assert.Equal(t, 25, searchResponse.Hits.Total.Value)
+
+					if len(searchResponse.Hits.Hits) == 0 {
+						break
+					}
+					for _, hit := range searchResponse.Hits.Hits {
+						assert.Len(t, hit.Sort, 2)
+						ids = append(ids, hit.Id)
+					}
+					searchAfter = searchResponse.Hits.Hits[len(searchResponse.Hits.Hits)-1].Sort
+				}
+
+				assert.Len(t, ids, 25)
+				assert.Contains(t, ids, "000")
+				assert.NotContains(t, ids, "100")
+			case "MatchWithPointInTime":
+				// The deleted document is still found by its terms and scored with the statistics of the point in time.
+				req := esapi.SearchRequest{
+					Body: strings.NewReader(fmt.Sprintf(`{"query": {"bool": {"should": [{"match": {"code": "000"}}, {"match_phrase": {"code": "000"}}]}}, "pit": {"id": "%s"}}`, pit.Id)),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+				assert.Len(t, searchResponse.Hits.Hits, 1)
+				assert.Equal(t, "000", searchResponse.Hits.Hits[0].Id)
+				assert.InDelta(t, 2.5933, *searchResponse.Hits.Hits[0].Score, 0.0001)
+			case "ClosePointInTime":
+				req := esapi.ClosePointInTimeRequest{
+					Body: strings.NewReader(fmt.Sprintf(`{"id": "%s"}`, pit.Id)),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var closeResponse elasticfacker.ElasticSearchClearContextResponseFake
+				err = json.NewDecoder(res.Body).Decode(&closeResponse)
+				assert.Nil(t, err)
+				assert.True(t, closeResponse.Succeeded)
+				assert.Equal(t, 1, closeResponse.NumFreed)
+			case "SearchClosedPointInTime":
+				req := esapi.SearchRequest{
+					Body: strings.NewReader(fmt.Sprintf(`{"pit": {"id": "%s"}}`, pit.Id)),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 404, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestScrollRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+	}{
+		{
+			name:      "ScrollWithFrom",
+			indexName: "products-test",
+		},
+		{
+			name:      "ScrollAllPages",
+			indexName: "products-test",
+		},
+		{
+			name:      "ClearScroll",
+			indexName: "products-test",
+		},
+		{
+			name:      "ScrollExpiredContext",
+			indexName: "products-test",
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	var bulkBody strings.Builder
+	for i := 0; i < 25; i++ {
+		bulkBody.WriteString(fmt.Sprintf("{\"index\": {\"_id\": \"%03d\"}}\n{\"code\": \"%03d\"}\n", i, i))
+	}
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body:  strings.NewReader(bulkBody.String()),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "ScrollWithFrom":
+				from := 5
+				req := esapi.SearchRequest{
+					Index:  []string{subtest.indexName},
+					From:   &from,
+					Scroll: time.Minute,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 400, res.StatusCode)
+				assert.Equal(t, 0, esFacker.OpenScrollContexts())
+			case "ScrollAllPages":
+				size := 10
+				req := esapi.SearchRequest{
+					Index:  []string{subtest.indexName},
+					Size:   &size,
+					Sort:   []string{"code.keyword:asc"},
+					Scroll: time.Minute,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+				assert.NotEmpty(t, searchResponse.ScrollId)
+				assert.Equal(t, 25, searchResponse.Hits.Total.Value)
+				assert.Equal(t, 1, esFacker.OpenScrollContexts())
+
+				// Documents indexed after the scroll was opened are not returned by it.
+				indexReq := esapi.IndexRequest{
+					Index:      subtest.indexName,
+					DocumentID: "100",
+					Body:       strings.NewReader(`{"code": "100"}`),
+				}
+
+				indexRes, err := indexReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer indexRes.Body.Close()
+
+				ids := make([]string, 0)
+				for len(searchResponse.Hits.Hits) > 0 {
+					for _, hit := range searchResponse.Hits.Hits {
+						ids = append(ids, hit.Id)
+					}
+
+					scrollReq := esapi.ScrollRequest{
+						ScrollID: searchResponse.ScrollId,
+						Scroll:   time.Minute,
+					}
+
+					scrollRes, err := scrollReq.Do(context.Background(), esClient)
+					assert.Nil(t, err)
+					defer scrollRes.Body.Close()
+
+					assert.Equal(t, 200, scrollRes.StatusCode)
+
+					searchResponse = elasticfacker.ElasticSearchResponseFake{}
+					err = json.NewDecoder(scrollRes.Body).Decode(&searchResponse)
+					assert.Nil(t, err)
+				}
+
+				assert.Len(t, ids, 25)
+				assert.Equal(t, "000", ids[0])
+				assert.Equal(t, "024", ids[24])
+				assert.NotContains(t, ids, "100")
+			case "ClearScroll":
+				req := esapi.SearchRequest{
+					Index:  []string{subtest.indexName},
+					Scroll: time.Minute,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+
+				clearReq := esapi.ClearScrollRequest{
+					ScrollID: []string{"_all"},
+				}
+
+				clearRes, err := clearReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer clearRes.Body.Close()
+
+				assert.Equal(t, 200, clearRes.StatusCode)
+
+				var clearResponse elasticfacker.ElasticSearchClearContextResponseFake
+				err = json.NewDecoder(clearRes.Body).Decode(&clearResponse)
+				assert.Nil(t, err)
+				assert.True(t, clearResponse.Succeeded)
+				assert.Equal(t, 2, clearResponse.NumFreed)
+				assert.Equal(t, 0, esFacker.OpenScrollContexts())
+
+				scrollReq := esapi.ScrollRequest{
+					Body: strings.NewReader(fmt.Sprintf(`{"scroll_id": "%s"}`, searchResponse.ScrollId)),
+				}
+
+				scrollRes, err := scrollReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer scrollRes.Body.Close()
+
+				assert.Equal(t, 404, scrollRes.StatusCode)
+			case "ScrollExpiredContext":
+				req := esapi.SearchRequest{
+					Index:  []string{subtest.indexName},
+					Scroll: time.Millisecond,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				var searchResponse elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+
+				time.Sleep(10 * time.Millisecond)
+
+				scrollReq := esapi.ScrollRequest{
+					ScrollID: searchResponse.ScrollId,
+				}
+
+				scrollRes, err := scrollReq.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer scrollRes.Body.Close()
+
+				assert.Equal(t, 404, scrollRes.StatusCode)
+
+				var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+				err = json.NewDecoder(scrollRes.Body).Decode(&errorResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, "search_context_missing_exception", errorResponse.Error.Type)
+				assert.Equal(t, 0, esFacker.OpenScrollContexts())
+			}
+		})
+	}
+}
+
+func TestSearchAggregationsRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+		body      string
+	}{
+		{
+			name:      "TermsWithSubAggregation",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"categories": {"terms": {"field": "category.keyword", "size": 2}, "aggs": {"brands": {"terms": {"field": "brand.keyword"}}}}}}`,
+		},
+		{
+			name:      "TermsOrderAndMissing",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"brands": {"terms": {"field": "brand.keyword", "order": {"_key": "asc"}, "missing": "none", "min_doc_count": 2}}}}`,
+		},
+		{
+			name:      "Range",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"prices": {"range": {"field": "price", "ranges": [{"from": 100, "to": 200}, {"to": 100}, {"from": 200, "key": "expensive"}]}}}}`,
+		},
+		{
+			name:      "Histogram",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"prices": {"histogram": {"field": "price", "interval": 100}}}}`,
+		},
+		{
+			name:      "DateHistogramCalendarInterval",
+			indexName: "products-test",
+			body:      `{"size": 0, "query": {"term": {"category": "phone"}}, "aggs": {"months": {"date_histogram": {"field": "created", "calendar_interval": "month", "time_zone": "+01:00", "format": "yyyy-MM"}}}}`,
+		},
+		{
+			name:      "DateHistogramFixedInterval",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"periods": {"date_histogram": {"field": "created", "fixed_interval": "30d", "min_doc_count": 1}}}}`,
+		},
+		{
+			name:      "DateHistogramWithoutInterval",
+			indexName: "products-test",
+			body:      `{"aggs": {"months": {"date_histogram": {"field": "created"}}}}`,
+		},
+		{
+			name:      "TermsOnTextField",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"categories": {"terms": {"field": "category"}}}}`,
+		},
+		{
+			name:      "UnknownAggregation",
+			indexName: "products-test",
+			body:      `{"aggs": {"unknown": {"not_an_aggregation": {"field": "created"}}}}`,
+		},
+		{
+			name:      "SingleValueMetrics",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"avg_price": {"avg": {"field": "price"}}, "total": {"sum": {"field": "price"}}, "cheapest": {"min": {"field": "price"}}, "latest": {"max": {"field": "created"}}, "brands": {"value_count": {"field": "brand.keyword"}}, "distinct_brands": {"cardinality": {"field": "brand.keyword"}}}}`,
+		},
+		{
+			name:      "Stats",
+			indexName: "products-test",
+			body:      `{"size": 0, "query": {"term": {"category": "phone"}}, "aggs": {"price_stats": {"stats": {"field": "price"}}, "price_extended_stats": {"extended_stats": {"field": "price"}}}}`,
+		},
+		{
+			name:      "Percentiles",
+			indexName: "products-test",
+			body:      `{"size": 0, "query": {"term": {"category": "phone"}}, "aggs": {"price_percentiles": {"percentiles": {"field": "price", "percents": [50, 75]}}}}`,
+		},
+		{
+			name:      "TermsOrderedByMetricWithTopHits",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"categories": {"terms": {"field": "category.keyword", "order": {"avg_price": "desc"}}, "aggs": {"avg_price": {"avg": {"field": "price"}}, "cheapest": {"top_hits": {"size": 1, "sort": [{"price": "asc"}]}}}}}}`,
+		},
+		{
+			name:      "FilterAndFilters",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"phones": {"filter": {"term": {"category": "phone"}}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}, "groups": {"filters": {"other_bucket_key": "others", "filters": {"cheap": {"range": {"price": {"lt": 100}}}, "expensive": {"range": {"price": {"gte": 1000}}}}}}}}`,
+		},
+		{
+			name:      "GlobalAndMissing",
+			indexName: "products-test",
+			body:      `{"size": 0, "query": {"term": {"category": "laptop"}}, "aggs": {"all_products": {"global": {}, "aggs": {"avg_price": {"avg": {"field": "price"}}}}, "without_brand": {"missing": {"field": "brand.keyword"}}}}`,
+		},
+		{
+			name:      "NestedAndReverseNested",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"reviews": {"nested": {"path": "reviews"}, "aggs": {"avg_stars": {"avg": {"field": "reviews.stars"}}, "authors": {"terms": {"field": "reviews.author.keyword"}, "aggs": {"products": {"reverse_nested": {}, "aggs": {"categories": {"terms": {"field": "category.keyword"}}}}}}}}}}`,
+		},
+		{
+			name:      "ParentPipelines",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"months": {"date_histogram": {"field": "created", "calendar_interval": "month"}, "aggs": {"sales": {"sum": {"field": "price"}}, "cumulative_sales": {"cumulative_sum": {"buckets_path": "sales"}}, "sales_change": {"derivative": {"buckets_path": "sales"}}, "big_months": {"bucket_selector": {"buckets_path": {"total": "sales"}, "script": "params.total > 300"}}, "top_months": {"bucket_sort": {"sort": [{"sales": {"order": "desc"}}], "size": 1}}}}}}`,
+		},
+		{
+			name:      "PipelineAtTopLevel",
+			indexName: "products-test",
+			body:      `{"size": 0, "aggs": {"sales": {"cumulative_sum": {"buckets_path": "price"}}}}`,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.BulkRequest{
+		Index: "products-test",
+		Body: strings.NewReader(`{"index": {"_id": "1"}}
+{"name": "phone one", "category": "phone", "brand": "acme", "price": 50.0, "created": "2023-01-10T10:00:00Z", "reviews": [{"author": "ann", "stars": 5}, {"author": "bob", "stars": 3}]}
+{"index": {"_id": "2"}}
+{"name": "phone two", "category": "phone", "brand": "acme", "price": 150, "created": "2023-01-31T23:30:00Z"}
+{"index": {"_id": "3"}}
+{"name": "phone three", "category": "phone", "brand": "globex", "price": 350, "created": "2023-03-05T08:00:00Z"}
+{"index": {"_id": "4"}}
+{"name": "laptop one", "category": "laptop", "brand": "globex", "price": 1200, "created": "2023-02-01T12:00:00Z", "reviews": [{"author": "ann", "stars": 4}]}
+{"index": {"_id": "5"}}
+{"name": "laptop two", "category": "laptop", "price": 199.99, "created": "2023-02-14T12:00:00Z"}
+{"index": {"_id": "6"}}
+{"name": "cable", "category": "accessory", "price": 9.5, "created": "2023-03-20T12:00:00Z"}
+`),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{subtest.indexName},
+				Body:  strings.NewReader(subtest.body),
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			if res.StatusCode == 200 {
+				err = json.NewDecoder(res.Body).Decode(&searchResponse)
+				assert.Nil(t, err)
+			}
+
+			buckets := func(name string) []interface{} {
+				aggregation, _ := searchResponse.Aggregations[name].(map[string]interface{})
+				buckets, _ := aggregation["buckets"].([]interface{})
+				return buckets
+			}
+			bucket := func(buckets []interface{}, position int) map[string]interface{} {
+				if position >= len(buckets) {
+					return map[string]interface{}{}
+				}
+				return buckets[position].(map[string]interface{})
+			}
+
+			switch subtest.name {
+			case "TermsWithSubAggregation":
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Len(t, searchResponse.Hits.Hits, 0)
+
+				categories := buckets("categories")
+				assert.Len(t, categories, 2)
+				assert.Equal(t, "phone", bucket(categories, 0)["key"])
+				assert.Equal(t, float64(3), bucket(categories, 0)["doc_count"])
+				assert.Equal(t, "laptop", bucket(categories, 1)["key"])
+				assert.Equal(t, float64(1), searchResponse.Aggregations["categories"].(map[string]interface{})["sum_other_doc_count"])
+
+				brands := bucket(categories, 0)["brands"].(map[string]interface{})["buckets"].([]interface{})
+				assert.Len(t, brands, 2)
+				assert.Equal(t, "acme", bucket(brands, 0)["key"])
+				assert.Equal(t, float64(2), bucket(brands, 0)["doc_count"])
+			case "TermsOrderAndMissing":
+				assert.Equal(t, 200, res.StatusCode)
+
+				brands := buckets("brands")
+				assert.Len(t, brands, 3)
+				assert.Equal(t, "acme", bucket(brands, 0)["key"])
+				assert.Equal(t, "globex", bucket(brands, 1)["key"])
+				assert.Equal(t, "none", bucket(brands, 2)["key"])
+				assert.Equal(t, float64(2), bucket(brands, 2)["doc_count"])
+			case "Range":
+				assert.Equal(t, 200, res.StatusCode)
+
+				prices := buckets("prices")
+				assert.Len(t, prices, 3)
+				assert.Equal(t, "*-100.0", bucket(prices, 0)["key"])
+				assert.Equal(t, float64(2), bucket(prices, 0)["doc_count"])
+				assert.Equal(t, "100.0-200.0", bucket(prices, 1)["key"])
+				assert.Equal(t, float64(2), bucket(prices, 1)["doc_count"])
+				assert.Equal(t, "expensive", bucket(prices, 2)["key"])
+				assert.Equal(t, float64(200), bucket(prices, 2)["from"])
+				assert.Equal(t, float64(2), bucket(prices, 2)["doc_count"])
+			case "Histogram":
+				assert.Equal(t, 200, res.StatusCode)
+
+				prices := buckets("prices")
+				assert.Len(t, prices, 13)
+				assert.Equal(t, float64(0), bucket(prices, 0)["key"])
+				assert.Equal(t, float64(2), bucket(prices, 0)["doc_count"])
+				assert.Equal(t, float64(200), bucket(prices, 2)["key"])
+				assert.Equal(t, float64(0), bucket(prices, 2)["doc_count"])
+				assert.Equal(t, float64(1200), bucket(prices, 12)["key"])
+				assert.Equal(t, float64(1), bucket(prices, 12)["doc_count"])
+			case "DateHistogramCalendarInterval":
+				assert.Equal(t, 200, res.StatusCode)
+
+				months := buckets("months")
+				assert.Len(t, months, 3)
+				assert.Equal(t, "2023-01", bucket(months, 0)["key_as_string"])
+				assert.Equal(t, float64(1), bucket(months, 0)["doc_count"])
+				assert.Equal(t, "2023-02", bucket(months, 1)["key_as_string"])
+				assert.Equal(t, float64(1), bucket(months, 1)["doc_count"])
+				assert.Equal(t, "2023-03", bucket(months, 2)["key_as_string"])
+				assert.Equal(t, float64(1675206000000), bucket(months, 1)["key"])
+			case "DateHistogramFixedInterval":
+				assert.Equal(t, 200, res.StatusCode)
+
+				periods := buckets("periods")
+				assert.Len(t, periods, 3)
+				assert.Equal(t, "2022-12-24T00:00:00.000Z", bucket(periods, 0)["key_as_string"])
+				assert.Equal(t, float64(1), bucket(periods, 0)["doc_count"])
+				assert.Equal(t, "2023-01-23T00:00:00.000Z", bucket(periods, 1)["key_as_string"])
+				assert.Equal(t, float64(3), bucket(periods, 1)["doc_count"])
+				assert.Equal(t, float64(2), bucket(periods, 2)["doc_count"])
+			case "DateHistogramWithoutInterval", "UnknownAggregation":
+				assert.Equal(t, 400, res.StatusCode)
+			case "TermsOnTextField":
+				assert.Equal(t, 400, res.StatusCode)
+
+				var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+				err = json.NewDecoder(res.Body).Decode(&errorResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, "illegal_argument_exception", errorResponse.Error.Type)
+				assert.Contains(t, errorResponse.Error.Reason, "fielddata=true on [category]")
+			case "SingleValueMetrics":
+				assert.Equal(t, 200, res.StatusCode)
+
+				value := func(name string) interface{} {
+					return searchResponse.Aggregations[name].(map[string]interface{})["value"]
+				}
+				assert.InDelta(t, 326.58, value("avg_price"), 0.01)
+				assert.InDelta(t, 1959.49, value("total"), 0.01)
+				assert.Equal(t, 9.5, value("cheapest"))
+				assert.Equal(t, "2023-03-20T12:00:00.000Z", searchResponse.Aggregations["latest"].(map[string]interface{})["value_as_string"])
+				assert.Equal(t, float64(4), value("brands"))
+				assert.Equal(t, float64(2), value("distinct_brands"))
+			case "Stats":
+				assert.Equal(t, 200, res.StatusCode)
+
+				stats := searchResponse.Aggregations["price_stats"].(map[string]interface{})
+				assert.Equal(t, float64(3), stats["count"])
+				assert.Equal(t, float64(50), stats["min"])
+				assert.Equal(t, float64(350), stats["max"])
+				assert.Equal(t, float64(550), stats["sum"])
+
+				extendedStats := searchResponse.Aggregations["price_extended_stats"].(map[string]interface{})
+				assert.Equal(t, float64(147500), extendedStats["sum_of_squares"])
+				assert.InDelta(t, 124.72, extendedStats["std_deviation"], 0.01)
+				assert.InDelta(t, 23333.33, extendedStats["variance_sampling"], 0.01)
+				assert.InDelta(t, 183.33+2*124.72, extendedStats["std_deviation_bounds"].(map[string]interface{})["upper"], 0.01)
+			case "Percentiles":
+				assert.Equal(t, 200, res.StatusCode)
+
+				percentiles := searchResponse.Aggregations["price_percentiles"].(map[string]interface{})["values"].(map[string]interface{})
+				assert.Equal(t, float64(150), percentiles["50.0"])
+				assert.Equal(t, float64(250), percentiles["75.0"])
+			case "TermsOrderedByMetricWithTopHits":
+				assert.Equal(t, 200, res.StatusCode)
+
+				categories := buckets("categories")
+				assert.Len(t, categories, 3)
+				assert.Equal(t, "laptop", bucket(categories, 0)["key"])
+				assert.Equal(t, "phone", bucket(categories, 1)["key"])
+				assert.Equal(t, "accessory", bucket(categories, 2)["key"])
+
+				topHits := bucket(categories, 0)["cheapest"].(map[string]interface{})["hits"].(map[string]interface{})
+				assert.Equal(t, float64(2), topHits["total"].(map[string]interface{})["value"])
+				hits := topHits["hits"].([]interface{})
+				assert.Len(t, hits, 1)
+				assert.Equal(t, "5", hits[0].(map[string]interface{})["_id"])
+			case "FilterAndFilters":
+				assert.Equal(t, 200, res.StatusCode)
+
+				phones := searchResponse.Aggregations["phones"].(map[string]interface{})
+				assert.Equal(t, float64(3), phones["doc_count"])
+				assert.InDelta(t, 183.33, phones["avg_price"].(map[string]interface{})["value"], 0.01)
+
+				groups := searchResponse.Aggregations["groups"].(map[string]interface{})["buckets"].(map[string]interface{})
+				assert.Len(t, groups, 3)
+				assert.Equal(t, float64(2), groups["cheap"].(map[string]interface{})["doc_count"])
+				assert.Equal(t, float64(1), groups["expensive"].(map[string]interface{})["doc_count"])
+				assert.Equal(t, float64(3), groups["others"].(map[string]interface{})["doc_count"])
+			case "GlobalAndMissing":
+				assert.Equal(t, 200, res.StatusCode)
+				assert.Equal(t, 2, searchResponse.Hits.Total.Value)
+
+				allProducts := searchResponse.Aggregations["all_products"].(map[string]interface{})
+				assert.Equal(t, float64(6), allProducts["doc_count"])
+				assert.InDelta(t, 326.58, allProducts["avg_price"].(map[string]interface{})["value"], 0.01)
+				assert.Equal(t, float64(1), searchResponse.Aggregations["without_brand"].(map[string]interface{})["doc_count"])
+			case "NestedAndReverseNested":
+				assert.Equal(t, 200, res.StatusCode)
+
+				reviews := searchResponse.Aggregations["reviews"].(map[string]interface{})
+				assert.Equal(t, float64(3), reviews["doc_count"])
+				assert.Equal(t, float64(4), reviews["avg_stars"].(map[string]interface{})["value"])
+
+				authors := reviews["authors"].(map[string]interface{})["buckets"].([]interface{})
+				assert.Len(t, authors, 2)
+				assert.Equal(t, "ann", bucket(authors, 0)["key"])
+				assert.Equal(t, float64(2), bucket(authors, 0)["doc_count"])
+
+				products := bucket(authors, 0)["products"].(map[string]interface{})
+				assert.Equal(t, float64(2), products["doc_count"])
+				assert.Len(t, products["categories"].(map[string]interface{})["buckets"], 2)
+			case "ParentPipelines":
+				assert.Equal(t, 200, res.StatusCode)
+
+				months := buckets("months")
+				assert.Len(t, months, 1)
+				assert.Equal(t, "2023-02-01T00:00:00.000Z", bucket(months, 0)["key_as_string"])
+				assert.InDelta(t, 1399.99, bucket(months, 0)["sales"].(map[string]interface{})["value"], 0.001)
+				assert.InDelta(t, 1599.99, bucket(months, 0)["cumulative_sales"].(map[string]interface{})["value"], 0.001)
+				assert.InDelta(t, 1199.99, bucket(months, 0)["sales_change"].(map[string]interface{})["value"], 0.001)
+			case "PipelineAtTopLevel":
+				assert.Equal(t, 400, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestMappingRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+		body      *strings.Reader
+		expected  int
+	}{
+		{
+			name:      "GetMapping",
+			indexName: "products-test",
+			expected:  200,
+		},
+		{
+			name:      "PutMappingNewField",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"properties": {"stock": {"type": "integer"}, "name": {"type": "text", "fields": {"raw": {"type": "keyword", "ignore_above": 100}}}}}`),
+			expected:  200,
+		},
+		{
+			name:      "PutMappingTypeChange",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"properties": {"price": {"type": "keyword"}}}`),
+			expected:  400,
+		},
+		{
+			name:      "PutMappingParameterChange",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"properties": {"created": {"type": "date", "format": "epoch_millis"}}}`),
+			expected:  400,
+		},
+		{
+			name:      "PutMappingUnknownType",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"properties": {"color": {"type": "colour"}}}`),
+			expected:  400,
+		},
+		{
+			name:      "GetFieldMapping",
+			indexName: "products-test",
+			expected:  200,
+		},
+		{
+			name:      "GetMappingMissingIndex",
+			indexName: "unknown-test",
+			expected:  404,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.IndicesCreateRequest{
+		Index: "products-test",
+		Body: strings.NewReader(`{
+			"settings": {"number_of_shards": 1},
+			"mappings": {
+				"properties": {
+					"name": {"type": "text"},
+					"price": {"type": "double"},
+					"created": {"type": "date", "format": "yyyy-MM-dd"},
+					"brand": {"properties": {"name": {"type": "keyword"}}}
+				}
+			}
+		}`),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	getMapping := func(indexName string) map[string]interface{} {
+		req := esapi.IndicesGetMappingRequest{
+			Index: []string{indexName},
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		var mappings map[string]map[string]map[string]interface{}
+		err = json.NewDecoder(res.Body).Decode(&mappings)
+		assert.Nil(t, err)
+		return mappings[indexName]["mappings"]["properties"].(map[string]interface{})
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "GetMapping":
+				properties := getMapping(subtest.indexName)
+				assert.Len(t, properties, 4)
+				assert.Equal(t, map[string]interface{}{"type": "date", "format": "yyyy-MM-dd"}, properties["created"])
+				assert.Equal(t, map[string]interface{}{"properties": map[string]interface{}{"name": map[string]interface{}{"type": "keyword"}}}, properties["brand"])
+			case "GetMappingMissingIndex":
+				req := esapi.IndicesGetMappingRequest{
+					Index: []string{subtest.indexName},
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, subtest.expected, res.StatusCode)
+			case "GetFieldMapping":
+				req := esapi.IndicesGetFieldMappingRequest{
+					Index:  []string{subtest.indexName},
+					Fields: []string{"name*", "brand.name"},
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, subtest.expected, res.StatusCode)
+
+				var fieldMappings map[string]map[string]map[string]elasticfacker.ElasticSearchFieldMappingFake
+				err = json.NewDecoder(res.Body).Decode(&fieldMappings)
+				assert.Nil(t, err)
+
+				fields := fieldMappings[subtest.indexName]["mappings"]
+				assert.Len(t, fields, 3)
+				assert.Equal(t, "name.raw", fields["name.raw"].FullName)
+				assert.Equal(t, map[string]interface{}{"type": "keyword", "ignore_above": float64(100)}, fields["name.raw"].Mapping["raw"])
+				assert.Equal(t, map[string]interface{}{"type": "keyword"}, fields["brand.name"].Mapping["name"])
+			default:
+				req := esapi.IndicesPutMappingRequest{
+					Index: []string{subtest.indexName},
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, subtest.expected, res.StatusCode)
+
+				var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+				switch subtest.name {
+				case "PutMappingNewField":
+					properties := getMapping(subtest.indexName)
+					assert.Equal(t, map[string]interface{}{"type": "integer"}, properties["stock"])
+					assert.Contains(t, properties["name"], "fields")
+				case "PutMappingTypeChange":
+					err = json.NewDecoder(res.Body).Decode(&errorResponse)
+					assert.Nil(t, err)
+					assert.Equal(t, "illegal_argument_exception", errorResponse.Error.Type)
+					assert.Equal(t, "mapper [price] cannot be changed from type [double] to [keyword]", errorResponse.Error.Reason)
+				case "PutMappingParameterChange":
+					err = json.NewDecoder(res.Body).Decode(&errorResponse)
+					assert.Nil(t, err)
+					assert.Equal(t, "illegal_argument_exception", errorResponse.Error.Type)
+					assert.Contains(t, errorResponse.Error.Reason, "Cannot update parameter [format] from [yyyy-MM-dd] to [epoch_millis]")
+				case "PutMappingUnknownType":
+					err = json.NewDecoder(res.Body).Decode(&errorResponse)
+					assert.Nil(t, err)
+					assert.Equal(t, "mapper_parsing_exception", errorResponse.Error.Type)
+					assert.NotContains(t, getMapping(subtest.indexName), "color")
+				}
+			}
+		})
+	}
+}
+
+func TestDynamicMappingRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+		mappings  string
+		body      *strings.Reader
+		expected  int
+	}{
+		{
+			name:      "DynamicInference",
+			indexName: "products-dynamic-test",
+			body:      strings.NewReader(`{"name": "Phone X", "stock": 5, "price": 99.5, "weight": 10.0, "available": true, "created": "2023-01-15T10:00:00Z", "released": "2023/01/15", "code": "42", "tags": [null, "new"], "brand": {"name": "Acme"}, "empty": null}`),
+			expected:  201,
+		},
+		{
+			name:      "DynamicStrict",
+			indexName: "products-strict-test",
+			mappings:  `{"dynamic": "strict", "properties": {"name": {"type": "text"}}}`,
+			body:      strings.NewReader(`{"name": "Phone X", "price": 99.5}`),
+			expected:  400,
+		},
+		{
+			name:      "DynamicStrictInnerObject",
+			indexName: "products-strict-inner-test",
+			mappings:  `{"properties": {"brand": {"dynamic": "strict", "properties": {"name": {"type": "keyword"}}}}}`,
+			body:      strings.NewReader(`{"stock": 5, "brand": {"name": "Acme", "country": "ES"}}`),
+			expected:  400,
+		},
+		{
+			name:      "DynamicFalse",
+			indexName: "products-false-test",
+			mappings:  `{"dynamic": false, "properties": {"name": {"type": "text"}}}`,
+			body:      strings.NewReader(`{"name": "Phone X", "price": 99.5}`),
+			expected:  201,
+		},
+		{
+			name:      "DynamicRuntime",
+			indexName: "products-runtime-test",
+			mappings:  `{"dynamic": "runtime"}`,
+			body:      strings.NewReader(`{"name": "Phone X", "price": 99.0, "brand": {"name": "Acme"}}`),
+			expected:  201,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			if subtest.mappings != "" {
+				req := esapi.IndicesCreateRequest{
+					Index: subtest.indexName,
+					Body:  strings.NewReader(`{"mappings": ` + subtest.mappings + `}`),
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, 200, res.StatusCode)
+			}
+
+			req := esapi.IndexRequest{
+				Index:      subtest.indexName,
+				DocumentID: "1",
+				Body:       subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+
+			mappingReq := esapi.IndicesGetMappingRequest{
+				Index: []string{subtest.indexName},
+			}
+
+			mappingRes, err := mappingReq.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer mappingRes.Body.Close()
+
+			var mappings map[string]map[string]map[string]interface{}
+			err = json.NewDecoder(mappingRes.Body).Decode(&mappings)
+			assert.Nil(t, err)
+			mapping := mappings[subtest.indexName]["mappings"]
+			properties, _ := mapping["properties"].(map[string]interface{})
+
+			switch subtest.name {
+			case "DynamicInference":
+				assert.Len(t, properties, 10)
+				assert.Equal(t, map[string]interface{}{"type": "long"}, properties["stock"])
+				assert.Equal(t, map[string]interface{}{"type": "float"}, properties["price"])
+				// A number written with a decimal part is a float even when the part is zero.
+				assert.Equal(t, map[string]interface{}{"type": "float"}, properties["weight"])
+				assert.Equal(t, map[string]interface{}{"type": "boolean"}, properties["available"])
+				assert.Equal(t, map[string]interface{}{"type": "date"}, properties["created"])
+				assert.Equal(t, map[string]interface{}{"type": "date", "format": "yyyy/MM/dd HH:mm:ss||yyyy/MM/dd"}, properties["released"])
+				assert.Equal(t, "text", properties["code"].(map[string]interface{})["type"])
+				assert.Equal(t, map[string]interface{}{
+					"type": "text",
+					"fields": map[string]interface{}{
+						"keyword": map[string]interface{}{"type": "keyword", "ignore_above": float64(256)},
+					},
+				}, properties["tags"])
+				assert.Equal(t, "keyword", properties["brand"].(map[string]interface{})["properties"].(map[string]interface{})["name"].(map[string]interface{})["fields"].(map[string]interface{})["keyword"].(map[string]interface{})["type"])
+				assert.NotContains(t, properties, "empty")
+			case "DynamicStrict", "DynamicStrictInnerObject":
+				var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+				err = json.NewDecoder(res.Body).Decode(&errorResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, "strict_dynamic_mapping_exception", errorResponse.Error.Type)
+
+				// A rejected document adds no field to the mapping.
+				assert.NotContains(t, properties, "price")
+				assert.NotContains(t, properties, "stock")
+				if subtest.name == "DynamicStrictInnerObject" {
+					assert.Equal(t, "mapping set to strict, dynamic introduction of [country] within [brand] is not allowed", errorResponse.Error.Reason)
+				}
+			case "DynamicFalse":
+				assert.Len(t, properties, 1)
+				assert.NotContains(t, properties, "price")
+			case "DynamicRuntime":
+				assert.Nil(t, properties)
+				assert.Equal(t, map[string]interface{}{
+					"name":       map[string]interface{}{"type": "keyword"},
+					"price":      map[string]interface{}{"type": "double"},
+					"brand.name": map[string]interface{}{"type": "keyword"},
+				}, mapping["runtime"])
+			}
+		})
+	}
+}
+
+func TestFieldTypesRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		hits     int
+	}{
+		{
+			name:     "TermOnTextMatchesTokens",
+			body:     strings.NewReader(`{"query": {"term": {"name": "phone"}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "TermOnTextIsNotAnalysed",
+			body:     strings.NewReader(`{"query": {"term": {"name": "Phone X"}}}`),
+			expected: 200,
+			hits:     0,
+		},
+		{
+			name:     "TermOnKeywordIsExact",
+			body:     strings.NewReader(`{"query": {"term": {"sku": "ab-1"}}}`),
+			expected: 200,
+			hits:     0,
+		},
+		{
+			name:     "MatchOnKeywordIsExact",
+			body:     strings.NewReader(`{"query": {"match": {"name.keyword": "Phone X"}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "MatchOnKeywordPartialValue",
+			body:     strings.NewReader(`{"query": {"match": {"sku": "AB"}}}`),
+			expected: 200,
+			hits:     0,
+		},
+		{
+			name:     "TermOnNumericCoercesStrings",
+			body:     strings.NewReader(`{"query": {"bool": {"filter": [{"term": {"price": "99.5"}}, {"term": {"stock": 5}}]}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "TermOnNumericInvalidValue",
+			body:     strings.NewReader(`{"query": {"term": {"price": "cheap"}}}`),
+			expected: 400,
+		},
+		{
+			name:     "MatchOnNumericLenient",
+			body:     strings.NewReader(`{"query": {"match": {"price": {"query": "cheap", "lenient": true}}}}`),
+			expected: 200,
+			hits:     0,
+		},
+		{
+			name:     "RangeDateMath",
+			body:     strings.NewReader(`{"query": {"range": {"created": {"gte": "now-1d/d", "lte": "now/d"}}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "RangeDateFormat",
+			body:     strings.NewReader(`{"query": {"range": {"created": {"gte": "01/01/2020", "lte": "2020-01-01", "format": "dd/MM/yyyy||yyyy-MM-dd"}}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "RangeDateRoundsUpInclusiveUpperBound",
+			body:     strings.NewReader(`{"query": {"range": {"created": {"gt": "2019-12-31", "lte": "2020-01-01"}}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "ExistsSkipsIgnoredMalformedValues",
+			body:     strings.NewReader(`{"query": {"exists": {"field": "weight"}}}`),
+			expected: 200,
+			hits:     1,
+		},
+		{
+			name:     "PrefixOnNumeric",
+			body:     strings.NewReader(`{"query": {"prefix": {"price": "9"}}}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.IndicesCreateRequest{
+		Index: "products-test",
+		Body: strings.NewReader(`{"mappings": {"properties": {
+			"name": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+			"sku": {"type": "keyword"},
+			"price": {"type": "double"},
+			"stock": {"type": "integer"},
+			"created": {"type": "date", "format": "yyyy-MM-dd||epoch_millis"},
+			"weight": {"type": "float", "ignore_malformed": true},
+			"code": {"type": "integer", "coerce": false}
+		}}}`),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	documents := []struct {
+		id       string
+		body     string
+		expected int
+	}{
+		{"1", `{"name": "Phone X", "sku": "AB-1", "price": 99.5, "stock": "5.7", "created": "` + time.Now().UTC().Format("2006-01-02") + `", "weight": 1.2, "code": 1}`, 201},
+		{"2", `{"name": "Laptop Pro", "sku": "CD-2", "price": 1200, "stock": 3, "created": "2020-01-01", "weight": "heavy", "code": 2}`, 201},
+		{"3", `{"name": "Tablet", "sku": "EF-3", "price": 300, "code": "3"}`, 400},
+		{"4", `{"name": "Watch", "sku": "GH-4", "price": "expensive"}`, 400},
+		{"5", `{"name": {"en": "Watch"}, "sku": "GH-5"}`, 400},
+		{"6", `{"name": "Watch", "sku": [{"code": "GH-6"}]}`, 400},
+		{"7", `{"name": "Watch", "weight": {"grams": 80}}`, 400},
+	}
+	for _, document := range documents {
+		req := esapi.IndexRequest{
+			Index:      "products-test",
+			DocumentID: document.id,
+			Body:       strings.NewReader(document.body),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, document.expected, res.StatusCode)
+		if document.expected != 201 {
+			var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+			err = json.NewDecoder(res.Body).Decode(&errorResponse)
+			assert.Nil(t, err)
+			assert.Equal(t, "mapper_parsing_exception", errorResponse.Error.Type)
+			if document.id == "5" {
+				assert.Equal(t, "failed to parse field [name] of type [text] in document with id '5'. Preview of field's value: 'map[en:Watch]'", errorResponse.Error.Reason)
+			}
+		}
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"products-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				var errorResponse elasticfacker.ElasticSearchErrorResponseFake
+				err = json.NewDecoder(res.Body).Decode(&errorResponse)
+				assert.Nil(t, err)
+				assert.Equal(t, "query_shard_exception", errorResponse.Error.Type)
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+			assert.Equal(t, subtest.hits, searchResponse.Hits.Total.Value)
+
+			switch subtest.name {
+			case "TermOnNumericCoercesStrings":
+				assert.Equal(t, "1", searchResponse.Hits.Hits[0].Id)
+			case "RangeDateFormat":
+				assert.Equal(t, "2", searchResponse.Hits.Hits[0].Id)
+			case "ExistsSkipsIgnoredMalformedValues":
+				assert.Equal(t, "1", searchResponse.Hits.Hits[0].Id)
+			}
+		})
+	}
+
+	searchReq := esapi.SearchRequest{
+		Index: []string{"products-test"},
+		Body:  strings.NewReader(`{"query": {"ids": {"values": ["2"]}}}`),
+	}
+
+	searchRes, err := searchReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer searchRes.Body.Close()
+
+	var searchResponse elasticfacker.ElasticSearchResponseFake
+	err = json.NewDecoder(searchRes.Body).Decode(&searchResponse)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"weight"}, searchResponse.Hits.Hits[0].Ignored)
+}
+
+func TestAnalyzeRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name      string
+		indexName string
+		body      *strings.Reader
+		expected  int
+		tokens    []string
+	}{
+		{
+			name:     "StandardAnalyzer",
+			body:     strings.NewReader(`{"analyzer": "standard", "text": "The QUICK brown-foxes, U.S.A. 3.14"}`),
+			expected: 200,
+			tokens:   []string{"the", "quick", "brown", "foxes", "u.s.a", "3.14"},
+		},
+		{
+			name:     "EnglishAnalyzer",
+			body:     strings.NewReader(`{"analyzer": "english", "text": "The foxes were running to their generalizations"}`),
+			expected: 200,
+			tokens:   []string{"fox", "were", "run", "gener"},
+		},
+		{
+			name:     "TokenizerAndFilters",
+			body:     strings.NewReader(`{"tokenizer": "whitespace", "filter": ["lowercase", "asciifolding", {"type": "edge_ngram", "min_gram": 2, "max_gram": 4}], "text": "Café"}`),
+			expected: 200,
+			tokens:   []string{"ca", "caf", "cafe"},
+		},
+		{
+			name:     "MultipleTexts",
+			body:     strings.NewReader(`{"analyzer": "whitespace", "text": ["one two", "three"]}`),
+			expected: 200,
+			tokens:   []string{"one", "two", "three"},
+		},
+		{
+			name:     "UnknownGlobalAnalyzer",
+			body:     strings.NewReader(`{"analyzer": "custom_synonyms", "text": "tv"}`),
+			expected: 400,
+		},
+		{
+			name:      "CustomAnalyzer",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"analyzer": "custom_synonyms", "text": "The TV"}`),
+			expected:  200,
+			tokens:    []string{"tv", "television"},
+		},
+		{
+			name:      "FieldAnalyzer",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"field": "name", "text": "Télévision"}`),
+			expected:  200,
+			tokens:    []string{"television", "tv"},
+		},
+		{
+			name:      "MatchUsesFieldAnalyzer",
+			indexName: "products-test",
+			body:      strings.NewReader(`{"query": {"match": {"name": "tv"}}}`),
+			expected:  200,
+		},
+		{
+			name:      "MissingIndex",
+			indexName: "unknown-test",
+			body:      strings.NewReader(`{"text": "tv"}`),
+			expected:  404,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	req := esapi.IndicesCreateRequest{
+		Index: "products-test",
+		Body: strings.NewReader(`{
+			"settings": {
+				"analysis": {
+					"analyzer": {
+						"custom_synonyms": {"tokenizer": "standard", "filter": ["lowercase", "asciifolding", "stop", "tv_synonyms"]}
+					},
+					"filter": {
+						"tv_synonyms": {"type": "synonym", "synonyms": ["tv, television"]}
+					}
+				}
+			},
+			"mappings": {"properties": {"name": {"type": "text", "analyzer": "custom_synonyms"}}}
+		}`),
+	}
+
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, 200, res.StatusCode)
+
+	indexReq := esapi.IndexRequest{
+		Index:      "products-test",
+		DocumentID: "1",
+		Body:       strings.NewReader(`{"name": "Smart Television"}`),
+	}
+
+	res, err = indexReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			switch subtest.name {
+			case "MatchUsesFieldAnalyzer":
+				req := esapi.SearchRequest{
+					Index: []string{subtest.indexName},
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, subtest.expected, res.StatusCode)
+
+				var response elasticfacker.ElasticSearchResponseFake
+				err = json.NewDecoder(res.Body).Decode(&response)
+				assert.Nil(t, err)
+				assert.Equal(t, 1, response.Hits.Total.Value)
+			default:
+				req := esapi.IndicesAnalyzeRequest{
+					Index: subtest.indexName,
+					Body:  subtest.body,
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+
+				assert.Equal(t, subtest.expected, res.StatusCode)
+				if subtest.expected != 200 {
+					return
+				}
+
+				var response elasticfacker.ElasticSearchAnalyzeResponseFake
+				err = json.NewDecoder(res.Body).Decode(&response)
+				assert.Nil(t, err)
+
+				tokens := make([]string, 0, len(response.Tokens))
+				for _, token := range response.Tokens {
+					tokens = append(tokens, token.Token)
+				}
+				assert.Equal(t, subtest.tokens, tokens)
+
+				switch subtest.name {
+				case "MultipleTexts":
+					assert.Equal(t, 102, response.Tokens[2].Position)
+					assert.Equal(t, 8, response.Tokens[2].StartOffset)
+				case "CustomAnalyzer":
+					assert.Equal(t, "SYNONYM", response.Tokens[1].Type)
+					assert.Equal(t, response.Tokens[0].Position, response.Tokens[1].Position)
+				}
+			}
+		})
+	}
+}
+
+func TestRelevanceScoringRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		ids      []string
+		scores   []float64
+	}{
+		{
+			name:     "MatchRanksByRelevance",
+			body:     strings.NewReader(`{"query": {"match": {"title": "quick fox"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+			scores:   []float64{0.4537965953350067, 0.4099394679069519},
+		},
+		{
+			name:     "MatchShorterFieldScoresHigher",
+			body:     strings.NewReader(`{"query": {"match": {"title": "dog"}}}`),
+			expected: 200,
+			ids:      []string{"003", "002"},
+		},
+		{
+			name:     "MultiMatchFieldBoost",
+			body:     strings.NewReader(`{"query": {"multi_match": {"query": "lazy", "fields": ["title", "tags^3"]}}}`),
+			expected: 200,
+			ids:      []string{"002", "003"},
+		},
+		{
+			name:     "MultiMatchUnknownType",
+			body:     strings.NewReader(`{"query": {"multi_match": {"query": "lazy", "type": "fuzziest"}}}`),
+			expected: 400,
+		},
+		{
+			name:     "SortByFieldHasNoScore",
+			body:     strings.NewReader(`{"query": {"match": {"title": "dog"}}, "sort": ["_id"]}`),
+			expected: 200,
+			ids:      []string{"002", "003"},
+		},
+		{
+			name:     "DeleteUpdatesStatistics",
+			body:     strings.NewReader(`{"query": {"match": {"title": "quick fox"}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+			scores:   []float64{0.5545178055763245},
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	documents := map[string]string{
+		"001": `{"title": "The quick brown fox"}`,
+		"002": `{"title": "quick quick fox jumps over the lazy dog", "tags": ["lazy"]}`,
+		"003": `{"title": "lazy dog"}`,
+	}
+	for _, id := range []string{"001", "002", "003"} {
+		req := esapi.IndexRequest{
+			Index:      "articles-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			if subtest.name == "DeleteUpdatesStatistics" {
+				req := esapi.DeleteRequest{
+					Index:      "articles-test",
+					DocumentID: "002",
+				}
+
+				res, err := req.Do(context.Background(), esClient)
+				assert.Nil(t, err)
+				res.Body.Close()
+			}
+
+			req := esapi.SearchRequest{
+				Index: []string{"articles-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			ids := make([]string, 0)
+			for _, hit := range searchResponse.Hits.Hits {
+				ids = append(ids, hit.Id)
+			}
+			assert.Equal(t, subtest.ids, ids)
+
+			if subtest.name == "SortByFieldHasNoScore" {
+				assert.Nil(t, searchResponse.Hits.MaxScore)
+				assert.Nil(t, searchResponse.Hits.Hits[0].Score)
+				return
+			}
+
+			assert.Equal(t, *searchResponse.Hits.Hits[0].Score, *searchResponse.Hits.MaxScore)
+			for position, score := range subtest.scores {
+				assert.Equal(t, score, *searchResponse.Hits.Hits[position].Score)
+			}
+		})
+	}
+}
+
+func TestFullTextQueriesRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		ids      []string
+		best     string
+	}{
+		{
+			name:     "MatchPhrase",
+			body:     strings.NewReader(`{"query": {"match_phrase": {"title": "quick brown"}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "MatchPhraseSlop",
+			body:     strings.NewReader(`{"query": {"match_phrase": {"title": {"query": "fox quick", "slop": 3}}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+			best:     "002",
+		},
+		{
+			name:     "MatchPhrasePrefix",
+			body:     strings.NewReader(`{"query": {"match_phrase_prefix": {"title": "quick th"}}}`),
+			expected: 200,
+			ids:      []string{"004"},
+		},
+		{
+			name:     "MatchBoolPrefix",
+			body:     strings.NewReader(`{"query": {"match_bool_prefix": {"title": "brown qui"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "004"},
+		},
+		{
+			name:     "MultiMatchPhraseWithBoost",
+			body:     strings.NewReader(`{"query": {"multi_match": {"query": "quick brown", "type": "phrase", "fields": ["title^3", "author"]}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "MultiMatchPhrasePrefix",
+			body:     strings.NewReader(`{"query": {"multi_match": {"query": "qui", "type": "phrase_prefix", "fields": ["title"]}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "004"},
+		},
+		{
+			name:     "MultiMatchCrossFields",
+			body:     strings.NewReader(`{"query": {"multi_match": {"query": "john smith", "type": "cross_fields", "fields": ["title", "author^2"], "operator": "and"}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "QueryStringSearchBoxInput",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "Quick Thinking"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "004"},
+			best:     "004",
+		},
+		{
+			name:     "QueryStringBooleanOperators",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "title:quick AND NOT genre:essay"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "QueryStringPhraseOrField",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "\"brown fox\" OR author:doe"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "003"},
+			best:     "002",
+		},
+		{
+			name:     "QueryStringRangeAndWildcard",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "year:[2000 TO 2015} AND qu*"}}}`),
+			expected: 200,
+			ids:      []string{"002"},
+		},
+		{
+			name:     "QueryStringComparison",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "year:>=2015"}}}`),
+			expected: 200,
+			ids:      []string{"003", "004"},
+		},
+		{
+			name:     "QueryStringOnlyNegative",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "-genre:fable"}}}`),
+			expected: 200,
+			ids:      []string{"003", "004"},
+		},
+		{
+			name:     "QueryStringDefaultOperatorAnd",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "quick brown", "default_operator": "AND"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "QueryStringGroupOnDefaultField",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "(quick OR lazy) AND year:<2010", "default_field": "title"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "QueryStringEscapedCharacters",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "title:thinking\\!"}}}`),
+			expected: 200,
+			ids:      []string{"004"},
+		},
+		{
+			name:     "QueryStringSyntaxError",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "title:(quick"}}}`),
+			expected: 400,
+		},
+		{
+			name:     "QueryStringUnknownOption",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "quick", "fuzzy": true}}}`),
+			expected: 400,
+		},
+		{
+			name:     "SimpleQueryStringNegation",
+			body:     strings.NewReader(`{"query": {"simple_query_string": {"query": "quick -fox", "fields": ["title"], "default_operator": "and"}}}`),
+			expected: 200,
+			ids:      []string{"004"},
+		},
+		{
+			name:     "SimpleQueryStringPhraseOrPrefix",
+			body:     strings.NewReader(`{"query": {"simple_query_string": {"query": "\"brown fox\" | laz*", "fields": ["title"]}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "003"},
+		},
+		{
+			name:     "SimpleQueryStringIgnoresInvalidSyntax",
+			body:     strings.NewReader(`{"query": {"simple_query_string": {"query": "(quick + brown) | dogs)", "fields": ["title"]}}}`),
+			expected: 200,
+			ids:      []string{"001", "002", "003"},
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: "books-test",
+		Body: strings.NewReader(`{"mappings": {"properties": {"title": {"type": "text"}, "author": {"type": "text"},
+			"genre": {"type": "keyword"}, "year": {"type": "integer"}}}}`),
+	}
+	createRes, err := createReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	createRes.Body.Close()
+
+	documents := map[string]string{
+		"001": `{"title": "The Quick Brown Fox", "author": "John Smith", "genre": "fable", "year": 1990}`,
+		"002": `{"title": "Brown fox jumps quick", "author": "Jane Doe", "genre": "fable", "year": 2005}`,
+		"003": `{"title": "Lazy dogs sleep", "author": "John Doe", "genre": "novel", "year": 2015}`,
+		"004": `{"title": "Quick thinking", "author": "Smith Jane", "genre": "essay", "year": 2020}`,
+	}
+	for _, id := range []string{"001", "002", "003", "004"} {
+		req := esapi.IndexRequest{
+			Index:      "books-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"books-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			ids := make([]string, 0)
+			for _, hit := range searchResponse.Hits.Hits {
+				ids = append(ids, hit.Id)
+			}
+			assert.ElementsMatch(t, subtest.ids, ids)
+
+			if subtest.best != "" {
+				assert.Equal(t, subtest.best, ids[0])
+			}
+		})
+	}
+}
+
+func TestTermLevelQueriesRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		ids      []string
+	}{
+		{
+			name:     "Wildcard",
+			body:     strings.NewReader(`{"query": {"wildcard": {"sku": {"value": "SKU-100?-*"}}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "WildcardCaseInsensitive",
+			body:     strings.NewReader(`{"query": {"wildcard": {"sku": {"value": "acc-*", "case_insensitive": true}}}}`),
+			expected: 200,
+			ids:      []string{"003"},
+		},
+		{
+			name:     "RegexpLuceneSyntax",
+			body:     strings.NewReader(`{"query": {"regexp": {"sku": "SKU-[0-9]{4}-(RED|BLU)"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "RegexpInterval",
+			body:     strings.NewReader(`{"query": {"regexp": {"sku": {"value": "SKU-<1000-1001>-.*"}}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "RegexpComplement",
+			body:     strings.NewReader(`{"query": {"regexp": {"sku": {"value": "~(SKU.*)", "flags": "COMPLEMENT"}}}}`),
+			expected: 200,
+			ids:      []string{"003"},
+		},
+		{
+			name:     "RegexpIsAnchored",
+			body:     strings.NewReader(`{"query": {"regexp": {"sku": "1001"}}}`),
+			expected: 200,
+			ids:      []string{},
+		},
+		{
+			name:     "RegexpInvalid",
+			body:     strings.NewReader(`{"query": {"regexp": {"sku": "SKU-[0-9"}}}`),
+			expected: 400,
+		},
+		{
+			name:     "FuzzyAuto",
+			body:     strings.NewReader(`{"query": {"fuzzy": {"name": {"value": "shose", "fuzziness": "AUTO"}}}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "FuzzyWithoutTranspositions",
+			body:     strings.NewReader(`{"query": {"fuzzy": {"name": {"value": "shose", "transpositions": false}}}}`),
+			expected: 200,
+			ids:      []string{},
+		},
+		{
+			name:     "FuzzyShortTermIsExact",
+			body:     strings.NewReader(`{"query": {"fuzzy": {"name": {"value": "rd"}}}}`),
+			expected: 200,
+			ids:      []string{},
+		},
+		{
+			name:     "FuzzyKeyword",
+			body:     strings.NewReader(`{"query": {"fuzzy": {"sku": {"value": "ACC-2010", "fuzziness": 2}}}}`),
+			expected: 200,
+			ids:      []string{"003"},
+		},
+		{
+			name:     "MatchFuzziness",
+			body:     strings.NewReader(`{"query": {"match": {"name": {"query": "lether walet", "fuzziness": "AUTO", "operator": "and"}}}}`),
+			expected: 200,
+			ids:      []string{"003"},
+		},
+		{
+			name:     "QueryStringFuzzyAndRegexp",
+			body:     strings.NewReader(`{"query": {"query_string": {"query": "runnig~ AND name:/sho.*/"}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "TermsSetMinimumField",
+			body:     strings.NewReader(`{"query": {"terms_set": {"tags": {"terms": ["sport", "red", "blue"], "minimum_should_match_field": "required_matches"}}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name: "TermsSetMinimumScript",
+			body: strings.NewReader(`{"query": {"terms_set": {"tags": {"terms": ["sport", "red"],
+				"minimum_should_match_script": {"source": "Math.min(params.num_terms, doc['required_matches'].value)"}}}}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "TermCaseInsensitive",
+			body:     strings.NewReader(`{"query": {"term": {"sku": {"value": "acc-2001", "case_insensitive": true}}}}`),
+			expected: 200,
+			ids:      []string{"003"},
+		},
+		{
+			name:     "PrefixCaseInsensitive",
+			body:     strings.NewReader(`{"query": {"prefix": {"sku": {"value": "sku-1002", "case_insensitive": true}}}}`),
+			expected: 200,
+			ids:      []string{"002"},
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Errorf("Error when creating the Elasticsearch client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: "skus-test",
+		Body: strings.NewReader(`{"mappings": {"properties": {"sku": {"type": "keyword"}, "name": {"type": "text"},
+			"tags": {"type": "keyword"}, "required_matches": {"type": "integer"}}}}`),
+	}
+	createRes, err := createReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	createRes.Body.Close()
+
+	documents := map[string]string{
+		"001": `{"sku": "SKU-1001-RED", "name": "Red running shoes", "tags": ["sport", "red", "shoes"], "required_matches": 2}`,
+		"002": `{"sku": "SKU-1002-BLU", "name": "Blue running shorts", "tags": ["sport", "blue"], "required_matches": 1}`,
+		"003": `{"sku": "ACC-2001", "name": "Leather wallet", "tags": ["leather"], "required_matches": 3}`,
+	}
+	for _, id := range []string{"001", "002", "003"} {
+		req := esapi.IndexRequest{
+			Index:      "skus-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"skus-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			ids := make([]string, 0)
+			for _, hit := range searchResponse.Hits.Hits {
+				ids = append(ids, hit.Id)
+			}
+			assert.ElementsMatch(t, subtest.ids, ids)
+		})
+	}
+}
+
+func TestHighlightRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name       string
+		body       *strings.Reader
+		expected   int
+		highlights map[string]map[string][]string
+	}{
+		{
+			name:     "DefaultTags",
+			body:     strings.NewReader(`{"query": {"match": {"title": "fox"}}, "highlight": {"fields": {"title": {}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"title": {"The quick brown <em>fox</em>"}},
+			},
+		},
+		{
+			name: "CustomTags",
+			body: strings.NewReader(`{"query": {"match": {"title": "brown"}},
+				"highlight": {"pre_tags": ["<mark>"], "post_tags": ["</mark>"], "fields": {"title": {}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"title": {"The quick <mark>brown</mark> fox"}},
+				"002": {"title": {"<mark>Brown</mark> bears"}},
+			},
+		},
+		{
+			name: "FragmentSizeAndNumberOfFragments",
+			body: strings.NewReader(`{"query": {"match": {"body": "fox"}},
+				"highlight": {"fields": {"body": {"fragment_size": 20, "number_of_fragments": 2}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"body": {"A <em>fox</em> is a small animal.", "The <em>fox</em> sleeps in a den."}},
+			},
+		},
+		{
+			name:     "WholeFieldWithoutFragments",
+			body:     strings.NewReader(`{"query": {"match": {"body": "fox"}}, "highlight": {"fields": {"body": {"number_of_fragments": 0}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"body": {"A <em>fox</em> is a small animal. The <em>fox</em> sleeps in a den."}},
+			},
+		},
+		{
+			name:     "PhraseOnlyWhereFound",
+			body:     strings.NewReader(`{"query": {"match_phrase": {"body": "small animal"}}, "highlight": {"fields": {"body": {}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"body": {"A fox is a <em>small</em> <em>animal</em>. The fox sleeps in a den."}},
+			},
+		},
+		{
+			name: "RequireFieldMatch",
+			body: strings.NewReader(`{"query": {"match": {"title": "fox"}},
+				"highlight": {"fields": {"title": {}, "body": {}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"title": {"The quick brown <em>fox</em>"}},
+			},
+		},
+		{
+			name: "WithoutRequireFieldMatch",
+			body: strings.NewReader(`{"query": {"match": {"title": "fox"}},
+				"highlight": {"require_field_match": false, "fields": {"title": {}, "body": {"fragment_size": 20}}}}`),
+			expected: 200,
+			highlights: map[string]map[string][]string{
+				"001": {"title": {"The quick brown <em>fox</em>"}, "body": {"A <em>fox</em> is a small animal.", "The <em>fox</em> sleeps in a den."}},
+			},
+		},
+		{
+			name:     "PreTagsWithoutPostTags",
+			body:     strings.NewReader(`{"query": {"match": {"title": "fox"}}, "highlight": {"pre_tags": ["<b>"], "fields": {"title": {}}}}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: "articles-test",
+		Body:  strings.NewReader(`{"mappings": {"properties": {"title": {"type": "text"}, "body": {"type": "text"}}}}`),
+	}
+	createRes, err := createReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	createRes.Body.Close()
+
+	documents := map[string]string{
+		"001": `{"title": "The quick brown fox", "body": "A fox is a small animal. The fox sleeps in a den."}`,
+		"002": `{"title": "Brown bears", "body": "Bears are big animals, not small."}`,
+	}
+	for _, id := range []string{"001", "002"} {
+		req := esapi.IndexRequest{
+			Index:      "articles-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"articles-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			highlights := make(map[string]map[string][]string)
+			for _, hit := range searchResponse.Hits.Hits {
+				highlights[hit.Id] = hit.Highlight
+			}
+			assert.Equal(t, subtest.highlights, highlights)
+		})
+	}
+}
+
+func TestStoredScriptsRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		ids      []string
+	}{
+		{
+			name:     "StoredTemplateWithSection",
+			body:     strings.NewReader(`{"id": "books-search", "params": {"text": "brown", "genres": ["fantasy"], "size": 5}}`),
+			expected: 200,
+			ids:      []string{"001"},
+		},
+		{
+			name:     "StoredTemplateWithInvertedSection",
+			body:     strings.NewReader(`{"id": "books-search", "params": {"text": "brown"}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name: "InlineSourceWithJoin",
+			body: strings.NewReader(`{"source": "{\"query\": {\"match\": {\"title\": \"{{#join delimiter=' '}}words{{/join delimiter=' '}}\"}}}",
+				"params": {"words": ["fox", "bears"]}}`),
+			expected: 200,
+			ids:      []string{"001", "002"},
+		},
+		{
+			name:     "InlineSourceObject",
+			body:     strings.NewReader(`{"source": {"query": {"term": {"genre": "{{genre}}"}}}, "params": {"genre": "nature"}}`),
+			expected: 200,
+			ids:      []string{"002"},
+		},
+		{
+			name:     "StoredTemplateNotFound",
+			body:     strings.NewReader(`{"id": "not-found", "params": {}}`),
+			expected: 404,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: "library-test",
+		Body:  strings.NewReader(`{"mappings": {"properties": {"title": {"type": "text"}, "genre": {"type": "keyword"}}}}`),
+	}
+	createRes, err := createReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	createRes.Body.Close()
+
+	documents := map[string]string{
+		"001": `{"title": "The quick brown fox", "genre": "fantasy"}`,
+		"002": `{"title": "Brown bears", "genre": "nature"}`,
+	}
+	for _, id := range []string{"001", "002"} {
+		req := esapi.IndexRequest{
+			Index:      "library-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	source := `{\"query\": {\"bool\": {\"must\": {\"match\": {\"title\": \"{{text}}\"}}, \"filter\": [` +
+		`{{#genres}}{\"terms\": {\"genre\": {{#toJson}}genres{{/toJson}}}}{{/genres}}` +
+		`{{^genres}}{\"match_all\": {}}{{/genres}}]}}, \"size\": \"{{size}}{{^size}}10{{/size}}\"}`
+	putReq := esapi.PutScriptRequest{
+		ScriptID: "books-search",
+		Body:     strings.NewReader(`{"script": {"lang": "mustache", "source": "` + source + `"}}`),
+	}
+	putRes, err := putReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, putRes.StatusCode)
+	putRes.Body.Close()
+
+	getReq := esapi.GetScriptRequest{ScriptID: "books-search"}
+	getRes, err := getReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, getRes.StatusCode)
+
+	var script elasticfacker.ElasticSearchGetScriptResponseFake
+	err = json.NewDecoder(getRes.Body).Decode(&script)
+	assert.Nil(t, err)
+	getRes.Body.Close()
+	assert.True(t, script.Found)
+	assert.Equal(t, "mustache", script.Script.Lang)
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchTemplateRequest{
+				Index: []string{"library-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+
+			ids := make([]string, 0)
+			for _, hit := range searchResponse.Hits.Hits {
+				ids = append(ids, hit.Id)
+			}
+			assert.ElementsMatch(t, subtest.ids, ids)
+		})
+	}
+
+	deleteReq := esapi.DeleteScriptRequest{ScriptID: "books-search"}
+	deleteRes, err := deleteReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, deleteRes.StatusCode)
+	deleteRes.Body.Close()
+
+	getRes, err = getReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 404, getRes.StatusCode)
+	getRes.Body.Close()
+}
+
+func TestRenderSearchTemplateRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name       string
+		templateId string
+		body       *strings.Reader
+		expected   int
+		output     string
+	}{
+		{
+			name:       "StoredTemplate",
+			templateId: "products-search",
+			body:       strings.NewReader(`{"params": {"text": "shoes", "filter": {"brands": ["acme", "globex"]}}}`),
+			expected:   200,
+			output:     `{"query":{"bool":{"must":{"match":{"name":"shoes"}},"filter":{"terms":{"brand":["acme","globex"]}}}},"size":10}`,
+		},
+		{
+			name:       "StoredTemplateWithoutOptionalParams",
+			templateId: "products-search",
+			body:       strings.NewReader(`{"params": {"text": "say \"hi\"", "size": 3}}`),
+			expected:   200,
+			output:     `{"query":{"bool":{"must":{"match":{"name":"say \"hi\""}}}},"size":3}`,
+		},
+		{
+			name:     "InlineSource",
+			body:     strings.NewReader(`{"source": "{\"query\": {\"match\": {\"tags\": \"{{#join}}tags{{/join}}\"}}}", "params": {"tags": ["a", "b"]}}`),
+			expected: 200,
+			output:   `{"query":{"match":{"tags":"a,b"}}}`,
+		},
+		{
+			name:       "TemplateNotFound",
+			templateId: "not-found",
+			body:       strings.NewReader(`{"params": {}}`),
+			expected:   404,
+		},
+		{
+			name:     "InvalidTemplate",
+			body:     strings.NewReader(`{"source": "{\"query\": {{#match}}}", "params": {}}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	source := `{\"query\": {\"bool\": {\"must\": {\"match\": {\"name\": \"{{text}}\"}}` +
+		`{{#filter}}, \"filter\": {\"terms\": {\"brand\": {{#toJson}}brands{{/toJson}}}}{{/filter}}}}, ` +
+		`\"size\": {{size}}{{^size}}10{{/size}}}`
+	putReq := esapi.PutScriptRequest{
+		ScriptID: "products-search",
+		Body:     strings.NewReader(`{"script": {"lang": "mustache", "source": "` + source + `"}}`),
+	}
+	putRes, err := putReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, putRes.StatusCode)
+	putRes.Body.Close()
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.RenderSearchTemplateRequest{
+				TemplateID: subtest.templateId,
+				Body:       subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var renderResponse elasticfacker.ElasticSearchRenderTemplateResponseFake
+			err = json.NewDecoder(res.Body).Decode(&renderResponse)
+			assert.Nil(t, err)
+			assert.Equal(t, subtest.output, string(renderResponse.TemplateOutput))
+		})
+	}
+}
+
+func TestMultiSearchRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		index    []string
+		template bool
+		body     *strings.Reader
+		expected int
+		statuses []int
+		errors   []string
+		totals   []int
+	}{
+		{
+			name:  "SearchesWithHeaders",
+			index: []string{"cities-test"},
+			body: strings.NewReader(`{}
+{"query": {"match": {"name": "paris"}}}
+{"index": "countries-test"}
+{"query": {"match_all": {}}}
+`),
+			expected: 200,
+			statuses: []int{200, 200},
+			totals:   []int{1, 2},
+		},
+		{
+			name: "SearchesEveryIndex",
+			body: strings.NewReader(`{}
+{"query": {"match": {"name": "france"}}}
+{"index": "cities-test,countries-test"}
+{"query": {"match_all": {}}}
+`),
+			expected: 200,
+			statuses: []int{200, 200},
+			totals:   []int{1, 5},
+		},
+		{
+			name: "FailuresPerEntry",
+			body: strings.NewReader(`{"index": "cities-test"}
+{"query": {"match_all": {}}}
+{"index": "not-found-test"}
+{"query": {"match_all": {}}}
+{"index": "cities-test"}
+{"query": {"unknown": {}}}
+`),
+			expected: 200,
+			statuses: []int{200, 404, 400},
+			errors:   []string{"", "index_not_found_exception", "parsing_exception"},
+			totals:   []int{3},
+		},
+		{
+			name:     "Template",
+			index:    []string{"cities-test"},
+			template: true,
+			body: strings.NewReader(`{}
+{"source": {"query": {"term": {"country": "{{country}}"}}}, "params": {"country": "france"}}
+{}
+{"id": "not-found", "params": {}}
+`),
+			expected: 200,
+			statuses: []int{200, 404},
+			errors:   []string{"", "resource_not_found_exception"},
+			totals:   []int{2},
+		},
+		{
+			name:     "MalformedHeader",
+			index:    []string{"cities-test"},
+			body:     strings.NewReader("{malformed\n{}\n"),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	documents := []struct {
+		index string
+		id    string
+		body  string
+	}{
+		{index: "cities-test", id: "001", body: `{"name": "Paris", "country": "france"}`},
+		{index: "cities-test", id: "002", body: `{"name": "Lyon", "country": "france"}`},
+		{index: "cities-test", id: "003", body: `{"name": "Madrid", "country": "spain"}`},
+		{index: "countries-test", id: "001", body: `{"name": "France"}`},
+		{index: "countries-test", id: "002", body: `{"name": "Spain"}`},
+	}
+	for _, document := range documents {
+		req := esapi.IndexRequest{
+			Index:      document.index,
+			DocumentID: document.id,
+			Body:       strings.NewReader(document.body),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			var req esapi.Request = esapi.MsearchRequest{
+				Index: subtest.index,
+				Body:  subtest.body,
+			}
+			if subtest.template {
+				req = esapi.MsearchTemplateRequest{
+					Index: subtest.index,
+					Body:  subtest.body,
+				}
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var multiSearchResponse elasticfacker.ElasticSearchMultiSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&multiSearchResponse)
+			assert.Nil(t, err)
+			assert.Len(t, multiSearchResponse.Responses, len(subtest.statuses))
+
+			totals := make([]int, 0)
+			for position, item := range multiSearchResponse.Responses {
+				var entry struct {
+					elasticfacker.ElasticSearchResponseFake
+					Status int                                   `json:"status"`
+					Error  *elasticfacker.ElasticSearchErrorFake `json:"error"`
+				}
+				err = json.Unmarshal(item, &entry)
+				assert.Nil(t, err)
+				assert.Equal(t, subtest.statuses[position], entry.Status)
+				if entry.Status == 200 {
+					totals = append(totals, entry.Hits.Total.Value)
+				} else if assert.NotNil(t, entry.Error) {
+					assert.Equal(t, subtest.errors[position], entry.Error.Type)
+				}
+			}
+			assert.Equal(t, subtest.totals, totals)
+		})
+	}
+
+	t.Run("SearchWithoutIndex", func(t *testing.T) {
+		req := esapi.SearchRequest{
+			Body: strings.NewReader(`{"sort": [{"name.keyword": "asc"}], "aggs": {"names": {"value_count": {"field": "name.keyword"}}}, "highlight": {"fields": {"name": {}}}, "query": {"match": {"name": "paris spain"}}}`),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, 200, res.StatusCode)
+
+		var searchResponse elasticfacker.ElasticSearchResponseFake
+		err = json.NewDecoder(res.Body).Decode(&searchResponse)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, searchResponse.Hits.Total.Value)
+		assert.Equal(t, float64(2), searchResponse.Aggregations["names"].(map[string]interface{})["value"])
+
+		hits := make([]string, 0)
+		for _, hit := range searchResponse.Hits.Hits {
+			hits = append(hits, hit.Index+"/"+hit.Id)
+		}
+		assert.Equal(t, []string{"cities-test/001", "countries-test/002"}, hits)
+		assert.Equal(t, []string{"<em>Spain</em>"}, searchResponse.Hits.Hits[1].Highlight["name"])
+	})
+}
+
+func TestMultiGetRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		index    string
+		body     *strings.Reader
+		expected int
+		docs     []elasticfacker.ElasticSearchGetResponseFake
+	}{
+		{
+			name:     "Ids",
+			index:    "products-mget-test",
+			body:     strings.NewReader(`{"ids": ["001", "404"]}`),
+			expected: 200,
+			docs: []elasticfacker.ElasticSearchGetResponseFake{
+				{Index: "products-mget-test", Id: "001", Found: true, Source: map[string]interface{}{
+					"name": "Shoes", "price": 50.0, "stock": map[string]interface{}{"store": 3.0, "online": 7.0}}},
+				{Index: "products-mget-test", Id: "404", Found: false},
+			},
+		},
+		{
+			name: "DocsWithSourceFiltering",
+			body: strings.NewReader(`{"docs": [
+				{"_index": "products-mget-test", "_id": "001", "_source": ["name", "stock.on*"]},
+				{"_index": "products-mget-test", "_id": "002", "_source": {"excludes": ["stock"]}, "routing": "user-1"},
+				{"_index": "products-mget-test", "_id": "002", "_source": false}]}`),
+			expected: 200,
+			docs: []elasticfacker.ElasticSearchGetResponseFake{
+				{Index: "products-mget-test", Id: "001", Found: true, Source: map[string]interface{}{
+					"name": "Shoes", "stock": map[string]interface{}{"online": 7.0}}},
+				{Index: "products-mget-test", Id: "002", Found: true, Source: map[string]interface{}{"name": "Socks", "price": 5.0}},
+				{Index: "products-mget-test", Id: "002", Found: true},
+			},
+		},
+		{
+			name: "DocsThroughAliasAndUnknownIndex",
+			body: strings.NewReader(`{"docs": [
+				{"_index": "products-mget-alias", "_id": "002", "_source": ["name"]},
+				{"_index": "unknown-mget-test", "_id": "001"}]}`),
+			expected: 200,
+			docs: []elasticfacker.ElasticSearchGetResponseFake{
+				{Index: "products-mget-test", Id: "002", Found: true, Source: map[string]interface{}{"name": "Socks"}},
+				{Index: "unknown-mget-test", Id: "001", Error: &elasticfacker.ElasticSearchErrorFake{Type: "index_not_found_exception"}},
+			},
+		},
+		{
+			name:     "DocsMissingIndex",
+			body:     strings.NewReader(`{"docs": [{"_id": "001"}]}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	documents := map[string]string{
+		"001": `{"name": "Shoes", "price": 50, "stock": {"store": 3, "online": 7}}`,
+		"002": `{"name": "Socks", "price": 5, "stock": {"store": 0, "online": 12}}`,
+	}
+	for _, id := range []string{"001", "002"} {
+		req := esapi.IndexRequest{
+			Index:      "products-mget-test",
+			DocumentID: id,
+			Body:       strings.NewReader(documents[id]),
+		}
+
+		res, err := req.Do(context.Background(), esClient)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	aliasReq := esapi.IndicesPutAliasRequest{
+		Index: []string{"products-mget-test"},
+		Name:  "products-mget-alias",
+	}
+	aliasRes, err := aliasReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	aliasRes.Body.Close()
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.MgetRequest{
+				Index: subtest.index,
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
+			}
+
+			var multiGetResponse elasticfacker.ElasticSearchMultiGetResponseFake
+			err = json.NewDecoder(res.Body).Decode(&multiGetResponse)
+			assert.Nil(t, err)
+			assert.Len(t, multiGetResponse.Docs, len(subtest.docs))
+			for position, item := range multiGetResponse.Docs {
+				var doc elasticfacker.ElasticSearchGetResponseFake
+				err = json.Unmarshal(item, &doc)
+				assert.Nil(t, err)
+				assert.Equal(t, subtest.docs[position].Index, doc.Index)
+				assert.Equal(t, subtest.docs[position].Id, doc.Id)
+				assert.Equal(t, subtest.docs[position].Found, doc.Found)
+				assert.Equal(t, subtest.docs[position].Source, doc.Source)
+
+				// Failed entries have an error instead of a found flag.
+				var fields map[string]interface{}
+				err = json.Unmarshal(item, &fields)
+				assert.Nil(t, err)
+				_, hasFound := fields["found"]
+				assert.Equal(t, subtest.docs[position].Error == nil, hasFound)
+				if subtest.docs[position].Error != nil && assert.NotNil(t, doc.Error) {
+					assert.Equal(t, subtest.docs[position].Error.Type, doc.Error.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceFilteringRequest(t *testing.T) {
+	time.Sleep(1 * time.Second)
+	subtests := []struct {
+		name     string
+		body     *strings.Reader
+		expected int
+		id       string
+		source   map[string]interface{}
+		fields   map[string][]interface{}
+	}{
+		{
+			name:     "SourceDisabled",
+			body:     strings.NewReader(`{"_source": false}`),
+			expected: 200,
+			id:       "001",
+		},
+		{
+			name:     "SourcePaths",
+			body:     strings.NewReader(`{"_source": ["name", "stock.*"]}`),
+			expected: 200,
+			id:       "001",
+			source:   map[string]interface{}{"name": "Red shoes", "stock": map[string]interface{}{"store": 3.0, "online": 7.0}},
+		},
+		{
+			name:     "SourceIncludesExcludes",
+			body:     strings.NewReader(`{"_source": {"includes": ["st*", "price"], "excludes": ["stock.store"]}}`),
+			expected: 200,
+			id:       "001",
+			source:   map[string]interface{}{"price": 49.5, "stock": map[string]interface{}{"online": 7.0}},
+		},
+		{
+			name: "FieldsWithFormat",
+			body: strings.NewReader(`{"_source": false,
+				"fields": ["name", "stock.*", {"field": "created", "format": "yyyy/MM/dd"}, {"field": "price", "format": "0.00"}]}`),
+			expected: 200,
+			id:       "001",
+			fields: map[string][]interface{}{
+				"name": {"Red shoes"}, "stock.store": {3.0}, "stock.online": {7.0}, "created": {"2024/03/01"}, "price": {"49.50"},
+			},
+		},
+		{
+			name:     "DocvalueFieldsWithoutStoredFields",
+			body:     strings.NewReader(`{"docvalue_fields": ["tags", "created"], "stored_fields": "_none_"}`),
+			expected: 200,
+			fields:   map[string][]interface{}{"tags": {"sale", "shoes"}, "created": {"2024-03-01T00:00:00.000Z"}},
+		},
+		{
+			name:     "DocvalueFieldsOfTextField",
+			body:     strings.NewReader(`{"docvalue_fields": ["name"]}`),
+			expected: 400,
+		},
+		{
+			name:     "StoredFieldsNoneWithSource",
+			body:     strings.NewReader(`{"stored_fields": "_none_", "_source": true}`),
+			expected: 400,
+		},
+	}
+
+	esClient, error := elasticsearch.NewDefaultClient()
+	if error != nil {
+		t.Fatalf("Error creating the client: %s", error)
+	}
+
+	esFacker := elasticfacker.NewInMemoryElasticsearch()
+	esFacker.Start("localhost:9200")
+	defer esFacker.Stop()
+
+	createReq := esapi.IndicesCreateRequest{
+		Index: "shoes-test",
+		Body: strings.NewReader(`{"mappings": {"properties": {"name": {"type": "text"}, "price": {"type": "double"},
+			"tags": {"type": "keyword"}, "created": {"type": "date"},
+			"stock": {"properties": {"store": {"type": "integer"}, "online": {"type": "integer"}}}}}}`),
+	}
+	createRes, err := createReq.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	createRes.Body.Close()
+
+	req := esapi.IndexRequest{
+		Index:      "shoes-test",
+		DocumentID: "001",
+		Body: strings.NewReader(`{"name": "Red shoes", "price": 49.5, "tags": ["shoes", "sale", "shoes"], "created": "2024-03-01",
+			"stock": {"store": 3, "online": 7}}`),
+	}
+	res, err := req.Do(context.Background(), esClient)
+	assert.Nil(t, err)
+	res.Body.Close()
+
+	for _, subtest := range subtests {
+		t.Run(subtest.name, func(t *testing.T) {
+			req := esapi.SearchRequest{
+				Index: []string{"shoes-test"},
+				Body:  subtest.body,
+			}
+
+			res, err := req.Do(context.Background(), esClient)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, subtest.expected, res.StatusCode)
+			if subtest.expected != 200 {
+				return
 			}
 
+			var searchResponse elasticfacker.ElasticSearchResponseFake
+			err = json.NewDecoder(res.Body).Decode(&searchResponse)
+			assert.Nil(t, err)
+			assert.Len(t, searchResponse.Hits.Hits, 1)
+			hit := searchResponse.Hits.Hits[0]
+			assert.Equal(t, subtest.id, hit.Id)
+			assert.Equal(t, subtest.source, hit.Source)
+			assert.Equal(t, subtest.fields, hit.Fields)
 		})
 	}
 }
@@ -0,0 +1,671 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultAnalyzer             = "standard"
+	DefaultPositionIncrementGap = 100
+)
+
+// analysisToken is a term produced by an analyzer, with its position and offsets in the analysed text.
+type analysisToken struct {
+	term        string
+	startOffset int
+	endOffset   int
+	tokenType   string
+	position    int
+}
+
+type charFilter func(text string) string
+
+type tokenizer func(text string) []analysisToken
+
+type tokenFilter func(tokens []analysisToken) []analysisToken
+
+// analyzer turns a text into terms: char filters change the text, the tokenizer splits it in tokens and the
+// token filters change, remove or add tokens.
+type analyzer struct {
+	charFilters []charFilter
+	tokenizer   tokenizer
+	filters     []tokenFilter
+}
+
+func (analyzer *analyzer) analyze(text string) []analysisToken {
+	for _, filter := range analyzer.charFilters {
+		text = filter(text)
+	}
+
+	tokens := analyzer.tokenizer(text)
+	for _, filter := range analyzer.filters {
+		tokens = filter(tokens)
+	}
+
+	return tokens
+}
+
+// analyzeValues analyses the values of a multi-valued field as a single stream: the positions of a value
+// follow the ones of the previous value after a gap, and its offsets follow after one character, so they
+// are the offsets of the values joined with a space.
+func (analyzer *analyzer) analyzeValues(texts []string) []analysisToken {
+	tokens := make([]analysisToken, 0)
+	lastPosition, lastOffset := -1, 0
+	for _, text := range texts {
+		position := lastPosition
+		for _, token := range analyzer.analyze(text) {
+			position = lastPosition + 1 + token.position
+			token.position = position
+			token.startOffset += lastOffset
+			token.endOffset += lastOffset
+			tokens = append(tokens, token)
+		}
+		lastPosition = position + DefaultPositionIncrementGap
+		lastOffset += len(text) + 1
+	}
+
+	return tokens
+}
+
+// terms returns the terms of the analysed text, in order.
+func (analyzer *analyzer) terms(text string) []string {
+	tokens := analyzer.analyze(text)
+	terms := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		terms = append(terms, token.term)
+	}
+
+	return terms
+}
+
+func newAnalysisError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "illegal_argument_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// indexAnalysis holds the analysis components declared in the settings of an index, next to the built in
+// ones. Components are built when they are looked up, so a custom analyzer sees the custom filters and
+// tokenizers it refers to.
+type indexAnalysis struct {
+	analyzers   map[string]interface{}
+	tokenizers  map[string]interface{}
+	filters     map[string]interface{}
+	charFilters map[string]interface{}
+}
+
+// newIndexAnalysis reads the analysis section of the settings of an index and checks that every custom
+// analyzer can be built.
+func newIndexAnalysis(settings map[string]interface{}) (*indexAnalysis, error) {
+	analysisSettings, _ := indexSetting(settings, "analysis").(map[string]interface{})
+
+	analysis := &indexAnalysis{}
+	analysis.analyzers, _ = analysisSettings["analyzer"].(map[string]interface{})
+	analysis.tokenizers, _ = analysisSettings["tokenizer"].(map[string]interface{})
+	analysis.filters, _ = analysisSettings["filter"].(map[string]interface{})
+	analysis.charFilters, _ = analysisSettings["char_filter"].(map[string]interface{})
+
+	names := make([]string, 0, len(analysis.analyzers))
+	for name := range analysis.analyzers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := analysis.analyzer(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return analysis, nil
+}
+
+// analyzer returns the analyzer with the name, a custom analyzer of the index or a built in one.
+func (analysis *indexAnalysis) analyzer(name string) (*analyzer, error) {
+	if analysis != nil {
+		if definition, exists := analysis.analyzers[name]; exists {
+			options, isObject := definition.(map[string]interface{})
+			if !isObject {
+				return nil, newAnalysisError("analyzer [%s] must be an object", name)
+			}
+			return analysis.buildAnalyzer(name, options)
+		}
+	}
+
+	builtIn := builtInAnalyzer(name, map[string]interface{}{})
+	if builtIn == nil {
+		return nil, newAnalysisError("failed to find analyzer [%s]", name)
+	}
+
+	return builtIn, nil
+}
+
+// defaultAnalyzer returns the analyzer of the text fields that do not declare one, the one named default
+// in the settings of the index or the standard analyzer, and for the search side default_search.
+func (analysis *indexAnalysis) defaultAnalyzer(search bool) *analyzer {
+	names := []string{"default"}
+	if search {
+		names = append([]string{"default_search"}, names...)
+	}
+
+	for _, name := range names {
+		if analysis != nil && analysis.analyzers[name] != nil {
+			defaultAnalyzer, err := analysis.analyzer(name)
+			if err == nil {
+				return defaultAnalyzer
+			}
+		}
+	}
+
+	return builtInAnalyzer(DefaultAnalyzer, map[string]interface{}{})
+}
+
+func (analysis *indexAnalysis) buildAnalyzer(name string, options map[string]interface{}) (*analyzer, error) {
+	analyzerType, _ := options["type"].(string)
+	if analyzerType == "" {
+		if options["tokenizer"] == nil {
+			return nil, newAnalysisError("analyzer [%s] must specify either an analyzer type, or a tokenizer", name)
+		}
+		analyzerType = "custom"
+	}
+
+	if analyzerType != "custom" {
+		builtIn := builtInAnalyzer(analyzerType, options)
+		if builtIn == nil {
+			return nil, newAnalysisError("Unknown analyzer type [%s] for [%s]", analyzerType, name)
+		}
+		return builtIn, nil
+	}
+
+	tokenizerName, _ := options["tokenizer"].(string)
+	if tokenizerName == "" {
+		return nil, newAnalysisError("Custom Analyzer [%s] must be configured with a tokenizer", name)
+	}
+	tokenizer, err := analysis.tokenizer(tokenizerName)
+	if err != nil {
+		return nil, newAnalysisError("Custom Analyzer [%s] failed to find tokenizer under name [%s]", name, tokenizerName)
+	}
+
+	built := &analyzer{tokenizer: tokenizer}
+	for _, charFilterName := range stringList(options["char_filter"]) {
+		filter, err := analysis.charFilter(charFilterName)
+		if err != nil {
+			return nil, newAnalysisError("Custom Analyzer [%s] failed to find char_filter under name [%s]", name, charFilterName)
+		}
+		built.charFilters = append(built.charFilters, filter)
+	}
+	for _, filterName := range stringList(options["filter"]) {
+		filter, err := analysis.tokenFilter(filterName, built)
+		if err != nil {
+			if queryErr, ok := err.(*queryError); ok && !strings.HasPrefix(queryErr.reason, "failed to find") {
+				return nil, err
+			}
+			return nil, newAnalysisError("Custom Analyzer [%s] failed to find filter under name [%s]", name, filterName)
+		}
+		built.filters = append(built.filters, filter)
+	}
+
+	return built, nil
+}
+
+// stringList reads a setting that is either a string or an array of strings.
+func stringList(value interface{}) []string {
+	switch typedValue := value.(type) {
+	case string:
+		return []string{typedValue}
+	case []interface{}:
+		values := make([]string, 0, len(typedValue))
+		for _, item := range typedValue {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case []string:
+		return typedValue
+	}
+
+	return nil
+}
+
+// componentDefinition returns the type and the options of an analysis component, declared by its name in
+// the index or given inline as an object, like the _analyze API allows.
+func componentDefinition(custom map[string]interface{}, component interface{}) (string, map[string]interface{}) {
+	switch typedComponent := component.(type) {
+	case string:
+		if definition, exists := custom[typedComponent].(map[string]interface{}); exists {
+			componentType, _ := definition["type"].(string)
+			return componentType, definition
+		}
+		return typedComponent, map[string]interface{}{}
+	case map[string]interface{}:
+		componentType, _ := typedComponent["type"].(string)
+		return componentType, typedComponent
+	}
+
+	return "", nil
+}
+
+func (analysis *indexAnalysis) tokenizer(component interface{}) (tokenizer, error) {
+	var custom map[string]interface{}
+	if analysis != nil {
+		custom = analysis.tokenizers
+	}
+	tokenizerType, options := componentDefinition(custom, component)
+
+	switch tokenizerType {
+	case "standard", "classic", "uax_url_email":
+		maxTokenLength := 255
+		if length, isNumber := toFloat(options["max_token_length"]); isNumber {
+			maxTokenLength = int(length)
+		}
+		return func(text string) []analysisToken {
+			return standardTokenize(text, maxTokenLength)
+		}, nil
+	case "whitespace":
+		return func(text string) []analysisToken {
+			return splitTokenize(text, func(r rune) bool { return !unicode.IsSpace(r) }, "word")
+		}, nil
+	case "letter":
+		return func(text string) []analysisToken {
+			return splitTokenize(text, unicode.IsLetter, "word")
+		}, nil
+	case "lowercase":
+		return func(text string) []analysisToken {
+			return lowercaseFilter(splitTokenize(text, unicode.IsLetter, "word"))
+		}, nil
+	case "keyword":
+		return func(text string) []analysisToken {
+			return []analysisToken{{term: text, startOffset: 0, endOffset: len(text), tokenType: "word"}}
+		}, nil
+	case "pattern":
+		pattern, _ := options["pattern"].(string)
+		if pattern == "" {
+			pattern = `\W+`
+		}
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newAnalysisError("Invalid pattern [%s]: %s", pattern, err.Error())
+		}
+		return func(text string) []analysisToken {
+			return patternTokenize(text, expression)
+		}, nil
+	case "ngram", "edge_ngram":
+		minGram, maxGram, err := gramSizes(tokenizerType, options)
+		if err != nil {
+			return nil, err
+		}
+		isTokenChar, err := tokenChars(options["token_chars"])
+		if err != nil {
+			return nil, err
+		}
+		return func(text string) []analysisToken {
+			return ngramTokenize(text, minGram, maxGram, tokenizerType == "edge_ngram", isTokenChar)
+		}, nil
+	}
+
+	return nil, newAnalysisError("failed to find tokenizer under [%v]", component)
+}
+
+func (analysis *indexAnalysis) charFilter(component interface{}) (charFilter, error) {
+	var custom map[string]interface{}
+	if analysis != nil {
+		custom = analysis.charFilters
+	}
+	charFilterType, options := componentDefinition(custom, component)
+
+	switch charFilterType {
+	case "html_strip":
+		tags := regexp.MustCompile(`<[^>]*>`)
+		return func(text string) string {
+			// Tags are replaced with as many spaces, so the offsets of the tokens stay the ones of the text.
+			text = tags.ReplaceAllStringFunc(text, func(tag string) string {
+				return strings.Repeat(" ", len(tag))
+			})
+			return htmlEntities.Replace(text)
+		}, nil
+	case "mapping":
+		replacements := make([]string, 0)
+		for _, rule := range stringList(options["mappings"]) {
+			parts := strings.SplitN(rule, "=>", 2)
+			if len(parts) != 2 {
+				return nil, newAnalysisError("Invalid Mapping Rule : [%s]", rule)
+			}
+			replacements = append(replacements, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+		replacer := strings.NewReplacer(replacements...)
+		return replacer.Replace, nil
+	case "pattern_replace":
+		pattern, _ := options["pattern"].(string)
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newAnalysisError("Invalid pattern [%s]: %s", pattern, err.Error())
+		}
+		replacement, _ := options["replacement"].(string)
+		return func(text string) string {
+			return expression.ReplaceAllString(text, replacement)
+		}, nil
+	}
+
+	return nil, newAnalysisError("failed to find char_filter under [%v]", component)
+}
+
+var htmlEntities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&#39;", "'", "&nbsp;", " ")
+
+// builtInAnalyzer returns the built in analyzer of the type, configured with the options, or nil.
+func builtInAnalyzer(analyzerType string, options map[string]interface{}) *analyzer {
+	standard := func(text string) []analysisToken { return standardTokenize(text, 255) }
+	letters := func(text string) []analysisToken { return splitTokenize(text, unicode.IsLetter, "word") }
+
+	stopwords := func(defaultStopwords string) tokenFilter {
+		stopwordsOption := options["stopwords"]
+		if stopwordsOption == nil {
+			stopwordsOption = defaultStopwords
+		}
+		return stopFilter(stopwordSet(stopwordsOption, false))
+	}
+
+	switch analyzerType {
+	case "standard":
+		return &analyzer{tokenizer: standard, filters: []tokenFilter{lowercaseFilter, stopwords("_none_")}}
+	case "simple":
+		return &analyzer{tokenizer: letters, filters: []tokenFilter{lowercaseFilter}}
+	case "whitespace":
+		return &analyzer{tokenizer: func(text string) []analysisToken {
+			return splitTokenize(text, func(r rune) bool { return !unicode.IsSpace(r) }, "word")
+		}}
+	case "keyword":
+		return &analyzer{tokenizer: func(text string) []analysisToken {
+			return []analysisToken{{term: text, startOffset: 0, endOffset: len(text), tokenType: "word"}}
+		}}
+	case "stop":
+		return &analyzer{tokenizer: letters, filters: []tokenFilter{lowercaseFilter, stopwords("_english_")}}
+	case "pattern":
+		pattern, _ := options["pattern"].(string)
+		if pattern == "" {
+			pattern = `\W+`
+		}
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil
+		}
+		lowercase := true
+		if value, isBool := toBool(options["lowercase"]); isBool {
+			lowercase = value
+		}
+		patternAnalyzer := &analyzer{tokenizer: func(text string) []analysisToken {
+			return patternTokenize(text, expression)
+		}}
+		if lowercase {
+			patternAnalyzer.filters = append(patternAnalyzer.filters, lowercaseFilter)
+		}
+		patternAnalyzer.filters = append(patternAnalyzer.filters, stopwords("_none_"))
+		return patternAnalyzer
+	case "english":
+		return &analyzer{tokenizer: standard, filters: []tokenFilter{
+			possessiveFilter, lowercaseFilter, stopwords("_english_"), porterStemFilter,
+		}}
+	}
+
+	// The other language analyzers are light: they lowercase, fold accents and remove stop words, but
+	// do not stem.
+	if _, exists := languageStopwords["_"+analyzerType+"_"]; exists {
+		return &analyzer{tokenizer: standard, filters: []tokenFilter{
+			lowercaseFilter, stopwords("_" + analyzerType + "_"), asciiFoldingFilter,
+		}}
+	}
+
+	return nil
+}
+
+// wordBreak classifies runes the way the word boundary rules of the standard tokenizer see them.
+type wordBreak int
+
+const (
+	wordBreakOther wordBreak = iota
+	wordBreakLetter
+	wordBreakNumber
+	wordBreakMidLetter
+	wordBreakMidNumber
+	wordBreakMidNumberLetter
+)
+
+func classifyRune(r rune) wordBreak {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsMark(r) || r == '_':
+		return wordBreakLetter
+	case unicode.IsDigit(r):
+		return wordBreakNumber
+	case r == ':' || r == '·':
+		return wordBreakMidLetter
+	case r == ',' || r == ';':
+		return wordBreakMidNumber
+	case r == '.' || r == '\'' || r == '’':
+		return wordBreakMidNumberLetter
+	}
+
+	return wordBreakOther
+}
+
+// standardTokenize splits a text on word boundaries, following the main rules of Unicode text segmentation:
+// letters and digits form words, and a dot, an apostrophe or a colon between letters, or a dot or a comma
+// between digits, do not break them.
+func standardTokenize(text string, maxTokenLength int) []analysisToken {
+	runes := []rune(text)
+	offsets := make([]int, len(runes)+1)
+	for position, offset := 0, 0; position < len(runes); position++ {
+		offsets[position] = offset
+		offset += utf8.RuneLen(runes[position])
+		offsets[position+1] = offset
+	}
+
+	tokens := make([]analysisToken, 0)
+	for start := 0; start < len(runes); {
+		if classifyRune(runes[start]) != wordBreakLetter && classifyRune(runes[start]) != wordBreakNumber {
+			start++
+			continue
+		}
+
+		end := start + 1
+		hasLetter := classifyRune(runes[start]) == wordBreakLetter
+		for end < len(runes) {
+			current := classifyRune(runes[end])
+			if current == wordBreakLetter || current == wordBreakNumber {
+				hasLetter = hasLetter || current == wordBreakLetter
+				end++
+				continue
+			}
+			if end+1 >= len(runes) {
+				break
+			}
+
+			previous, next := classifyRune(runes[end-1]), classifyRune(runes[end+1])
+			joinsLetters := (current == wordBreakMidLetter || current == wordBreakMidNumberLetter) &&
+				previous == wordBreakLetter && next == wordBreakLetter
+			joinsNumbers := (current == wordBreakMidNumber || current == wordBreakMidNumberLetter) &&
+				previous == wordBreakNumber && next == wordBreakNumber
+			if !joinsLetters && !joinsNumbers {
+				break
+			}
+			end += 2
+		}
+
+		tokenType := "<NUM>"
+		if hasLetter {
+			tokenType = "<ALPHANUM>"
+		}
+		// Longer tokens are split at the max token length.
+		for chunk := start; chunk < end; chunk += maxTokenLength {
+			chunkEnd := chunk + maxTokenLength
+			if chunkEnd > end {
+				chunkEnd = end
+			}
+			tokens = append(tokens, analysisToken{
+				term:        string(runes[chunk:chunkEnd]),
+				startOffset: offsets[chunk],
+				endOffset:   offsets[chunkEnd],
+				tokenType:   tokenType,
+				position:    len(tokens),
+			})
+		}
+		start = end
+	}
+
+	return tokens
+}
+
+// splitTokenize returns the runs of runes that are token characters.
+func splitTokenize(text string, isTokenChar func(r rune) bool, tokenType string) []analysisToken {
+	tokens := make([]analysisToken, 0)
+	start := -1
+	for offset, r := range text {
+		if isTokenChar(r) {
+			if start < 0 {
+				start = offset
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, analysisToken{term: text[start:offset], startOffset: start, endOffset: offset, tokenType: tokenType, position: len(tokens)})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, analysisToken{term: text[start:], startOffset: start, endOffset: len(text), tokenType: tokenType, position: len(tokens)})
+	}
+
+	return tokens
+}
+
+func patternTokenize(text string, expression *regexp.Regexp) []analysisToken {
+	tokens := make([]analysisToken, 0)
+	start := 0
+	for _, separator := range expression.FindAllStringIndex(text, -1) {
+		if separator[0] > start {
+			tokens = append(tokens, analysisToken{term: text[start:separator[0]], startOffset: start, endOffset: separator[0], tokenType: "word", position: len(tokens)})
+		}
+		start = separator[1]
+	}
+	if start < len(text) {
+		tokens = append(tokens, analysisToken{term: text[start:], startOffset: start, endOffset: len(text), tokenType: "word", position: len(tokens)})
+	}
+
+	return tokens
+}
+
+func gramSizes(componentType string, options map[string]interface{}) (int, int, error) {
+	minGram, maxGram := 1, 2
+	if value, isNumber := toFloat(options["min_gram"]); isNumber {
+		minGram = int(value)
+	}
+	if value, isNumber := toFloat(options["max_gram"]); isNumber {
+		maxGram = int(value)
+	}
+	if minGram < 1 || minGram > maxGram {
+		return 0, 0, newAnalysisError("[%s] min_gram [%d] must be positive and not greater than max_gram [%d]", componentType, minGram, maxGram)
+	}
+
+	return minGram, maxGram, nil
+}
+
+// tokenChars reads the character classes kept in ngram tokens, every character when there are none.
+func tokenChars(value interface{}) (func(r rune) bool, error) {
+	classes := stringList(value)
+	if len(classes) == 0 {
+		return func(r rune) bool { return true }, nil
+	}
+
+	checks := make([]func(r rune) bool, 0, len(classes))
+	for _, class := range classes {
+		switch class {
+		case "letter":
+			checks = append(checks, unicode.IsLetter)
+		case "digit":
+			checks = append(checks, unicode.IsDigit)
+		case "whitespace":
+			checks = append(checks, unicode.IsSpace)
+		case "punctuation":
+			checks = append(checks, unicode.IsPunct)
+		case "symbol":
+			checks = append(checks, unicode.IsSymbol)
+		default:
+			return nil, newAnalysisError("Unknown token type: '%s', must be one of [letter, digit, whitespace, punctuation, symbol, custom]", class)
+		}
+	}
+
+	return func(r rune) bool {
+		for _, check := range checks {
+			if check(r) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func ngramTokenize(text string, minGram int, maxGram int, edge bool, isTokenChar func(r rune) bool) []analysisToken {
+	tokens := make([]analysisToken, 0)
+	for _, word := range splitTokenize(text, isTokenChar, "word") {
+		for _, gram := range ngrams(word, minGram, maxGram, edge) {
+			gram.position = len(tokens)
+			tokens = append(tokens, gram)
+		}
+	}
+
+	return tokens
+}
+
+// ngrams returns the grams of a token, ordered by start and then by length, or only the ones at the start
+// of the token for edge grams.
+func ngrams(token analysisToken, minGram int, maxGram int, edge bool) []analysisToken {
+	runes := []rune(token.term)
+	offsets := make([]int, len(runes)+1)
+	for position := range runes {
+		offsets[position+1] = offsets[position] + utf8.RuneLen(runes[position])
+	}
+
+	grams := make([]analysisToken, 0)
+	for start := 0; start < len(runes); start++ {
+		if edge && start > 0 {
+			break
+		}
+		for length := minGram; length <= maxGram && start+length <= len(runes); length++ {
+			startOffset, endOffset := token.startOffset+offsets[start], token.startOffset+offsets[start+length]
+			// Filters keep the offsets of the token when its term is not the text it comes from.
+			if token.endOffset-token.startOffset != len(token.term) {
+				startOffset, endOffset = token.startOffset, token.endOffset
+			}
+			grams = append(grams, analysisToken{
+				term:        string(runes[start : start+length]),
+				startOffset: startOffset,
+				endOffset:   endOffset,
+				tokenType:   token.tokenType,
+				position:    token.position,
+			})
+		}
+	}
+
+	return grams
+}
+
+// validateAnalyzers checks that the analyzers the text fields of the mapping refer to exist in the index.
+func (mapping *fieldMapping) validateAnalyzers(analysis *indexAnalysis) error {
+	for _, fullName := range mapping.fieldNames("") {
+		field, _ := mapping.lookupField(fullName)
+		for _, parameter := range []string{"analyzer", "search_analyzer", "search_quote_analyzer"} {
+			name, isString := field.options[parameter].(string)
+			if !isString {
+				continue
+			}
+			if _, err := analysis.analyzer(name); err != nil {
+				return newMapperParsingError("Failed to parse mapping: analyzer [%s] has not been configured in mappings", name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package elasticfacker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DynamicTrue    = "true"
+	DynamicFalse   = "false"
+	DynamicStrict  = "strict"
+	DynamicRuntime = "runtime"
+
+	DefaultIgnoreAbove = 256
+)
+
+// defaultDynamicDateFormats are the formats strings are checked against when date detection is enabled.
+var defaultDynamicDateFormats = []string{"strict_date_optional_time", "yyyy/MM/dd HH:mm:ss||yyyy/MM/dd"}
+
+// dynamicMapper adds the fields of a document missing from the mapping of the index, the way Elasticsearch
+// infers them when the document is indexed.
+type dynamicMapper struct {
+	documentId       string
+	root             *fieldMapping
+	dateDetection    bool
+	numericDetection bool
+	dateFormats      []string
+}
+
+// mapDocument returns the mapping updated with the new fields of the document, the mapping of the index is
+// only replaced once the whole document is mapped, so a rejected document adds no field. The document is
+// read with its numbers as they are written, 10.0 is mapped as a float where 10 is mapped as a long.
+func (mapping *fieldMapping) mapDocument(documentId string, body []byte) (*fieldMapping, error) {
+	var source map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	err := decoder.Decode(&source)
+	if err != nil {
+		return nil, newMapperParsingError("failed to parse")
+	}
+
+	mapper := &dynamicMapper{
+		documentId:    documentId,
+		root:          mapping.clone(),
+		dateDetection: true,
+		dateFormats:   defaultDynamicDateFormats,
+	}
+	if dateDetection, isBool := toBool(mapping.options["date_detection"]); isBool {
+		mapper.dateDetection = dateDetection
+	}
+	if numericDetection, isBool := toBool(mapping.options["numeric_detection"]); isBool {
+		mapper.numericDetection = numericDetection
+	}
+	if dateFormats, isArray := mapping.options["dynamic_date_formats"].([]interface{}); isArray {
+		mapper.dateFormats = make([]string, 0, len(dateFormats))
+		for _, dateFormat := range dateFormats {
+			mapper.dateFormats = append(mapper.dateFormats, fmt.Sprint(dateFormat))
+		}
+	}
+
+	err = mapper.mapObject(mapper.root, "", dynamicSetting(mapping, DynamicTrue), source)
+	if err != nil {
+		return nil, err
+	}
+
+	return mapper.root, nil
+}
+
+func dynamicSetting(mapping *fieldMapping, inherited string) string {
+	dynamic, exists := mapping.options["dynamic"]
+	if !exists {
+		return inherited
+	}
+
+	return fmt.Sprint(dynamic)
+}
+
+func (mapper *dynamicMapper) mapObject(object *fieldMapping, path string, dynamic string, source map[string]interface{}) error {
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fullName := joinFieldPath(path, name)
+		value := firstNonNullValue(source[name])
+		if value == nil {
+			continue
+		}
+
+		// Dots in field names are expanded to objects.
+		if dot := strings.Index(name, "."); dot > 0 {
+			if _, mapped := object.properties[name]; !mapped {
+				err := mapper.mapObject(object, path, dynamic, map[string]interface{}{
+					name[:dot]: map[string]interface{}{name[dot+1:]: source[name]},
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		property, mapped := object.properties[name]
+		if mapped {
+			if !property.isObject() {
+				if objectValue := findObjectValue(source[name]); objectValue != nil && isConcreteFieldType(property.fieldType) {
+					return newMapperParsingError("failed to parse field [%s] of type [%s] in document with id '%s'. Preview of field's value: '%v'",
+						fullName, property.fieldType, mapper.documentId, objectValue)
+				}
+				continue
+			}
+			if enabled, isBool := toBool(property.options["enabled"]); isBool && !enabled {
+				continue
+			}
+			childObject, isObject := value.(map[string]interface{})
+			if !isObject {
+				return newMapperParsingError("object mapping for [%s] tried to parse field [%s] as object, but found a concrete value", fullName, name)
+			}
+			err := mapper.mapObject(property, fullName, dynamicSetting(property, dynamic), childObject)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if mapper.isRuntimeField(fullName) {
+			continue
+		}
+
+		switch dynamic {
+		case DynamicStrict:
+			return &queryError{
+				errorType: "strict_dynamic_mapping_exception",
+				reason:    fmt.Sprintf("mapping set to strict, dynamic introduction of [%s] within [%s] is not allowed", name, dynamicParentName(path)),
+			}
+		case DynamicFalse:
+			continue
+		case DynamicRuntime:
+			mapper.mapRuntimeField(fullName, value)
+			continue
+		}
+
+		childObject, isObject := value.(map[string]interface{})
+		if !isObject {
+			object.properties[name] = mapper.inferField(value)
+			continue
+		}
+
+		property = newObjectMapping()
+		err := mapper.mapObject(property, fullName, dynamic, childObject)
+		if err != nil {
+			return err
+		}
+		object.properties[name] = property
+	}
+
+	return nil
+}
+
+func dynamicParentName(path string) string {
+	if path == "" {
+		return "_doc"
+	}
+
+	return path
+}
+
+// isConcreteFieldType reports whether values of the type can not be objects, unlike geo points, ranges or
+// flattened fields.
+func isConcreteFieldType(fieldType string) bool {
+	return numericFieldTypes[fieldType] || keywordFieldTypes[fieldType] || textFieldTypes[fieldType] ||
+		dateFieldTypes[fieldType] || fieldType == "boolean"
+}
+
+// findObjectValue returns the first object of a value or of the items of an array, nil when there is none.
+func findObjectValue(value interface{}) map[string]interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		return typedValue
+	case []interface{}:
+		for _, item := range typedValue {
+			if objectValue := findObjectValue(item); objectValue != nil {
+				return objectValue
+			}
+		}
+	}
+
+	return nil
+}
+
+// firstNonNullValue returns the value a field is mapped from, the first value that is not null for arrays.
+func firstNonNullValue(value interface{}) interface{} {
+	values, isArray := value.([]interface{})
+	if !isArray {
+		return value
+	}
+
+	for _, item := range values {
+		if item = firstNonNullValue(item); item != nil {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// inferField returns the mapping of a new field from its value: booleans, long or float numbers, dates and
+// numbers detected in strings, and text with a keyword multi-field for any other string.
+func (mapper *dynamicMapper) inferField(value interface{}) *fieldMapping {
+	mapping := &fieldMapping{options: make(map[string]interface{})}
+
+	switch typedValue := value.(type) {
+	case bool:
+		mapping.fieldType = "boolean"
+	case json.Number:
+		mapping.fieldType = numberFieldType(typedValue.String())
+	case string:
+		if fieldType, format := mapper.detectStringType(typedValue); fieldType != "" {
+			mapping.fieldType = fieldType
+			if format != "" {
+				mapping.options["format"] = format
+			}
+			break
+		}
+
+		mapping.fieldType = "text"
+		mapping.fields = map[string]*fieldMapping{
+			"keyword": {
+				fieldType: "keyword",
+				options:   map[string]interface{}{"ignore_above": float64(DefaultIgnoreAbove)},
+			},
+		}
+	default:
+		mapping.fieldType = "keyword"
+	}
+
+	return mapping
+}
+
+// numberFieldType returns the type of a number from the way it is written, numbers written with a decimal
+// part or an exponent are floats.
+func numberFieldType(literal string) string {
+	if strings.ContainsAny(literal, ".eE") {
+		return "float"
+	}
+	number, err := strconv.ParseFloat(literal, 64)
+	if err != nil || math.Abs(number) >= math.MaxInt64 {
+		return "float"
+	}
+
+	return "long"
+}
+
+// detectStringType returns the type of a string holding a date or, with numeric detection, a number, and
+// the format the date was detected with when it is not the default one.
+func (mapper *dynamicMapper) detectStringType(value string) (string, string) {
+	_, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		if mapper.numericDetection {
+			return numberFieldType(value), ""
+		}
+		return "", ""
+	}
+
+	if !mapper.dateDetection {
+		return "", ""
+	}
+	for position, format := range mapper.dateFormats {
+		parser, err := newDateFormat(format)
+		if err != nil {
+			continue
+		}
+		if _, err := parser.parse(value, time.UTC); err == nil {
+			if position == 0 && format == defaultDynamicDateFormats[0] {
+				return "date", ""
+			}
+			return "date", format
+		}
+	}
+
+	return "", ""
+}
+
+func (mapper *dynamicMapper) isRuntimeField(fullName string) bool {
+	runtimeFields, _ := mapper.root.options["runtime"].(map[string]interface{})
+	_, exists := runtimeFields[fullName]
+
+	return exists
+}
+
+// mapRuntimeField adds a runtime field for a new field when dynamic is runtime, strings become keywords
+// and objects are mapped as one runtime field per leaf.
+func (mapper *dynamicMapper) mapRuntimeField(fullName string, value interface{}) {
+	if object, isObject := value.(map[string]interface{}); isObject {
+		for name, child := range object {
+			if child = firstNonNullValue(child); child != nil && !mapper.isRuntimeField(fullName+"."+name) {
+				mapper.mapRuntimeField(fullName+"."+name, child)
+			}
+		}
+		return
+	}
+
+	fieldType := "keyword"
+	switch typedValue := value.(type) {
+	case bool:
+		fieldType = "boolean"
+	case json.Number:
+		fieldType = numberFieldType(typedValue.String())
+		if fieldType == "float" {
+			fieldType = "double"
+		}
+	case string:
+		detectedType, _ := mapper.detectStringType(typedValue)
+		switch detectedType {
+		case "date", "long":
+			fieldType = detectedType
+		case "float":
+			fieldType = "double"
+		}
+	}
+
+	runtimeFields, _ := mapper.root.options["runtime"].(map[string]interface{})
+	if runtimeFields == nil {
+		runtimeFields = make(map[string]interface{})
+	} else {
+		copied := make(map[string]interface{}, len(runtimeFields)+1)
+		for name, field := range runtimeFields {
+			copied[name] = field
+		}
+		runtimeFields = copied
+	}
+	runtimeFields[fullName] = map[string]interface{}{"type": fieldType}
+	mapper.root.options["runtime"] = runtimeFields
+}
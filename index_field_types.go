@@ -0,0 +1,384 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var numericFieldTypes = map[string]bool{
+	"long": true, "integer": true, "short": true, "byte": true, "unsigned_long": true,
+	"double": true, "float": true, "half_float": true, "scaled_float": true,
+}
+
+var integerFieldTypes = map[string]bool{
+	"long": true, "integer": true, "short": true, "byte": true, "unsigned_long": true,
+}
+
+var keywordFieldTypes = map[string]bool{
+	"keyword": true, "constant_keyword": true, "wildcard": true, "version": true, "ip": true,
+}
+
+var textFieldTypes = map[string]bool{
+	"text": true, "match_only_text": true, "search_as_you_type": true,
+}
+
+var dateFieldTypes = map[string]bool{
+	"date": true, "date_nanos": true,
+}
+
+// mappedField is a field as the mapping of its index describes it. It turns the values of documents and
+// queries into the terms the field indexes, so that they compare the way they do in Elasticsearch: text
+// is analysed, keywords are exact, dates are epoch milliseconds and numbers are coerced. A field of an
+// index without mapping has no type and compares the raw values of the source.
+type mappedField struct {
+	name            string
+	sourcePath      string
+	fieldType       string
+	unmapped        bool
+	format          *dateFormat
+	ignoreAbove     int
+	coerce          bool
+	ignoreMalformed bool
+	fielddata       bool
+	analyzer        *analyzer
+	searchAnalyzer  *analyzer
+}
+
+func (es *InMemoryElasticsearch) mappedField(indexName string, name string) *mappedField {
+	mapping, exists := es.indicesMappings[indexName]
+	if !exists {
+		return &mappedField{name: name, sourcePath: name, coerce: true}
+	}
+
+	return newMappedField(mapping, es.indicesSettings[indexName], es.indicesAnalysis[indexName], name)
+}
+
+func newMappedField(mapping *fieldMapping, settings map[string]interface{}, analysis *indexAnalysis, name string) *mappedField {
+	field := &mappedField{
+		name:       name,
+		sourcePath: name,
+		coerce:     true,
+	}
+	if coerce, isBool := toBool(indexSetting(settings, "mapping.coerce")); isBool {
+		field.coerce = coerce
+	}
+	if ignoreMalformed, isBool := toBool(indexSetting(settings, "mapping.ignore_malformed")); isBool {
+		field.ignoreMalformed = ignoreMalformed
+	}
+
+	switch name {
+	case "_id", "_index":
+		field.fieldType = "keyword"
+		return field
+	}
+
+	options := map[string]interface{}{}
+	runtimeFields, _ := mapping.options["runtime"].(map[string]interface{})
+	if runtimeField, isRuntime := runtimeFields[name].(map[string]interface{}); isRuntime {
+		field.fieldType, _ = runtimeField["type"].(string)
+		options = runtimeField
+	} else {
+		leaf, sourcePath := mapping.lookupField(name)
+		if leaf == nil {
+			field.unmapped = true
+			field.analyzer = analysis.defaultAnalyzer(false)
+			field.searchAnalyzer = analysis.defaultAnalyzer(true)
+			return field
+		}
+		field.fieldType = leaf.fieldType
+		field.sourcePath = sourcePath
+		options = leaf.options
+	}
+
+	if dateFieldTypes[field.fieldType] {
+		format, _ := options["format"].(string)
+		field.format, _ = newDateFormat(format)
+	}
+	if textFieldTypes[field.fieldType] {
+		field.analyzer, field.searchAnalyzer = fieldAnalyzers(analysis, options)
+		field.fielddata, _ = toBool(options["fielddata"])
+	}
+	if ignoreAbove, isNumber := toFloat(options["ignore_above"]); isNumber {
+		field.ignoreAbove = int(ignoreAbove)
+	}
+	if coerce, isBool := toBool(options["coerce"]); isBool {
+		field.coerce = coerce
+	}
+	if ignoreMalformed, isBool := toBool(options["ignore_malformed"]); isBool {
+		field.ignoreMalformed = ignoreMalformed
+	}
+
+	return field
+}
+
+func (field *mappedField) isText() bool {
+	return field.fieldType == "" || textFieldTypes[field.fieldType]
+}
+
+// isDateField reports whether the field holds dates, whose terms are epoch milliseconds.
+func (field *mappedField) isDateField() bool {
+	return dateFieldTypes[field.fieldType]
+}
+
+// fieldAnalyzers returns the analyzers of a text field, for indexing and for searching. The search analyzer
+// is the search_analyzer of the field, its analyzer, or the default analyzers of the index.
+func fieldAnalyzers(analysis *indexAnalysis, options map[string]interface{}) (*analyzer, *analyzer) {
+	indexAnalyzer := analysis.defaultAnalyzer(false)
+	searchAnalyzer := analysis.defaultAnalyzer(true)
+	if name, isString := options["analyzer"].(string); isString {
+		if fieldAnalyzer, err := analysis.analyzer(name); err == nil {
+			indexAnalyzer, searchAnalyzer = fieldAnalyzer, fieldAnalyzer
+		}
+	}
+	if name, isString := options["search_analyzer"].(string); isString {
+		if fieldAnalyzer, err := analysis.analyzer(name); err == nil {
+			searchAnalyzer = fieldAnalyzer
+		}
+	}
+
+	return indexAnalyzer, searchAnalyzer
+}
+
+// analyze splits a text into the terms a text field indexes, fields without a type are tokenized.
+func (field *mappedField) analyze(text string) []string {
+	if field.analyzer == nil {
+		return tokenize(text)
+	}
+
+	return field.analyzer.terms(text)
+}
+
+// analyzeQuery splits the text of a query into the terms it searches, with the search analyzer of the field.
+func (field *mappedField) analyzeQuery(text string) []string {
+	if field.searchAnalyzer == nil {
+		return field.analyze(text)
+	}
+
+	return field.searchAnalyzer.terms(text)
+}
+
+// documentValues returns the values of the field in the document, the source values for text fields and
+// for fields without a type, and the terms indexed by the field for the other types.
+func (field *mappedField) documentValues(document Document) []interface{} {
+	if field.unmapped {
+		return nil
+	}
+
+	values := fieldValues(document, field.sourcePath)
+	if field.isText() {
+		return values
+	}
+
+	terms := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		term, err := field.indexedValue(value)
+		if err == nil && term != nil {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+// documentTerms returns the terms the field indexes for the document, text is split in tokens.
+func (field *mappedField) documentTerms(document Document) []interface{} {
+	values := field.documentValues(document)
+	if field.fieldType == "" || !field.isText() {
+		return values
+	}
+
+	terms := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		for _, token := range field.analyze(fmt.Sprint(value)) {
+			terms = append(terms, token)
+		}
+	}
+
+	return terms
+}
+
+// aggregatable returns the error of aggregating or sorting on the field: text fields have no doc values,
+// unless fielddata is enabled on them.
+func (field *mappedField) aggregatable() error {
+	if textFieldTypes[field.fieldType] && !field.fielddata {
+		return newFielddataError(field.name)
+	}
+
+	return nil
+}
+
+// docValues returns the values aggregations and sorts read for the field in the document, the terms the
+// field indexes, which for text fields with fielddata are their tokens.
+func (field *mappedField) docValues(document Document) []interface{} {
+	if textFieldTypes[field.fieldType] {
+		return field.documentTerms(document)
+	}
+
+	return field.documentValues(document)
+}
+
+// matchTerms returns the terms match queries compare with, fields without a type are analysed like text.
+func (field *mappedField) matchTerms(document Document) []interface{} {
+	if field.fieldType != "" {
+		return field.documentTerms(document)
+	}
+
+	terms := make([]interface{}, 0)
+	for _, value := range field.documentValues(document) {
+		for _, token := range field.analyze(fmt.Sprint(value)) {
+			terms = append(terms, token)
+		}
+	}
+
+	return terms
+}
+
+// indexedValue returns the term a value of the source is indexed as, an error when the value is malformed
+// for the type of the field, and no term for keywords longer than ignore_above.
+func (field *mappedField) indexedValue(value interface{}) (interface{}, error) {
+	switch {
+	case numericFieldTypes[field.fieldType]:
+		text, isString := value.(string)
+		if isString && !field.coerce {
+			return nil, fmt.Errorf("[coerce] is false, strings are not accepted for [%s]", field.fieldType)
+		}
+		number, isNumber := toFloat(value)
+		if !isNumber {
+			if isString {
+				return nil, fmt.Errorf("For input string: \"%s\"", text)
+			}
+			return nil, fmt.Errorf("Current token is not a number")
+		}
+		if integerFieldTypes[field.fieldType] && number != math.Trunc(number) {
+			if !field.coerce {
+				return nil, fmt.Errorf("Value [%v] has a decimal part", value)
+			}
+			number = math.Trunc(number)
+		}
+		return number, nil
+	case field.fieldType == "boolean":
+		if value == "" {
+			return false, nil
+		}
+		boolean, isBool := toBool(value)
+		if !isBool {
+			return nil, fmt.Errorf("Failed to parse value [%v] as only [true] or [false] are allowed.", value)
+		}
+		return boolean, nil
+	case dateFieldTypes[field.fieldType]:
+		date, err := field.format.parse(value, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		return float64(date.UnixMilli()), nil
+	case keywordFieldTypes[field.fieldType]:
+		var text string
+		switch typedValue := value.(type) {
+		case string:
+			text = typedValue
+		case float64:
+			text = strconv.FormatFloat(typedValue, 'f', -1, 64)
+		default:
+			text = fmt.Sprint(typedValue)
+		}
+		if field.ignoreAbove > 0 && len([]rune(text)) > field.ignoreAbove {
+			return nil, nil
+		}
+		return text, nil
+	default:
+		return value, nil
+	}
+}
+
+// queryValue returns the term a value of a query is compared with, an error when it can not be one for
+// the type of the field. Text and fields without a type keep the value as it is.
+func (field *mappedField) queryValue(value interface{}) (interface{}, error) {
+	switch {
+	case field.isText() || field.unmapped:
+		return value, nil
+	case numericFieldTypes[field.fieldType]:
+		number, isNumber := toFloat(value)
+		if !isNumber {
+			return nil, newQueryShardError("failed to create query: For input string: \"%v\"", value)
+		}
+		return number, nil
+	case field.fieldType == "boolean":
+		boolean, isBool := toBool(value)
+		if !isBool {
+			return nil, newQueryShardError("failed to create query: Can't parse boolean value [%v], expected [true] or [false]", value)
+		}
+		return boolean, nil
+	case dateFieldTypes[field.fieldType]:
+		return field.queryDate(value, nil, nil, false)
+	case keywordFieldTypes[field.fieldType]:
+		term, _ := (&mappedField{fieldType: field.fieldType}).indexedValue(value)
+		return term, nil
+	default:
+		return value, nil
+	}
+}
+
+// queryDate returns the epoch milliseconds of a date of a query, which can be date math. The format of
+// the query, if any, is used instead of the one of the field.
+func (field *mappedField) queryDate(value interface{}, format *dateFormat, location *time.Location, roundUp bool) (float64, error) {
+	if format == nil {
+		format = field.format
+	}
+
+	date, err := parseDateMath(value, format, location, roundUp)
+	if err != nil {
+		return 0, newQueryShardError("failed to create query: %s", err.Error())
+	}
+
+	return float64(date.UnixMilli()), nil
+}
+
+func newFielddataError(name string) *queryError {
+	return &queryError{
+		errorType: "illegal_argument_exception",
+		reason: fmt.Sprintf("Text fields are not optimised for operations that require per-document field data like "+
+			"aggregations and sorting, so these operations are disabled by default. Please use a keyword field instead. "+
+			"Alternatively, set fielddata=true on [%s] in order to load field data by uninverting the inverted index. "+
+			"Note that this can use significant memory.", name),
+	}
+}
+
+func newQueryShardError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "query_shard_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// parseDocumentFields checks the values of the document against the mapping of its fields. Malformed
+// values are rejected, unless the field ignores them, and the names of the fields that ignored a value,
+// for being malformed or above ignore_above, are returned.
+func parseDocumentFields(mapping *fieldMapping, settings map[string]interface{}, analysis *indexAnalysis, document Document) ([]string, error) {
+	ignored := make([]string, 0)
+	for _, name := range mapping.fieldNames("") {
+		field := newMappedField(mapping, settings, analysis, name)
+		if field.fieldType == FieldTypeObject || field.fieldType == FieldTypeNested || field.isText() {
+			continue
+		}
+
+		for _, value := range fieldValues(document, field.sourcePath) {
+			term, err := field.indexedValue(value)
+			if err != nil && !field.ignoreMalformed {
+				return nil, newMapperParsingError("failed to parse field [%s] of type [%s] in document with id '%s'. Preview of field's value: '%v'",
+					name, field.fieldType, document.Id, value)
+			}
+			if err != nil || term == nil {
+				ignored = append(ignored, name)
+				break
+			}
+		}
+	}
+
+	if len(ignored) == 0 {
+		return nil, nil
+	}
+
+	return ignored, nil
+}
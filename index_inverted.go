@@ -0,0 +1,266 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const (
+	BM25K1 = 1.2
+	BM25B  = 0.75
+)
+
+// invertedIndex holds the terms the text fields of the documents of an index are analysed into, with the
+// statistics BM25 scores them with. It is updated on every write, so scores follow the documents the index
+// holds, like the ones of a single shard without deleted documents.
+type invertedIndex struct {
+	documents map[string]map[string]*fieldTerms
+	fields    map[string]*fieldStatistics
+}
+
+// fieldTerms are the terms of a field of a document, with the positions each term is found at, and the
+// length of the field.
+type fieldTerms struct {
+	positions map[string][]int
+	length    int
+}
+
+// fieldStatistics are the number of documents that have a field, the number of terms of the field in all of
+// them and the number of documents holding each term.
+type fieldStatistics struct {
+	docCount      int
+	totalTermFreq int
+	docFreqs      map[string]int
+}
+
+func newInvertedIndex() *invertedIndex {
+	return &invertedIndex{
+		documents: make(map[string]map[string]*fieldTerms),
+		fields:    make(map[string]*fieldStatistics),
+	}
+}
+
+// clone returns a copy of the index that later writes to the index do not change.
+func (index *invertedIndex) clone() *invertedIndex {
+	cloned := newInvertedIndex()
+	for documentId, documentFields := range index.documents {
+		cloned.documents[documentId] = documentFields
+	}
+	for field, statistics := range index.fields {
+		docFreqs := make(map[string]int, len(statistics.docFreqs))
+		for term, docFreq := range statistics.docFreqs {
+			docFreqs[term] = docFreq
+		}
+		cloned.fields[field] = &fieldStatistics{
+			docCount:      statistics.docCount,
+			totalTermFreq: statistics.totalTermFreq,
+			docFreqs:      docFreqs,
+		}
+	}
+
+	return cloned
+}
+
+// add indexes the tokens of the text fields of a document, replacing the terms of a previous version.
+func (index *invertedIndex) add(documentId string, fieldTokens map[string][]analysisToken) {
+	index.remove(documentId)
+
+	documentFields := make(map[string]*fieldTerms, len(fieldTokens))
+	for field, tokens := range fieldTokens {
+		if len(tokens) == 0 {
+			continue
+		}
+		terms := &fieldTerms{positions: make(map[string][]int)}
+		lastPosition := -1
+		for _, token := range tokens {
+			terms.positions[token.term] = append(terms.positions[token.term], token.position)
+			// Tokens at the position of the previous one, like synonyms, do not make the field longer.
+			if token.position != lastPosition {
+				terms.length++
+			}
+			lastPosition = token.position
+		}
+		documentFields[field] = terms
+
+		statistics, exists := index.fields[field]
+		if !exists {
+			statistics = &fieldStatistics{docFreqs: make(map[string]int)}
+			index.fields[field] = statistics
+		}
+		statistics.docCount++
+		statistics.totalTermFreq += len(tokens)
+		for term := range terms.positions {
+			statistics.docFreqs[term]++
+		}
+	}
+
+	index.documents[documentId] = documentFields
+}
+
+func (index *invertedIndex) remove(documentId string) {
+	for field, terms := range index.documents[documentId] {
+		statistics := index.fields[field]
+		statistics.docCount--
+		for term, positions := range terms.positions {
+			statistics.totalTermFreq -= len(positions)
+			statistics.docFreqs[term]--
+			if statistics.docFreqs[term] == 0 {
+				delete(statistics.docFreqs, term)
+			}
+		}
+		if statistics.docCount == 0 {
+			delete(index.fields, field)
+		}
+	}
+
+	delete(index.documents, documentId)
+}
+
+// termPositions returns the positions of the term in the field of the document.
+func (index *invertedIndex) termPositions(documentId string, field string, term string) []int {
+	terms, exists := index.documents[documentId][field]
+	if !exists {
+		return nil
+	}
+
+	return terms.positions[term]
+}
+
+// score returns the BM25 score of a term in the field of a document.
+func (index *invertedIndex) score(documentId string, field string, term string) float64 {
+	frequency := len(index.termPositions(documentId, field, term))
+	if frequency == 0 {
+		return 0
+	}
+
+	return index.scoreFrequency(documentId, field, index.idf(field, index.docFreq(field, term)), float32(frequency))
+}
+
+func (index *invertedIndex) docFreq(field string, term string) int {
+	statistics, exists := index.fields[field]
+	if !exists {
+		return 0
+	}
+
+	return statistics.docFreqs[term]
+}
+
+// idf returns the inverse document frequency of a term held by docFreq documents of the field.
+func (index *invertedIndex) idf(field string, docFreq int) float32 {
+	statistics, exists := index.fields[field]
+	if !exists {
+		return 0
+	}
+
+	docCount := float64(statistics.docCount)
+
+	return float32(math.Log(1 + (docCount-float64(docFreq)+0.5)/(float64(docFreq)+0.5)))
+}
+
+// scoreFrequency returns the BM25 score of a frequency in the field of a document, computed with the same
+// single precision arithmetic and the same lossy field lengths as Lucene, so scores match the ones of
+// Elasticsearch. Phrases are scored with the sum of the idf of their terms and their own frequency.
+func (index *invertedIndex) scoreFrequency(documentId string, field string, idf float32, frequency float32) float64 {
+	statistics, exists := index.fields[field]
+	terms, indexed := index.documents[documentId][field]
+	if !exists || !indexed || frequency == 0 {
+		return 0
+	}
+
+	averageLength := float32(float64(statistics.totalTermFreq) / float64(statistics.docCount))
+	length := float32(decodeFieldLength(encodeFieldLength(terms.length)))
+	normInverse := 1 / (float32(BM25K1) * ((1 - float32(BM25B)) + float32(BM25B)*length/averageLength))
+
+	return float64(idf - idf/(1+frequency*normInverse))
+}
+
+// expandPrefix returns the terms of the field starting with the prefix, in order, up to maxExpansions.
+func (index *invertedIndex) expandPrefix(field string, prefix string, maxExpansions int) []string {
+	statistics, exists := index.fields[field]
+	if !exists {
+		return nil
+	}
+
+	terms := make([]string, 0)
+	for term := range statistics.docFreqs {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+	if len(terms) > maxExpansions {
+		terms = terms[:maxExpansions]
+	}
+
+	return terms
+}
+
+// fieldLengthFreeValues is the number of field lengths Lucene stores exactly, longer ones lose precision.
+const fieldLengthFreeValues = 255 - 231
+
+// encodeFieldLength stores a field length in a byte, the way Lucene stores norms.
+func encodeFieldLength(length int) int {
+	if length < fieldLengthFreeValues {
+		return length
+	}
+
+	return fieldLengthFreeValues + longToInt4(length-fieldLengthFreeValues)
+}
+
+func decodeFieldLength(encoded int) int {
+	if encoded < fieldLengthFreeValues {
+		return encoded
+	}
+
+	return fieldLengthFreeValues + int4ToLong(encoded-fieldLengthFreeValues)
+}
+
+// longToInt4 encodes a number in a float with 3 bits of mantissa and 5 bits of exponent.
+func longToInt4(number int) int {
+	numBits := bits.Len(uint(number))
+	if numBits < 4 {
+		return number
+	}
+
+	shift := numBits - 4
+	encoded := (number >> shift) & 0x07
+
+	return encoded | (shift+1)<<3
+}
+
+func int4ToLong(encoded int) int {
+	mantissa := encoded & 0x07
+	shift := (encoded >> 3) - 1
+	if shift == -1 {
+		return mantissa
+	}
+
+	return (mantissa | 0x08) << shift
+}
+
+// documentTokens returns the tokens of the text fields of a document, analysed with the index analyzer of
+// each field.
+func documentTokens(mapping *fieldMapping, settings map[string]interface{}, analysis *indexAnalysis, document Document) map[string][]analysisToken {
+	fieldTokens := make(map[string][]analysisToken)
+	for _, name := range mapping.fieldNames("") {
+		field := newMappedField(mapping, settings, analysis, name)
+		if !textFieldTypes[field.fieldType] || field.analyzer == nil {
+			continue
+		}
+
+		texts := make([]string, 0)
+		for _, value := range fieldValues(document, field.sourcePath) {
+			if _, isObject := value.(map[string]interface{}); !isObject && value != nil {
+				texts = append(texts, fmt.Sprint(value))
+			}
+		}
+		if len(texts) > 0 {
+			fieldTokens[name] = field.analyzer.analyzeValues(texts)
+		}
+	}
+
+	return fieldTokens
+}
@@ -0,0 +1,434 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	FieldTypeObject = "object"
+	FieldTypeNested = "nested"
+)
+
+// fieldTypes are the field types accepted in a mapping.
+var fieldTypes = map[string]bool{
+	"text": true, "match_only_text": true, "keyword": true, "constant_keyword": true, "wildcard": true,
+	"long": true, "integer": true, "short": true, "byte": true, "double": true, "float": true, "half_float": true,
+	"scaled_float": true, "unsigned_long": true, "boolean": true, "date": true, "date_nanos": true,
+	"object": true, "nested": true, "flattened": true, "ip": true, "binary": true, "geo_point": true, "geo_shape": true,
+	"search_as_you_type": true, "completion": true, "token_count": true, "alias": true, "version": true,
+	"integer_range": true, "float_range": true, "long_range": true, "double_range": true, "date_range": true, "ip_range": true,
+	"dense_vector": true, "sparse_vector": true, "rank_feature": true, "rank_features": true, "percolator": true, "histogram": true,
+}
+
+// rootMappingParameters are the parameters a mapping accepts next to its properties.
+var rootMappingParameters = map[string]bool{
+	"dynamic": true, "date_detection": true, "numeric_detection": true, "dynamic_date_formats": true,
+	"dynamic_templates": true, "_source": true, "_routing": true, "_meta": true, "runtime": true, "enabled": true,
+	"subobjects": true,
+}
+
+// updateableMappingParameters are the field parameters that a mapping update can change.
+var updateableMappingParameters = map[string]bool{
+	"ignore_above": true, "search_analyzer": true, "search_quote_analyzer": true, "meta": true,
+	"ignore_malformed": true, "copy_to": true, "dynamic": true, "date_detection": true, "numeric_detection": true,
+	"dynamic_date_formats": true, "dynamic_templates": true, "_meta": true, "runtime": true,
+}
+
+// fieldMapping is the mapping of a field, or of the root object of an index. Objects have properties, the
+// other types can have multi-fields, and any other parameter of the field is kept in options.
+type fieldMapping struct {
+	fieldType  string
+	properties map[string]*fieldMapping
+	fields     map[string]*fieldMapping
+	options    map[string]interface{}
+}
+
+func newObjectMapping() *fieldMapping {
+	return &fieldMapping{
+		fieldType:  FieldTypeObject,
+		properties: make(map[string]*fieldMapping),
+		options:    make(map[string]interface{}),
+	}
+}
+
+func (mapping *fieldMapping) isObject() bool {
+	return mapping.fieldType == FieldTypeObject || mapping.fieldType == FieldTypeNested
+}
+
+func newMapperParsingError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "mapper_parsing_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// newMappingConflictError returns the error of a mapping update that changes what an existing field can not change.
+func newMappingConflictError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "illegal_argument_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// parseMapping parses the mappings object of an index creation or of a put mapping request.
+func parseMapping(definition map[string]interface{}) (*fieldMapping, error) {
+	root := newObjectMapping()
+
+	unsupported := make([]string, 0)
+	for key, value := range definition {
+		switch {
+		case key == "properties":
+			properties, err := parseProperties("_doc", value)
+			if err != nil {
+				return nil, err
+			}
+			root.properties = properties
+		case rootMappingParameters[key]:
+			if key == "dynamic" {
+				err := validateDynamic(value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			root.options[key] = value
+		default:
+			unsupported = append(unsupported, fmt.Sprintf("%s : %v", key, value))
+		}
+	}
+
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, newMapperParsingError("Root mapping definition has unsupported parameters:  [%s]", strings.Join(unsupported, "] ["))
+	}
+
+	return root, nil
+}
+
+func parseProperties(parentName string, value interface{}) (map[string]*fieldMapping, error) {
+	definitions, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, newMapperParsingError("Expected map for property [properties] on field [%s] but got a %T", parentName, value)
+	}
+
+	properties := make(map[string]*fieldMapping, len(definitions))
+	for name, definition := range definitions {
+		property, err := parseFieldMapping(name, definition)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = property
+	}
+
+	return properties, nil
+}
+
+func parseFieldMapping(name string, definition interface{}) (*fieldMapping, error) {
+	definitionObject, ok := definition.(map[string]interface{})
+	if !ok {
+		return nil, newMapperParsingError("Expected map for property [fields] on field [%s] but got a %T", name, definition)
+	}
+
+	mapping := &fieldMapping{
+		fieldType: FieldTypeObject,
+		options:   make(map[string]interface{}),
+	}
+	if fieldType, exists := definitionObject["type"]; exists {
+		fieldTypeString, _ := fieldType.(string)
+		if !fieldTypes[fieldTypeString] {
+			return nil, newMapperParsingError("No handler for type [%v] declared on field [%s]", fieldType, name)
+		}
+		mapping.fieldType = fieldTypeString
+	}
+
+	for key, value := range definitionObject {
+		switch key {
+		case "type":
+		case "properties":
+			if !mapping.isObject() {
+				return nil, newMapperParsingError("unknown parameter [properties] on mapper [%s] of type [%s]", name, mapping.fieldType)
+			}
+			properties, err := parseProperties(name, value)
+			if err != nil {
+				return nil, err
+			}
+			mapping.properties = properties
+		case "fields":
+			if mapping.isObject() {
+				return nil, newMapperParsingError("unknown parameter [fields] on mapper [%s] of type [%s]", name, mapping.fieldType)
+			}
+			fields, err := parseProperties(name, value)
+			if err != nil {
+				return nil, err
+			}
+			mapping.fields = fields
+		case "dynamic":
+			if !mapping.isObject() {
+				return nil, newMapperParsingError("unknown parameter [dynamic] on mapper [%s] of type [%s]", name, mapping.fieldType)
+			}
+			err := validateDynamic(value)
+			if err != nil {
+				return nil, err
+			}
+			mapping.options[key] = value
+		case "format":
+			format, _ := value.(string)
+			if _, err := newDateFormat(format); err != nil {
+				return nil, newMapperParsingError("Invalid format: [%v]: %s", value, err.Error())
+			}
+			mapping.options[key] = value
+		default:
+			mapping.options[key] = value
+		}
+	}
+
+	if mapping.isObject() && mapping.properties == nil {
+		mapping.properties = make(map[string]*fieldMapping)
+	}
+
+	return mapping, nil
+}
+
+func validateDynamic(value interface{}) error {
+	switch fmt.Sprint(value) {
+	case "true", "false", "strict", "runtime":
+		return nil
+	default:
+		return newMapperParsingError("Could not convert [dynamic] to boolean or strict or runtime, found [%v]", value)
+	}
+}
+
+// toJSON returns the mapping the way the get mapping API prints it, objects have no type.
+func (mapping *fieldMapping) toJSON() map[string]interface{} {
+	result := make(map[string]interface{}, len(mapping.options)+2)
+	for key, value := range mapping.options {
+		result[key] = value
+	}
+
+	if mapping.fieldType != FieldTypeObject {
+		result["type"] = mapping.fieldType
+	}
+	if len(mapping.properties) > 0 {
+		properties := make(map[string]interface{}, len(mapping.properties))
+		for name, property := range mapping.properties {
+			properties[name] = property.toJSON()
+		}
+		result["properties"] = properties
+	}
+	if len(mapping.fields) > 0 {
+		fields := make(map[string]interface{}, len(mapping.fields))
+		for name, field := range mapping.fields {
+			fields[name] = field.toJSON()
+		}
+		result["fields"] = fields
+	}
+
+	return result
+}
+
+func (mapping *fieldMapping) clone() *fieldMapping {
+	cloned := &fieldMapping{
+		fieldType: mapping.fieldType,
+		options:   make(map[string]interface{}, len(mapping.options)),
+	}
+	for key, value := range mapping.options {
+		cloned.options[key] = value
+	}
+	if mapping.properties != nil {
+		cloned.properties = make(map[string]*fieldMapping, len(mapping.properties))
+		for name, property := range mapping.properties {
+			cloned.properties[name] = property.clone()
+		}
+	}
+	if mapping.fields != nil {
+		cloned.fields = make(map[string]*fieldMapping, len(mapping.fields))
+		for name, field := range mapping.fields {
+			cloned.fields[name] = field.clone()
+		}
+	}
+
+	return cloned
+}
+
+// combine adds the fields of another mapping this one does not map, the fields both map keep this mapping.
+func (mapping *fieldMapping) combine(other *fieldMapping) {
+	for key, value := range other.options {
+		if _, exists := mapping.options[key]; !exists {
+			mapping.options[key] = value
+		}
+	}
+	for name, property := range other.properties {
+		if existing, exists := mapping.properties[name]; exists {
+			existing.combine(property)
+			continue
+		}
+		if mapping.properties == nil {
+			mapping.properties = make(map[string]*fieldMapping)
+		}
+		mapping.properties[name] = property.clone()
+	}
+	for name, field := range other.fields {
+		if existing, exists := mapping.fields[name]; exists {
+			existing.combine(field)
+			continue
+		}
+		if mapping.fields == nil {
+			mapping.fields = make(map[string]*fieldMapping)
+		}
+		mapping.fields[name] = field.clone()
+	}
+}
+
+// merge applies a mapping update, new fields are added and existing ones can only change the parameters
+// that are updateable, like Elasticsearch does.
+func (mapping *fieldMapping) merge(fullName string, update *fieldMapping) error {
+	if mapping.isObject() != update.isObject() {
+		return newMappingConflictError("can't merge a non object mapping [%s] with an object mapping", fullName)
+	}
+	if mapping.isObject() && mapping.fieldType != update.fieldType {
+		if mapping.fieldType == FieldTypeNested {
+			return newMappingConflictError("object mapping [%s] can't be changed from nested to non-nested", fullName)
+		}
+		return newMappingConflictError("object mapping [%s] can't be changed from non-nested to nested", fullName)
+	}
+	if mapping.fieldType != update.fieldType {
+		return newMappingConflictError("mapper [%s] cannot be changed from type [%s] to [%s]", fullName, mapping.fieldType, update.fieldType)
+	}
+
+	if mapping.isObject() {
+		for key, value := range update.options {
+			if !updateableMappingParameters[key] && !reflect.DeepEqual(mapping.options[key], value) {
+				if _, exists := mapping.options[key]; exists || key == "enabled" {
+					return newMappingConflictError("the [%s] parameter can't be updated for the object mapping [%s]", key, fullName)
+				}
+			}
+			mapping.options[key] = value
+		}
+		for name, property := range update.properties {
+			existing, exists := mapping.properties[name]
+			if !exists {
+				mapping.properties[name] = property
+				continue
+			}
+			err := existing.merge(joinFieldPath(fullName, name), property)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	conflicts := make([]string, 0)
+	for _, key := range mappingParameterNames(mapping.options, update.options) {
+		current, hasCurrent := mapping.options[key]
+		updated, hasUpdated := update.options[key]
+		if updateableMappingParameters[key] || reflect.DeepEqual(current, updated) {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("Cannot update parameter [%s] from [%s] to [%s]",
+			key, mappingParameterValue(current, hasCurrent), mappingParameterValue(updated, hasUpdated)))
+	}
+	if len(conflicts) > 0 {
+		return newMappingConflictError("Mapper for [%s] conflicts with existing mapper:\n\t%s", fullName, strings.Join(conflicts, "\n\t"))
+	}
+
+	mapping.options = update.options
+	for name, field := range update.fields {
+		if mapping.fields == nil {
+			mapping.fields = make(map[string]*fieldMapping)
+		}
+		existing, exists := mapping.fields[name]
+		if !exists {
+			mapping.fields[name] = field
+			continue
+		}
+		err := existing.merge(fullName+"."+name, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mappingParameterNames(current map[string]interface{}, updated map[string]interface{}) []string {
+	names := make([]string, 0, len(current)+len(updated))
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range updated {
+		if _, exists := current[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func mappingParameterValue(value interface{}, exists bool) string {
+	if !exists {
+		return "default"
+	}
+
+	return fmt.Sprint(value)
+}
+
+func joinFieldPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+// lookupField returns the mapping of a field by its full name, multi-fields included, and the path of the
+// field in the source, which for a multi-field is the one of the field holding it.
+func (mapping *fieldMapping) lookupField(fullName string) (*fieldMapping, string) {
+	path := strings.Split(fullName, ".")
+
+	current := mapping
+	sourcePath := ""
+	for position := 0; position < len(path); position++ {
+		var next *fieldMapping
+		// Field names may contain dots themselves, so the longest matching name is tried first.
+		for length := len(path) - position; length > 0 && next == nil; length-- {
+			name := strings.Join(path[position:position+length], ".")
+			if child, exists := current.properties[name]; exists {
+				next = child
+				sourcePath = joinFieldPath(sourcePath, name)
+			} else if child, exists := current.fields[name]; exists {
+				next = child
+			}
+			if next != nil {
+				position += length - 1
+			}
+		}
+		if next == nil {
+			return nil, ""
+		}
+		current = next
+	}
+
+	return current, sourcePath
+}
+
+// fieldNames returns the full names of every field of the mapping, multi-fields included, in order.
+func (mapping *fieldMapping) fieldNames(prefix string) []string {
+	names := make([]string, 0)
+	for name, property := range mapping.properties {
+		fullName := joinFieldPath(prefix, name)
+		names = append(names, fullName)
+		names = append(names, property.fieldNames(fullName)...)
+	}
+	for name, field := range mapping.fields {
+		fullName := joinFieldPath(prefix, name)
+		names = append(names, fullName)
+		names = append(names, field.fieldNames(fullName)...)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,236 @@
+package elasticfacker
+
+// porterStemmer implements the Porter stemming algorithm, as published by Martin Porter and used by the
+// english analyzer and the porter_stem filter. It works on lowercase ASCII words, other words are kept.
+type porterStemmer struct {
+	word []byte
+	// end is the last letter of the stem while checking a suffix.
+	end int
+}
+
+func porterStem(term string) string {
+	if len(term) <= 2 {
+		return term
+	}
+	for position := 0; position < len(term); position++ {
+		if term[position] < 'a' || term[position] > 'z' {
+			return term
+		}
+	}
+
+	stemmer := &porterStemmer{word: []byte(term)}
+	stemmer.step1ab()
+	if len(stemmer.word) > 1 {
+		stemmer.step1c()
+		stemmer.step2()
+		stemmer.step3()
+		stemmer.step4()
+		stemmer.step5()
+	}
+
+	return string(stemmer.word)
+}
+
+// isConsonant reports whether the letter at the position is a consonant, y is one when it does not
+// follow a consonant.
+func (stemmer *porterStemmer) isConsonant(position int) bool {
+	switch stemmer.word[position] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return position == 0 || !stemmer.isConsonant(position-1)
+	}
+
+	return true
+}
+
+// measure returns the number of vowel consonant sequences in the stem, before end.
+func (stemmer *porterStemmer) measure() int {
+	count, position := 0, 0
+	for ; position <= stemmer.end && stemmer.isConsonant(position); position++ {
+	}
+	for position <= stemmer.end {
+		for ; position <= stemmer.end && !stemmer.isConsonant(position); position++ {
+		}
+		if position > stemmer.end {
+			break
+		}
+		count++
+		for ; position <= stemmer.end && stemmer.isConsonant(position); position++ {
+		}
+	}
+
+	return count
+}
+
+func (stemmer *porterStemmer) stemHasVowel() bool {
+	for position := 0; position <= stemmer.end; position++ {
+		if !stemmer.isConsonant(position) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (stemmer *porterStemmer) endsWithDoubleConsonant(position int) bool {
+	return position >= 1 && stemmer.word[position] == stemmer.word[position-1] && stemmer.isConsonant(position)
+}
+
+// endsWithCVC reports whether the letters up to the position are consonant, vowel, consonant, the last
+// one not being w, x or y, like in hop or fil.
+func (stemmer *porterStemmer) endsWithCVC(position int) bool {
+	if position < 2 || !stemmer.isConsonant(position) || stemmer.isConsonant(position-1) || !stemmer.isConsonant(position-2) {
+		return false
+	}
+	switch stemmer.word[position] {
+	case 'w', 'x', 'y':
+		return false
+	}
+
+	return true
+}
+
+// endsWith reports whether the word ends with the suffix, and sets end before it.
+func (stemmer *porterStemmer) endsWith(suffix string) bool {
+	length := len(stemmer.word)
+	if len(suffix) > length || string(stemmer.word[length-len(suffix):]) != suffix {
+		return false
+	}
+	stemmer.end = length - len(suffix) - 1
+
+	return true
+}
+
+func (stemmer *porterStemmer) setSuffix(suffix string) {
+	stemmer.word = append(stemmer.word[:stemmer.end+1], suffix...)
+}
+
+func (stemmer *porterStemmer) replaceWhenMeasured(suffix string) {
+	if stemmer.measure() > 0 {
+		stemmer.setSuffix(suffix)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing.
+func (stemmer *porterStemmer) step1ab() {
+	if stemmer.word[len(stemmer.word)-1] == 's' {
+		switch {
+		case stemmer.endsWith("sses"):
+			stemmer.word = stemmer.word[:len(stemmer.word)-2]
+		case stemmer.endsWith("ies"):
+			stemmer.setSuffix("i")
+		case len(stemmer.word) > 1 && stemmer.word[len(stemmer.word)-2] != 's':
+			stemmer.word = stemmer.word[:len(stemmer.word)-1]
+		}
+	}
+
+	if stemmer.endsWith("eed") {
+		if stemmer.measure() > 0 {
+			stemmer.word = stemmer.word[:len(stemmer.word)-1]
+		}
+		return
+	}
+	if !(stemmer.endsWith("ed") || stemmer.endsWith("ing")) || !stemmer.stemHasVowel() {
+		return
+	}
+
+	stemmer.word = stemmer.word[:stemmer.end+1]
+	last := len(stemmer.word) - 1
+	switch {
+	case stemmer.endsWith("at"):
+		stemmer.setSuffix("ate")
+	case stemmer.endsWith("bl"):
+		stemmer.setSuffix("ble")
+	case stemmer.endsWith("iz"):
+		stemmer.setSuffix("ize")
+	case stemmer.endsWithDoubleConsonant(last):
+		switch stemmer.word[last] {
+		case 'l', 's', 'z':
+		default:
+			stemmer.word = stemmer.word[:last]
+		}
+	default:
+		stemmer.end = last
+		if stemmer.measure() == 1 && stemmer.endsWithCVC(last) {
+			stemmer.word = append(stemmer.word, 'e')
+		}
+	}
+}
+
+// step1c turns a final y into i when there is another vowel in the stem.
+func (stemmer *porterStemmer) step1c() {
+	if stemmer.endsWith("y") && stemmer.stemHasVowel() {
+		stemmer.word[len(stemmer.word)-1] = 'i'
+	}
+}
+
+var porterStep2Suffixes = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"},
+	{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"},
+	{"ation", "ate"}, {"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}, {"logi", "log"},
+}
+
+var porterStep3Suffixes = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var porterStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent", "ion", "ou", "ism",
+	"ate", "iti", "ous", "ive", "ize",
+}
+
+// step2 maps double suffixes to single ones, like -ization to -ize.
+func (stemmer *porterStemmer) step2() {
+	stemmer.replaceSuffixes(porterStep2Suffixes)
+}
+
+// step3 removes or simplifies -ic-, -full, -ness and the like.
+func (stemmer *porterStemmer) step3() {
+	stemmer.replaceSuffixes(porterStep3Suffixes)
+}
+
+func (stemmer *porterStemmer) replaceSuffixes(suffixes [][2]string) {
+	for _, suffix := range suffixes {
+		if stemmer.endsWith(suffix[0]) {
+			stemmer.replaceWhenMeasured(suffix[1])
+			return
+		}
+	}
+}
+
+// step4 removes -ant, -ence and the like when the stem has more than one vowel consonant sequence.
+func (stemmer *porterStemmer) step4() {
+	for _, suffix := range porterStep4Suffixes {
+		if !stemmer.endsWith(suffix) {
+			continue
+		}
+		if suffix == "ion" && (stemmer.end < 0 || (stemmer.word[stemmer.end] != 's' && stemmer.word[stemmer.end] != 't')) {
+			return
+		}
+		if stemmer.measure() > 1 {
+			stemmer.word = stemmer.word[:stemmer.end+1]
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and turns a final -ll into -l, when the stem is long enough.
+func (stemmer *porterStemmer) step5() {
+	last := len(stemmer.word) - 1
+	stemmer.end = last
+	if stemmer.word[last] == 'e' {
+		stemmer.end = last - 1
+		measure := stemmer.measure()
+		if measure > 1 || (measure == 1 && !stemmer.endsWithCVC(last-1)) {
+			stemmer.word = stemmer.word[:last]
+		}
+	}
+
+	last = len(stemmer.word) - 1
+	stemmer.end = last
+	if stemmer.word[last] == 'l' && stemmer.endsWithDoubleConsonant(last) && stemmer.measure() > 1 {
+		stemmer.word = stemmer.word[:last]
+	}
+}
@@ -0,0 +1,484 @@
+package elasticfacker
+
+import (
+	"sort"
+	"strings"
+)
+
+// tokenFilter returns the token filter of the component, declared in the index or built in. The analyzer
+// holds the filters that come before it, synonym rules are analysed with them.
+func (analysis *indexAnalysis) tokenFilter(component interface{}, preceding *analyzer) (tokenFilter, error) {
+	var custom map[string]interface{}
+	if analysis != nil {
+		custom = analysis.filters
+	}
+	filterType, options := componentDefinition(custom, component)
+
+	preserveOriginal, _ := toBool(options["preserve_original"])
+
+	switch filterType {
+	case "lowercase":
+		return lowercaseFilter, nil
+	case "uppercase":
+		return uppercaseFilter, nil
+	case "asciifolding":
+		if preserveOriginal {
+			return preservingFilter(asciiFoldingFilter), nil
+		}
+		return asciiFoldingFilter, nil
+	case "trim":
+		return mapTerms(strings.TrimSpace), nil
+	case "unique":
+		return uniqueFilter, nil
+	case "reverse":
+		return mapTerms(reverseString), nil
+	case "stop":
+		stopwords := options["stopwords"]
+		if stopwords == nil {
+			stopwords = "_english_"
+		}
+		ignoreCase, _ := toBool(options["ignore_case"])
+		return stopFilter(stopwordSet(stopwords, ignoreCase)), nil
+	case "synonym", "synonym_graph":
+		return analysis.synonymFilter(options, preceding)
+	case "stemmer":
+		language, _ := options["language"].(string)
+		if language == "" {
+			language, _ = options["name"].(string)
+		}
+		stemmer, exists := stemmers[language]
+		if !exists && language == "" {
+			stemmer, exists = stemmers["english"]
+		}
+		if !exists {
+			return nil, newAnalysisError("Unknown stemmer language [%s]", language)
+		}
+		return mapTerms(stemmer), nil
+	case "porter_stem":
+		return porterStemFilter, nil
+	case "kstem":
+		return mapTerms(lightEnglishStem), nil
+	case "possessive_english":
+		return possessiveFilter, nil
+	case "edge_ngram", "edgeNGram", "ngram", "nGram":
+		minGram, maxGram, err := gramSizes(filterType, options)
+		if err != nil {
+			return nil, err
+		}
+		edge := strings.HasPrefix(strings.ToLower(filterType), "edge")
+		return func(tokens []analysisToken) []analysisToken {
+			filtered := make([]analysisToken, 0, len(tokens))
+			for _, token := range tokens {
+				grams := ngrams(token, minGram, maxGram, edge)
+				// Grams keep the offsets of the token they come from.
+				for position := range grams {
+					grams[position].startOffset, grams[position].endOffset = token.startOffset, token.endOffset
+				}
+				if preserveOriginal && (len(grams) == 0 || grams[len(grams)-1].term != token.term) {
+					grams = append(grams, token)
+				}
+				filtered = append(filtered, grams...)
+			}
+			return filtered
+		}, nil
+	case "length":
+		minLength, maxLength := 0, int(^uint(0)>>1)
+		if value, isNumber := toFloat(options["min"]); isNumber {
+			minLength = int(value)
+		}
+		if value, isNumber := toFloat(options["max"]); isNumber {
+			maxLength = int(value)
+		}
+		return func(tokens []analysisToken) []analysisToken {
+			filtered := make([]analysisToken, 0, len(tokens))
+			for _, token := range tokens {
+				if length := len([]rune(token.term)); length >= minLength && length <= maxLength {
+					filtered = append(filtered, token)
+				}
+			}
+			return filtered
+		}, nil
+	}
+
+	return nil, newAnalysisError("failed to find filter under [%v]", component)
+}
+
+func mapTerms(change func(term string) string) tokenFilter {
+	return func(tokens []analysisToken) []analysisToken {
+		filtered := make([]analysisToken, len(tokens))
+		for position, token := range tokens {
+			token.term = change(token.term)
+			filtered[position] = token
+		}
+		return filtered
+	}
+}
+
+// preservingFilter returns the filter keeping every token it changes next to the changed one.
+func preservingFilter(filter tokenFilter) tokenFilter {
+	return func(tokens []analysisToken) []analysisToken {
+		preserved := make([]analysisToken, 0, len(tokens))
+		for _, token := range tokens {
+			changed := filter([]analysisToken{token})
+			if len(changed) == 1 && changed[0].term != token.term {
+				preserved = append(preserved, changed[0], token)
+				continue
+			}
+			preserved = append(preserved, changed...)
+		}
+		return preserved
+	}
+}
+
+var (
+	lowercaseFilter    = mapTerms(strings.ToLower)
+	uppercaseFilter    = mapTerms(strings.ToUpper)
+	asciiFoldingFilter = mapTerms(foldToASCII)
+	porterStemFilter   = mapTerms(porterStem)
+)
+
+func possessiveFilter(tokens []analysisToken) []analysisToken {
+	return mapTerms(func(term string) string {
+		for _, suffix := range []string{"'s", "'S", "’s", "’S"} {
+			if strings.HasSuffix(term, suffix) {
+				return strings.TrimSuffix(term, suffix)
+			}
+		}
+		return term
+	})(tokens)
+}
+
+func uniqueFilter(tokens []analysisToken) []analysisToken {
+	seen := make(map[string]bool, len(tokens))
+	filtered := make([]analysisToken, 0, len(tokens))
+	for _, token := range tokens {
+		if !seen[token.term] {
+			seen[token.term] = true
+			filtered = append(filtered, token)
+		}
+	}
+
+	return filtered
+}
+
+func reverseString(text string) string {
+	runes := []rune(text)
+	for left, right := 0, len(runes)-1; left < right; left, right = left+1, right-1 {
+		runes[left], runes[right] = runes[right], runes[left]
+	}
+
+	return string(runes)
+}
+
+// stopFilter removes the stop words, the positions of the other tokens are kept so phrases still see the
+// gap.
+func stopFilter(stopwords map[string]bool) tokenFilter {
+	return func(tokens []analysisToken) []analysisToken {
+		filtered := make([]analysisToken, 0, len(tokens))
+		for _, token := range tokens {
+			if !stopwords[token.term] && !(stopwords[""] && stopwords[strings.ToLower(token.term)]) {
+				filtered = append(filtered, token)
+			}
+		}
+		return filtered
+	}
+}
+
+// stopwordSet returns the stop words of a predefined list, like _english_, or of an array. With ignore_case
+// the words are lowercased and the set holds the empty word, so the filter lowercases the tokens it checks.
+func stopwordSet(value interface{}, ignoreCase bool) map[string]bool {
+	words := stringList(value)
+	if predefined, isString := value.(string); isString {
+		words = languageStopwords[predefined]
+	}
+
+	stopwords := make(map[string]bool, len(words))
+	for _, word := range words {
+		if ignoreCase {
+			word = strings.ToLower(word)
+		}
+		stopwords[word] = true
+	}
+	if ignoreCase && len(words) > 0 {
+		stopwords[""] = true
+	}
+
+	return stopwords
+}
+
+var languageStopwords = map[string][]string{
+	"_none_": {},
+	"_english_": {
+		"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is", "it", "no",
+		"not", "of", "on", "or", "such", "that", "the", "their", "then", "there", "these", "they", "this",
+		"to", "was", "will", "with",
+	},
+	"_spanish_": {
+		"de", "la", "que", "el", "en", "y", "a", "los", "del", "se", "las", "por", "un", "para", "con", "no",
+		"una", "su", "al", "lo", "como", "más", "pero", "sus", "le", "ya", "o", "este", "sí", "porque", "esta",
+		"entre", "cuando", "muy", "sin", "sobre", "también", "me", "hasta", "hay", "donde", "quien", "desde",
+		"todo", "nos", "durante", "todos", "uno", "les", "ni", "contra", "otros", "ese", "eso", "ante", "ellos",
+		"e", "esto", "mí", "antes", "algunos", "qué", "unos", "yo", "otro", "otras", "otra", "él", "tanto",
+		"esa", "estos", "mucho", "quienes", "nada", "muchos", "cual", "poco", "ella", "estar", "estas", "es",
+		"son", "fue", "ha", "han", "era", "ser",
+	},
+	"_french_": {
+		"au", "aux", "avec", "ce", "ces", "dans", "de", "des", "du", "elle", "en", "et", "eux", "il", "je",
+		"la", "le", "les", "leur", "lui", "ma", "mais", "me", "même", "mes", "moi", "mon", "ne", "nos",
+		"notre", "nous", "on", "ou", "par", "pas", "pour", "qu", "que", "qui", "sa", "se", "ses", "son", "sur",
+		"ta", "te", "tes", "toi", "ton", "tu", "un", "une", "vos", "votre", "vous", "c", "d", "j", "l", "à",
+		"m", "n", "s", "t", "y", "été", "est", "sont", "était", "être",
+	},
+	"_german_": {
+		"aber", "alle", "als", "also", "am", "an", "auch", "auf", "aus", "bei", "bin", "bis", "bist", "da",
+		"damit", "dann", "das", "dass", "dein", "dem", "den", "der", "des", "die", "dies", "dir", "doch", "du",
+		"ein", "eine", "einem", "einen", "einer", "eines", "er", "es", "für", "hat", "hatte", "ich", "ihr",
+		"im", "in", "ist", "ja", "kein", "man", "mich", "mir", "mit", "nach", "nicht", "noch", "nur", "ob",
+		"oder", "sein", "sich", "sie", "sind", "so", "um", "und", "uns", "von", "vor", "war", "was", "wenn",
+		"wie", "wir", "wird", "zu", "zum", "zur",
+	},
+	"_italian_": {
+		"ad", "al", "allo", "ai", "agli", "alla", "alle", "con", "col", "da", "dal", "dallo", "dai", "dagli",
+		"dalla", "dalle", "di", "del", "dello", "dei", "degli", "della", "delle", "in", "nel", "nello", "nei",
+		"negli", "nella", "nelle", "su", "sul", "sullo", "sui", "sugli", "sulla", "sulle", "per", "tra",
+		"contro", "io", "tu", "lui", "lei", "noi", "voi", "loro", "il", "lo", "la", "i", "gli", "le", "un",
+		"uno", "una", "ma", "ed", "se", "perché", "anche", "come", "che", "non", "più", "e", "è", "o",
+	},
+	"_portuguese_": {
+		"de", "a", "o", "que", "e", "do", "da", "em", "um", "para", "com", "não", "uma", "os", "no", "se",
+		"na", "por", "mais", "as", "dos", "como", "mas", "ao", "ele", "das", "à", "seu", "sua", "ou", "quando",
+		"muito", "nos", "já", "eu", "também", "só", "pelo", "pela", "até", "isso", "ela", "entre", "depois",
+		"sem", "mesmo", "aos", "seus", "quem", "nas", "me", "esse", "eles", "você", "essa", "num", "nem",
+		"suas", "meu", "às", "minha", "numa", "pelos", "elas", "qual", "nós", "lhe", "deles", "essas", "esses",
+		"é", "foi", "ser",
+	},
+	"_dutch_": {
+		"de", "en", "van", "ik", "te", "dat", "die", "in", "een", "hij", "het", "niet", "zijn", "is", "was",
+		"op", "aan", "met", "als", "voor", "had", "er", "maar", "om", "hem", "dan", "zou", "of", "wat", "mijn",
+		"men", "dit", "zo", "door", "over", "ze", "zich", "bij", "ook", "tot", "je", "mij", "uit", "der",
+		"daar", "haar", "naar", "heb", "hoe", "heeft", "hebben", "deze", "u", "want", "nog", "zal", "me", "zij",
+		"nu", "ge", "geen", "omdat", "iets", "worden", "toch", "al", "waren", "veel", "meer", "doen", "toen",
+	},
+}
+
+// asciiFolding holds the ASCII equivalent of the accented Latin letters, ligatures and typographic
+// punctuation the asciifolding filter replaces.
+var asciiFolding = buildASCIIFolding(map[string]string{
+	"ÀÁÂÃÄÅĀĂĄǍ": "A", "àáâãäåāăąǎª": "a", "ÇĆĈĊČ": "C", "çćĉċč": "c", "ÐĎĐ": "D", "ðďđ": "d",
+	"ÈÉÊËĒĔĖĘĚ": "E", "èéêëēĕėęě": "e", "ĜĞĠĢ": "G", "ĝğġģ": "g", "ĤĦ": "H", "ĥħ": "h",
+	"ÌÍÎÏĨĪĬĮİǏ": "I", "ìíîïĩīĭįıǐ": "i", "Ĵ": "J", "ĵ": "j", "Ķ": "K", "ķĸ": "k", "ĹĻĽĿŁ": "L",
+	"ĺļľŀł": "l", "ÑŃŅŇŊ": "N", "ñńņňŉŋ": "n", "ÒÓÔÕÖØŌŎŐǑ": "O", "òóôõöøōŏőǒº": "o", "ŔŖŘ": "R",
+	"ŕŗř": "r", "ŚŜŞŠ": "S", "śŝşšſ": "s", "ŢŤŦ": "T", "ţťŧ": "t", "ÙÚÛÜŨŪŬŮŰŲǓ": "U",
+	"ùúûüũūŭůűųǔ": "u", "Ŵ": "W", "ŵ": "w", "ÝŶŸ": "Y", "ýÿŷ": "y", "ŹŻŽ": "Z", "źżž": "z",
+	"Æ": "AE", "æ": "ae", "Œ": "OE", "œ": "oe", "ß": "ss", "Þ": "TH", "þ": "th", "Ĳ": "IJ", "ĳ": "ij",
+	"‘’‚‛": "'", "“”„‟«»": "\"", "‐‑‒–—": "-", "…": "...",
+})
+
+func buildASCIIFolding(groups map[string]string) map[rune]string {
+	folding := make(map[rune]string)
+	for letters, folded := range groups {
+		for _, letter := range letters {
+			folding[letter] = folded
+		}
+	}
+
+	return folding
+}
+
+func foldToASCII(term string) string {
+	var folded strings.Builder
+	for _, r := range term {
+		if replacement, exists := asciiFolding[r]; exists {
+			folded.WriteString(replacement)
+			continue
+		}
+		folded.WriteRune(r)
+	}
+
+	return folded.String()
+}
+
+// stemmers are the stemmer filter languages, only the English ones stem, the light language analyzers
+// keep their words as they are.
+var stemmers = map[string]func(term string) string{
+	"english":            porterStem,
+	"porter":             porterStem,
+	"light_english":      lightEnglishStem,
+	"minimal_english":    minimalEnglishStem,
+	"possessive_english": func(term string) string { return strings.TrimSuffix(term, "'s") },
+}
+
+// minimalEnglishStem removes the plural of English words, the way the minimal_english stemmer does.
+func minimalEnglishStem(term string) string {
+	if len(term) < 3 || term[len(term)-1] != 's' {
+		return term
+	}
+
+	switch term[len(term)-2] {
+	case 'u', 's':
+		return term
+	case 'e':
+		if len(term) > 3 && term[len(term)-3] == 'i' && term[len(term)-4] != 'a' && term[len(term)-4] != 'e' {
+			return term[:len(term)-3] + "y"
+		}
+		if term[len(term)-3] == 'i' || term[len(term)-3] == 'a' || term[len(term)-3] == 'o' || term[len(term)-3] == 'e' {
+			return term
+		}
+	}
+
+	return term[:len(term)-1]
+}
+
+// lightEnglishStem removes the plural and the most common inflections of English words.
+func lightEnglishStem(term string) string {
+	term = minimalEnglishStem(term)
+	for _, suffix := range []string{"ing", "ed"} {
+		if strings.HasSuffix(term, suffix) && len(term)-len(suffix) >= 3 {
+			return term[:len(term)-len(suffix)]
+		}
+	}
+
+	return term
+}
+
+// synonymRule replaces the terms of the input, one or more consecutive tokens, with the ones of the output.
+// An equivalence rule keeps the input tokens and adds the other synonyms.
+type synonymRule struct {
+	input       []string
+	outputs     [][]string
+	keepsSource bool
+}
+
+// synonymFilter parses the synonyms, in the Solr format, and returns the filter adding them. Each side of a
+// rule is analysed with the filters before the synonym filter.
+func (analysis *indexAnalysis) synonymFilter(options map[string]interface{}, preceding *analyzer) (tokenFilter, error) {
+	if options["synonyms_path"] != nil || options["synonyms_set"] != nil {
+		return nil, newAnalysisError("synonyms_path and synonyms_set are not supported, synonyms must be given inline")
+	}
+	if format, _ := options["format"].(string); format != "" && format != "solr" {
+		return nil, newAnalysisError("synonym format [%s] is not supported", format)
+	}
+	expand := true
+	if value, isBool := toBool(options["expand"]); isBool {
+		expand = value
+	}
+	lenient, _ := toBool(options["lenient"])
+
+	if preceding == nil {
+		preceding = builtInAnalyzer("whitespace", nil)
+	}
+	analyze := func(phrase string) []string {
+		return preceding.terms(strings.TrimSpace(phrase))
+	}
+
+	rules := make(map[string]*synonymRule)
+	addRule := func(input []string, output []string, keepsSource bool) {
+		key := strings.Join(input, " ")
+		rule, exists := rules[key]
+		if !exists {
+			rule = &synonymRule{input: input, keepsSource: keepsSource}
+			rules[key] = rule
+		}
+		for _, existing := range rule.outputs {
+			if strings.Join(existing, " ") == strings.Join(output, " ") {
+				return
+			}
+		}
+		rule.outputs = append(rule.outputs, output)
+	}
+
+	for _, line := range stringList(options["synonyms"]) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sides := strings.Split(line, "=>")
+		if len(sides) > 2 {
+			if lenient {
+				continue
+			}
+			return nil, newAnalysisError("failed to build synonyms: more than one explicit mapping specified on the same line")
+		}
+
+		inputs := make([][]string, 0)
+		for _, phrase := range strings.Split(sides[0], ",") {
+			if terms := analyze(phrase); len(terms) > 0 {
+				inputs = append(inputs, terms)
+			}
+		}
+
+		if len(sides) == 2 {
+			for _, input := range inputs {
+				for _, phrase := range strings.Split(sides[1], ",") {
+					if output := analyze(phrase); len(output) > 0 {
+						addRule(input, output, false)
+					}
+				}
+			}
+			continue
+		}
+
+		for _, input := range inputs {
+			if !expand {
+				addRule(input, inputs[0], true)
+				continue
+			}
+			for _, output := range inputs {
+				addRule(input, output, true)
+			}
+		}
+	}
+
+	return func(tokens []analysisToken) []analysisToken {
+		return applySynonyms(tokens, rules)
+	}, nil
+}
+
+func applySynonyms(tokens []analysisToken, rules map[string]*synonymRule) []analysisToken {
+	filtered := make([]analysisToken, 0, len(tokens))
+	for start := 0; start < len(tokens); {
+		// The longest input starting at the token wins.
+		var matched *synonymRule
+		for end := len(tokens); end > start && matched == nil; end-- {
+			terms := make([]string, 0, end-start)
+			for _, token := range tokens[start:end] {
+				terms = append(terms, token.term)
+			}
+			matched = rules[strings.Join(terms, " ")]
+		}
+		if matched == nil {
+			filtered = append(filtered, tokens[start])
+			start++
+			continue
+		}
+
+		source := tokens[start : start+len(matched.input)]
+		added := make([]analysisToken, 0)
+		if matched.keepsSource {
+			added = append(added, source...)
+		}
+		for _, output := range matched.outputs {
+			if matched.keepsSource && strings.Join(output, " ") == strings.Join(matched.input, " ") {
+				continue
+			}
+			for offset, term := range output {
+				added = append(added, analysisToken{
+					term:        term,
+					startOffset: source[0].startOffset,
+					endOffset:   source[len(source)-1].endOffset,
+					tokenType:   "SYNONYM",
+					position:    source[0].position + offset,
+				})
+			}
+		}
+		sort.SliceStable(added, func(left, right int) bool {
+			return added[left].position < added[right].position
+		})
+
+		filtered = append(filtered, added...)
+		start += len(matched.input)
+	}
+
+	return filtered
+}
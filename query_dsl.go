@@ -0,0 +1,973 @@
+package elasticfacker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchQuery is a parsed query DSL clause that can be evaluated against the documents of an index.
+type searchQuery interface {
+	evaluate(document Document) (bool, float64)
+}
+
+type queryError struct {
+	errorType string
+	reason    string
+}
+
+func (err *queryError) Error() string {
+	return err.reason
+}
+
+func newParsingError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "parsing_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+type queryParser struct {
+	es        *InMemoryElasticsearch
+	indexName string
+}
+
+// parseSearchQuery parses the query of a search request, an empty query matches every document.
+func (es *InMemoryElasticsearch) parseSearchQuery(indexName string, query interface{}) (searchQuery, error) {
+	if query == nil {
+		return &matchAllQuery{boost: 1}, nil
+	}
+
+	// Bodies built as a string with the content of the query object, like "\"match\": {...}", are still accepted.
+	if queryFragment, ok := query.(string); ok {
+		var queryObject map[string]interface{}
+		err := json.Unmarshal([]byte("{"+queryFragment+"}"), &queryObject)
+		if err != nil {
+			return nil, newParsingError("[query] query malformed, no start_object after query name")
+		}
+		query = queryObject
+	}
+
+	parser := &queryParser{
+		es:        es,
+		indexName: indexName,
+	}
+
+	return parser.parse(query)
+}
+
+func (parser *queryParser) parse(query interface{}) (searchQuery, error) {
+	queryObject, ok := query.(map[string]interface{})
+	if !ok || len(queryObject) != 1 {
+		return nil, newParsingError("[_na] query malformed, must start with start_object")
+	}
+
+	for queryName, queryBody := range queryObject {
+		switch queryName {
+		case "match_all":
+			return parser.parseMatchAll(queryBody)
+		case "match_none":
+			return &matchNoneQuery{}, nil
+		case "match":
+			return parser.parseMatch(queryBody)
+		case "match_phrase":
+			return parser.parseMatchPhrase(queryBody)
+		case "match_phrase_prefix":
+			return parser.parseMatchPhrasePrefix(queryBody)
+		case "match_bool_prefix":
+			return parser.parseMatchBoolPrefix(queryBody)
+		case "multi_match":
+			return parser.parseMultiMatch(queryBody)
+		case "query_string":
+			return parser.parseQueryString(queryBody)
+		case "simple_query_string":
+			return parser.parseSimpleQueryString(queryBody)
+		case "term":
+			return parser.parseTerm(queryBody)
+		case "terms":
+			return parser.parseTerms(queryBody)
+		case "range":
+			return parser.parseRange(queryBody)
+		case "exists":
+			return parser.parseExists(queryBody)
+		case "ids":
+			return parser.parseIds(queryBody)
+		case "prefix":
+			return parser.parsePrefix(queryBody)
+		case "wildcard":
+			return parser.parseWildcard(queryBody)
+		case "regexp":
+			return parser.parseRegexp(queryBody)
+		case "fuzzy":
+			return parser.parseFuzzy(queryBody)
+		case "terms_set":
+			return parser.parseTermsSet(queryBody)
+		case "bool":
+			return parser.parseBool(queryBody)
+		default:
+			return nil, newParsingError("unknown query [%s]", queryName)
+		}
+	}
+
+	return nil, newParsingError("[_na] query malformed, empty clause found")
+}
+
+func (parser *queryParser) parseMatchAll(queryBody interface{}) (searchQuery, error) {
+	options, _ := queryBody.(map[string]interface{})
+
+	return &matchAllQuery{boost: boostOption(options)}, nil
+}
+
+func (parser *queryParser) parseMatch(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("match", queryBody, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.newMatchQuery(field, value, options)
+}
+
+// newMatchQuery returns the match query of a field, the query of match and of each field of multi_match.
+func (parser *queryParser) newMatchQuery(fieldName string, value interface{}, options map[string]interface{}) (searchQuery, error) {
+	operator, _ := options["operator"].(string)
+	query := &matchQuery{
+		field:              parser.field(fieldName),
+		index:              parser.es.indicesInverted[parser.indexName],
+		operatorAnd:        strings.EqualFold(operator, "and"),
+		minimumShouldMatch: options["minimum_should_match"],
+		boost:              boostOption(options),
+	}
+
+	// Text is analysed into terms, the value of any other type is a single term.
+	if query.field.isText() {
+		queryAnalyzer, err := parser.queryAnalyzer(query.field, options)
+		if err != nil {
+			return nil, err
+		}
+		terms := query.field.analyzeQuery(fmt.Sprint(value))
+		if queryAnalyzer != nil {
+			terms = queryAnalyzer.terms(fmt.Sprint(value))
+		}
+		for _, term := range terms {
+			query.terms = append(query.terms, term)
+		}
+		if options["fuzziness"] != nil {
+			query.fuzzy, err = newFuzzyMatcher(options["fuzziness"], options["prefix_length"], options["fuzzy_transpositions"])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return query, nil
+	}
+
+	term, err := query.field.queryValue(value)
+	if err != nil {
+		if lenient, _ := toBool(options["lenient"]); lenient {
+			return &matchNoneQuery{}, nil
+		}
+		return nil, err
+	}
+	query.terms = []interface{}{term}
+
+	return query, nil
+}
+
+// queryField is a field of a query on several fields, with the boost of its name^boost suffix.
+type queryField struct {
+	name     string
+	boost    float64
+	expanded bool
+}
+
+// queryFields resolves the fields of a query on several fields, names with a boost suffix and wildcard
+// patterns, which expand to the fields of the mapping. Without fields every field is queried.
+func (parser *queryParser) queryFields(patterns []string) []queryField {
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+
+	fields := make([]queryField, 0, len(patterns))
+	for _, pattern := range patterns {
+		boost := 1.0
+		if separator := strings.LastIndex(pattern, "^"); separator > 0 {
+			if fieldBoost, err := strconv.ParseFloat(pattern[separator+1:], 64); err == nil {
+				pattern, boost = pattern[:separator], fieldBoost
+			}
+		}
+
+		mapping := parser.es.indicesMappings[parser.indexName]
+		if !strings.Contains(pattern, "*") || mapping == nil {
+			fields = append(fields, queryField{name: pattern, boost: boost})
+			continue
+		}
+		for _, name := range mapping.fieldNames("") {
+			field := parser.field(name)
+			if field.fieldType == FieldTypeObject || field.fieldType == FieldTypeNested || !matchesAnyPattern([]string{pattern}, name) {
+				continue
+			}
+			fields = append(fields, queryField{name: name, boost: boost, expanded: true})
+		}
+	}
+
+	return fields
+}
+
+func (parser *queryParser) field(name string) *mappedField {
+	return parser.es.mappedField(parser.indexName, name)
+}
+
+// queryValues converts the values of a query to the terms of the field they are compared with.
+func queryValues(field *mappedField, values []interface{}) ([]interface{}, error) {
+	terms := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		term, err := field.queryValue(value)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+func (parser *queryParser) parseTerm(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("term", queryBody, "value")
+	if err != nil {
+		return nil, err
+	}
+	if _, isArray := value.([]interface{}); isArray {
+		return nil, newParsingError("[term] query does not support array of values")
+	}
+
+	mapped := parser.field(field)
+	values, err := queryValues(mapped, []interface{}{value})
+	if err != nil {
+		return nil, err
+	}
+
+	caseInsensitive, _ := toBool(options["case_insensitive"])
+
+	return &termsQuery{
+		field:           mapped,
+		values:          values,
+		caseInsensitive: caseInsensitive,
+		boost:           boostOption(options),
+	}, nil
+}
+
+func (parser *queryParser) parseTerms(queryBody interface{}) (searchQuery, error) {
+	options, ok := queryBody.(map[string]interface{})
+	if !ok {
+		return nil, newParsingError("[terms] query malformed, no start_object after query name")
+	}
+
+	query := &termsQuery{boost: boostOption(options)}
+	for field, values := range options {
+		if field == "boost" {
+			continue
+		}
+
+		if query.field != nil {
+			return nil, newParsingError("[terms] query does not support multiple fields")
+		}
+
+		valuesArray, ok := values.([]interface{})
+		if !ok {
+			return nil, newParsingError("[terms] query does not support [%s]", field)
+		}
+		query.field = parser.field(field)
+		terms, err := queryValues(query.field, valuesArray)
+		if err != nil {
+			return nil, err
+		}
+		query.values = terms
+	}
+
+	if query.field == nil {
+		return nil, newParsingError("[terms] query requires a field")
+	}
+
+	return query, nil
+}
+
+func (parser *queryParser) parseRange(queryBody interface{}) (searchQuery, error) {
+	field, _, options, err := singleFieldQuery("range", queryBody, "")
+	if err != nil {
+		return nil, err
+	}
+
+	query := &rangeQuery{
+		field: parser.field(field),
+		boost: boostOption(options),
+	}
+
+	var format *dateFormat
+	if formatOption, _ := options["format"].(string); formatOption != "" {
+		format, err = newDateFormat(formatOption)
+		if err != nil {
+			return nil, newParsingError("[range] %s", err.Error())
+		}
+	}
+	location := time.UTC
+	if timeZone, _ := options["time_zone"].(string); timeZone != "" {
+		location, err = parseTimeZone(timeZone)
+		if err != nil {
+			return nil, newParsingError("[range] %s", err.Error())
+		}
+	}
+
+	for operator, value := range options {
+		switch operator {
+		case "gt", "gte", "lt", "lte":
+			if value == nil {
+				continue
+			}
+			// Dates round up for the bounds that would otherwise leave out part of the rounded unit.
+			if query.field.isDateField() {
+				value, err = query.field.queryDate(value, format, location, operator == "gt" || operator == "lte")
+			} else {
+				value, err = query.field.queryValue(value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			query.bounds = append(query.bounds, rangeBound{operator: operator, value: value})
+		case "from", "to", "include_lower", "include_upper":
+			return nil, newParsingError("[range] query does not support deprecated [%s], use gt, gte, lt or lte", operator)
+		case "boost", "format", "time_zone", "relation":
+		default:
+			return nil, newParsingError("[range] query does not support [%s]", operator)
+		}
+	}
+
+	return query, nil
+}
+
+func (parser *queryParser) parseExists(queryBody interface{}) (searchQuery, error) {
+	options, _ := queryBody.(map[string]interface{})
+	field, ok := options["field"].(string)
+	if !ok {
+		return nil, newParsingError("[exists] must be provided with a [field]")
+	}
+
+	return &existsQuery{
+		field: parser.field(field),
+		boost: boostOption(options),
+	}, nil
+}
+
+func (parser *queryParser) parseIds(queryBody interface{}) (searchQuery, error) {
+	options, _ := queryBody.(map[string]interface{})
+	values, ok := options["values"].([]interface{})
+	if !ok {
+		return nil, newParsingError("[ids] query malformed, [values] must be an array")
+	}
+
+	return &termsQuery{
+		field:  parser.field("_id"),
+		values: values,
+		boost:  boostOption(options),
+	}, nil
+}
+
+func (parser *queryParser) parsePrefix(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("prefix", queryBody, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, ok := value.(string)
+	if !ok {
+		return nil, newParsingError("[prefix] query requires a string value")
+	}
+
+	mapped, err := parser.stringQueryField("prefix", field)
+	if err != nil {
+		return nil, err
+	}
+	caseInsensitive, _ := toBool(options["case_insensitive"])
+
+	return &prefixQuery{
+		field:           mapped,
+		prefix:          prefix,
+		caseInsensitive: caseInsensitive,
+		boost:           boostOption(options),
+	}, nil
+}
+
+func (parser *queryParser) parseBool(queryBody interface{}) (searchQuery, error) {
+	options, ok := queryBody.(map[string]interface{})
+	if !ok {
+		return nil, newParsingError("[bool] query malformed, no start_object after query name")
+	}
+
+	query := &boolQuery{
+		minimumShouldMatch: options["minimum_should_match"],
+		boost:              boostOption(options),
+	}
+	for occur, clauses := range options {
+		var target *[]searchQuery
+		switch occur {
+		case "must":
+			target = &query.must
+		case "filter":
+			target = &query.filter
+		case "should":
+			target = &query.should
+		case "must_not":
+			target = &query.mustNot
+		case "minimum_should_match", "boost", "_name":
+			continue
+		default:
+			return nil, newParsingError("[bool] query does not support [%s]", occur)
+		}
+
+		clausesArray, isArray := clauses.([]interface{})
+		if !isArray {
+			clausesArray = []interface{}{clauses}
+		}
+		for _, clause := range clausesArray {
+			parsedClause, err := parser.parse(clause)
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, parsedClause)
+		}
+	}
+
+	return query, nil
+}
+
+// singleFieldQuery reads queries shaped as {"field": value} or {"field": {"<valueKey>": value, ...options}}.
+func singleFieldQuery(queryName string, queryBody interface{}, valueKey string) (string, interface{}, map[string]interface{}, error) {
+	queryObject, ok := queryBody.(map[string]interface{})
+	if !ok || len(queryObject) == 0 {
+		return "", nil, nil, newParsingError("[%s] query malformed, no start_object after query name", queryName)
+	}
+	if len(queryObject) > 1 {
+		return "", nil, nil, newParsingError("[%s] query doesn't support multiple fields", queryName)
+	}
+
+	for field, fieldBody := range queryObject {
+		options, isObject := fieldBody.(map[string]interface{})
+		if !isObject {
+			return field, fieldBody, map[string]interface{}{}, nil
+		}
+
+		if valueKey == "" {
+			return field, nil, options, nil
+		}
+
+		value, exists := options[valueKey]
+		if !exists {
+			return "", nil, nil, newParsingError("[%s] query does not support [%s] without [%s]", queryName, field, valueKey)
+		}
+
+		return field, value, options, nil
+	}
+
+	return "", nil, nil, nil
+}
+
+func boostOption(options map[string]interface{}) float64 {
+	boost, ok := toFloat(options["boost"])
+	if !ok {
+		return 1
+	}
+
+	return boost
+}
+
+type matchAllQuery struct {
+	boost float64
+}
+
+func (query *matchAllQuery) evaluate(document Document) (bool, float64) {
+	return true, query.boost
+}
+
+type matchNoneQuery struct{}
+
+func (query *matchNoneQuery) evaluate(document Document) (bool, float64) {
+	return false, 0
+}
+
+type matchQuery struct {
+	field              *mappedField
+	index              *invertedIndex
+	terms              []interface{}
+	fuzzy              *fuzzyMatcher
+	operatorAnd        bool
+	minimumShouldMatch interface{}
+	boost              float64
+}
+
+func (query *matchQuery) evaluate(document Document) (bool, float64) {
+	if len(query.terms) == 0 {
+		return false, 0
+	}
+
+	documentTerms := make(map[interface{}]bool)
+	for _, term := range query.field.matchTerms(document) {
+		documentTerms[term] = true
+	}
+
+	// Text fields are scored with BM25, the sum of the scores of the terms found, other fields with the
+	// share of the terms found. With fuzziness a term is found as the most similar term of the document
+	// within its edit distance, its score scaled by the similarity.
+	scored := query.index != nil && textFieldTypes[query.field.fieldType]
+	matched, score := 0, 0.0
+	for _, term := range query.terms {
+		found, similarity := term, 1.0
+		if query.fuzzy != nil {
+			found, similarity = query.fuzzyTerm(fmt.Sprint(term), documentTerms)
+		}
+		if found != nil && documentTerms[found] {
+			matched++
+			if scored {
+				score += similarity * query.index.score(document.Id, query.field.name, fmt.Sprint(found))
+			}
+		}
+	}
+	if !scored {
+		score = float64(matched) / float64(len(query.terms))
+	}
+
+	required := 1
+	if query.operatorAnd {
+		required = len(query.terms)
+	}
+	if query.minimumShouldMatch != nil {
+		required = minimumShouldMatch(query.minimumShouldMatch, len(query.terms))
+	}
+
+	if matched == 0 || matched < required {
+		return false, 0
+	}
+
+	return true, query.boost * score
+}
+
+// fuzzyTerm returns the term of the document most similar to the term of the query, and its similarity.
+func (query *matchQuery) fuzzyTerm(term string, documentTerms map[interface{}]bool) (interface{}, float64) {
+	var found interface{}
+	best := -1.0
+	for documentTerm := range documentTerms {
+		text, isString := documentTerm.(string)
+		if !isString {
+			continue
+		}
+		similar, similarity := query.fuzzy.similarity(term, text)
+		if similar && (similarity > best || (similarity == best && text < found.(string))) {
+			found, best = text, similarity
+		}
+	}
+
+	return found, best
+}
+
+// disMaxQuery matches the documents any of its queries match, scored with the best score of them plus the
+// scores of the others multiplied by the tie breaker.
+type disMaxQuery struct {
+	queries    []searchQuery
+	tieBreaker float64
+	boost      float64
+}
+
+func (query *disMaxQuery) evaluate(document Document) (bool, float64) {
+	matched := false
+	maxScore, sumScore := 0.0, 0.0
+	for _, clause := range query.queries {
+		clauseMatched, clauseScore := clause.evaluate(document)
+		if !clauseMatched {
+			continue
+		}
+		matched = true
+		sumScore += clauseScore
+		maxScore = math.Max(maxScore, clauseScore)
+	}
+	if !matched {
+		return false, 0
+	}
+
+	return true, query.boost * (maxScore + query.tieBreaker*(sumScore-maxScore))
+}
+
+type termsQuery struct {
+	field           *mappedField
+	values          []interface{}
+	caseInsensitive bool
+	boost           float64
+}
+
+func (query *termsQuery) evaluate(document Document) (bool, float64) {
+	for _, documentValue := range query.field.documentTerms(document) {
+		for _, value := range query.values {
+			comparison, comparable := compareValues(documentValue, value)
+			if comparable && comparison == 0 {
+				return true, query.boost
+			}
+			documentText, documentIsString := documentValue.(string)
+			text, isString := value.(string)
+			if query.caseInsensitive && documentIsString && isString && strings.EqualFold(documentText, text) {
+				return true, query.boost
+			}
+		}
+	}
+
+	return false, 0
+}
+
+type rangeBound struct {
+	operator string
+	value    interface{}
+}
+
+type rangeQuery struct {
+	field  *mappedField
+	bounds []rangeBound
+	boost  float64
+}
+
+func (query *rangeQuery) evaluate(document Document) (bool, float64) {
+	for _, documentValue := range query.field.documentTerms(document) {
+		if query.inRange(documentValue) {
+			return true, query.boost
+		}
+	}
+
+	return false, 0
+}
+
+func (query *rangeQuery) inRange(documentValue interface{}) bool {
+	for _, bound := range query.bounds {
+		comparison, comparable := compareValues(documentValue, bound.value)
+		if !comparable {
+			return false
+		}
+
+		switch bound.operator {
+		case "gt":
+			if comparison <= 0 {
+				return false
+			}
+		case "gte":
+			if comparison < 0 {
+				return false
+			}
+		case "lt":
+			if comparison >= 0 {
+				return false
+			}
+		case "lte":
+			if comparison > 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+type existsQuery struct {
+	field *mappedField
+	boost float64
+}
+
+func (query *existsQuery) evaluate(document Document) (bool, float64) {
+	var exists bool
+	switch {
+	case query.field.unmapped:
+		exists = false
+	case query.field.isText() || query.field.fieldType == FieldTypeObject || query.field.fieldType == FieldTypeNested:
+		exists = fieldExists(document.Source, strings.Split(query.field.sourcePath, "."))
+	default:
+		// Malformed values that were ignored and keywords above ignore_above are not indexed.
+		exists = len(query.field.documentTerms(document)) > 0
+	}
+	if !exists {
+		return false, 0
+	}
+
+	return true, query.boost
+}
+
+type prefixQuery struct {
+	field           *mappedField
+	prefix          string
+	caseInsensitive bool
+	boost           float64
+}
+
+func (query *prefixQuery) evaluate(document Document) (bool, float64) {
+	prefix := query.prefix
+	if query.caseInsensitive {
+		prefix = strings.ToLower(prefix)
+	}
+
+	for _, documentValue := range query.field.documentTerms(document) {
+		value, ok := documentValue.(string)
+		if ok && query.caseInsensitive {
+			value = strings.ToLower(value)
+		}
+		if ok && strings.HasPrefix(value, prefix) {
+			return true, query.boost
+		}
+	}
+
+	return false, 0
+}
+
+type boolQuery struct {
+	must               []searchQuery
+	filter             []searchQuery
+	should             []searchQuery
+	mustNot            []searchQuery
+	minimumShouldMatch interface{}
+	boost              float64
+}
+
+func (query *boolQuery) evaluate(document Document) (bool, float64) {
+	score := 0.0
+	for _, clause := range query.must {
+		matched, clauseScore := clause.evaluate(document)
+		if !matched {
+			return false, 0
+		}
+		score += clauseScore
+	}
+
+	for _, clause := range query.filter {
+		matched, _ := clause.evaluate(document)
+		if !matched {
+			return false, 0
+		}
+	}
+
+	for _, clause := range query.mustNot {
+		matched, _ := clause.evaluate(document)
+		if matched {
+			return false, 0
+		}
+	}
+
+	shouldMatched := 0
+	for _, clause := range query.should {
+		matched, clauseScore := clause.evaluate(document)
+		if matched {
+			shouldMatched++
+			score += clauseScore
+		}
+	}
+
+	// Should clauses are optional when there is any must or filter clause, unless minimum_should_match says otherwise.
+	required := 0
+	if len(query.must) == 0 && len(query.filter) == 0 && len(query.should) > 0 {
+		required = 1
+	}
+	if query.minimumShouldMatch != nil {
+		required = minimumShouldMatch(query.minimumShouldMatch, len(query.should))
+	}
+	if shouldMatched < required {
+		return false, 0
+	}
+
+	return true, query.boost * score
+}
+
+// minimumShouldMatch resolves a minimum_should_match value, an integer or a percentage that can be negative,
+// into the number of optional clauses that must match.
+func minimumShouldMatch(value interface{}, optionalClauses int) int {
+	specification := strings.TrimSpace(fmt.Sprint(value))
+
+	var required int
+	if strings.HasSuffix(specification, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(specification, "%"), 64)
+		if err != nil {
+			return optionalClauses
+		}
+		required = int(math.Floor(float64(optionalClauses) * math.Abs(percentage) / 100))
+		if percentage < 0 {
+			required = optionalClauses - required
+		}
+	} else {
+		number, err := strconv.Atoi(specification)
+		if err != nil {
+			return optionalClauses
+		}
+		required = number
+		if number < 0 {
+			required = optionalClauses + number
+		}
+	}
+
+	if required < 0 {
+		return 0
+	}
+	if required > optionalClauses {
+		return optionalClauses
+	}
+
+	return required
+}
+
+// fieldValues returns the values of a dotted field path in the document, arrays and arrays of objects
+// are flattened the same way Elasticsearch indexes them.
+func fieldValues(document Document, field string) []interface{} {
+	switch field {
+	case "_id":
+		return []interface{}{document.Id}
+	case "_index":
+		return []interface{}{document.Index}
+	}
+
+	values := make([]interface{}, 0)
+	collectFieldValues(document.Source, strings.Split(field, "."), &values)
+
+	return values
+}
+
+func collectFieldValues(value interface{}, path []string, values *[]interface{}) {
+	switch typedValue := value.(type) {
+	case nil:
+		return
+	case []interface{}:
+		for _, item := range typedValue {
+			collectFieldValues(item, path, values)
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return
+		}
+
+		// Object keys may contain dots themselves, so the longest matching key is tried first.
+		for length := len(path); length > 0; length-- {
+			child, exists := typedValue[strings.Join(path[:length], ".")]
+			if exists {
+				collectFieldValues(child, path[length:], values)
+			}
+		}
+	default:
+		if len(path) == 0 {
+			*values = append(*values, typedValue)
+		}
+	}
+}
+
+// fieldExists reports whether the path holds any value, an object counts when any of its fields has a value.
+func fieldExists(value interface{}, path []string) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, item := range typedValue {
+			if fieldExists(item, path) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			for _, child := range typedValue {
+				if fieldExists(child, path) {
+					return true
+				}
+			}
+			return false
+		}
+
+		for length := len(path); length > 0; length-- {
+			child, exists := typedValue[strings.Join(path[:length], ".")]
+			if exists && fieldExists(child, path[length:]) {
+				return true
+			}
+		}
+	default:
+		return len(path) == 0
+	}
+
+	return false
+}
+
+// compareValues compares a document value with a query value, numbers are compared numerically even
+// when one of them is sent as a string.
+func compareValues(documentValue interface{}, queryValue interface{}) (int, bool) {
+	_, documentIsString := documentValue.(string)
+	_, queryIsString := queryValue.(string)
+	if documentIsString && queryIsString {
+		return strings.Compare(documentValue.(string), queryValue.(string)), true
+	}
+
+	documentNumber, documentIsNumber := toFloat(documentValue)
+	queryNumber, queryIsNumber := toFloat(queryValue)
+	if documentIsNumber && queryIsNumber {
+		switch {
+		case documentNumber < queryNumber:
+			return -1, true
+		case documentNumber > queryNumber:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	documentBool, documentIsBool := toBool(documentValue)
+	queryBool, queryIsBool := toBool(queryValue)
+	if documentIsBool && queryIsBool {
+		switch {
+		case documentBool == queryBool:
+			return 0, true
+		case !documentBool:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch typedValue := value.(type) {
+	case float64:
+		return typedValue, true
+	case int:
+		return float64(typedValue), true
+	case int64:
+		return float64(typedValue), true
+	case json.Number:
+		number, err := typedValue.Float64()
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch typedValue := value.(type) {
+	case bool:
+		return typedValue, true
+	case string:
+		switch typedValue {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+// tokenize splits a text into lowercase terms on anything that is not a letter or a digit.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
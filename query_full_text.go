@@ -0,0 +1,418 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxExpansions is the number of terms the last term of a phrase prefix query expands to.
+const DefaultMaxExpansions = 50
+
+func (parser *queryParser) parseMatchPhrase(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("match_phrase", queryBody, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.newMatchPhraseQuery(field, value, options)
+}
+
+func (parser *queryParser) parseMatchPhrasePrefix(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("match_phrase_prefix", queryBody, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.newMatchPhrasePrefixQuery(field, value, options)
+}
+
+func (parser *queryParser) parseMatchBoolPrefix(queryBody interface{}) (searchQuery, error) {
+	field, value, options, err := singleFieldQuery("match_bool_prefix", queryBody, "query")
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.newMatchBoolPrefixQuery(field, value, options)
+}
+
+// queryAnalyzer returns the analyzer the text of a query on the field is analysed with: the analyzer
+// option of the query, or the search analyzer of the field.
+func (parser *queryParser) queryAnalyzer(field *mappedField, options map[string]interface{}) (*analyzer, error) {
+	if name, isString := options["analyzer"].(string); isString {
+		queryAnalyzer, err := parser.es.indicesAnalysis[parser.indexName].analyzer(name)
+		if err != nil {
+			return nil, newQueryShardError("[match] analyzer [%s] not found", name)
+		}
+		return queryAnalyzer, nil
+	}
+	if field.searchAnalyzer != nil {
+		return field.searchAnalyzer, nil
+	}
+
+	return field.analyzer, nil
+}
+
+func (parser *queryParser) newMatchPhraseQuery(fieldName string, value interface{}, options map[string]interface{}) (searchQuery, error) {
+	return parser.newPhraseQuery(fieldName, value, options, false)
+}
+
+func (parser *queryParser) newMatchPhrasePrefixQuery(fieldName string, value interface{}, options map[string]interface{}) (searchQuery, error) {
+	return parser.newPhraseQuery(fieldName, value, options, true)
+}
+
+// newPhraseQuery returns the query of a phrase on a field, whose last term is a prefix for
+// match_phrase_prefix. Fields that are not text hold no positions and match the phrase as a single term.
+func (parser *queryParser) newPhraseQuery(fieldName string, value interface{}, options map[string]interface{}, prefix bool) (searchQuery, error) {
+	field := parser.field(fieldName)
+	index := parser.es.indicesInverted[parser.indexName]
+	if !textFieldTypes[field.fieldType] || index == nil {
+		if prefix && keywordFieldTypes[field.fieldType] {
+			return &prefixQuery{field: field, prefix: fmt.Sprint(value), boost: boostOption(options)}, nil
+		}
+		termOptions := map[string]interface{}{"operator": "and", "boost": options["boost"], "lenient": options["lenient"]}
+		return parser.newMatchQuery(fieldName, value, termOptions)
+	}
+
+	phraseAnalyzer, err := parser.queryAnalyzer(field, options)
+	if err != nil {
+		return nil, err
+	}
+	tokens := phraseAnalyzer.analyze(fmt.Sprint(value))
+	if len(tokens) == 0 {
+		return &matchNoneQuery{}, nil
+	}
+
+	query := &phraseQuery{
+		field: field,
+		index: index,
+		boost: boostOption(options),
+	}
+	if slop, isNumber := toFloat(options["slop"]); isNumber {
+		query.slop = int(slop)
+	}
+
+	// Tokens at the same position, like synonyms, are alternatives for that position of the phrase.
+	for _, token := range tokens {
+		offset := token.position - tokens[0].position
+		last := len(query.offsets) - 1
+		if last >= 0 && query.offsets[last] == offset {
+			query.terms[last] = append(query.terms[last], token.term)
+			continue
+		}
+		query.offsets = append(query.offsets, offset)
+		query.terms = append(query.terms, []string{token.term})
+	}
+
+	if prefix {
+		maxExpansions := DefaultMaxExpansions
+		if maxExpansionsOption, isNumber := toFloat(options["max_expansions"]); isNumber {
+			maxExpansions = int(maxExpansionsOption)
+		}
+		last := len(query.terms) - 1
+		expansions := make([]string, 0)
+		for _, term := range query.terms[last] {
+			expansions = append(expansions, index.expandPrefix(field.name, term, maxExpansions)...)
+		}
+		if len(expansions) == 0 {
+			return &matchNoneQuery{}, nil
+		}
+		query.terms[last] = expansions
+	}
+
+	return query, nil
+}
+
+// newMatchBoolPrefixQuery returns the query of match_bool_prefix, a bool query with a should clause for
+// each term of the text but the last one, which is a prefix.
+func (parser *queryParser) newMatchBoolPrefixQuery(fieldName string, value interface{}, options map[string]interface{}) (searchQuery, error) {
+	field := parser.field(fieldName)
+	if !textFieldTypes[field.fieldType] {
+		return parser.newMatchQuery(fieldName, value, options)
+	}
+
+	prefixAnalyzer, err := parser.queryAnalyzer(field, options)
+	if err != nil {
+		return nil, err
+	}
+	terms := prefixAnalyzer.terms(fmt.Sprint(value))
+	if len(terms) == 0 {
+		return &matchNoneQuery{}, nil
+	}
+
+	query := &boolQuery{
+		minimumShouldMatch: options["minimum_should_match"],
+		boost:              boostOption(options),
+	}
+	for _, term := range terms[:len(terms)-1] {
+		query.should = append(query.should, &matchQuery{
+			field: field,
+			index: parser.es.indicesInverted[parser.indexName],
+			terms: []interface{}{term},
+			boost: 1,
+		})
+	}
+	query.should = append(query.should, &prefixQuery{field: field, prefix: terms[len(terms)-1], boost: 1})
+
+	if operator, _ := options["operator"].(string); query.minimumShouldMatch == nil && strings.EqualFold(operator, "and") {
+		query.minimumShouldMatch = len(query.should)
+	}
+
+	return query, nil
+}
+
+// parseMultiMatch parses a multi_match query, a query of its type on each of its fields: best_fields
+// scores with the best field, most_fields adds the scores of every field, phrase and phrase_prefix run a
+// phrase query on each field, bool_prefix a match_bool_prefix query, and cross_fields searches each term
+// in all the fields as if they were a single one.
+func (parser *queryParser) parseMultiMatch(queryBody interface{}) (searchQuery, error) {
+	options, isObject := queryBody.(map[string]interface{})
+	if !isObject {
+		return nil, newParsingError("[multi_match] query malformed, no start_object after query name")
+	}
+	value, exists := options["query"]
+	if !exists {
+		return nil, newParsingError("No text specified for multi_match query")
+	}
+
+	matchType, _ := options["type"].(string)
+	tieBreaker, _ := toFloat(options["tie_breaker"])
+	var fieldQuery func(string, interface{}, map[string]interface{}) (searchQuery, error)
+	switch matchType {
+	case "", "best_fields":
+		fieldQuery = parser.newMatchQuery
+	case "most_fields":
+		fieldQuery = parser.newMatchQuery
+		tieBreaker = 1
+	case "phrase":
+		fieldQuery = parser.newMatchPhraseQuery
+	case "phrase_prefix":
+		fieldQuery = parser.newMatchPhrasePrefixQuery
+	case "bool_prefix":
+		fieldQuery = parser.newMatchBoolPrefixQuery
+		tieBreaker = 1
+	case "cross_fields":
+		return parser.newCrossFieldsQuery(value, options, tieBreaker)
+	default:
+		return nil, newParsingError("failed to parse [multi_match] query type [%s]. unknown type.", matchType)
+	}
+
+	query := &disMaxQuery{
+		tieBreaker: tieBreaker,
+		boost:      boostOption(options),
+	}
+	for _, field := range parser.queryFields(stringList(options["fields"])) {
+		clause, err := fieldQuery(field.name, value, fieldOptions(options, field))
+		if err != nil {
+			return nil, err
+		}
+		query.queries = append(query.queries, clause)
+	}
+
+	return query, nil
+}
+
+// fieldOptions returns the options of the query on one of the fields of a query on several fields, with
+// the boost of the field. Fields expanded from a wildcard are lenient, values of the wrong type do not
+// fail the query.
+func fieldOptions(options map[string]interface{}, field queryField) map[string]interface{} {
+	fieldOptions := make(map[string]interface{}, len(options))
+	for name, option := range options {
+		fieldOptions[name] = option
+	}
+	fieldOptions["boost"] = field.boost
+	if field.expanded {
+		fieldOptions["lenient"] = true
+	}
+
+	return fieldOptions
+}
+
+// newCrossFieldsQuery returns the query of a cross_fields multi_match. The text fields are searched as a
+// single field: the text is analysed once, operator and minimum_should_match apply to its terms, and each
+// term scores with its best field, with the document frequency of the field where it is most frequent so
+// that rare fields do not win. The other fields are searched with match queries.
+func (parser *queryParser) newCrossFieldsQuery(value interface{}, options map[string]interface{}, tieBreaker float64) (searchQuery, error) {
+	operator, _ := options["operator"].(string)
+	query := &crossFieldsQuery{
+		index:              parser.es.indicesInverted[parser.indexName],
+		operatorAnd:        strings.EqualFold(operator, "and"),
+		minimumShouldMatch: options["minimum_should_match"],
+		tieBreaker:         tieBreaker,
+		boost:              1,
+	}
+	combined := &disMaxQuery{
+		tieBreaker: tieBreaker,
+		boost:      boostOption(options),
+	}
+
+	for _, field := range parser.queryFields(stringList(options["fields"])) {
+		mapped := parser.field(field.name)
+		if !textFieldTypes[mapped.fieldType] || query.index == nil {
+			clause, err := parser.newMatchQuery(field.name, value, fieldOptions(options, field))
+			if err != nil {
+				return nil, err
+			}
+			combined.queries = append(combined.queries, clause)
+			continue
+		}
+
+		if len(query.fields) == 0 {
+			fieldAnalyzer, err := parser.queryAnalyzer(mapped, options)
+			if err != nil {
+				return nil, err
+			}
+			query.terms = fieldAnalyzer.terms(fmt.Sprint(value))
+		}
+		query.fields = append(query.fields, crossField{field: mapped, boost: field.boost})
+	}
+
+	if len(query.fields) > 0 {
+		combined.queries = append(combined.queries, query)
+	}
+
+	return combined, nil
+}
+
+// phraseQuery matches the documents holding the terms of a phrase at the positions they have in it, up to
+// slop moves away, and scores them with BM25 on the frequency of the phrase, a sloppy match counting less
+// the further it is from the exact phrase, and the sum of the idf of its terms.
+type phraseQuery struct {
+	field *mappedField
+	index *invertedIndex
+	// terms are the alternatives for each position of the phrase, offsets their positions in it.
+	terms   [][]string
+	offsets []int
+	slop    int
+	boost   float64
+}
+
+func (query *phraseQuery) evaluate(document Document) (bool, float64) {
+	idf := float32(0)
+	positions := make([][]int, len(query.terms))
+	for position, terms := range query.terms {
+		for _, term := range terms {
+			positions[position] = append(positions[position], query.index.termPositions(document.Id, query.field.name, term)...)
+			idf += query.index.idf(query.field.name, query.index.docFreq(query.field.name, term))
+		}
+		if len(positions[position]) == 0 {
+			return false, 0
+		}
+	}
+
+	frequency := query.frequency(positions)
+	if frequency == 0 {
+		return false, 0
+	}
+
+	return true, query.boost * query.index.scoreFrequency(document.Id, query.field.name, idf, frequency)
+}
+
+// frequency returns the number of times the phrase is found, from each position of its first term. A match
+// whose terms need to move by a distance to make the exact phrase counts as 1 / (1 + distance).
+func (query *phraseQuery) frequency(positions [][]int) float32 {
+	frequency := float32(0)
+	for _, anchor := range positions[0] {
+		used := map[int]bool{anchor: true}
+		best := -1
+		var search func(term int, lowest int, highest int)
+		search = func(term int, lowest int, highest int) {
+			distance := highest - lowest
+			if distance > query.slop || (best >= 0 && distance >= best) {
+				return
+			}
+			if term == len(positions) {
+				best = distance
+				return
+			}
+			for _, position := range positions[term] {
+				if used[position] {
+					continue
+				}
+				used[position] = true
+				relative := position - query.offsets[term]
+				nextLowest, nextHighest := lowest, highest
+				if relative < nextLowest {
+					nextLowest = relative
+				}
+				if relative > nextHighest {
+					nextHighest = relative
+				}
+				search(term+1, nextLowest, nextHighest)
+				delete(used, position)
+			}
+		}
+
+		relative := anchor - query.offsets[0]
+		search(1, relative, relative)
+		if best >= 0 {
+			frequency += 1 / float32(1+best)
+		}
+	}
+
+	return frequency
+}
+
+type crossField struct {
+	field *mappedField
+	boost float64
+}
+
+// crossFieldsQuery is the term centric query of a cross_fields multi_match on text fields.
+type crossFieldsQuery struct {
+	fields             []crossField
+	index              *invertedIndex
+	terms              []string
+	operatorAnd        bool
+	minimumShouldMatch interface{}
+	tieBreaker         float64
+	boost              float64
+}
+
+func (query *crossFieldsQuery) evaluate(document Document) (bool, float64) {
+	if len(query.terms) == 0 {
+		return false, 0
+	}
+
+	matched, score := 0, 0.0
+	for _, term := range query.terms {
+		docFreq := 0
+		for _, field := range query.fields {
+			if fieldDocFreq := query.index.docFreq(field.field.name, term); fieldDocFreq > docFreq {
+				docFreq = fieldDocFreq
+			}
+		}
+
+		found := false
+		maxScore, sumScore := 0.0, 0.0
+		for _, field := range query.fields {
+			frequency := len(query.index.termPositions(document.Id, field.field.name, term))
+			if frequency == 0 {
+				continue
+			}
+			found = true
+			idf := query.index.idf(field.field.name, docFreq)
+			fieldScore := field.boost * query.index.scoreFrequency(document.Id, field.field.name, idf, float32(frequency))
+			sumScore += fieldScore
+			if fieldScore > maxScore {
+				maxScore = fieldScore
+			}
+		}
+		if found {
+			matched++
+			score += maxScore + query.tieBreaker*(sumScore-maxScore)
+		}
+	}
+
+	required := 1
+	if query.operatorAnd {
+		required = len(query.terms)
+	}
+	if query.minimumShouldMatch != nil {
+		required = minimumShouldMatch(query.minimumShouldMatch, len(query.terms))
+	}
+	if matched == 0 || matched < required {
+		return false, 0
+	}
+
+	return true, query.boost * score
+}
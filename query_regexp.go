@@ -0,0 +1,577 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultMaxRegexLength is the length of the longest regular expression a regexp query accepts.
+const DefaultMaxRegexLength = 1000
+
+// The optional operators of the Lucene regular expression syntax, enabled by the flags of a regexp query.
+const (
+	regexpFlagIntersection = 1 << iota
+	regexpFlagComplement
+	regexpFlagEmpty
+	regexpFlagAnyString
+	regexpFlagInterval
+	regexpFlagAll  = 0xff
+	regexpFlagNone = 0
+)
+
+var regexpFlagNames = map[string]int{
+	"ALL":          regexpFlagAll,
+	"NONE":         regexpFlagNone,
+	"INTERSECTION": regexpFlagIntersection,
+	"COMPLEMENT":   regexpFlagComplement,
+	"EMPTY":        regexpFlagEmpty,
+	"ANYSTRING":    regexpFlagAnyString,
+	"INTERVAL":     regexpFlagInterval,
+}
+
+// parseRegexpFlags parses the flags of a regexp query, operator names separated by |.
+func parseRegexpFlags(value interface{}) (int, error) {
+	if value == nil {
+		return regexpFlagAll, nil
+	}
+
+	flags := 0
+	for _, name := range strings.Split(fmt.Sprint(value), "|") {
+		flag, exists := regexpFlagNames[strings.ToUpper(strings.TrimSpace(name))]
+		if !exists {
+			return 0, newParsingError("Unknown regexp flag [%s]", name)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+type regexpNodeKind int
+
+const (
+	regexpCharacter regexpNodeKind = iota
+	regexpString
+	regexpConcatenation
+	regexpUnion
+	regexpIntersection
+	regexpRepeat
+	regexpComplement
+	regexpAnyString
+	regexpEmptyLanguage
+	regexpInterval
+)
+
+type runeRange struct {
+	low  rune
+	high rune
+}
+
+// regexpNode is a node of a parsed Lucene regular expression. Lucene regular expressions always match
+// the whole term and, unlike the ones of Go, have complement, intersection and numeric interval operators.
+type regexpNode struct {
+	kind     regexpNodeKind
+	children []*regexpNode
+	// ranges are the characters a character node matches, all but them when negated.
+	ranges  []runeRange
+	negated bool
+	text    []rune
+	// minimum and maximum are the bounds of a repeat, maximum is -1 when unbounded, or of an interval.
+	minimum int
+	maximum int
+	// digits is the fixed width of the numbers of an interval, 0 when they have no fixed width.
+	digits int
+}
+
+type regexpParser struct {
+	pattern  []rune
+	position int
+	flags    int
+}
+
+// parseLuceneRegexp parses a regular expression with the Lucene syntax, with the optional operators the
+// flags enable.
+func parseLuceneRegexp(pattern string, flags int) (*regexpNode, error) {
+	parser := &regexpParser{pattern: []rune(pattern), flags: flags}
+	node, err := parser.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.pattern) {
+		return nil, parser.syntaxError("end-of-string expected")
+	}
+
+	return node, nil
+}
+
+func (parser *regexpParser) syntaxError(message string) error {
+	return fmt.Errorf("%s at position %d", message, parser.position)
+}
+
+func (parser *regexpParser) more() bool {
+	return parser.position < len(parser.pattern)
+}
+
+func (parser *regexpParser) peek(characters string) bool {
+	return parser.more() && strings.ContainsRune(characters, parser.pattern[parser.position])
+}
+
+func (parser *regexpParser) match(character rune) bool {
+	if parser.more() && parser.pattern[parser.position] == character {
+		parser.position++
+		return true
+	}
+
+	return false
+}
+
+func (parser *regexpParser) enabled(flag int) bool {
+	return parser.flags&flag != 0
+}
+
+func (parser *regexpParser) parseUnion() (*regexpNode, error) {
+	node, err := parser.parseIntersection()
+	if err != nil || !parser.match('|') {
+		return node, err
+	}
+	right, err := parser.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexpNode{kind: regexpUnion, children: []*regexpNode{node, right}}, nil
+}
+
+func (parser *regexpParser) parseIntersection() (*regexpNode, error) {
+	node, err := parser.parseConcatenation()
+	if err != nil || !parser.enabled(regexpFlagIntersection) || !parser.match('&') {
+		return node, err
+	}
+	right, err := parser.parseIntersection()
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexpNode{kind: regexpIntersection, children: []*regexpNode{node, right}}, nil
+}
+
+func (parser *regexpParser) parseConcatenation() (*regexpNode, error) {
+	node, err := parser.parseRepeat()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.more() || parser.peek(")|") || (parser.enabled(regexpFlagIntersection) && parser.peek("&")) {
+		return node, nil
+	}
+	right, err := parser.parseConcatenation()
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexpNode{kind: regexpConcatenation, children: []*regexpNode{node, right}}, nil
+}
+
+func (parser *regexpParser) parseRepeat() (*regexpNode, error) {
+	node, err := parser.parseComplement()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek("?*+{") {
+		repeat := &regexpNode{kind: regexpRepeat, children: []*regexpNode{node}, maximum: -1}
+		switch {
+		case parser.match('?'):
+			repeat.maximum = 1
+		case parser.match('*'):
+		case parser.match('+'):
+			repeat.minimum = 1
+		case parser.match('{'):
+			repeat.minimum, err = parser.parseInteger()
+			if err != nil {
+				return nil, err
+			}
+			repeat.maximum = repeat.minimum
+			if parser.match(',') {
+				repeat.maximum = -1
+				if !parser.peek("}") {
+					repeat.maximum, err = parser.parseInteger()
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if !parser.match('}') {
+				return nil, parser.syntaxError("expected '}'")
+			}
+		}
+		node = repeat
+	}
+
+	return node, nil
+}
+
+func (parser *regexpParser) parseInteger() (int, error) {
+	start := parser.position
+	for parser.more() && unicode.IsDigit(parser.pattern[parser.position]) {
+		parser.position++
+	}
+	if start == parser.position {
+		return 0, parser.syntaxError("integer expected")
+	}
+
+	return strconv.Atoi(string(parser.pattern[start:parser.position]))
+}
+
+func (parser *regexpParser) parseComplement() (*regexpNode, error) {
+	if !parser.enabled(regexpFlagComplement) || !parser.match('~') {
+		return parser.parseCharacterClass()
+	}
+	node, err := parser.parseComplement()
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexpNode{kind: regexpComplement, children: []*regexpNode{node}}, nil
+}
+
+func (parser *regexpParser) parseCharacterClass() (*regexpNode, error) {
+	if !parser.match('[') {
+		return parser.parseSimple()
+	}
+
+	node := &regexpNode{kind: regexpCharacter, negated: parser.match('^')}
+	for parser.more() && !parser.peek("]") {
+		if predefined := parser.parsePredefinedClass(); predefined != nil {
+			if predefined.negated {
+				return nil, parser.syntaxError("negated predefined classes are not supported in a character class")
+			}
+			node.ranges = append(node.ranges, predefined.ranges...)
+			continue
+		}
+		low, err := parser.parseCharacter()
+		if err != nil {
+			return nil, err
+		}
+		high := low
+		if parser.match('-') {
+			if high, err = parser.parseCharacter(); err != nil {
+				return nil, err
+			}
+		}
+		node.ranges = append(node.ranges, runeRange{low: low, high: high})
+	}
+	if !parser.match(']') {
+		return nil, parser.syntaxError("expected ']'")
+	}
+
+	return node, nil
+}
+
+// parsePredefinedClass parses \d, \s and \w, and their negations \D, \S and \W.
+func (parser *regexpParser) parsePredefinedClass() *regexpNode {
+	if parser.position+1 >= len(parser.pattern) || parser.pattern[parser.position] != '\\' {
+		return nil
+	}
+
+	var ranges []runeRange
+	switch unicode.ToLower(parser.pattern[parser.position+1]) {
+	case 'd':
+		ranges = []runeRange{{'0', '9'}}
+	case 's':
+		ranges = []runeRange{{' ', ' '}, {'\t', '\r'}}
+	case 'w':
+		ranges = []runeRange{{'a', 'z'}, {'A', 'Z'}, {'0', '9'}, {'_', '_'}}
+	default:
+		return nil
+	}
+	negated := unicode.IsUpper(parser.pattern[parser.position+1])
+	parser.position += 2
+
+	return &regexpNode{kind: regexpCharacter, ranges: ranges, negated: negated}
+}
+
+func (parser *regexpParser) parseSimple() (*regexpNode, error) {
+	if predefined := parser.parsePredefinedClass(); predefined != nil {
+		return predefined, nil
+	}
+
+	switch {
+	case parser.match('.'):
+		return &regexpNode{kind: regexpCharacter, negated: true}, nil
+	case parser.enabled(regexpFlagEmpty) && parser.match('#'):
+		return &regexpNode{kind: regexpEmptyLanguage}, nil
+	case parser.enabled(regexpFlagAnyString) && parser.match('@'):
+		return &regexpNode{kind: regexpAnyString}, nil
+	case parser.match('"'):
+		start := parser.position
+		for parser.more() && !parser.peek("\"") {
+			parser.position++
+		}
+		if !parser.match('"') {
+			return nil, parser.syntaxError("expected '\"'")
+		}
+		return &regexpNode{kind: regexpString, text: parser.pattern[start : parser.position-1]}, nil
+	case parser.match('('):
+		if parser.match(')') {
+			return &regexpNode{kind: regexpString}, nil
+		}
+		node, err := parser.parseUnion()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.match(')') {
+			return nil, parser.syntaxError("expected ')'")
+		}
+		return node, nil
+	case parser.enabled(regexpFlagInterval) && parser.match('<'):
+		return parser.parseInterval()
+	}
+
+	character, err := parser.parseCharacter()
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexpNode{kind: regexpString, text: []rune{character}}, nil
+}
+
+// parseInterval parses <min-max>, the numbers between both, with their width when both bounds have it.
+func (parser *regexpParser) parseInterval() (*regexpNode, error) {
+	start := parser.position
+	for parser.more() && !parser.peek(">") {
+		parser.position++
+	}
+	if !parser.match('>') {
+		return nil, parser.syntaxError("expected '>'")
+	}
+
+	bounds := strings.Split(string(parser.pattern[start:parser.position-1]), "-")
+	if len(bounds) != 2 {
+		return nil, parser.syntaxError("interval syntax error")
+	}
+	minimum, minimumErr := strconv.Atoi(bounds[0])
+	maximum, maximumErr := strconv.Atoi(bounds[1])
+	if minimumErr != nil || maximumErr != nil || bounds[0] == "" || bounds[1] == "" {
+		return nil, parser.syntaxError("interval syntax error")
+	}
+	if minimum > maximum {
+		minimum, maximum = maximum, minimum
+	}
+
+	node := &regexpNode{kind: regexpInterval, minimum: minimum, maximum: maximum}
+	if len(bounds[0]) == len(bounds[1]) {
+		node.digits = len(bounds[0])
+	}
+
+	return node, nil
+}
+
+func (parser *regexpParser) parseCharacter() (rune, error) {
+	if parser.match('\\') && !parser.more() {
+		return 0, parser.syntaxError("unexpected end-of-string")
+	}
+	if !parser.more() {
+		return 0, parser.syntaxError("unexpected end-of-string")
+	}
+	parser.position++
+
+	return parser.pattern[parser.position-1], nil
+}
+
+// matches reports whether the regular expression matches the whole value.
+func (node *regexpNode) matches(value string, caseInsensitive bool) bool {
+	matcher := &regexpMatcher{
+		value:           []rune(value),
+		caseInsensitive: caseInsensitive,
+		ends:            make(map[*regexpNode]map[int][]int),
+	}
+	for _, end := range matcher.matchEnds(node, 0) {
+		if end == len(matcher.value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// regexpMatcher finds the positions where each node of a regular expression can end a match starting at a
+// position of the value, remembering them so that nested repeats stay polynomial.
+type regexpMatcher struct {
+	value           []rune
+	caseInsensitive bool
+	ends            map[*regexpNode]map[int][]int
+}
+
+func (matcher *regexpMatcher) matchEnds(node *regexpNode, start int) []int {
+	if ends, exists := matcher.ends[node][start]; exists {
+		return ends
+	}
+
+	ends := matcher.computeEnds(node, start)
+	if matcher.ends[node] == nil {
+		matcher.ends[node] = make(map[int][]int)
+	}
+	matcher.ends[node][start] = ends
+
+	return ends
+}
+
+func (matcher *regexpMatcher) computeEnds(node *regexpNode, start int) []int {
+	length := len(matcher.value)
+	switch node.kind {
+	case regexpCharacter:
+		if start < length && node.matchesCharacter(matcher.value[start], matcher.caseInsensitive) {
+			return []int{start + 1}
+		}
+	case regexpString:
+		end := start + len(node.text)
+		if end > length {
+			return nil
+		}
+		for offset, character := range node.text {
+			if !equalCharacters(character, matcher.value[start+offset], matcher.caseInsensitive) {
+				return nil
+			}
+		}
+		return []int{end}
+	case regexpConcatenation:
+		ends := newIntSet()
+		for _, middle := range matcher.matchEnds(node.children[0], start) {
+			ends.add(matcher.matchEnds(node.children[1], middle)...)
+		}
+		return ends.sorted()
+	case regexpUnion:
+		ends := newIntSet()
+		for _, child := range node.children {
+			ends.add(matcher.matchEnds(child, start)...)
+		}
+		return ends.sorted()
+	case regexpIntersection:
+		right := newIntSet()
+		right.add(matcher.matchEnds(node.children[1], start)...)
+		ends := make([]int, 0)
+		for _, end := range matcher.matchEnds(node.children[0], start) {
+			if right[end] {
+				ends = append(ends, end)
+			}
+		}
+		return ends
+	case regexpRepeat:
+		return matcher.repeatEnds(node, start)
+	case regexpComplement:
+		childEnds := newIntSet()
+		childEnds.add(matcher.matchEnds(node.children[0], start)...)
+		ends := make([]int, 0)
+		for end := start; end <= length; end++ {
+			if !childEnds[end] {
+				ends = append(ends, end)
+			}
+		}
+		return ends
+	case regexpAnyString:
+		ends := make([]int, 0, length-start+1)
+		for end := start; end <= length; end++ {
+			ends = append(ends, end)
+		}
+		return ends
+	case regexpInterval:
+		ends := make([]int, 0)
+		for end := start + 1; end <= length && unicode.IsDigit(matcher.value[end-1]); end++ {
+			if node.digits > 0 && end-start != node.digits {
+				continue
+			}
+			number, err := strconv.Atoi(string(matcher.value[start:end]))
+			if err == nil && number >= node.minimum && number <= node.maximum {
+				ends = append(ends, end)
+			}
+		}
+		return ends
+	}
+
+	return nil
+}
+
+// repeatEnds returns the ends of the repeats of the child, from the minimum number of them to the maximum.
+func (matcher *regexpMatcher) repeatEnds(node *regexpNode, start int) []int {
+	ends := newIntSet()
+	if node.minimum == 0 {
+		ends.add(start)
+	}
+
+	current := []int{start}
+	for count := 1; len(current) > 0 && (node.maximum < 0 || count <= node.maximum); count++ {
+		next := newIntSet()
+		for _, position := range current {
+			next.add(matcher.matchEnds(node.children[0], position)...)
+		}
+
+		current = next.sorted()
+		if count < node.minimum {
+			continue
+		}
+		// Unbounded repeats stop once they reach no new position.
+		fresh := make([]int, 0)
+		for _, end := range current {
+			if !ends[end] {
+				fresh = append(fresh, end)
+			}
+		}
+		ends.add(current...)
+		if node.maximum < 0 {
+			current = fresh
+		}
+	}
+
+	return ends.sorted()
+}
+
+func (node *regexpNode) matchesCharacter(character rune, caseInsensitive bool) bool {
+	inRanges := func(character rune) bool {
+		for _, characters := range node.ranges {
+			if character >= characters.low && character <= characters.high {
+				return true
+			}
+		}
+		return false
+	}
+
+	matched := inRanges(character)
+	if !matched && caseInsensitive {
+		matched = inRanges(unicode.ToLower(character)) || inRanges(unicode.ToUpper(character))
+	}
+
+	return matched != node.negated
+}
+
+func equalCharacters(left rune, right rune, caseInsensitive bool) bool {
+	if caseInsensitive {
+		return unicode.ToLower(left) == unicode.ToLower(right)
+	}
+
+	return left == right
+}
+
+type intSet map[int]bool
+
+func newIntSet() intSet {
+	return make(intSet)
+}
+
+func (set intSet) add(values ...int) {
+	for _, value := range values {
+		set[value] = true
+	}
+}
+
+func (set intSet) sorted() []int {
+	values := make([]int, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Ints(values)
+
+	return values
+}
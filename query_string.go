@@ -0,0 +1,965 @@
+package elasticfacker
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var queryStringOptions = map[string]bool{
+	"query": true, "default_field": true, "fields": true, "default_operator": true, "analyzer": true,
+	"quote_analyzer": true, "allow_leading_wildcard": true, "analyze_wildcard": true, "lenient": true,
+	"auto_generate_synonyms_phrase_query": true, "enable_position_increments": true, "fuzziness": true,
+	"fuzzy_max_expansions": true, "fuzzy_prefix_length": true, "fuzzy_transpositions": true,
+	"max_determinized_states": true, "minimum_should_match": true, "phrase_slop": true,
+	"quote_field_suffix": true, "rewrite": true, "time_zone": true, "type": true, "tie_breaker": true,
+	"escape": true, "boost": true, "_name": true,
+}
+
+var simpleQueryStringOptions = map[string]bool{
+	"query": true, "fields": true, "default_operator": true, "analyzer": true, "analyze_wildcard": true,
+	"auto_generate_synonyms_phrase_query": true, "flags": true, "fuzzy_max_expansions": true,
+	"fuzzy_prefix_length": true, "fuzzy_transpositions": true, "lenient": true, "minimum_should_match": true,
+	"quote_field_suffix": true, "boost": true, "_name": true,
+}
+
+// textQueryBuilder builds the queries of the terms, phrases, wildcards and ranges of a query_string or a
+// simple_query_string query, on each of the fields they search, combined like a multi_match query.
+type textQueryBuilder struct {
+	parser      *queryParser
+	options     map[string]interface{}
+	operatorAnd bool
+	tieBreaker  float64
+	lenient     bool
+}
+
+func (parser *queryParser) newTextQueryBuilder(queryName string, options map[string]interface{}, supported map[string]bool) (*textQueryBuilder, []queryField, string, error) {
+	for option := range options {
+		if !supported[option] {
+			return nil, nil, "", newParsingError("[%s] query does not support [%s]", queryName, option)
+		}
+	}
+	text, isString := options["query"].(string)
+	if !isString {
+		return nil, nil, "", newParsingError("[%s] must be provided with a [query]", queryName)
+	}
+
+	builder := &textQueryBuilder{
+		parser:  parser,
+		options: options,
+	}
+	operator, _ := options["default_operator"].(string)
+	builder.operatorAnd = strings.EqualFold(operator, "and")
+	builder.tieBreaker, _ = toFloat(options["tie_breaker"])
+	if matchType, _ := options["type"].(string); matchType == "most_fields" {
+		builder.tieBreaker = 1
+	}
+	builder.lenient, _ = toBool(options["lenient"])
+
+	// Without fields the query searches the default fields of the index, every field unless set otherwise.
+	patterns := stringList(options["fields"])
+	if defaultField, isString := options["default_field"].(string); isString && len(patterns) == 0 {
+		patterns = []string{defaultField}
+	}
+	if len(patterns) == 0 {
+		patterns = stringList(indexSetting(parser.es.indicesSettings[parser.indexName], "query.default_field"))
+	}
+
+	return builder, parser.queryFields(patterns), text, nil
+}
+
+// fieldQuery runs the build function on each field and combines the queries it returns. Fields the value
+// is malformed for are left out when the query is lenient, and a text analysed into no term, like a stop
+// word, returns no query.
+func (builder *textQueryBuilder) fieldQuery(fields []queryField, build func(queryField, map[string]interface{}) (searchQuery, error)) (searchQuery, error) {
+	queries := make([]searchQuery, 0, len(fields))
+	empty := 0
+	for _, field := range fields {
+		options := map[string]interface{}{
+			"boost":   field.boost,
+			"lenient": builder.lenient || field.expanded,
+		}
+		if analyzerName, isString := builder.options["analyzer"].(string); isString {
+			options["analyzer"] = analyzerName
+		}
+		if builder.operatorAnd {
+			options["operator"] = "and"
+		}
+
+		query, err := build(field, options)
+		if err != nil {
+			if options["lenient"] == true {
+				continue
+			}
+			return nil, err
+		}
+		if match, isMatch := query.(*matchQuery); isMatch && len(match.terms) == 0 {
+			empty++
+			continue
+		}
+		queries = append(queries, query)
+	}
+
+	switch {
+	case len(queries) == 0 && empty > 0:
+		return nil, nil
+	case len(queries) == 0:
+		return &matchNoneQuery{}, nil
+	case len(queries) == 1:
+		return queries[0], nil
+	}
+
+	return &disMaxQuery{queries: queries, tieBreaker: builder.tieBreaker, boost: 1}, nil
+}
+
+func (builder *textQueryBuilder) termQuery(fields []queryField, text string) (searchQuery, error) {
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		return builder.parser.newMatchQuery(field.name, text, options)
+	})
+}
+
+func (builder *textQueryBuilder) phraseQuery(fields []queryField, text string, slop int) (searchQuery, error) {
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		options["slop"] = slop
+		if quoteAnalyzer, isString := builder.options["quote_analyzer"].(string); isString {
+			options["analyzer"] = quoteAnalyzer
+		}
+		return builder.parser.newMatchPhraseQuery(field.name, text, options)
+	})
+}
+
+// wildcardQuery returns the query of a term with wildcards, a prefix query when the only one ends it. Text
+// fields index lowercase terms, so the pattern is lowercased for them.
+func (builder *textQueryBuilder) wildcardQuery(fields []queryField, pattern string) (searchQuery, error) {
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		mapped, err := builder.parser.stringQueryField("wildcard", field.name)
+		if err != nil {
+			return nil, err
+		}
+		fieldPattern := pattern
+		if mapped.isText() {
+			fieldPattern = strings.ToLower(pattern)
+		}
+
+		body := strings.TrimSuffix(fieldPattern, "*")
+		if !strings.ContainsAny(body, "*?\\") {
+			return &prefixQuery{field: mapped, prefix: body, boost: field.boost}, nil
+		}
+
+		return &wildcardQuery{field: mapped, pattern: fieldPattern, boost: field.boost}, nil
+	})
+}
+
+// fuzzyQuery returns the query of a term~edits, lowercased for text fields.
+func (builder *textQueryBuilder) fuzzyQuery(fields []queryField, term string, fuzzinessOption interface{}) (searchQuery, error) {
+	matcher, err := newFuzzyMatcher(fuzzinessOption, builder.options["fuzzy_prefix_length"], builder.options["fuzzy_transpositions"])
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		mapped, err := builder.parser.stringQueryField("fuzzy", field.name)
+		if err != nil {
+			return nil, err
+		}
+		value := term
+		if mapped.isText() {
+			value = strings.ToLower(term)
+		}
+
+		return &fuzzyQuery{
+			field:   mapped,
+			index:   builder.parser.es.indicesInverted[builder.parser.indexName],
+			value:   value,
+			matcher: matcher,
+			boost:   field.boost,
+		}, nil
+	})
+}
+
+func (builder *textQueryBuilder) regexpQuery(fields []queryField, pattern string) (searchQuery, error) {
+	expression, err := parseLuceneRegexp(pattern, regexpFlagAll)
+	if err != nil {
+		return nil, newQueryShardError("failed to create query: %s", err.Error())
+	}
+
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		mapped, err := builder.parser.stringQueryField("regexp", field.name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &regexpQuery{field: mapped, expression: expression, boost: field.boost}, nil
+	})
+}
+
+func (builder *textQueryBuilder) rangeQuery(fields []queryField, bounds map[string]interface{}) (searchQuery, error) {
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		rangeOptions := map[string]interface{}{"boost": field.boost}
+		for operator, value := range bounds {
+			rangeOptions[operator] = value
+		}
+		if timeZone, isString := builder.options["time_zone"].(string); isString {
+			rangeOptions["time_zone"] = timeZone
+		}
+		return builder.parser.parseRange(map[string]interface{}{field.name: rangeOptions})
+	})
+}
+
+func (builder *textQueryBuilder) existsQuery(fields []queryField) (searchQuery, error) {
+	return builder.fieldQuery(fields, func(field queryField, options map[string]interface{}) (searchQuery, error) {
+		return &existsQuery{field: builder.parser.field(field.name), boost: field.boost}, nil
+	})
+}
+
+// boostQuery multiplies the score of its query by a boost, the ^boost suffix of query string clauses.
+type boostQuery struct {
+	query searchQuery
+	boost float64
+}
+
+func (query *boostQuery) evaluate(document Document) (bool, float64) {
+	matched, score := query.query.evaluate(document)
+
+	return matched, query.boost * score
+}
+
+// parseQueryString parses a query_string query, the Lucene query syntax: terms and "phrases" on the
+// default fields or on field:value, groups in parentheses, AND, OR, NOT and the + and - prefixes,
+// wildcards, /regular expressions/, ranges as [from TO to], {from TO to} or >value, and ^boost and ~slop
+// or ~edits suffixes.
+func (parser *queryParser) parseQueryString(queryBody interface{}) (searchQuery, error) {
+	options, isObject := queryBody.(map[string]interface{})
+	if !isObject {
+		return nil, newParsingError("[query_string] query malformed, no start_object after query name")
+	}
+	builder, fields, text, err := parser.newTextQueryBuilder("query_string", options, queryStringOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	stringParser := &queryStringParser{
+		builder: builder,
+		text:    []rune(text),
+	}
+	if phraseSlop, isNumber := toFloat(options["phrase_slop"]); isNumber {
+		stringParser.phraseSlop = int(phraseSlop)
+	}
+
+	query, err := stringParser.parseClauses(fields, true)
+	if err == nil && stringParser.position < len(stringParser.text) {
+		err = errQueryStringSyntax
+	}
+	if err == errQueryStringSyntax {
+		return nil, newQueryShardError("Failed to parse query [%s]", text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		return &matchNoneQuery{}, nil
+	}
+	if options["boost"] != nil {
+		query = &boostQuery{query: query, boost: boostOption(options)}
+	}
+
+	return query, nil
+}
+
+var errQueryStringSyntax = &queryError{errorType: "query_shard_exception", reason: "syntax error"}
+
+type queryStringParser struct {
+	builder    *textQueryBuilder
+	text       []rune
+	position   int
+	phraseSlop int
+}
+
+const (
+	occurMust    = "must"
+	occurShould  = "should"
+	occurMustNot = "must_not"
+)
+
+type queryStringClause struct {
+	query searchQuery
+	occur string
+}
+
+// parseClauses parses clauses up to the end of the text or of the group, and combines them in a bool
+// query with the occurrence rules of the Lucene query parser.
+func (stringParser *queryStringParser) parseClauses(fields []queryField, topLevel bool) (searchQuery, error) {
+	clauses := make([]*queryStringClause, 0)
+	for {
+		stringParser.skipSpaces()
+		if stringParser.position >= len(stringParser.text) || stringParser.peek() == ')' {
+			break
+		}
+
+		conjunction := ""
+		switch {
+		case stringParser.consumeKeyword("AND"), stringParser.consume("&&"):
+			conjunction = "AND"
+		case stringParser.consumeKeyword("OR"), stringParser.consume("||"):
+			conjunction = "OR"
+		}
+		stringParser.skipSpaces()
+
+		modifier := ""
+		switch {
+		case stringParser.consumeKeyword("NOT"), stringParser.consume("!"), stringParser.consume("-"):
+			modifier = "NOT"
+		case stringParser.consume("+"):
+			modifier = "REQUIRED"
+		}
+
+		query, err := stringParser.parseClause(fields)
+		if err != nil {
+			return nil, err
+		}
+		clauses = stringParser.addClause(clauses, conjunction, modifier, query)
+	}
+
+	return stringParser.combineClauses(clauses, topLevel), nil
+}
+
+// addClause adds a clause with the occurrence its conjunction, its modifier and the default operator give
+// it, and makes the previous clause required for AND, or optional for OR with the AND default operator.
+func (stringParser *queryStringParser) addClause(clauses []*queryStringClause, conjunction string, modifier string, query searchQuery) []*queryStringClause {
+	operatorAnd := stringParser.builder.operatorAnd
+	if len(clauses) > 0 {
+		last := clauses[len(clauses)-1]
+		if conjunction == "AND" && last.occur != occurMustNot {
+			last.occur = occurMust
+		}
+		if operatorAnd && conjunction == "OR" && last.occur != occurMustNot {
+			last.occur = occurShould
+		}
+	}
+	if query == nil {
+		return clauses
+	}
+
+	prohibited := modifier == "NOT"
+	required := modifier == "REQUIRED" || (conjunction == "AND" && !prohibited)
+	if operatorAnd {
+		required = !prohibited && conjunction != "OR"
+	}
+
+	occur := occurShould
+	switch {
+	case prohibited:
+		occur = occurMustNot
+	case required:
+		occur = occurMust
+	}
+
+	return append(clauses, &queryStringClause{query: query, occur: occur})
+}
+
+// combineClauses returns the bool query of the clauses. Groups of negative clauses only match every other
+// document, and minimum_should_match applies to the top level clauses.
+func (stringParser *queryStringParser) combineClauses(clauses []*queryStringClause, topLevel bool) searchQuery {
+	options := stringParser.builder.options
+	if len(clauses) == 0 {
+		return nil
+	}
+	if len(clauses) == 1 && clauses[0].occur != occurMustNot && (!topLevel || options["minimum_should_match"] == nil) {
+		return clauses[0].query
+	}
+
+	query := &boolQuery{boost: 1}
+	if topLevel {
+		query.minimumShouldMatch = options["minimum_should_match"]
+	}
+	for _, clause := range clauses {
+		switch clause.occur {
+		case occurMust:
+			query.must = append(query.must, clause.query)
+		case occurShould:
+			query.should = append(query.should, clause.query)
+		case occurMustNot:
+			query.mustNot = append(query.mustNot, clause.query)
+		}
+	}
+	if len(query.must) == 0 && len(query.should) == 0 {
+		query.filter = []searchQuery{&matchAllQuery{boost: 1}}
+	}
+
+	return query
+}
+
+// parseClause parses a clause: a group, a phrase, a range or a term, on the fields or on its own field,
+// followed by a boost.
+func (stringParser *queryStringParser) parseClause(fields []queryField) (searchQuery, error) {
+	stringParser.skipSpaces()
+	if stringParser.position >= len(stringParser.text) {
+		return nil, errQueryStringSyntax
+	}
+
+	explicitField := false
+	if !strings.ContainsRune("(\"[{/", stringParser.peek()) {
+		start := stringParser.position
+		name, _ := stringParser.readTerm()
+		if name != "" && stringParser.consume(":") {
+			explicitField = true
+			if name == "_exists_" {
+				fieldName, _ := stringParser.readTerm()
+				if fieldName == "" {
+					return nil, errQueryStringSyntax
+				}
+				query, err := stringParser.builder.existsQuery(stringParser.builder.parser.queryFields([]string{fieldName}))
+				if err != nil {
+					return nil, err
+				}
+				return stringParser.parseBoost(query)
+			}
+			if name == "*" {
+				explicitField = false
+			} else {
+				fields = stringParser.builder.parser.queryFields([]string{name})
+			}
+		} else {
+			stringParser.position = start
+		}
+	}
+
+	var query searchQuery
+	var err error
+	switch stringParser.peek() {
+	case '(':
+		stringParser.position++
+		query, err = stringParser.parseClauses(fields, false)
+		if err != nil {
+			return nil, err
+		}
+		if !stringParser.consume(")") {
+			return nil, errQueryStringSyntax
+		}
+	case '"':
+		query, err = stringParser.parsePhrase(fields)
+	case '/':
+		query, err = stringParser.parseRegexp(fields)
+	case '[', '{':
+		query, err = stringParser.parseRange(fields)
+	case '>', '<':
+		query, err = stringParser.parseComparison(fields)
+	default:
+		query, err = stringParser.parseTerm(fields, explicitField)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return stringParser.parseBoost(query)
+}
+
+func (stringParser *queryStringParser) parsePhrase(fields []queryField) (searchQuery, error) {
+	stringParser.position++
+	var phrase strings.Builder
+	for {
+		if stringParser.position >= len(stringParser.text) {
+			return nil, errQueryStringSyntax
+		}
+		character := stringParser.text[stringParser.position]
+		stringParser.position++
+		if character == '"' {
+			break
+		}
+		if character == '\\' && stringParser.position < len(stringParser.text) {
+			character = stringParser.text[stringParser.position]
+			stringParser.position++
+		}
+		phrase.WriteRune(character)
+	}
+
+	slop := stringParser.phraseSlop
+	if stringParser.consume("~") {
+		if number, found := stringParser.readNumber(); found {
+			slop = int(number)
+		}
+	}
+
+	return stringParser.builder.phraseQuery(fields, phrase.String(), slop)
+}
+
+// parseRegexp parses a /regular expression/, where \/ escapes a slash.
+func (stringParser *queryStringParser) parseRegexp(fields []queryField) (searchQuery, error) {
+	stringParser.position++
+	var pattern strings.Builder
+	for {
+		if stringParser.position >= len(stringParser.text) {
+			return nil, errQueryStringSyntax
+		}
+		character := stringParser.text[stringParser.position]
+		stringParser.position++
+		if character == '/' {
+			break
+		}
+		if character == '\\' && stringParser.peek() == '/' {
+			character = '/'
+			stringParser.position++
+		}
+		pattern.WriteRune(character)
+	}
+
+	return stringParser.builder.regexpQuery(fields, pattern.String())
+}
+
+// parseRange parses [from TO to], including its bounds, or {from TO to}, excluding them, where * leaves a
+// side open.
+func (stringParser *queryStringParser) parseRange(fields []queryField) (searchQuery, error) {
+	includeLower := stringParser.peek() == '['
+	stringParser.position++
+
+	values := make([]string, 0, 3)
+	for len(values) < 3 {
+		stringParser.skipSpaces()
+		if stringParser.peek() == '"' {
+			stringParser.position++
+			end := stringParser.position
+			for end < len(stringParser.text) && stringParser.text[end] != '"' {
+				end++
+			}
+			if end >= len(stringParser.text) {
+				return nil, errQueryStringSyntax
+			}
+			values = append(values, string(stringParser.text[stringParser.position:end]))
+			stringParser.position = end + 1
+			continue
+		}
+		value, _ := stringParser.readRangeValue()
+		if value == "" {
+			return nil, errQueryStringSyntax
+		}
+		values = append(values, value)
+	}
+	stringParser.skipSpaces()
+	includeUpper := stringParser.peek() == ']'
+	if values[1] != "TO" || (stringParser.peek() != ']' && stringParser.peek() != '}') {
+		return nil, errQueryStringSyntax
+	}
+	stringParser.position++
+
+	bounds := map[string]interface{}{}
+	if values[0] != "*" {
+		bounds[rangeOperator("gt", includeLower)] = values[0]
+	}
+	if values[2] != "*" {
+		bounds[rangeOperator("lt", includeUpper)] = values[2]
+	}
+
+	return stringParser.builder.rangeQuery(fields, bounds)
+}
+
+func rangeOperator(operator string, inclusive bool) string {
+	if inclusive {
+		return operator + "e"
+	}
+
+	return operator
+}
+
+// parseComparison parses the >value, >=value, <value and <=value ranges.
+func (stringParser *queryStringParser) parseComparison(fields []queryField) (searchQuery, error) {
+	operator := "gt"
+	if stringParser.peek() == '<' {
+		operator = "lt"
+	}
+	stringParser.position++
+	operator = rangeOperator(operator, stringParser.consume("="))
+
+	value, _ := stringParser.readTerm()
+	if value == "" {
+		return nil, errQueryStringSyntax
+	}
+
+	return stringParser.builder.rangeQuery(fields, map[string]interface{}{operator: value})
+}
+
+// parseTerm parses a term, with wildcards when it has unescaped * or ?, or fuzzy when it ends with ~. A
+// lone * matches every document, or the documents with a value for the field it is given.
+func (stringParser *queryStringParser) parseTerm(fields []queryField, explicitField bool) (searchQuery, error) {
+	term, wildcard := stringParser.readTerm()
+	if term == "" {
+		return nil, errQueryStringSyntax
+	}
+
+	// A term~edits is fuzzy, with the fuzziness option when the number of edits is left out.
+	fuzzy := stringParser.consume("~")
+	fuzziness := stringParser.builder.options["fuzziness"]
+	if edits, found := stringParser.readNumber(); fuzzy && found {
+		fuzziness = edits
+	}
+
+	switch {
+	case fuzzy && !wildcard:
+		return stringParser.builder.fuzzyQuery(fields, unescapeQueryString(term), fuzziness)
+	case term == "*" && explicitField:
+		return stringParser.builder.existsQuery(fields)
+	case term == "*":
+		return &matchAllQuery{boost: 1}, nil
+	case wildcard:
+		return stringParser.builder.wildcardQuery(fields, term)
+	}
+
+	return stringParser.builder.termQuery(fields, unescapeQueryString(term))
+}
+
+func (stringParser *queryStringParser) parseBoost(query searchQuery) (searchQuery, error) {
+	if !stringParser.consume("^") {
+		return query, nil
+	}
+	boost, found := stringParser.readNumber()
+	if !found {
+		return nil, errQueryStringSyntax
+	}
+	if query == nil {
+		return nil, nil
+	}
+
+	return &boostQuery{query: query, boost: boost}, nil
+}
+
+// readTerm reads a term up to a space or a special character, keeping its escapes, and reports whether it
+// has wildcards.
+func (stringParser *queryStringParser) readTerm() (string, bool) {
+	start := stringParser.position
+	wildcard := false
+	for stringParser.position < len(stringParser.text) {
+		character := stringParser.text[stringParser.position]
+		if character == '\\' && stringParser.position+1 < len(stringParser.text) {
+			stringParser.position += 2
+			continue
+		}
+		if unicode.IsSpace(character) || strings.ContainsRune("()[]{}\":^~", character) {
+			break
+		}
+		if character == '*' || character == '?' {
+			wildcard = true
+		}
+		stringParser.position++
+	}
+
+	return string(stringParser.text[start:stringParser.position]), wildcard
+}
+
+func (stringParser *queryStringParser) readRangeValue() (string, bool) {
+	start := stringParser.position
+	for stringParser.position < len(stringParser.text) {
+		character := stringParser.text[stringParser.position]
+		if unicode.IsSpace(character) || character == ']' || character == '}' {
+			break
+		}
+		stringParser.position++
+	}
+
+	return unescapeQueryString(string(stringParser.text[start:stringParser.position])), stringParser.position > start
+}
+
+func (stringParser *queryStringParser) readNumber() (float64, bool) {
+	start := stringParser.position
+	for stringParser.position < len(stringParser.text) &&
+		(unicode.IsDigit(stringParser.text[stringParser.position]) || stringParser.text[stringParser.position] == '.') {
+		stringParser.position++
+	}
+	number, err := strconv.ParseFloat(string(stringParser.text[start:stringParser.position]), 64)
+
+	return number, err == nil
+}
+
+func (stringParser *queryStringParser) peek() rune {
+	if stringParser.position >= len(stringParser.text) {
+		return 0
+	}
+
+	return stringParser.text[stringParser.position]
+}
+
+func (stringParser *queryStringParser) skipSpaces() {
+	for stringParser.position < len(stringParser.text) && unicode.IsSpace(stringParser.text[stringParser.position]) {
+		stringParser.position++
+	}
+}
+
+func (stringParser *queryStringParser) consume(token string) bool {
+	tokenRunes := []rune(token)
+	end := stringParser.position + len(tokenRunes)
+	if end > len(stringParser.text) || string(stringParser.text[stringParser.position:end]) != token {
+		return false
+	}
+	stringParser.position = end
+
+	return true
+}
+
+// consumeKeyword consumes an operator keyword, which must be followed by a space or a group.
+func (stringParser *queryStringParser) consumeKeyword(keyword string) bool {
+	start := stringParser.position
+	if !stringParser.consume(keyword) {
+		return false
+	}
+	next := stringParser.peek()
+	if next != 0 && !unicode.IsSpace(next) && next != '(' {
+		stringParser.position = start
+		return false
+	}
+
+	return true
+}
+
+// unescapeQueryString removes the backslashes escaping the characters of a term.
+func unescapeQueryString(term string) string {
+	if !strings.Contains(term, "\\") {
+		return term
+	}
+
+	var unescaped strings.Builder
+	escaped := false
+	for _, character := range term {
+		if character == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		unescaped.WriteRune(character)
+	}
+
+	return unescaped.String()
+}
+
+// escapeWildcard escapes the characters a wildcard pattern gives a meaning to.
+func escapeWildcard(text string) string {
+	var escaped strings.Builder
+	for _, character := range text {
+		if character == '*' || character == '?' || character == '\\' {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(character)
+	}
+
+	return escaped.String()
+}
+
+// parseSimpleQueryString parses a simple_query_string query, a syntax that never fails: + requires the
+// next term, | makes it optional, - excludes it, "phrases" with a ~slop, prefix* terms and parentheses.
+// Terms and operators are combined left to right like the Lucene simple query parser does.
+func (parser *queryParser) parseSimpleQueryString(queryBody interface{}) (searchQuery, error) {
+	options, isObject := queryBody.(map[string]interface{})
+	if !isObject {
+		return nil, newParsingError("[simple_query_string] query malformed, no start_object after query name")
+	}
+	builder, fields, text, err := parser.newTextQueryBuilder("simple_query_string", options, simpleQueryStringOptions)
+	if err != nil {
+		return nil, err
+	}
+	builder.tieBreaker = 1
+
+	simpleParser := &simpleQueryParser{
+		builder: builder,
+		fields:  fields,
+		text:    []rune(text),
+	}
+	query, err := simpleParser.parse(false)
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		return &matchNoneQuery{}, nil
+	}
+
+	if topQuery, isBool := query.(*boolQuery); isBool && options["minimum_should_match"] != nil {
+		topQuery.minimumShouldMatch = options["minimum_should_match"]
+	}
+	if options["boost"] != nil {
+		query = &boostQuery{query: query, boost: boostOption(options)}
+	}
+
+	return query, nil
+}
+
+type simpleQueryParser struct {
+	builder  *textQueryBuilder
+	fields   []queryField
+	text     []rune
+	position int
+}
+
+// simpleQueryState is the query built so far for a level of parentheses, with the operator that joins the
+// next term and the number of - before it.
+type simpleQueryState struct {
+	top               searchQuery
+	currentOperation  string
+	previousOperation string
+	negations         int
+}
+
+func (simpleParser *simpleQueryParser) parse(nested bool) (searchQuery, error) {
+	state := &simpleQueryState{}
+	for simpleParser.position < len(simpleParser.text) {
+		character := simpleParser.text[simpleParser.position]
+		var branch searchQuery
+		var err error
+		switch {
+		case character == '(':
+			simpleParser.position++
+			branch, err = simpleParser.parse(true)
+		case character == ')':
+			simpleParser.position++
+			if nested {
+				return state.top, nil
+			}
+			continue
+		case character == '"':
+			branch, err = simpleParser.parsePhrase()
+		case character == '+' || character == '|':
+			if state.currentOperation == "" && state.top != nil {
+				state.currentOperation = occurShould
+				if character == '+' {
+					state.currentOperation = occurMust
+				}
+			}
+			simpleParser.position++
+			continue
+		case character == '-':
+			state.negations++
+			simpleParser.position++
+			continue
+		case unicode.IsSpace(character):
+			simpleParser.position++
+			continue
+		default:
+			branch, err = simpleParser.parseTerm()
+		}
+		if err != nil {
+			return nil, err
+		}
+		simpleParser.buildQueryTree(state, branch)
+	}
+
+	return state.top, nil
+}
+
+// buildQueryTree adds a branch to the query, in the bool query of the current operator, or in a new bool
+// query holding the query so far when the operator changes.
+func (simpleParser *simpleQueryParser) buildQueryTree(state *simpleQueryState, branch searchQuery) {
+	if branch == nil {
+		state.negations = 0
+		state.currentOperation = ""
+		return
+	}
+	if state.negations%2 == 1 {
+		branch = &boolQuery{
+			mustNot: []searchQuery{branch},
+			should:  []searchQuery{&matchAllQuery{boost: 1}},
+			boost:   1,
+		}
+	}
+
+	if state.top == nil {
+		state.top = branch
+	} else {
+		if state.currentOperation == "" {
+			state.currentOperation = occurShould
+			if simpleParser.builder.operatorAnd {
+				state.currentOperation = occurMust
+			}
+		}
+		top, isBool := state.top.(*boolQuery)
+		if !isBool || state.previousOperation != state.currentOperation {
+			top = &boolQuery{boost: 1}
+			top.addClause(state.currentOperation, state.top)
+			state.top = top
+		}
+		top.addClause(state.currentOperation, branch)
+		state.previousOperation = state.currentOperation
+	}
+	state.negations = 0
+	state.currentOperation = ""
+}
+
+func (query *boolQuery) addClause(occur string, clause searchQuery) {
+	if occur == occurMust {
+		query.must = append(query.must, clause)
+		return
+	}
+	query.should = append(query.should, clause)
+}
+
+// parsePhrase parses a phrase with an optional ~slop. A quote that is never closed is skipped.
+func (simpleParser *simpleQueryParser) parsePhrase() (searchQuery, error) {
+	start := simpleParser.position + 1
+	var phrase strings.Builder
+	for position := start; position < len(simpleParser.text); position++ {
+		character := simpleParser.text[position]
+		if character == '\\' && position+1 < len(simpleParser.text) {
+			position++
+			phrase.WriteRune(simpleParser.text[position])
+			continue
+		}
+		if character != '"' {
+			phrase.WriteRune(character)
+			continue
+		}
+
+		simpleParser.position = position + 1
+		slop := 0
+		if number, found := simpleParser.readSlop(); found {
+			slop = number
+		}
+		return simpleParser.builder.phraseQuery(simpleParser.fields, phrase.String(), slop)
+	}
+
+	simpleParser.position = start
+	return nil, nil
+}
+
+// parseTerm parses a term up to a space or an operator, a prefix when it ends with an unescaped *, fuzzy
+// when it ends with ~edits.
+func (simpleParser *simpleQueryParser) parseTerm() (searchQuery, error) {
+	var term strings.Builder
+	prefix := false
+	for simpleParser.position < len(simpleParser.text) {
+		character := simpleParser.text[simpleParser.position]
+		if unicode.IsSpace(character) || strings.ContainsRune("\"|+()~", character) {
+			break
+		}
+		simpleParser.position++
+		if character == '\\' && simpleParser.position < len(simpleParser.text) {
+			term.WriteRune(simpleParser.text[simpleParser.position])
+			simpleParser.position++
+			prefix = false
+			continue
+		}
+		prefix = character == '*'
+		term.WriteRune(character)
+	}
+
+	edits, fuzzy := simpleParser.readSlop()
+
+	text := term.String()
+	switch {
+	case prefix:
+		return simpleParser.builder.wildcardQuery(simpleParser.fields, escapeWildcard(strings.TrimSuffix(text, "*"))+"*")
+	case text == "":
+		return nil, nil
+	case fuzzy:
+		return simpleParser.builder.fuzzyQuery(simpleParser.fields, text, edits)
+	}
+
+	return simpleParser.builder.termQuery(simpleParser.fields, text)
+}
+
+func (simpleParser *simpleQueryParser) readSlop() (int, bool) {
+	if simpleParser.position >= len(simpleParser.text) || simpleParser.text[simpleParser.position] != '~' {
+		return 0, false
+	}
+	simpleParser.position++
+	start := simpleParser.position
+	for simpleParser.position < len(simpleParser.text) && unicode.IsDigit(simpleParser.text[simpleParser.position]) {
+		simpleParser.position++
+	}
+	number, err := strconv.Atoi(string(simpleParser.text[start:simpleParser.position]))
+
+	return number, err == nil
+}
@@ -0,0 +1,517 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stringQueryField returns the field of a query on the terms of keyword and text fields, like prefix or
+// wildcard, which fails on the fields of other types.
+func (parser *queryParser) stringQueryField(queryName string, fieldName string) (*mappedField, error) {
+	field := parser.field(fieldName)
+	if !field.isText() && !keywordFieldTypes[field.fieldType] && !field.unmapped {
+		return nil, newQueryShardError("Can only use %s queries on keyword, text and wildcard fields - not on [%s] which is of type [%s]",
+			queryName, fieldName, field.fieldType)
+	}
+
+	return field, nil
+}
+
+// termLevelQuery reads queries shaped as {"field": value} or {"field": {"value": value, ...options}}, where
+// the value may also be given under the name of the query, like {"field": {"wildcard": "ki*y"}}.
+func termLevelQuery(queryName string, queryBody interface{}) (string, string, map[string]interface{}, error) {
+	field, value, options, err := singleFieldQuery(queryName, queryBody, "")
+	if err != nil {
+		return "", "", nil, err
+	}
+	if value == nil {
+		value = options["value"]
+	}
+	if value == nil {
+		value = options[queryName]
+	}
+	if value == nil {
+		return "", "", nil, newParsingError("[%s] query does not support [%s] without [value]", queryName, field)
+	}
+
+	return field, fmt.Sprint(value), options, nil
+}
+
+func (parser *queryParser) parseWildcard(queryBody interface{}) (searchQuery, error) {
+	fieldName, pattern, options, err := termLevelQuery("wildcard", queryBody)
+	if err != nil {
+		return nil, err
+	}
+	field, err := parser.stringQueryField("wildcard", fieldName)
+	if err != nil {
+		return nil, err
+	}
+	caseInsensitive, _ := toBool(options["case_insensitive"])
+
+	return &wildcardQuery{
+		field:           field,
+		pattern:         pattern,
+		caseInsensitive: caseInsensitive,
+		boost:           boostOption(options),
+	}, nil
+}
+
+// parseRegexp parses a regexp query, a regular expression with the Lucene syntax matching whole terms.
+func (parser *queryParser) parseRegexp(queryBody interface{}) (searchQuery, error) {
+	fieldName, pattern, options, err := termLevelQuery("regexp", queryBody)
+	if err != nil {
+		return nil, err
+	}
+	field, err := parser.stringQueryField("regexp", fieldName)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRegexLength := DefaultMaxRegexLength
+	if setting, isNumber := toFloat(indexSetting(parser.es.indicesSettings[parser.indexName], "max_regex_length")); isNumber {
+		maxRegexLength = int(setting)
+	}
+	if len([]rune(pattern)) > maxRegexLength {
+		return nil, &queryError{
+			errorType: "illegal_argument_exception",
+			reason: fmt.Sprintf("The length of regex [%d] used in the Regexp Query request has exceeded the allowed maximum of [%d]. "+
+				"This maximum can be set by changing the [index.max_regex_length] index level setting.", len([]rune(pattern)), maxRegexLength),
+		}
+	}
+
+	flags, err := parseRegexpFlags(options["flags"])
+	if err != nil {
+		return nil, err
+	}
+	expression, err := parseLuceneRegexp(pattern, flags)
+	if err != nil {
+		return nil, newQueryShardError("failed to create query: %s", err.Error())
+	}
+	caseInsensitive, _ := toBool(options["case_insensitive"])
+
+	return &regexpQuery{
+		field:           field,
+		expression:      expression,
+		caseInsensitive: caseInsensitive,
+		boost:           boostOption(options),
+	}, nil
+}
+
+// parseFuzzy parses a fuzzy query, the terms within an edit distance of its value.
+func (parser *queryParser) parseFuzzy(queryBody interface{}) (searchQuery, error) {
+	fieldName, value, options, err := termLevelQuery("fuzzy", queryBody)
+	if err != nil {
+		return nil, err
+	}
+	field, err := parser.stringQueryField("fuzzy", fieldName)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := newFuzzyMatcher(options["fuzziness"], options["prefix_length"], options["transpositions"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &fuzzyQuery{
+		field:   field,
+		index:   parser.es.indicesInverted[parser.indexName],
+		value:   value,
+		matcher: matcher,
+		boost:   boostOption(options),
+	}, nil
+}
+
+// parseTermsSet parses a terms_set query, the documents holding a number of its terms given by a field of
+// the document, a script or minimum_should_match.
+func (parser *queryParser) parseTermsSet(queryBody interface{}) (searchQuery, error) {
+	fieldName, _, options, err := singleFieldQuery("terms_set", queryBody, "terms")
+	if err != nil {
+		return nil, err
+	}
+	terms, isArray := options["terms"].([]interface{})
+	if !isArray {
+		return nil, newParsingError("[terms_set] query does not support [terms] that is not an array")
+	}
+
+	query := &termsSetQuery{
+		field: parser.field(fieldName),
+		boost: boostOption(options),
+	}
+	query.values, err = queryValues(query.field, terms)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case options["minimum_should_match_field"] != nil:
+		minimumField, _ := options["minimum_should_match_field"].(string)
+		query.minimumField = parser.field(minimumField)
+	case options["minimum_should_match_script"] != nil:
+		source, params, err := parseScriptSource(options["minimum_should_match_script"])
+		if err != nil {
+			return nil, err
+		}
+		query.minimumScript, err = parseScriptExpression(source)
+		if err != nil {
+			return nil, err
+		}
+		query.scriptParams = params
+		if mapping, exists := parser.es.indicesMappings[parser.indexName]; exists {
+			for _, name := range mapping.fieldNames("") {
+				query.scriptFields = append(query.scriptFields, parser.field(name))
+			}
+		}
+	case options["minimum_should_match"] != nil:
+		query.minimumShouldMatch = options["minimum_should_match"]
+	default:
+		return nil, newParsingError("[terms_set] query requires [minimum_should_match], [minimum_should_match_field] or [minimum_should_match_script]")
+	}
+
+	return query, nil
+}
+
+// wildcardQuery matches the documents with a term of the field matching a pattern, where * matches any
+// characters, ? a single one and \ escapes the next character.
+type wildcardQuery struct {
+	field           *mappedField
+	pattern         string
+	caseInsensitive bool
+	boost           float64
+}
+
+func (query *wildcardQuery) evaluate(document Document) (bool, float64) {
+	pattern := query.pattern
+	if query.caseInsensitive {
+		pattern = strings.ToLower(pattern)
+	}
+
+	for _, documentValue := range query.field.documentTerms(document) {
+		value, ok := documentValue.(string)
+		if !ok {
+			continue
+		}
+		if query.caseInsensitive {
+			value = strings.ToLower(value)
+		}
+		if matchesWildcard([]rune(pattern), []rune(value)) {
+			return true, query.boost
+		}
+	}
+
+	return false, 0
+}
+
+// matchesWildcard reports whether the value matches the wildcard pattern, backtracking to the last star.
+func matchesWildcard(pattern []rune, value []rune) bool {
+	patternIndex, valueIndex := 0, 0
+	starIndex, starValueIndex := -1, 0
+	for valueIndex < len(value) {
+		if patternIndex < len(pattern) {
+			switch character := pattern[patternIndex]; {
+			case character == '*':
+				starIndex, starValueIndex = patternIndex, valueIndex
+				patternIndex++
+				continue
+			case character == '?':
+				patternIndex++
+				valueIndex++
+				continue
+			case character == '\\' && patternIndex+1 < len(pattern):
+				if pattern[patternIndex+1] == value[valueIndex] {
+					patternIndex += 2
+					valueIndex++
+					continue
+				}
+			case character == value[valueIndex]:
+				patternIndex++
+				valueIndex++
+				continue
+			}
+		}
+		if starIndex < 0 {
+			return false
+		}
+		starValueIndex++
+		patternIndex, valueIndex = starIndex+1, starValueIndex
+	}
+
+	for patternIndex < len(pattern) && pattern[patternIndex] == '*' {
+		patternIndex++
+	}
+
+	return patternIndex == len(pattern)
+}
+
+type regexpQuery struct {
+	field           *mappedField
+	expression      *regexpNode
+	caseInsensitive bool
+	boost           float64
+}
+
+func (query *regexpQuery) evaluate(document Document) (bool, float64) {
+	for _, documentValue := range query.field.documentTerms(document) {
+		value, ok := documentValue.(string)
+		if ok && query.expression.matches(value, query.caseInsensitive) {
+			return true, query.boost
+		}
+	}
+
+	return false, 0
+}
+
+// fuzziness is the edit distance allowed by a fuzzy query, fixed or, for AUTO, growing with the length of
+// the term: none below low characters, one below high characters and two from there.
+type fuzziness struct {
+	auto  bool
+	low   int
+	high  int
+	edits int
+}
+
+func parseFuzziness(value interface{}) (fuzziness, error) {
+	text := strings.ToUpper(strings.TrimSpace(fmt.Sprint(value)))
+	switch {
+	case value == nil || text == "AUTO":
+		return fuzziness{auto: true, low: 3, high: 6}, nil
+	case strings.HasPrefix(text, "AUTO:"):
+		bounds := strings.Split(strings.TrimPrefix(text, "AUTO:"), ",")
+		if len(bounds) == 2 {
+			low, lowErr := strconv.Atoi(bounds[0])
+			high, highErr := strconv.Atoi(bounds[1])
+			if lowErr == nil && highErr == nil && low <= high {
+				return fuzziness{auto: true, low: low, high: high}, nil
+			}
+		}
+	default:
+		if edits, isNumber := toFloat(value); isNumber && edits >= 0 {
+			return fuzziness{edits: int(math.Min(2, edits))}, nil
+		}
+	}
+
+	return fuzziness{}, newParsingError("failed to parse [%v] as fuzziness", value)
+}
+
+// maxEdits returns the edit distance allowed for a term.
+func (fuzziness fuzziness) maxEdits(term string) int {
+	if !fuzziness.auto {
+		return fuzziness.edits
+	}
+
+	length := len([]rune(term))
+	switch {
+	case length < fuzziness.low:
+		return 0
+	case length < fuzziness.high:
+		return 1
+	}
+
+	return 2
+}
+
+// fuzzyMatcher finds the terms within the edit distance of a term that share its first prefixLength
+// characters. Swapping two adjacent characters is a single edit with transpositions.
+type fuzzyMatcher struct {
+	fuzziness      fuzziness
+	prefixLength   int
+	transpositions bool
+}
+
+func newFuzzyMatcher(fuzzinessOption interface{}, prefixLengthOption interface{}, transpositionsOption interface{}) (*fuzzyMatcher, error) {
+	fuzziness, err := parseFuzziness(fuzzinessOption)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := &fuzzyMatcher{fuzziness: fuzziness, transpositions: true}
+	if prefixLength, isNumber := toFloat(prefixLengthOption); isNumber {
+		matcher.prefixLength = int(prefixLength)
+	}
+	if transpositions, isBool := toBool(transpositionsOption); isBool {
+		matcher.transpositions = transpositions
+	}
+
+	return matcher, nil
+}
+
+// similarity reports whether the candidate is close enough to the term, and how similar they are, from 1
+// for the same term down to 0, the way Lucene boosts the terms a fuzzy query expands to.
+func (matcher *fuzzyMatcher) similarity(term string, candidate string) (bool, float64) {
+	termRunes, candidateRunes := []rune(term), []rune(candidate)
+	prefixLength := matcher.prefixLength
+	if prefixLength > len(termRunes) {
+		prefixLength = len(termRunes)
+	}
+	if len(candidateRunes) < prefixLength || string(candidateRunes[:prefixLength]) != string(termRunes[:prefixLength]) {
+		return false, 0
+	}
+
+	maxEdits := matcher.fuzziness.maxEdits(term)
+	distance := editDistance(termRunes[prefixLength:], candidateRunes[prefixLength:], matcher.transpositions)
+	if distance > maxEdits {
+		return false, 0
+	}
+
+	shortest := len(termRunes)
+	if len(candidateRunes) < shortest {
+		shortest = len(candidateRunes)
+	}
+	if shortest == 0 {
+		return true, 1
+	}
+
+	return true, math.Max(0, 1-float64(distance)/float64(shortest))
+}
+
+// editDistance returns the Levenshtein distance between two terms, or the optimal string alignment
+// distance, which also counts swapping two adjacent characters as one edit, with transpositions.
+func editDistance(left []rune, right []rune, transpositions bool) int {
+	previousRow := make([]int, len(right)+1)
+	row := make([]int, len(right)+1)
+	beforePreviousRow := make([]int, len(right)+1)
+	for column := range row {
+		row[column] = column
+	}
+
+	for line := 1; line <= len(left); line++ {
+		beforePreviousRow, previousRow, row = previousRow, row, beforePreviousRow
+		row[0] = line
+		for column := 1; column <= len(right); column++ {
+			cost := 1
+			if left[line-1] == right[column-1] {
+				cost = 0
+			}
+			row[column] = minInt(previousRow[column]+1, row[column-1]+1, previousRow[column-1]+cost)
+			if transpositions && line > 1 && column > 1 && left[line-1] == right[column-2] && left[line-2] == right[column-1] {
+				row[column] = minInt(row[column], beforePreviousRow[column-2]+1)
+			}
+		}
+	}
+
+	return row[len(right)]
+}
+
+func minInt(first int, others ...int) int {
+	minimum := first
+	for _, other := range others {
+		if other < minimum {
+			minimum = other
+		}
+	}
+
+	return minimum
+}
+
+// fuzzyQuery matches the documents with a term of the field close to its value. Terms of text fields are
+// scored with BM25 scaled by their similarity to the value, and their scores added, other fields with the
+// boost.
+type fuzzyQuery struct {
+	field   *mappedField
+	index   *invertedIndex
+	value   string
+	matcher *fuzzyMatcher
+	boost   float64
+}
+
+func (query *fuzzyQuery) evaluate(document Document) (bool, float64) {
+	scored := query.index != nil && textFieldTypes[query.field.fieldType]
+	matched, score := false, 0.0
+	seen := make(map[string]bool)
+	for _, documentValue := range query.field.matchTerms(document) {
+		term, ok := documentValue.(string)
+		if !ok || seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		similar, similarity := query.matcher.similarity(query.value, term)
+		if !similar {
+			continue
+		}
+		matched = true
+		if scored {
+			score += similarity * query.index.score(document.Id, query.field.name, term)
+		}
+	}
+	if !matched {
+		return false, 0
+	}
+	if !scored {
+		score = 1
+	}
+
+	return true, query.boost * score
+}
+
+// termsSetQuery matches the documents holding a minimum number of its terms, read from a field of the
+// document, computed by a script with the num_terms param, or given by minimum_should_match.
+type termsSetQuery struct {
+	field              *mappedField
+	values             []interface{}
+	minimumField       *mappedField
+	minimumScript      scriptExpression
+	scriptParams       map[string]interface{}
+	scriptFields       []*mappedField
+	minimumShouldMatch interface{}
+	boost              float64
+}
+
+func (query *termsSetQuery) evaluate(document Document) (bool, float64) {
+	matched := 0
+	documentTerms := query.field.documentTerms(document)
+	for _, value := range query.values {
+		for _, documentValue := range documentTerms {
+			if comparison, comparable := compareValues(documentValue, value); comparable && comparison == 0 {
+				matched++
+				break
+			}
+		}
+	}
+
+	required, known := query.required(document)
+	if !known || matched == 0 || matched < required {
+		return false, 0
+	}
+
+	return true, query.boost
+}
+
+// required returns the number of terms a document must hold, unknown when its field has no value.
+func (query *termsSetQuery) required(document Document) (int, bool) {
+	switch {
+	case query.minimumField != nil:
+		for _, value := range query.minimumField.documentTerms(document) {
+			if number, isNumber := toFloat(value); isNumber {
+				return int(number), true
+			}
+		}
+		return 0, false
+	case query.minimumScript != nil:
+		params := make(map[string]interface{}, len(query.scriptParams)+2)
+		for name, value := range query.scriptParams {
+			params[name] = value
+		}
+		params["num_terms"] = float64(len(query.values))
+		docValues := make(map[string]interface{}, len(query.scriptFields))
+		for _, field := range query.scriptFields {
+			values := field.documentTerms(document)
+			docValue := map[string]interface{}{"size": float64(len(values))}
+			if len(values) > 0 {
+				docValue["value"] = values[0]
+			}
+			docValues[field.name] = docValue
+		}
+		params["doc"] = docValues
+
+		value, err := query.minimumScript(params)
+		number, isNumber := toFloat(value)
+		if err != nil || !isNumber {
+			return 0, false
+		}
+		return int(number), true
+	}
+
+	return minimumShouldMatch(query.minimumShouldMatch, len(query.values)), true
+}
@@ -0,0 +1,454 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// scriptExpression is a parsed Painless expression, the small subset used by bucket_selector and similar
+// scripts: numbers, strings, booleans, params, doc values, arithmetic, comparisons, logical operators and
+// Math.min and Math.max. Doc values are read from the "doc" param, a map of field names to their values.
+type scriptExpression func(params map[string]interface{}) (interface{}, error)
+
+func newScriptError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "script_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// parseScriptSource reads the script of a request, a string or an object with the source and the params.
+func parseScriptSource(script interface{}) (string, map[string]interface{}, error) {
+	switch typedScript := script.(type) {
+	case string:
+		return typedScript, map[string]interface{}{}, nil
+	case map[string]interface{}:
+		source, _ := typedScript["source"].(string)
+		if source == "" {
+			return "", nil, newParsingError("must specify either [source] for an inline script or [id] for a stored script")
+		}
+		if lang, _ := typedScript["lang"].(string); lang != "" && lang != "painless" && lang != "expression" {
+			return "", nil, newAggregationError("script_lang not supported [%s]", lang)
+		}
+		params, _ := typedScript["params"].(map[string]interface{})
+		if params == nil {
+			params = map[string]interface{}{}
+		}
+		return source, params, nil
+	default:
+		return "", nil, newParsingError("[script] must be a string or an object")
+	}
+}
+
+func parseScriptExpression(source string) (scriptExpression, error) {
+	tokens, err := tokenizeScript(source)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &scriptParser{source: source, tokens: tokens}
+	expression, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, newScriptError("compile error: unexpected token [%s] in [%s]", parser.tokens[parser.position], source)
+	}
+
+	return expression, nil
+}
+
+func tokenizeScript(source string) ([]string, error) {
+	source = strings.TrimSuffix(strings.TrimSpace(source), ";")
+	if strings.HasPrefix(source, "return ") {
+		source = strings.TrimPrefix(source, "return ")
+	}
+
+	tokens := make([]string, 0)
+	for position := 0; position < len(source); {
+		character := rune(source[position])
+		switch {
+		case unicode.IsSpace(character):
+			position++
+		case unicode.IsDigit(character):
+			end := position
+			for end < len(source) && (unicode.IsDigit(rune(source[end])) || source[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, source[position:end])
+			position = end
+		case unicode.IsLetter(character) || character == '_':
+			end := position
+			for end < len(source) && (unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end])) || source[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, source[position:end])
+			position = end
+		case character == '\'' || character == '"':
+			end := strings.IndexByte(source[position+1:], source[position])
+			if end < 0 {
+				return nil, newScriptError("compile error: unterminated string in [%s]", source)
+			}
+			tokens = append(tokens, source[position:position+end+2])
+			position += end + 2
+		default:
+			operator := source[position : position+1]
+			if position+1 < len(source) {
+				switch twoCharacters := source[position : position+2]; twoCharacters {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					operator = twoCharacters
+				}
+			}
+			if !containsString(scriptOperators, operator) {
+				return nil, newScriptError("compile error: unexpected character [%s] in [%s]", operator, source)
+			}
+			tokens = append(tokens, operator)
+			position += len(operator)
+		}
+	}
+
+	return tokens, nil
+}
+
+var scriptOperators = []string{"+", "-", "*", "/", "%", "<", ">", "!", "(", ")", "[", "]", ".", ",", "==", "!=", "<=", ">=", "&&", "||"}
+
+type scriptParser struct {
+	source   string
+	tokens   []string
+	position int
+}
+
+func (parser *scriptParser) peek() string {
+	if parser.position < len(parser.tokens) {
+		return parser.tokens[parser.position]
+	}
+
+	return ""
+}
+
+func (parser *scriptParser) next() string {
+	token := parser.peek()
+	parser.position++
+
+	return token
+}
+
+func (parser *scriptParser) parseBinary(operators []string, operand func() (scriptExpression, error),
+	apply func(operator string, left interface{}, right interface{}) (interface{}, error)) (scriptExpression, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := parser.peek()
+		if !containsString(operators, operator) {
+			return left, nil
+		}
+		parser.next()
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		leftExpression := left
+		left = func(params map[string]interface{}) (interface{}, error) {
+			leftValue, err := leftExpression(params)
+			if err != nil {
+				return nil, err
+			}
+			rightValue, err := right(params)
+			if err != nil {
+				return nil, err
+			}
+			return apply(operator, leftValue, rightValue)
+		}
+	}
+}
+
+func (parser *scriptParser) parseOr() (scriptExpression, error) {
+	return parser.parseBinary([]string{"||"}, parser.parseAnd, applyLogical)
+}
+
+func (parser *scriptParser) parseAnd() (scriptExpression, error) {
+	return parser.parseBinary([]string{"&&"}, parser.parseEquality, applyLogical)
+}
+
+func (parser *scriptParser) parseEquality() (scriptExpression, error) {
+	return parser.parseBinary([]string{"==", "!="}, parser.parseComparison, applyComparison)
+}
+
+func (parser *scriptParser) parseComparison() (scriptExpression, error) {
+	return parser.parseBinary([]string{"<", "<=", ">", ">="}, parser.parseAdditive, applyComparison)
+}
+
+func (parser *scriptParser) parseAdditive() (scriptExpression, error) {
+	return parser.parseBinary([]string{"+", "-"}, parser.parseMultiplicative, applyArithmetic)
+}
+
+func (parser *scriptParser) parseMultiplicative() (scriptExpression, error) {
+	return parser.parseBinary([]string{"*", "/", "%"}, parser.parseUnary, applyArithmetic)
+}
+
+func (parser *scriptParser) parseUnary() (scriptExpression, error) {
+	switch parser.peek() {
+	case "!":
+		parser.next()
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(params map[string]interface{}) (interface{}, error) {
+			value, err := operand(params)
+			if err != nil {
+				return nil, err
+			}
+			boolValue, isBool := value.(bool)
+			if !isBool {
+				return nil, newScriptError("runtime error: cannot apply [!] to [%v]", value)
+			}
+			return !boolValue, nil
+		}, nil
+	case "-":
+		parser.next()
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(params map[string]interface{}) (interface{}, error) {
+			value, err := operand(params)
+			if err != nil {
+				return nil, err
+			}
+			return applyArithmetic("-", 0.0, value)
+		}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *scriptParser) parsePrimary() (scriptExpression, error) {
+	token := parser.next()
+	switch {
+	case token == "":
+		return nil, newScriptError("compile error: unexpected end of script [%s]", parser.source)
+	case token == "(":
+		expression, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, newScriptError("compile error: missing [)] in [%s]", parser.source)
+		}
+		return expression, nil
+	case token == "true" || token == "false":
+		value := token == "true"
+		return func(params map[string]interface{}) (interface{}, error) { return value, nil }, nil
+	case token == "null":
+		return func(params map[string]interface{}) (interface{}, error) { return nil, nil }, nil
+	case token[0] == '\'' || token[0] == '"':
+		value := token[1 : len(token)-1]
+		return func(params map[string]interface{}) (interface{}, error) { return value, nil }, nil
+	case unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, newScriptError("compile error: invalid number [%s] in [%s]", token, parser.source)
+		}
+		return func(params map[string]interface{}) (interface{}, error) { return value, nil }, nil
+	case token == "params":
+		return parser.parseParamsAccess("")
+	case token == "doc":
+		return parser.parseParamsAccess("doc")
+	case token == "Math":
+		return parser.parseMathCall()
+	default:
+		return nil, newScriptError("compile error: cannot resolve symbol [%s] in [%s]", token, parser.source)
+	}
+}
+
+// parseParamsAccess reads params.name, params['name'] and nested accesses to them, or the same accesses to
+// a param, like doc['field'].value.
+func (parser *scriptParser) parseParamsAccess(param string) (scriptExpression, error) {
+	path := make([]string, 0)
+	for {
+		switch parser.peek() {
+		case ".":
+			parser.next()
+			path = append(path, parser.next())
+			continue
+		case "[":
+			parser.next()
+			key := parser.next()
+			if parser.next() != "]" || len(key) < 2 {
+				return nil, newScriptError("compile error: invalid params access in [%s]", parser.source)
+			}
+			path = append(path, key[1:len(key)-1])
+			continue
+		}
+		break
+	}
+
+	return func(params map[string]interface{}) (interface{}, error) {
+		var value interface{} = params
+		if param != "" {
+			value = params[param]
+		}
+		for _, key := range path {
+			object, isObject := value.(map[string]interface{})
+			if !isObject {
+				return nil, newScriptError("runtime error: cannot access [%s] of [%v]", key, value)
+			}
+			value = object[key]
+		}
+		return value, nil
+	}, nil
+}
+
+// parseMathCall reads Math.min(a, b) and Math.max(a, b).
+func (parser *scriptParser) parseMathCall() (scriptExpression, error) {
+	if parser.next() != "." {
+		return nil, newScriptError("compile error: invalid Math access in [%s]", parser.source)
+	}
+	function := parser.next()
+	if function != "min" && function != "max" {
+		return nil, newScriptError("compile error: unknown call [%s] in [%s]", function, parser.source)
+	}
+	if parser.next() != "(" {
+		return nil, newScriptError("compile error: missing [(] in [%s]", parser.source)
+	}
+
+	arguments := make([]scriptExpression, 0, 2)
+	for {
+		argument, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+		if separator := parser.next(); separator == ")" {
+			break
+		} else if separator != "," {
+			return nil, newScriptError("compile error: missing [)] in [%s]", parser.source)
+		}
+	}
+	if len(arguments) != 2 {
+		return nil, newScriptError("compile error: Math.%s requires 2 arguments in [%s]", function, parser.source)
+	}
+
+	return func(params map[string]interface{}) (interface{}, error) {
+		values := make([]float64, 0, 2)
+		for _, argument := range arguments {
+			value, err := argument(params)
+			if err != nil {
+				return nil, err
+			}
+			number, isNumber := toFloat(value)
+			if !isNumber {
+				return nil, newScriptError("runtime error: cannot apply Math.%s to [%v]", function, value)
+			}
+			values = append(values, number)
+		}
+		if function == "min" {
+			return math.Min(values[0], values[1]), nil
+		}
+		return math.Max(values[0], values[1]), nil
+	}, nil
+}
+
+func applyLogical(operator string, left interface{}, right interface{}) (interface{}, error) {
+	leftBool, leftIsBool := left.(bool)
+	rightBool, rightIsBool := right.(bool)
+	if !leftIsBool || !rightIsBool {
+		return nil, newScriptError("runtime error: cannot apply [%s] to [%v] and [%v]", operator, left, right)
+	}
+
+	if operator == "&&" {
+		return leftBool && rightBool, nil
+	}
+
+	return leftBool || rightBool, nil
+}
+
+func applyComparison(operator string, left interface{}, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		switch operator {
+		case "==":
+			return left == right, nil
+		case "!=":
+			return left != right, nil
+		}
+		return nil, newScriptError("runtime error: cannot apply [%s] to [%v] and [%v]", operator, left, right)
+	}
+
+	comparison, comparable := compareValues(left, right)
+	if !comparable {
+		return nil, newScriptError("runtime error: cannot apply [%s] to [%v] and [%v]", operator, left, right)
+	}
+
+	switch operator {
+	case "==":
+		return comparison == 0, nil
+	case "!=":
+		return comparison != 0, nil
+	case "<":
+		return comparison < 0, nil
+	case "<=":
+		return comparison <= 0, nil
+	case ">":
+		return comparison > 0, nil
+	default:
+		return comparison >= 0, nil
+	}
+}
+
+func applyArithmetic(operator string, left interface{}, right interface{}) (interface{}, error) {
+	leftString, leftIsString := left.(string)
+	rightString, rightIsString := right.(string)
+	if operator == "+" && (leftIsString || rightIsString) {
+		if !leftIsString {
+			leftString = fmt.Sprint(left)
+		}
+		if !rightIsString {
+			rightString = fmt.Sprint(right)
+		}
+		return leftString + rightString, nil
+	}
+
+	leftNumber, leftIsNumber := toFloat(left)
+	rightNumber, rightIsNumber := toFloat(right)
+	if !leftIsNumber || !rightIsNumber || leftIsString || rightIsString {
+		return nil, newScriptError("runtime error: cannot apply [%s] to [%v] and [%v]", operator, left, right)
+	}
+
+	switch operator {
+	case "+":
+		return leftNumber + rightNumber, nil
+	case "-":
+		return leftNumber - rightNumber, nil
+	case "*":
+		return leftNumber * rightNumber, nil
+	case "/":
+		if rightNumber == 0 {
+			return nil, newScriptError("runtime error: / by zero")
+		}
+		return leftNumber / rightNumber, nil
+	default:
+		if rightNumber == 0 {
+			return nil, newScriptError("runtime error: / by zero")
+		}
+		return float64(int64(leftNumber) % int64(rightNumber)), nil
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,319 @@
+package elasticfacker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type mustacheNodeKind int
+
+const (
+	mustacheText mustacheNodeKind = iota
+	mustacheVariable
+	mustacheUnescaped
+	mustacheSection
+	mustacheInverted
+)
+
+// mustacheNode is a part of a parsed mustache template: text, a variable, escaped or not, or a section
+// with the nodes it holds. Sections are rendered for each item of a list, once for any other truthy value,
+// and inverted sections only for missing and falsy values.
+type mustacheNode struct {
+	kind      mustacheNodeKind
+	text      string
+	delimiter string
+	nodes     []*mustacheNode
+}
+
+func newMustacheError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "mustache_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// parseMustache parses a template into its nodes. Besides variables and sections, the toJson, join and url
+// sections of Elasticsearch are functions of their content: the JSON of the value it names, the values of
+// the list it names joined with a delimiter, and the content URL encoded.
+func parseMustache(template string) ([]*mustacheNode, error) {
+	root := &mustacheNode{kind: mustacheSection}
+	stack := []*mustacheNode{root}
+	line := 1
+	for len(template) > 0 {
+		open := strings.Index(template, "{{")
+		if open < 0 {
+			open = len(template)
+		}
+		if open > 0 {
+			parent := stack[len(stack)-1]
+			parent.nodes = append(parent.nodes, &mustacheNode{kind: mustacheText, text: template[:open]})
+			line += strings.Count(template[:open], "\n")
+			template = template[open:]
+			continue
+		}
+
+		closer, contentStart := "}}", 2
+		if strings.HasPrefix(template, "{{{") {
+			closer, contentStart = "}}}", 3
+		}
+		end := strings.Index(template[contentStart:], closer)
+		if end < 0 {
+			return nil, newMustacheError("Improperly closed variable in query-template:%d", line)
+		}
+		tag := strings.TrimSpace(template[contentStart : contentStart+end])
+		line += strings.Count(template[:contentStart+end+len(closer)], "\n")
+		template = template[contentStart+end+len(closer):]
+
+		parent := stack[len(stack)-1]
+		switch {
+		case closer == "}}}":
+			parent.nodes = append(parent.nodes, &mustacheNode{kind: mustacheUnescaped, text: tag})
+		case strings.HasPrefix(tag, "!"):
+		case strings.HasPrefix(tag, "&"):
+			parent.nodes = append(parent.nodes, &mustacheNode{kind: mustacheUnescaped, text: strings.TrimSpace(tag[1:])})
+		case strings.HasPrefix(tag, "#"), strings.HasPrefix(tag, "^"):
+			kind := mustacheSection
+			if tag[0] == '^' {
+				kind = mustacheInverted
+			}
+			name, delimiter := mustacheSectionName(tag[1:])
+			section := &mustacheNode{kind: kind, text: name, delimiter: delimiter}
+			parent.nodes = append(parent.nodes, section)
+			stack = append(stack, section)
+		case strings.HasPrefix(tag, "/"):
+			name, _ := mustacheSectionName(tag[1:])
+			if len(stack) == 1 {
+				return nil, newMustacheError("Closing tag with no open tag [%s] in query-template:%d", name, line)
+			}
+			if name != parent.text {
+				return nil, newMustacheError("Mismatched start/end tags: %s != %s in query-template:%d", parent.text, name, line)
+			}
+			stack = stack[:len(stack)-1]
+		case tag == "":
+			return nil, newMustacheError("Empty mustache in query-template:%d", line)
+		default:
+			parent.nodes = append(parent.nodes, &mustacheNode{kind: mustacheVariable, text: tag})
+		}
+	}
+	if len(stack) > 1 {
+		return nil, newMustacheError("Failed to close '%s' tag in query-template:%d", stack[len(stack)-1].text, line)
+	}
+
+	return root.nodes, nil
+}
+
+// mustacheSectionName splits the name of a section tag from its arguments, the delimiter of a join like
+// {{#join delimiter='||'}}.
+func mustacheSectionName(tag string) (string, string) {
+	tag = strings.TrimSpace(tag)
+	name, arguments, _ := strings.Cut(tag, " ")
+	if name != "join" {
+		return tag, ""
+	}
+
+	delimiter := ","
+	if value, found := strings.CutPrefix(strings.TrimSpace(arguments), "delimiter="); found {
+		delimiter = strings.Trim(value, "'\"")
+	}
+
+	return name, delimiter
+}
+
+// renderMustache renders the nodes with the values of the context, the innermost value last. Names are
+// looked up from the innermost value out, dots go down into objects and lists.
+func renderMustache(nodes []*mustacheNode, context []interface{}, builder *strings.Builder) {
+	for _, node := range nodes {
+		switch node.kind {
+		case mustacheText:
+			builder.WriteString(node.text)
+		case mustacheVariable:
+			value, _ := mustacheLookup(context, node.text)
+			builder.WriteString(escapeJSONString(mustacheValueText(value)))
+		case mustacheUnescaped:
+			value, _ := mustacheLookup(context, node.text)
+			builder.WriteString(mustacheValueText(value))
+		case mustacheInverted:
+			value, _ := mustacheLookup(context, node.text)
+			if !mustacheTruthy(value) {
+				renderMustache(node.nodes, context, builder)
+			}
+		case mustacheSection:
+			renderMustacheSection(node, context, builder)
+		}
+	}
+}
+
+func renderMustacheSection(node *mustacheNode, context []interface{}, builder *strings.Builder) {
+	switch node.text {
+	case "toJson", "join", "url":
+		var content strings.Builder
+		renderMustache(node.nodes, context, &content)
+		builder.WriteString(mustacheFunction(node, context, content.String()))
+		return
+	}
+
+	value, _ := mustacheLookup(context, node.text)
+	if !mustacheTruthy(value) {
+		return
+	}
+	if items, isList := value.([]interface{}); isList {
+		for _, item := range items {
+			renderMustache(node.nodes, append(context, item), builder)
+		}
+		return
+	}
+	renderMustache(node.nodes, append(context, value), builder)
+}
+
+func mustacheFunction(node *mustacheNode, context []interface{}, content string) string {
+	if node.text == "url" {
+		return url.QueryEscape(content)
+	}
+
+	value, found := mustacheLookup(context, strings.TrimSpace(content))
+	if !found || value == nil {
+		return ""
+	}
+	if node.text == "toJson" {
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(value)
+		return strings.TrimSuffix(buffer.String(), "\n")
+	}
+
+	items, isList := value.([]interface{})
+	if !isList {
+		return escapeJSONString(mustacheValueText(value))
+	}
+	texts := make([]string, 0, len(items))
+	for _, item := range items {
+		texts = append(texts, mustacheValueText(item))
+	}
+
+	return escapeJSONString(strings.Join(texts, node.delimiter))
+}
+
+func mustacheLookup(context []interface{}, name string) (interface{}, bool) {
+	if name == "." {
+		return context[len(context)-1], true
+	}
+
+	path := strings.Split(name, ".")
+	for position := len(context) - 1; position >= 0; position-- {
+		value, found := mustacheChild(context[position], path[0])
+		if !found {
+			continue
+		}
+		for _, key := range path[1:] {
+			value, found = mustacheChild(value, key)
+			if !found {
+				return nil, false
+			}
+		}
+		return value, true
+	}
+
+	return nil, false
+}
+
+func mustacheChild(value interface{}, key string) (interface{}, bool) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		child, found := typedValue[key]
+		return child, found
+	case []interface{}:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(typedValue) {
+			return nil, false
+		}
+		return typedValue[index], true
+	}
+
+	return nil, false
+}
+
+// mustacheTruthy reports whether a section renders for the value: missing values, null, false, empty
+// strings and empty lists do not.
+func mustacheTruthy(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typedValue
+	case string:
+		return typedValue != ""
+	case []interface{}:
+		return len(typedValue) > 0
+	}
+
+	return true
+}
+
+// mustacheValueText returns the text a value renders to, lists and objects the way Java prints them.
+func mustacheValueText(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case []interface{}:
+		texts := make([]string, 0, len(typedValue))
+		for _, item := range typedValue {
+			texts = append(texts, mustacheValueText(item))
+		}
+		return "[" + strings.Join(texts, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typedValue))
+		for key := range typedValue {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		texts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			texts = append(texts, key+"="+mustacheValueText(typedValue[key]))
+		}
+		return "{" + strings.Join(texts, ", ") + "}"
+	}
+
+	return fmt.Sprint(value)
+}
+
+// escapeJSONString escapes a text to be written inside a JSON string, the escaping of templates rendering
+// JSON.
+func escapeJSONString(text string) string {
+	var builder strings.Builder
+	for _, character := range text {
+		switch character {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\f':
+			builder.WriteString(`\f`)
+		default:
+			if character < 0x20 {
+				builder.WriteString(fmt.Sprintf(`\u%04x`, character))
+				continue
+			}
+			builder.WriteRune(character)
+		}
+	}
+
+	return builder.String()
+}
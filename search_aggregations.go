@@ -0,0 +1,1093 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxBuckets = 65536
+
+// aggregation is a parsed aggregation of a search request, it is computed over the hits of the search or of
+// the bucket it is nested in.
+type aggregation interface {
+	aggregate(hits []searchHit) (map[string]interface{}, error)
+}
+
+// aggregations are the named aggregations of a request or the sub-aggregations of a bucket aggregation.
+type aggregations map[string]aggregation
+
+func (aggs aggregations) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	results := make(map[string]interface{}, len(aggs))
+	for name, agg := range aggs {
+		if _, isPipeline := agg.(pipelineAggregation); isPipeline {
+			continue
+		}
+
+		result, err := agg.aggregate(hits)
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+
+	return results, nil
+}
+
+// bucket returns a bucket with the key and the document count, and the sub-aggregations computed over its hits.
+func (aggs aggregations) bucket(key interface{}, hits []searchHit) (map[string]interface{}, error) {
+	bucket, err := aggs.aggregate(hits)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		bucket["key"] = key
+	}
+	bucket["doc_count"] = len(hits)
+
+	return bucket, nil
+}
+
+// metaAggregation returns the meta object of the aggregation definition along with its result.
+type metaAggregation struct {
+	aggregation
+	meta map[string]interface{}
+}
+
+func (agg *metaAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	result, err := agg.aggregation.aggregate(hits)
+	if err != nil {
+		return nil, err
+	}
+	result["meta"] = agg.meta
+
+	return result, nil
+}
+
+func newAggregationError(format string, args ...interface{}) *queryError {
+	return &queryError{
+		errorType: "illegal_argument_exception",
+		reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+// aggregationParser parses aggregation trees, documents are the ones the search runs over, which global
+// aggregations use, and depth is how deep in the tree the aggregations being parsed are.
+type aggregationParser struct {
+	es        *InMemoryElasticsearch
+	indexName string
+	documents []Document
+	depth     int
+}
+
+// parseAggregations parses the aggs, or aggregations, object of a search request.
+func (es *InMemoryElasticsearch) parseAggregations(indexName string, documents []Document, definitions map[string]interface{}) (aggregations, error) {
+	parser := &aggregationParser{
+		es:        es,
+		indexName: indexName,
+		documents: documents,
+	}
+
+	return parser.parseAggregations(definitions)
+}
+
+func (parser *aggregationParser) parseAggregations(definitions map[string]interface{}) (aggregations, error) {
+	aggs := make(aggregations, len(definitions))
+	for name, definition := range definitions {
+		definitionObject, ok := definition.(map[string]interface{})
+		if !ok {
+			return nil, newParsingError("Expected [START_OBJECT] under [%s], but got a [VALUE] in [%s]", name, name)
+		}
+
+		var aggregationType string
+		var aggregationBody interface{}
+		var subDefinitions map[string]interface{}
+		var meta map[string]interface{}
+		for key, value := range definitionObject {
+			switch key {
+			case "aggs", "aggregations":
+				subDefinitions, ok = value.(map[string]interface{})
+				if !ok {
+					return nil, newParsingError("Expected [START_OBJECT] under [%s], but got a [VALUE] in [%s]", key, name)
+				}
+			case "meta":
+				meta, ok = value.(map[string]interface{})
+				if !ok {
+					return nil, newParsingError("Expected [START_OBJECT] under [meta], but got a [VALUE] in [%s]", name)
+				}
+			default:
+				if aggregationType != "" {
+					return nil, newParsingError("Found two aggregation type definitions in [%s]: [%s] and [%s]", name, aggregationType, key)
+				}
+				aggregationType = key
+				aggregationBody = value
+			}
+		}
+		if aggregationType == "" {
+			return nil, newParsingError("Missing definition for aggregation [%s]", name)
+		}
+
+		parser.depth++
+		subAggregations, err := parser.parseAggregations(subDefinitions)
+		parser.depth--
+		if err != nil {
+			return nil, err
+		}
+
+		aggs[name], err = parser.parseAggregation(name, aggregationType, aggregationBody, subAggregations)
+		if err != nil {
+			return nil, err
+		}
+		if _, isPipeline := aggs[name].(pipelineAggregation); meta != nil && !isPipeline {
+			aggs[name] = &metaAggregation{aggregation: aggs[name], meta: meta}
+		}
+	}
+
+	return aggs, nil
+}
+
+func (parser *aggregationParser) parseAggregation(name string, aggregationType string, body interface{}, subAggregations aggregations) (aggregation, error) {
+	// The body of a filter aggregation is a query, that can be anything the query parser accepts.
+	if aggregationType == "filter" {
+		return parser.parseFilter(body, subAggregations)
+	}
+
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, newParsingError("[%s] aggregation body must be an object", aggregationType)
+	}
+
+	switch aggregationType {
+	case "bucket_sort", "bucket_selector", "cumulative_sum", "derivative":
+		if parser.depth == 0 {
+			return nil, newAggregationError("%s aggregation [%s] must be declared inside of another aggregation", aggregationType, name)
+		}
+	}
+
+	switch aggregationType {
+	case "terms":
+		return parser.parseTerms(options, subAggregations)
+	case "range":
+		return parser.parseRange(options, subAggregations)
+	case "histogram":
+		return parser.parseHistogram(options, subAggregations)
+	case "date_histogram":
+		return parser.parseDateHistogram(options, subAggregations)
+	case "avg", "sum", "min", "max":
+		return parser.parseSingleValueMetric(aggregationType, options)
+	case "value_count":
+		return parser.parseValueCount(options)
+	case "stats", "extended_stats":
+		return parser.parseStats(aggregationType, options)
+	case "percentiles":
+		return parser.parsePercentiles(options)
+	case "cardinality":
+		return parser.parseCardinality(options)
+	case "top_hits":
+		return parser.parseTopHits(options)
+	case "filters":
+		return parser.parseFilters(options, subAggregations)
+	case "global":
+		if parser.depth > 0 {
+			return nil, newAggregationError("Aggregation [%s] of type [global] cannot be a sub-aggregation", name)
+		}
+		return &globalAggregation{documents: parser.documents, subAggregations: subAggregations}, nil
+	case "missing":
+		return parser.parseMissing(options, subAggregations)
+	case "nested":
+		return parser.parseNested(name, options, subAggregations)
+	case "reverse_nested":
+		return parser.parseReverseNested(options, subAggregations)
+	case "bucket_sort":
+		return parser.parseBucketSort(options)
+	case "bucket_selector":
+		return parser.parseBucketSelector(options)
+	case "cumulative_sum", "derivative":
+		return parser.parseSequencePipeline(aggregationType, options)
+	default:
+		return nil, newParsingError("Unknown aggregation type [%s]", aggregationType)
+	}
+}
+
+func (parser *aggregationParser) parseTerms(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	field, err := parser.aggregatedField("terms", options)
+	if err != nil {
+		return nil, err
+	}
+	size, err := intOption("terms", options, "size", DefaultSearchSize)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, newAggregationError("[size] must be greater than 0. Found [%d] in [terms]", size)
+	}
+	minDocCount, err := intOption("terms", options, "min_doc_count", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	order := []bucketOrder{{path: "_count", descending: true}}
+	if options["order"] != nil {
+		order, err = parseBucketOrder("terms", options["order"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &termsAggregation{
+		field:           field,
+		size:            size,
+		minDocCount:     minDocCount,
+		missing:         options["missing"],
+		order:           order,
+		subAggregations: subAggregations,
+	}, nil
+}
+
+func (parser *aggregationParser) parseRange(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	field, err := parser.aggregatedField("range", options)
+	if err != nil {
+		return nil, err
+	}
+
+	rangeDefinitions, ok := options["ranges"].([]interface{})
+	if !ok || len(rangeDefinitions) == 0 {
+		return nil, newAggregationError("No [ranges] specified for the [range] aggregation")
+	}
+
+	ranges := make([]aggregationRange, 0, len(rangeDefinitions))
+	for _, rangeDefinition := range rangeDefinitions {
+		rangeObject, ok := rangeDefinition.(map[string]interface{})
+		if !ok {
+			return nil, newParsingError("[ranges] expects an array of objects")
+		}
+
+		var parsedRange aggregationRange
+		for _, bound := range []string{"from", "to"} {
+			value, exists := rangeObject[bound]
+			if !exists || value == nil {
+				continue
+			}
+			number, isNumber := toFloat(value)
+			if !isNumber {
+				return nil, newParsingError("[range] failed to parse field [%s] with value [%v]", bound, value)
+			}
+			if bound == "from" {
+				parsedRange.from = &number
+			} else {
+				parsedRange.to = &number
+			}
+		}
+
+		parsedRange.key, _ = rangeObject["key"].(string)
+		if parsedRange.key == "" {
+			parsedRange.key = rangeKey(parsedRange.from, parsedRange.to)
+		}
+		ranges = append(ranges, parsedRange)
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return compareRangeBound(ranges[i].from, ranges[j].from, math.Inf(-1)) < 0 ||
+			(compareRangeBound(ranges[i].from, ranges[j].from, math.Inf(-1)) == 0 &&
+				compareRangeBound(ranges[i].to, ranges[j].to, math.Inf(1)) < 0)
+	})
+
+	keyed, _ := options["keyed"].(bool)
+
+	return &rangeAggregation{
+		field:           field,
+		ranges:          ranges,
+		keyed:           keyed,
+		missing:         options["missing"],
+		subAggregations: subAggregations,
+	}, nil
+}
+
+func (parser *aggregationParser) parseHistogram(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	field, err := parser.aggregatedField("histogram", options)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, isNumber := toFloat(options["interval"])
+	if options["interval"] == nil {
+		return nil, newAggregationError("Required one of fields [interval], but none were specified.")
+	}
+	if !isNumber || interval <= 0 {
+		return nil, newAggregationError("[interval] must be >0 for histogram aggregation [%v]", options["interval"])
+	}
+
+	bucketing := histogramBucketing{interval: interval}
+	if options["offset"] != nil {
+		bucketing.offset, isNumber = toFloat(options["offset"])
+		if !isNumber {
+			return nil, newParsingError("[histogram] failed to parse field [offset] with value [%v]", options["offset"])
+		}
+	}
+
+	histogram, err := parseHistogramOptions("histogram", field, options, subAggregations, func(value interface{}) (float64, bool) {
+		return toFloat(value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	histogram.rounding = &bucketing
+
+	return histogram, nil
+}
+
+func (parser *aggregationParser) parseDateHistogram(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	field, err := parser.aggregatedField("date_histogram", options)
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.UTC
+	if timeZone, _ := options["time_zone"].(string); timeZone != "" {
+		location, err = parseTimeZone(timeZone)
+		if err != nil {
+			return nil, newAggregationError(err.Error())
+		}
+	}
+
+	format, _ := options["format"].(string)
+	keyFormat, err := newDateFormat(format)
+	if err != nil {
+		return nil, newAggregationError(err.Error())
+	}
+
+	rounding := &dateRounding{location: location}
+	calendarInterval, _ := options["calendar_interval"].(string)
+	fixedInterval, _ := options["fixed_interval"].(string)
+	switch {
+	case options["interval"] != nil:
+		return nil, newAggregationError("[interval] on [date_histogram] is no longer supported, use [fixed_interval] or [calendar_interval] instead")
+	case calendarInterval != "" && fixedInterval != "":
+		return nil, newAggregationError("Cannot use [fixed_interval] with [calendar_interval] configuration option.")
+	case calendarInterval != "":
+		rounding.calendarUnit = calendarUnits[calendarInterval]
+		if rounding.calendarUnit == "" {
+			return nil, newAggregationError("The supplied interval [%s] could not be parsed as a calendar interval.", calendarInterval)
+		}
+	case fixedInterval != "":
+		rounding.fixed, err = parseTimeValue("date_histogram.fixedInterval", fixedInterval)
+		if err != nil {
+			return nil, newAggregationError(err.Error())
+		}
+		if rounding.fixed <= 0 {
+			return nil, newAggregationError("Zero or negative time interval not supported")
+		}
+	default:
+		return nil, newAggregationError("Required one of fields [fixed_interval, calendar_interval], but none were specified.")
+	}
+
+	if offset, _ := options["offset"].(string); offset != "" {
+		negative := strings.HasPrefix(offset, "-")
+		rounding.offset, err = parseTimeValue("offset", strings.TrimLeft(offset, "+-"))
+		if err != nil {
+			return nil, newAggregationError(err.Error())
+		}
+		if negative {
+			rounding.offset = -rounding.offset
+		}
+	}
+
+	histogram, err := parseHistogramOptions("date_histogram", field, options, subAggregations, func(value interface{}) (float64, bool) {
+		date, err := keyFormat.parse(value, location)
+		if err != nil {
+			date, err = parseDate(value, location)
+		}
+		return float64(date.UnixMilli()), err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	histogram.rounding = rounding
+	histogram.keyFormat = keyFormat
+
+	return histogram, nil
+}
+
+func parseHistogramOptions(aggregationType string, field *mappedField, options map[string]interface{}, subAggregations aggregations,
+	toNumber func(value interface{}) (float64, bool)) (*histogramAggregation, error) {
+	minDocCount, err := intOption(aggregationType, options, "min_doc_count", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	order := []bucketOrder{{path: "_key"}}
+	if options["order"] != nil {
+		order, err = parseBucketOrder(aggregationType, options["order"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	histogram := &histogramAggregation{
+		field:           field,
+		minDocCount:     minDocCount,
+		order:           order,
+		toNumber:        toNumber,
+		subAggregations: subAggregations,
+	}
+	histogram.keyed, _ = options["keyed"].(bool)
+
+	if options["missing"] != nil {
+		missing, isNumber := toNumber(options["missing"])
+		if !isNumber {
+			return nil, newParsingError("[%s] failed to parse field [missing] with value [%v]", aggregationType, options["missing"])
+		}
+		histogram.missing = &missing
+	}
+
+	for _, boundsOption := range []string{"extended_bounds", "hard_bounds"} {
+		if options[boundsOption] == nil {
+			continue
+		}
+		boundsObject, ok := options[boundsOption].(map[string]interface{})
+		if !ok {
+			return nil, newParsingError("[%s] expects an object with min and max", boundsOption)
+		}
+
+		bounds := &histogramBounds{}
+		for _, bound := range []string{"min", "max"} {
+			if boundsObject[bound] == nil {
+				continue
+			}
+			number, isNumber := toNumber(boundsObject[bound])
+			if !isNumber {
+				return nil, newParsingError("[%s] failed to parse field [%s] with value [%v]", boundsOption, bound, boundsObject[bound])
+			}
+			if bound == "min" {
+				bounds.min = &number
+			} else {
+				bounds.max = &number
+			}
+		}
+
+		if bounds.min != nil && bounds.max != nil && *bounds.min > *bounds.max {
+			return nil, newAggregationError("[%s.min][%v] cannot be greater than [%s.max][%v].",
+				boundsOption, boundsObject["min"], boundsOption, boundsObject["max"])
+		}
+		if boundsOption == "extended_bounds" {
+			histogram.extendedBounds = bounds
+		} else {
+			histogram.hardBounds = bounds
+		}
+	}
+
+	return histogram, nil
+}
+
+func requiredField(aggregationType string, options map[string]interface{}) (string, error) {
+	field, _ := options["field"].(string)
+	if field == "" {
+		return "", newAggregationError("Required one of fields [field, script], but none were specified in [%s].", aggregationType)
+	}
+
+	return field, nil
+}
+
+// aggregatedField returns the field an aggregation reads its values from, as the mapping describes it, so
+// the values of the hits are the terms the field indexes. Text fields can not be aggregated.
+func (parser *aggregationParser) aggregatedField(aggregationType string, options map[string]interface{}) (*mappedField, error) {
+	name, err := requiredField(aggregationType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	field := parser.es.mappedField(parser.indexName, name)
+	err = field.aggregatable()
+	if err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+func intOption(aggregationType string, options map[string]interface{}, name string, defaultValue int) (int, error) {
+	value, exists := options[name]
+	if !exists || value == nil {
+		return defaultValue, nil
+	}
+
+	number, isNumber := toFloat(value)
+	if !isNumber || number != math.Trunc(number) {
+		return 0, newParsingError("[%s] failed to parse field [%s] with value [%v]", aggregationType, name, value)
+	}
+
+	return int(number), nil
+}
+
+// bucketOrder is an order of the buckets of a multi bucket aggregation, by _count, _key or the path
+// of a sub-aggregation value.
+type bucketOrder struct {
+	path       string
+	descending bool
+}
+
+func parseBucketOrder(aggregationType string, order interface{}) ([]bucketOrder, error) {
+	orderObjects, isArray := order.([]interface{})
+	if !isArray {
+		orderObjects = []interface{}{order}
+	}
+
+	orders := make([]bucketOrder, 0, len(orderObjects))
+	for _, orderObject := range orderObjects {
+		orderMap, ok := orderObject.(map[string]interface{})
+		if !ok || len(orderMap) != 1 {
+			return nil, newParsingError("[%s] order must be an object with a single path", aggregationType)
+		}
+
+		for path, direction := range orderMap {
+			directionString, _ := direction.(string)
+			switch strings.ToLower(directionString) {
+			case SortOrderAsc, SortOrderDesc:
+			default:
+				return nil, newParsingError("Unknown order direction [%v]", direction)
+			}
+			if path == "_term" {
+				path = "_key"
+			}
+			orders = append(orders, bucketOrder{path: path, descending: strings.EqualFold(directionString, SortOrderDesc)})
+		}
+	}
+
+	return orders, nil
+}
+
+// sortBuckets orders the buckets, ties are broken by the key in ascending order.
+func sortBuckets(buckets []map[string]interface{}, orders []bucketOrder) error {
+	orders = append(orders, bucketOrder{path: "_key"})
+	for _, order := range orders {
+		for _, bucket := range buckets {
+			if _, err := bucketPathValue(bucket, order.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	sort.SliceStable(buckets, func(i, j int) bool {
+		for _, order := range orders {
+			a, _ := bucketPathValue(buckets[i], order.path)
+			b, _ := bucketPathValue(buckets[j], order.path)
+			comparison, comparable := compareValues(a, b)
+			if !comparable || comparison == 0 {
+				continue
+			}
+			if order.descending {
+				return comparison > 0
+			}
+			return comparison < 0
+		}
+		return false
+	})
+
+	return nil
+}
+
+// bucketPathValue resolves a buckets path, like _count, _key, agg, agg.metric or agg>sub.metric, in a bucket.
+func bucketPathValue(bucket map[string]interface{}, path string) (interface{}, error) {
+	switch path {
+	case "_count":
+		return bucket["doc_count"], nil
+	case "_key":
+		return bucket["key"], nil
+	}
+
+	steps := strings.Split(path, ">")
+	current := bucket
+	for position, step := range steps {
+		name, metric, hasMetric := step, "", false
+		if position == len(steps)-1 {
+			if dot := strings.LastIndex(step, "."); dot >= 0 {
+				name, metric, hasMetric = step[:dot], step[dot+1:], true
+			}
+		}
+
+		result, exists := current[name].(map[string]interface{})
+		if !exists {
+			return nil, newAggregationError("Invalid aggregation order path [%s]. Unknown aggregation [%s]", path, name)
+		}
+
+		if position < len(steps)-1 {
+			current = result
+			continue
+		}
+
+		switch {
+		case hasMetric && metric == "_count":
+			return result["doc_count"], nil
+		case hasMetric:
+			value, exists := result[metric]
+			if !exists {
+				// Percentiles are keyed like 50.0 but can be referenced as 50.
+				if values, isObject := result["values"].(map[string]interface{}); isObject {
+					value, exists = values[metric]
+					if percent, isNumber := toFloat(metric); !exists && isNumber {
+						value, exists = values[formatDouble(percent)]
+					}
+				}
+			}
+			if !exists {
+				return nil, newAggregationError("Invalid aggregation order path [%s]. Unknown value key [%s] for aggregation [%s]", path, metric, name)
+			}
+			return value, nil
+		case hasKey(result, "value"):
+			return result["value"], nil
+		case hasKey(result, "doc_count"):
+			return result["doc_count"], nil
+		default:
+			return nil, newAggregationError("Invalid aggregation order path [%s]. A multi value aggregation requires a value key", path)
+		}
+	}
+
+	return nil, newAggregationError("Invalid aggregation order path [%s]", path)
+}
+
+func hasKey(object map[string]interface{}, key string) bool {
+	_, exists := object[key]
+	return exists
+}
+
+type termsAggregation struct {
+	field           *mappedField
+	size            int
+	minDocCount     int
+	missing         interface{}
+	order           []bucketOrder
+	subAggregations aggregations
+}
+
+func (agg *termsAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	keys := make([]interface{}, 0)
+	keyHits := make(map[interface{}][]searchHit)
+	for _, hit := range hits {
+		values := agg.field.docValues(hit.document)
+		if len(values) == 0 && agg.missing != nil {
+			values = []interface{}{agg.missing}
+		}
+
+		seen := make(map[interface{}]bool, len(values))
+		for _, value := range values {
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+
+			if _, exists := keyHits[value]; !exists {
+				keys = append(keys, value)
+			}
+			keyHits[value] = append(keyHits[value], hit)
+		}
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if len(keyHits[key]) < agg.minDocCount {
+			continue
+		}
+
+		bucket, err := agg.subAggregations.bucket(key, keyHits[key])
+		if err != nil {
+			return nil, err
+		}
+		if boolKey, isBool := key.(bool); isBool {
+			bucket["key"] = 0
+			if boolKey {
+				bucket["key"] = 1
+			}
+			bucket["key_as_string"] = strconv.FormatBool(boolKey)
+		}
+		if dateKey, isNumber := key.(float64); isNumber && agg.field.isDateField() {
+			bucket["key"] = int64(dateKey)
+			bucket["key_as_string"] = agg.field.format.format(time.UnixMilli(int64(dateKey)).UTC())
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	err := sortBuckets(buckets, agg.order)
+	if err != nil {
+		return nil, err
+	}
+
+	otherDocCount := 0
+	if len(buckets) > agg.size {
+		for _, bucket := range buckets[agg.size:] {
+			otherDocCount += bucket["doc_count"].(int)
+		}
+		buckets = buckets[:agg.size]
+	}
+
+	buckets, err = agg.subAggregations.reduceBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"doc_count_error_upper_bound": 0,
+		"sum_other_doc_count":         otherDocCount,
+		"buckets":                     buckets,
+	}, nil
+}
+
+type aggregationRange struct {
+	key  string
+	from *float64
+	to   *float64
+}
+
+func (agg aggregationRange) contains(value float64) bool {
+	return (agg.from == nil || value >= *agg.from) && (agg.to == nil || value < *agg.to)
+}
+
+// rangeKey builds the key of a range without one, like *-100.0 or 100.0-200.0.
+func rangeKey(from *float64, to *float64) string {
+	bound := func(value *float64) string {
+		if value == nil {
+			return "*"
+		}
+		return formatDouble(*value)
+	}
+
+	return bound(from) + "-" + bound(to)
+}
+
+func compareRangeBound(a *float64, b *float64, unbounded float64) int {
+	aValue, bValue := unbounded, unbounded
+	if a != nil {
+		aValue = *a
+	}
+	if b != nil {
+		bValue = *b
+	}
+
+	switch {
+	case aValue < bValue:
+		return -1
+	case aValue > bValue:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// formatDouble prints a number the way Java prints a double, 100 is printed as 100.0.
+func formatDouble(value float64) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if !strings.ContainsAny(text, ".eE") {
+		text += ".0"
+	}
+
+	return text
+}
+
+type rangeAggregation struct {
+	field           *mappedField
+	ranges          []aggregationRange
+	keyed           bool
+	missing         interface{}
+	subAggregations aggregations
+}
+
+func (agg *rangeAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	buckets := make([]map[string]interface{}, 0, len(agg.ranges))
+	for _, aggRange := range agg.ranges {
+		rangeHits := make([]searchHit, 0)
+		for _, hit := range hits {
+			values := agg.field.docValues(hit.document)
+			if len(values) == 0 && agg.missing != nil {
+				values = []interface{}{agg.missing}
+			}
+
+			for _, value := range values {
+				number, isNumber := toFloat(value)
+				if isNumber && aggRange.contains(number) {
+					rangeHits = append(rangeHits, hit)
+					break
+				}
+			}
+		}
+
+		bucket, err := agg.subAggregations.bucket(aggRange.key, rangeHits)
+		if err != nil {
+			return nil, err
+		}
+		if aggRange.from != nil {
+			bucket["from"] = *aggRange.from
+		}
+		if aggRange.to != nil {
+			bucket["to"] = *aggRange.to
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	buckets, err := agg.subAggregations.reduceBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	if agg.keyed {
+		keyedBuckets := make(map[string]interface{}, len(buckets))
+		for _, bucket := range buckets {
+			key := bucket["key"].(string)
+			delete(bucket, "key")
+			keyedBuckets[key] = bucket
+		}
+		return map[string]interface{}{"buckets": keyedBuckets}, nil
+	}
+
+	return map[string]interface{}{"buckets": buckets}, nil
+}
+
+// bucketRounding maps a value to the key of its bucket and gives the key of the bucket that follows.
+type bucketRounding interface {
+	round(value float64) float64
+	next(key float64) float64
+}
+
+type histogramBucketing struct {
+	interval float64
+	offset   float64
+}
+
+func (rounding *histogramBucketing) round(value float64) float64 {
+	return math.Floor((value-rounding.offset)/rounding.interval)*rounding.interval + rounding.offset
+}
+
+func (rounding *histogramBucketing) next(key float64) float64 {
+	return key + rounding.interval
+}
+
+var calendarUnits = map[string]string{
+	"minute": "minute", "1m": "minute",
+	"hour": "hour", "1h": "hour",
+	"day": "day", "1d": "day",
+	"week": "week", "1w": "week",
+	"month": "month", "1M": "month",
+	"quarter": "quarter", "1q": "quarter",
+	"year": "year", "1y": "year",
+}
+
+// dateRounding rounds epoch milliseconds down to a calendar unit or a fixed interval, in a time zone.
+type dateRounding struct {
+	calendarUnit string
+	fixed        time.Duration
+	offset       time.Duration
+	location     *time.Location
+}
+
+func (rounding *dateRounding) round(value float64) float64 {
+	date := time.UnixMilli(int64(value)).Add(-rounding.offset).In(rounding.location)
+
+	if rounding.calendarUnit == "" {
+		_, zoneOffset := date.Zone()
+		localMillis := date.UnixMilli() + int64(zoneOffset)*1000
+		interval := rounding.fixed.Milliseconds()
+		key := int64(math.Floor(float64(localMillis)/float64(interval)))*interval - int64(zoneOffset)*1000
+
+		return float64(key + rounding.offset.Milliseconds())
+	}
+
+	year, month, day := date.Date()
+	switch rounding.calendarUnit {
+	case "second":
+		date = time.Date(year, month, day, date.Hour(), date.Minute(), date.Second(), 0, rounding.location)
+	case "minute":
+		date = time.Date(year, month, day, date.Hour(), date.Minute(), 0, 0, rounding.location)
+	case "hour":
+		date = time.Date(year, month, day, date.Hour(), 0, 0, 0, rounding.location)
+	case "day":
+		date = time.Date(year, month, day, 0, 0, 0, 0, rounding.location)
+	case "week":
+		date = time.Date(year, month, day-(int(date.Weekday())+6)%7, 0, 0, 0, 0, rounding.location)
+	case "month":
+		date = time.Date(year, month, 1, 0, 0, 0, 0, rounding.location)
+	case "quarter":
+		date = time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, rounding.location)
+	case "year":
+		date = time.Date(year, time.January, 1, 0, 0, 0, 0, rounding.location)
+	}
+
+	return float64(date.Add(rounding.offset).UnixMilli())
+}
+
+func (rounding *dateRounding) next(key float64) float64 {
+	if rounding.calendarUnit == "" {
+		return rounding.round(key + float64(rounding.fixed.Milliseconds()))
+	}
+
+	date := time.UnixMilli(int64(key)).Add(-rounding.offset).In(rounding.location)
+	switch rounding.calendarUnit {
+	case "second":
+		date = date.Add(time.Second)
+	case "minute":
+		date = date.Add(time.Minute)
+	case "hour":
+		date = date.Add(time.Hour)
+	case "day":
+		date = date.AddDate(0, 0, 1)
+	case "week":
+		date = date.AddDate(0, 0, 7)
+	case "month":
+		date = date.AddDate(0, 1, 0)
+	case "quarter":
+		date = date.AddDate(0, 3, 0)
+	case "year":
+		date = date.AddDate(1, 0, 0)
+	}
+
+	return rounding.round(float64(date.Add(rounding.offset).UnixMilli()))
+}
+
+type histogramBounds struct {
+	min *float64
+	max *float64
+}
+
+// histogramAggregation is a histogram or a date_histogram, dates are bucketed as epoch milliseconds.
+type histogramAggregation struct {
+	field           *mappedField
+	rounding        bucketRounding
+	toNumber        func(value interface{}) (float64, bool)
+	keyFormat       *dateFormat
+	minDocCount     int
+	missing         *float64
+	extendedBounds  *histogramBounds
+	hardBounds      *histogramBounds
+	keyed           bool
+	order           []bucketOrder
+	subAggregations aggregations
+}
+
+func (agg *histogramAggregation) inHardBounds(value float64) bool {
+	return agg.hardBounds == nil ||
+		((agg.hardBounds.min == nil || value >= *agg.hardBounds.min) && (agg.hardBounds.max == nil || value < *agg.hardBounds.max))
+}
+
+func (agg *histogramAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	keyHits := make(map[float64][]searchHit)
+	for _, hit := range hits {
+		numbers := make([]float64, 0)
+		for _, value := range agg.field.docValues(hit.document) {
+			number, isNumber := agg.toNumber(value)
+			if isNumber {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) == 0 && agg.missing != nil {
+			numbers = append(numbers, *agg.missing)
+		}
+
+		seen := make(map[float64]bool, len(numbers))
+		for _, number := range numbers {
+			if !agg.inHardBounds(number) {
+				continue
+			}
+			key := agg.rounding.round(number)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keyHits[key] = append(keyHits[key], hit)
+		}
+	}
+
+	keys := make([]float64, 0, len(keyHits))
+	for key := range keyHits {
+		keys = append(keys, key)
+	}
+	sort.Float64s(keys)
+
+	if agg.minDocCount == 0 {
+		var err error
+		keys, err = agg.fillGaps(keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if len(keyHits[key]) < agg.minDocCount {
+			continue
+		}
+
+		var bucketKey interface{} = key
+		if agg.keyFormat != nil {
+			bucketKey = int64(key)
+		}
+		bucket, err := agg.subAggregations.bucket(bucketKey, keyHits[key])
+		if err != nil {
+			return nil, err
+		}
+		if agg.keyFormat != nil {
+			date := time.UnixMilli(int64(key))
+			if rounding, isDate := agg.rounding.(*dateRounding); isDate {
+				date = date.In(rounding.location)
+			}
+			bucket["key_as_string"] = agg.keyFormat.format(date)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	err := sortBuckets(buckets, agg.order)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err = agg.subAggregations.reduceBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	if agg.keyed {
+		keyedBuckets := make(map[string]interface{}, len(buckets))
+		for _, bucket := range buckets {
+			if keyAsString, isString := bucket["key_as_string"].(string); isString {
+				keyedBuckets[keyAsString] = bucket
+			} else {
+				keyedBuckets[formatDouble(bucket["key"].(float64))] = bucket
+			}
+		}
+		return map[string]interface{}{"buckets": keyedBuckets}, nil
+	}
+
+	return map[string]interface{}{"buckets": buckets}, nil
+}
+
+// fillGaps adds the empty buckets between the first and the last key, widened to the extended bounds.
+func (agg *histogramAggregation) fillGaps(keys []float64) ([]float64, error) {
+	first, last := math.Inf(1), math.Inf(-1)
+	if len(keys) > 0 {
+		first, last = keys[0], keys[len(keys)-1]
+	}
+	if agg.extendedBounds != nil {
+		if agg.extendedBounds.min != nil && agg.inHardBounds(*agg.extendedBounds.min) {
+			first = math.Min(first, agg.rounding.round(*agg.extendedBounds.min))
+		}
+		if agg.extendedBounds.max != nil && agg.inHardBounds(*agg.extendedBounds.max) {
+			last = math.Max(last, agg.rounding.round(*agg.extendedBounds.max))
+		}
+	}
+	if math.IsInf(first, 0) || math.IsInf(last, 0) {
+		return keys, nil
+	}
+
+	filled := make([]float64, 0, len(keys))
+	for key := first; key <= last; key = agg.rounding.next(key) {
+		if len(filled) >= MaxBuckets {
+			return nil, &queryError{
+				errorType: "too_many_buckets_exception",
+				reason: fmt.Sprintf("Trying to create too many buckets. Must be less than or equal to: [%d]. "+
+					"This limit can be set by changing the [search.max_buckets] cluster level setting.", MaxBuckets),
+			}
+		}
+		filled = append(filled, key)
+	}
+
+	return filled, nil
+}
@@ -0,0 +1,364 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDateFormat = "strict_date_optional_time||epoch_millis"
+
+// dateLayouts are the layouts tried, in order, to read a date without an explicit format.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000Z0700",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// namedDateFormats maps the Elasticsearch built in formats to Go layouts, epoch formats are handled apart.
+var namedDateFormats = map[string]string{
+	"strict_date_optional_time":       "2006-01-02T15:04:05.000Z07:00",
+	"date_optional_time":              "2006-01-02T15:04:05.000Z07:00",
+	"strict_date_optional_time_nanos": "2006-01-02T15:04:05.000000000Z07:00",
+	"date_time":                       "2006-01-02T15:04:05.000Z07:00",
+	"strict_date_time":                "2006-01-02T15:04:05.000Z07:00",
+	"date_time_no_millis":             "2006-01-02T15:04:05Z07:00",
+	"strict_date_time_no_millis":      "2006-01-02T15:04:05Z07:00",
+	"date":                            "2006-01-02",
+	"strict_date":                     "2006-01-02",
+	"basic_date":                      "20060102",
+	"basic_date_time":                 "20060102T150405.000Z0700",
+	"date_hour_minute_second":         "2006-01-02T15:04:05",
+	"strict_date_hour_minute_second":  "2006-01-02T15:04:05",
+	"date_hour_minute":                "2006-01-02T15:04",
+	"strict_date_hour_minute":         "2006-01-02T15:04",
+	"hour_minute":                     "15:04",
+	"strict_hour_minute":              "15:04",
+	"hour_minute_second":              "15:04:05",
+	"strict_hour_minute_second":       "15:04:05",
+	"year_month":                      "2006-01",
+	"strict_year_month":               "2006-01",
+	"year_month_day":                  "2006-01-02",
+	"strict_year_month_day":           "2006-01-02",
+	"year":                            "2006",
+	"strict_year":                     "2006",
+}
+
+// dateFormat is a date format as Elasticsearch accepts it, one or more formats joined with ||.
+// Dates are printed with the first one and parsed with the first one that matches.
+type dateFormat struct {
+	formats []string
+}
+
+func newDateFormat(format string) (*dateFormat, error) {
+	if format == "" {
+		format = DefaultDateFormat
+	}
+
+	formats := strings.Split(format, "||")
+	for position, name := range formats {
+		name = strings.TrimSpace(name)
+		formats[position] = name
+		if name == "epoch_millis" || name == "epoch_second" {
+			continue
+		}
+		if _, named := namedDateFormats[name]; !named && javaDateLayout(name) == "" {
+			return nil, fmt.Errorf("Invalid format: [%s]: Unknown pattern letter", format)
+		}
+	}
+
+	return &dateFormat{formats: formats}, nil
+}
+
+func (format *dateFormat) format(date time.Time) string {
+	switch name := format.formats[0]; name {
+	case "epoch_millis":
+		return strconv.FormatInt(date.UnixMilli(), 10)
+	case "epoch_second":
+		return strconv.FormatInt(date.Unix(), 10)
+	default:
+		return date.Format(goDateLayout(name))
+	}
+}
+
+func (format *dateFormat) parse(value interface{}, location *time.Location) (time.Time, error) {
+	for _, name := range format.formats {
+		switch name {
+		case "epoch_millis", "epoch_second":
+			number, isNumber := toFloat(value)
+			if !isNumber {
+				continue
+			}
+			if name == "epoch_second" {
+				number *= 1000
+			}
+			return time.UnixMilli(int64(math.Floor(number))).UTC(), nil
+		default:
+			text, isString := value.(string)
+			if !isString {
+				continue
+			}
+			layout := goDateLayout(name)
+			date, err := time.ParseInLocation(layout, text, location)
+			if err == nil {
+				return date, nil
+			}
+			// The optional time formats also accept a date alone or a date and time without zone.
+			if strings.HasSuffix(name, "date_optional_time") {
+				date, err = parseDate(text, location)
+				if err == nil {
+					return date, nil
+				}
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("failed to parse date field [%v] with format [%s]", value, strings.Join(format.formats, "||"))
+}
+
+// parseDate reads a date in any of the common ISO 8601 layouts, or as epoch milliseconds.
+func parseDate(value interface{}, location *time.Location) (time.Time, error) {
+	if location == nil {
+		location = time.UTC
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		for _, layout := range dateLayouts {
+			date, err := time.ParseInLocation(layout, typedValue, location)
+			if err == nil {
+				return date, nil
+			}
+		}
+		millis, err := strconv.ParseInt(typedValue, 10, 64)
+		if err == nil {
+			return time.UnixMilli(millis).UTC(), nil
+		}
+	default:
+		number, isNumber := toFloat(value)
+		if isNumber {
+			return time.UnixMilli(int64(math.Floor(number))).UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("failed to parse date field [%v] with format [%s]", value, DefaultDateFormat)
+}
+
+func goDateLayout(name string) string {
+	layout, named := namedDateFormats[name]
+	if named {
+		return layout
+	}
+
+	return javaDateLayout(name)
+}
+
+// javaDateLayout converts a Java date pattern, like yyyy-MM-dd'T'HH:mm, to a Go layout. It returns an empty
+// layout when the pattern has letters that are not supported.
+func javaDateLayout(pattern string) string {
+	replacements := map[string]string{
+		"yyyy": "2006", "uuuu": "2006", "yy": "06", "uu": "06",
+		"MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+		"dd": "02", "d": "2",
+		"EEEE": "Monday", "EEE": "Mon",
+		"HH": "15", "H": "15", "hh": "03", "h": "3",
+		"mm": "04", "m": "4",
+		"ss": "05", "s": "5",
+		"SSSSSSSSS": "000000000", "SSSSSS": "000000", "SSS": "000", "SS": "00", "S": "0",
+		"a":   "PM",
+		"XXX": "Z07:00", "XX": "Z0700", "X": "Z07",
+		"ZZZ": "-07:00", "ZZ": "-07:00", "Z": "-0700",
+		"xxx": "-07:00", "xx": "-0700",
+		"z": "MST",
+	}
+
+	var layout strings.Builder
+	for position := 0; position < len(pattern); {
+		character := pattern[position]
+
+		if character == '\'' {
+			end := strings.IndexByte(pattern[position+1:], '\'')
+			if end < 0 {
+				return ""
+			}
+			layout.WriteString(pattern[position+1 : position+1+end])
+			position += end + 2
+			continue
+		}
+
+		if (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z') {
+			length := 1
+			for position+length < len(pattern) && pattern[position+length] == character {
+				length++
+			}
+
+			replacement, known := replacements[pattern[position:position+length]]
+			if !known {
+				return ""
+			}
+			// Go reads fractional seconds only after a dot or a comma.
+			if character == 'S' && (position == 0 || (pattern[position-1] != '.' && pattern[position-1] != ',')) {
+				return ""
+			}
+
+			layout.WriteString(replacement)
+			position += length
+			continue
+		}
+
+		layout.WriteByte(character)
+		position++
+	}
+
+	return layout.String()
+}
+
+// parseTimeZone reads a time zone as an offset, like +01:00, or as a zone id, like Europe/Madrid.
+func parseTimeZone(timeZone string) (*time.Location, error) {
+	if timeZone == "" || timeZone == "Z" || timeZone == "UTC" {
+		return time.UTC, nil
+	}
+
+	if timeZone[0] == '+' || timeZone[0] == '-' {
+		offset, err := time.Parse("-07:00", timeZone)
+		if err != nil {
+			offset, err = time.Parse("-0700", timeZone)
+		}
+		if err != nil {
+			offset, err = time.Parse("-07", timeZone)
+		}
+		if err == nil {
+			_, seconds := offset.Zone()
+			return time.FixedZone(timeZone, seconds), nil
+		}
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time-zone ID: %s", timeZone)
+	}
+
+	return location, nil
+}
+
+// dateMathUnits are the units of date math expressions, with the calendar unit dates are rounded to.
+var dateMathUnits = map[byte]string{
+	'y': "year", 'M': "month", 'w': "week", 'd': "day", 'h': "hour", 'H': "hour", 'm': "minute", 's': "second",
+}
+
+// parseDateMath reads a date that can be a date math expression: now or a date followed by ||, and then
+// any number of additions, subtractions and roundings, like now-1d/d or 2023-01-01||+1M. Rounding up goes
+// to the last millisecond of the unit, the way inclusive upper and exclusive lower bounds are rounded.
+func parseDateMath(value interface{}, format *dateFormat, location *time.Location, roundUp bool) (time.Time, error) {
+	if location == nil {
+		location = time.UTC
+	}
+
+	expression, isString := value.(string)
+	var anchor time.Time
+	var operations string
+	switch {
+	case !isString:
+		return format.parse(value, location)
+	case strings.HasPrefix(expression, "now"):
+		anchor = time.Now().In(location)
+		operations = expression[len("now"):]
+	case strings.Contains(expression, "||"):
+		separator := strings.Index(expression, "||")
+		date, err := format.parse(expression[:separator], location)
+		if err != nil {
+			return time.Time{}, err
+		}
+		anchor = date.In(location)
+		operations = expression[separator+2:]
+	default:
+		date, err := format.parse(expression, location)
+		if err != nil || !roundUp {
+			return date, err
+		}
+		return roundUpPartialDate(expression, date, location), nil
+	}
+
+	date := anchor
+	for position := 0; position < len(operations); {
+		operator := operations[position]
+		position++
+
+		if operator == '/' {
+			if position >= len(operations) || dateMathUnits[operations[position]] == "" {
+				return time.Time{}, fmt.Errorf("rounding `/` can only be used on single unit types [%s]", expression)
+			}
+			rounding := &dateRounding{calendarUnit: dateMathUnits[operations[position]], location: location}
+			rounded := rounding.round(float64(date.UnixMilli()))
+			if roundUp {
+				rounded = rounding.next(rounded) - 1
+			}
+			date = time.UnixMilli(int64(rounded)).In(location)
+			position++
+			continue
+		}
+
+		if operator != '+' && operator != '-' {
+			return time.Time{}, fmt.Errorf("operator not supported for date math [%s]", expression)
+		}
+
+		start := position
+		for position < len(operations) && operations[position] >= '0' && operations[position] <= '9' {
+			position++
+		}
+		amount := 1
+		if position > start {
+			amount, _ = strconv.Atoi(operations[start:position])
+		}
+		if operator == '-' {
+			amount = -amount
+		}
+		if position >= len(operations) || dateMathUnits[operations[position]] == "" {
+			return time.Time{}, fmt.Errorf("unit is missing or unrecognized in date math [%s]", expression)
+		}
+
+		switch operations[position] {
+		case 'y':
+			date = date.AddDate(amount, 0, 0)
+		case 'M':
+			date = date.AddDate(0, amount, 0)
+		case 'w':
+			date = date.AddDate(0, 0, 7*amount)
+		case 'd':
+			date = date.AddDate(0, 0, amount)
+		case 'h', 'H':
+			date = date.Add(time.Duration(amount) * time.Hour)
+		case 'm':
+			date = date.Add(time.Duration(amount) * time.Minute)
+		case 's':
+			date = date.Add(time.Duration(amount) * time.Second)
+		}
+		position++
+	}
+
+	return date, nil
+}
+
+// partialDateUnits are the ISO layouts missing the time, or part of the date, with the unit they cover.
+var partialDateUnits = map[string]string{"2006-01-02": "day", "2006-01": "month", "2006": "year"}
+
+// roundUpPartialDate moves a date given without its time, or without its day, to the last millisecond it
+// covers, so that lte 2023-01-15 includes the whole day like it does in Elasticsearch.
+func roundUpPartialDate(expression string, date time.Time, location *time.Location) time.Time {
+	for layout, unit := range partialDateUnits {
+		if _, err := time.Parse(layout, expression); err == nil {
+			rounding := &dateRounding{calendarUnit: unit, location: location}
+			return time.UnixMilli(int64(rounding.next(float64(date.UnixMilli())) - 1)).In(location)
+		}
+	}
+
+	return date
+}
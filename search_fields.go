@@ -0,0 +1,246 @@
+package elasticfacker
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldOption is a field of the fields or docvalue_fields options of a search, a name or a pattern, and
+// the format its values are returned in. Unmapped fields are only returned by the fields option, when
+// it asks for them.
+type fieldOption struct {
+	pattern         string
+	format          string
+	includeUnmapped bool
+}
+
+// parseFieldOptions reads the fields or docvalue_fields option of a search, an array of field names and
+// patterns or of objects with the field and its format. Fields named without a pattern are checked
+// against the mapping of the index: text fields have no doc values, and only dates and numbers have
+// a format.
+func (es *InMemoryElasticsearch) parseFieldOptions(indexName string, section string, value interface{}) ([]fieldOption, error) {
+	if value == nil {
+		return nil, nil
+	}
+	items, isList := value.([]interface{})
+	if !isList {
+		return nil, newParsingError("[%s] expects an array of field names or objects", section)
+	}
+
+	options := make([]fieldOption, 0, len(items))
+	for _, item := range items {
+		var option fieldOption
+		switch typedItem := item.(type) {
+		case string:
+			option.pattern = typedItem
+		case map[string]interface{}:
+			for key, optionValue := range typedItem {
+				switch {
+				case key == "field":
+					option.pattern, _ = optionValue.(string)
+				case key == "format":
+					option.format, _ = optionValue.(string)
+				case key == "include_unmapped" && section == "fields":
+					option.includeUnmapped, _ = toBool(optionValue)
+				default:
+					return nil, newParsingError("[%s] unknown field [%s]", section, key)
+				}
+			}
+		default:
+			return nil, newParsingError("[%s] expects an array of field names or objects", section)
+		}
+		if option.pattern == "" {
+			return nil, newParsingError("Required [field]")
+		}
+
+		if !strings.Contains(option.pattern, "*") {
+			field := es.mappedField(indexName, option.pattern)
+			if section == "docvalue_fields" {
+				if err := field.aggregatable(); err != nil {
+					return nil, err
+				}
+			}
+			if option.format != "" && !field.unmapped && field.fieldType != "" {
+				switch {
+				case field.isDateField():
+					if _, err := newDateFormat(option.format); err != nil {
+						return nil, newAnalysisError("%s", err.Error())
+					}
+				case numericFieldTypes[field.fieldType]:
+				default:
+					return nil, newAnalysisError("Field [%s] of type [%s] doesn't support formats.", option.pattern, field.fieldType)
+				}
+			}
+		}
+		options = append(options, option)
+	}
+
+	return options, nil
+}
+
+// fetchFields adds the values of the fields of the option to the fields of a hit. The fields option
+// returns the values of the source, parsed the way the field indexes them, while docvalue_fields returns
+// the values the field indexes, sorted, keywords once each.
+func (es *InMemoryElasticsearch) fetchFields(document Document, option fieldOption, docValues bool, fields map[string][]interface{}) {
+	names := []string{option.pattern}
+	if strings.Contains(option.pattern, "*") {
+		names = make([]string, 0)
+		if mapping, exists := es.indicesMappings[document.Index]; exists {
+			for _, name := range mapping.fieldNames("") {
+				if matchesWildcard([]rune(option.pattern), []rune(name)) {
+					names = append(names, name)
+				}
+			}
+		}
+		if option.includeUnmapped {
+			for _, name := range sourceFieldNames(document.Source) {
+				if matchesWildcard([]rune(option.pattern), []rune(name)) && !containsString(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		field := es.mappedField(document.Index, name)
+		switch {
+		case field.fieldType == FieldTypeObject || field.fieldType == FieldTypeNested:
+			continue
+		case field.unmapped && !option.includeUnmapped:
+			continue
+		case docValues && field.aggregatable() != nil:
+			continue
+		}
+
+		values := fetchFieldValues(field, document, option.format, docValues)
+		if len(values) > 0 {
+			fields[name] = values
+		}
+	}
+}
+
+func fetchFieldValues(field *mappedField, document Document, format string, docValues bool) []interface{} {
+	var terms []interface{}
+	switch {
+	case docValues:
+		terms = sortDocValues(field.docValues(document), keywordFieldTypes[field.fieldType] || textFieldTypes[field.fieldType])
+	case field.unmapped || field.fieldType == "" || textFieldTypes[field.fieldType]:
+		return fieldValues(document, field.sourcePath)
+	default:
+		terms = field.documentValues(document)
+	}
+
+	values := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		values = append(values, formatFieldValue(field, term, format))
+	}
+
+	return values
+}
+
+// sortDocValues sorts the terms of a field the way its doc values hold them, keywords are held once each.
+func sortDocValues(terms []interface{}, distinct bool) []interface{} {
+	sorted := make([]interface{}, len(terms))
+	copy(sorted, terms)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		switch first := sorted[i].(type) {
+		case float64:
+			second, _ := sorted[j].(float64)
+			return first < second
+		case string:
+			second, _ := sorted[j].(string)
+			return first < second
+		case bool:
+			second, _ := sorted[j].(bool)
+			return !first && second
+		}
+		return false
+	})
+	if !distinct {
+		return sorted
+	}
+
+	unique := make([]interface{}, 0, len(sorted))
+	for position, term := range sorted {
+		if position == 0 || term != sorted[position-1] {
+			unique = append(unique, term)
+		}
+	}
+
+	return unique
+}
+
+// formatFieldValue returns an indexed value in the format asked for, dates in the one of their field by
+// default and numbers as they are.
+func formatFieldValue(field *mappedField, term interface{}, format string) interface{} {
+	switch {
+	case field.isDateField():
+		dateFormat := field.format
+		if format != "" {
+			if requested, err := newDateFormat(format); err == nil {
+				dateFormat = requested
+			}
+		}
+		millis, _ := term.(float64)
+		return dateFormat.format(time.UnixMilli(int64(millis)).UTC())
+	case numericFieldTypes[field.fieldType] && format != "":
+		number, _ := term.(float64)
+		return decimalFormat(format, number)
+	}
+
+	return term
+}
+
+// decimalFormat prints a number with a Java DecimalFormat pattern like 0.00 or #.###: the zeros of the
+// fraction are the digits always printed and the hashes the ones printed unless they are trailing zeros.
+// A hash integer part leaves out the zero of numbers below one.
+func decimalFormat(pattern string, number float64) string {
+	integerPattern, fractionPattern, _ := strings.Cut(pattern, ".")
+	minDigits := strings.Count(fractionPattern, "0")
+	maxDigits := minDigits + strings.Count(fractionPattern, "#")
+
+	whole, digits, _ := strings.Cut(strconv.FormatFloat(number, 'f', maxDigits, 64), ".")
+	for len(digits) > minDigits && strings.HasSuffix(digits, "0") {
+		digits = digits[:len(digits)-1]
+	}
+	if !strings.Contains(integerPattern, "0") && digits != "" && (whole == "0" || whole == "-0") {
+		whole = strings.TrimSuffix(whole, "0")
+	}
+	if digits == "" {
+		return whole
+	}
+
+	return whole + "." + digits
+}
+
+// sourceFieldNames returns the paths of the values of a source, the leaves of its objects, sorted.
+func sourceFieldNames(source map[string]interface{}) []string {
+	paths := make(map[string]bool)
+	collectSourceFieldNames(source, "", paths)
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func collectSourceFieldNames(value interface{}, path string, paths map[string]bool) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typedValue {
+			collectSourceFieldNames(child, joinFieldPath(path, key), paths)
+		}
+	case []interface{}:
+		for _, item := range typedValue {
+			collectSourceFieldNames(item, path, paths)
+		}
+	case nil:
+	default:
+		paths[path] = true
+	}
+}
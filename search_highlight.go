@@ -0,0 +1,661 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultFragmentSize      = 100
+	DefaultNumberOfFragments = 5
+)
+
+var highlightOptionNames = map[string]bool{
+	"pre_tags": true, "post_tags": true, "tags_schema": true, "fragment_size": true, "number_of_fragments": true,
+	"require_field_match": true, "no_match_size": true, "highlight_query": true, "order": true, "encoder": true,
+	"type": true, "boundary_scanner": true, "boundary_scanner_locale": true, "boundary_chars": true,
+	"boundary_max_scan": true, "fragmenter": true, "fragment_offset": true, "phrase_limit": true,
+	"max_analyzed_offset": true, "force_source": true, "highlight_filter": true, "matched_fields": true,
+	"options": true,
+}
+
+// highlightOptions are the options of a highlighted field, the ones of the highlight section unless the
+// field sets its own.
+type highlightOptions struct {
+	preTag            string
+	postTag           string
+	fragmentSize      int
+	numberOfFragments int
+	noMatchSize       int
+	requireFieldMatch bool
+	orderByScore      bool
+	encodeHTML        bool
+	targets           []highlightTarget
+}
+
+type highlightedField struct {
+	pattern string
+	options highlightOptions
+}
+
+// highlighter builds the highlight of the hits of a search: the fragments of each highlighted field holding
+// the terms the query searched for, wrapped in tags.
+type highlighter struct {
+	es        *InMemoryElasticsearch
+	indexName string
+	fields    []highlightedField
+}
+
+// parseHighlight parses the highlight section of a search. Fields are an object keyed by field name, or an
+// array of such objects to keep their order, and their options override the ones of the section.
+func (es *InMemoryElasticsearch) parseHighlight(indexName string, highlight map[string]interface{}, query interface{}) (*highlighter, error) {
+	parser := &queryParser{es: es, indexName: indexName}
+	parsedQuery, err := es.parseSearchQuery(indexName, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := highlightOptions{
+		preTag:            "<em>",
+		postTag:           "</em>",
+		fragmentSize:      DefaultFragmentSize,
+		numberOfFragments: DefaultNumberOfFragments,
+		requireFieldMatch: true,
+		targets:           highlightTargets(parsedQuery),
+	}
+	for name := range highlight {
+		if name != "fields" && !highlightOptionNames[name] {
+			return nil, newParsingError("[highlight] unknown field [%s]", name)
+		}
+	}
+	defaults, err = parser.highlightOptions("highlight", highlight, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldObjects := make([]map[string]interface{}, 0)
+	switch fields := highlight["fields"].(type) {
+	case nil:
+	case map[string]interface{}:
+		fieldObjects = append(fieldObjects, fields)
+	case []interface{}:
+		for _, item := range fields {
+			fieldObject, ok := item.(map[string]interface{})
+			if !ok || len(fieldObject) != 1 {
+				return nil, newParsingError("[highlight] failed to parse field [fields]")
+			}
+			fieldObjects = append(fieldObjects, fieldObject)
+		}
+	default:
+		return nil, newParsingError("[highlight] failed to parse field [fields]")
+	}
+
+	result := &highlighter{es: es, indexName: indexName}
+	for _, fieldObject := range fieldObjects {
+		patterns := make([]string, 0, len(fieldObject))
+		for pattern := range fieldObject {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			fieldOptions, _ := fieldObject[pattern].(map[string]interface{})
+			for name := range fieldOptions {
+				if !highlightOptionNames[name] {
+					return nil, newParsingError("[fields] unknown field [%s]", name)
+				}
+			}
+			options, err := parser.highlightOptions("fields", fieldOptions, defaults)
+			if err != nil {
+				return nil, err
+			}
+			result.fields = append(result.fields, highlightedField{pattern: pattern, options: options})
+		}
+	}
+
+	return result, nil
+}
+
+// highlightOptions returns the options set in the body over the given ones.
+func (parser *queryParser) highlightOptions(section string, body map[string]interface{}, options highlightOptions) (highlightOptions, error) {
+	if body["tags_schema"] != nil {
+		schema, _ := body["tags_schema"].(string)
+		if schema != "styled" && schema != "default" {
+			return options, newAnalysisError("Unknown tag schema [%v]", body["tags_schema"])
+		}
+		if schema == "styled" {
+			options.preTag = "<em class=\"hlt1\">"
+		}
+	}
+
+	preTags, hasPreTags := body["pre_tags"]
+	postTags, hasPostTags := body["post_tags"]
+	if hasPreTags != hasPostTags && section == "highlight" {
+		if hasPreTags {
+			return options, newAnalysisError("pre_tags are set but post_tags are not set")
+		}
+		return options, newAnalysisError("post_tags are set but pre_tags are not set")
+	}
+	if hasPreTags {
+		tags := stringList(preTags)
+		if len(tags) == 0 {
+			return options, newParsingError("[%s] failed to parse field [pre_tags]", section)
+		}
+		options.preTag = tags[0]
+	}
+	if hasPostTags {
+		tags := stringList(postTags)
+		if len(tags) == 0 {
+			return options, newParsingError("[%s] failed to parse field [post_tags]", section)
+		}
+		options.postTag = tags[0]
+	}
+
+	for name, target := range map[string]*int{
+		"fragment_size":       &options.fragmentSize,
+		"number_of_fragments": &options.numberOfFragments,
+		"no_match_size":       &options.noMatchSize,
+	} {
+		if body[name] == nil {
+			continue
+		}
+		number, isNumber := toFloat(body[name])
+		if !isNumber || number != float64(int(number)) {
+			return options, newParsingError("[%s] failed to parse field [%s]", section, name)
+		}
+		*target = int(number)
+	}
+	if options.numberOfFragments < 0 {
+		return options, newAnalysisError("[number_of_fragments] must be positive but was: %d", options.numberOfFragments)
+	}
+
+	if body["require_field_match"] != nil {
+		requireFieldMatch, isBool := toBool(body["require_field_match"])
+		if !isBool {
+			return options, newParsingError("[%s] failed to parse field [require_field_match]", section)
+		}
+		options.requireFieldMatch = requireFieldMatch
+	}
+	if order, isString := body["order"].(string); isString {
+		options.orderByScore = order == "score"
+	}
+	if encoder, isString := body["encoder"].(string); isString {
+		options.encodeHTML = encoder == "html"
+	}
+	if highlighterType, isString := body["type"].(string); isString {
+		switch highlighterType {
+		case "unified", "plain", "fvh":
+		default:
+			return options, newAnalysisError("unknown highlighter type [%s]", highlighterType)
+		}
+	}
+
+	if body["highlight_query"] != nil {
+		highlightQuery, err := parser.parse(body["highlight_query"])
+		if err != nil {
+			return options, err
+		}
+		options.targets = highlightTargets(highlightQuery)
+	}
+
+	return options, nil
+}
+
+// highlight sets the highlight of the documents of a page of hits, documents without any highlighted
+// fragment have none.
+func (highlighter *highlighter) highlight(documents []Document) {
+	for position := range documents {
+		highlights := make(map[string][]string)
+		for _, field := range highlighter.fields {
+			for _, name := range highlighter.fieldNames(field.pattern) {
+				if _, done := highlights[name]; done {
+					continue
+				}
+				fragments := highlighter.highlightField(documents[position], name, field.options)
+				if len(fragments) > 0 {
+					highlights[name] = fragments
+				}
+			}
+		}
+		if len(highlights) > 0 {
+			documents[position].Highlight = highlights
+		}
+	}
+}
+
+// fieldNames expands a highlighted field pattern to the text and keyword fields of the mapping it matches.
+func (highlighter *highlighter) fieldNames(pattern string) []string {
+	mapping := highlighter.es.indicesMappings[highlighter.indexName]
+	if !strings.Contains(pattern, "*") || mapping == nil {
+		return []string{pattern}
+	}
+
+	names := make([]string, 0)
+	for _, name := range mapping.fieldNames("") {
+		field := highlighter.es.mappedField(highlighter.indexName, name)
+		if (textFieldTypes[field.fieldType] || field.fieldType == "keyword") && matchesAnyPattern([]string{pattern}, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// highlightPassage is a fragment of a value of a field, with the spans of the terms it highlights.
+type highlightPassage struct {
+	value int
+	start int
+	end   int
+	spans []highlightSpan
+}
+
+type highlightSpan struct {
+	start int
+	end   int
+}
+
+// highlightField returns the highlighted fragments of a field of the document. The best passages, the ones
+// with the most highlighted terms, are kept up to number_of_fragments, in the order of the field unless
+// they are ordered by score. With no fragments the whole values holding a term are highlighted.
+func (highlighter *highlighter) highlightField(document Document, name string, options highlightOptions) []string {
+	field := highlighter.es.mappedField(highlighter.indexName, name)
+	if field.unmapped || !(field.isText() || field.fieldType == "keyword") {
+		return nil
+	}
+
+	texts := make([]string, 0)
+	for _, value := range fieldValues(document, field.sourcePath) {
+		if _, isObject := value.(map[string]interface{}); !isObject {
+			texts = append(texts, fmt.Sprint(value))
+		}
+	}
+
+	passages := make([]highlightPassage, 0)
+	for valueIndex, text := range texts {
+		spans := highlightSpans(field, text, options)
+		if len(spans) == 0 {
+			continue
+		}
+		if options.numberOfFragments == 0 {
+			passages = append(passages, highlightPassage{value: valueIndex, start: 0, end: len(text), spans: spans})
+			continue
+		}
+		passages = append(passages, highlightPassages(valueIndex, text, spans, options.fragmentSize)...)
+	}
+
+	if len(passages) == 0 {
+		if options.noMatchSize <= 0 || len(texts) == 0 {
+			return nil
+		}
+		return []string{options.encode(noMatchFragment(texts[0], options.noMatchSize))}
+	}
+
+	if options.numberOfFragments > 0 {
+		sort.SliceStable(passages, func(i, j int) bool {
+			return len(passages[i].spans) > len(passages[j].spans)
+		})
+		if len(passages) > options.numberOfFragments {
+			passages = passages[:options.numberOfFragments]
+		}
+		if !options.orderByScore {
+			sort.SliceStable(passages, func(i, j int) bool {
+				if passages[i].value != passages[j].value {
+					return passages[i].value < passages[j].value
+				}
+				return passages[i].start < passages[j].start
+			})
+		}
+	}
+
+	fragments := make([]string, 0, len(passages))
+	for _, passage := range passages {
+		fragments = append(fragments, options.render(texts[passage.value], passage))
+	}
+
+	return fragments
+}
+
+// highlightSpans returns the offsets of the tokens of the text the query searched for, merged when they
+// overlap. Text is analysed with the analyzer of the field, any other value is a single term.
+func highlightSpans(field *mappedField, text string, options highlightOptions) []highlightSpan {
+	var tokens []analysisToken
+	if field.isText() {
+		fieldAnalyzer := field.analyzer
+		if fieldAnalyzer == nil {
+			fieldAnalyzer = builtInAnalyzer(DefaultAnalyzer, nil)
+		}
+		tokens = fieldAnalyzer.analyze(text)
+	} else {
+		term, err := field.indexedValue(text)
+		if err != nil || term == nil {
+			return nil
+		}
+		tokens = []analysisToken{{term: fmt.Sprint(term), startOffset: 0, endOffset: len(text)}}
+	}
+
+	matched := make([]bool, len(tokens))
+	for _, target := range options.targets {
+		if options.requireFieldMatch && target.field != field.name {
+			continue
+		}
+		target.match(tokens, matched)
+	}
+
+	spans := make([]highlightSpan, 0)
+	for position, token := range tokens {
+		if matched[position] && token.startOffset >= 0 && token.endOffset <= len(text) && token.startOffset < token.endOffset {
+			spans = append(spans, highlightSpan{start: token.startOffset, end: token.endOffset})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	merged := make([]highlightSpan, 0, len(spans))
+	for _, span := range spans {
+		last := len(merged) - 1
+		if last >= 0 && span.start <= merged[last].end {
+			if span.end > merged[last].end {
+				merged[last].end = span.end
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	return merged
+}
+
+// highlightPassages groups the spans of a value in passages. A passage starts at the sentence holding its
+// first span and takes the following sentences while it stays within the fragment size, sentences longer
+// than it are split at the first word boundary past it.
+func highlightPassages(valueIndex int, text string, spans []highlightSpan, fragmentSize int) []highlightPassage {
+	chunks := sentenceChunks(text, fragmentSize)
+	passages := make([]highlightPassage, 0)
+	chunk := 0
+	for _, span := range spans {
+		last := len(passages) - 1
+		if last >= 0 && span.start < passages[last].end {
+			passages[last].spans = append(passages[last].spans, span)
+			if span.end > passages[last].end {
+				passages[last].end = span.end
+			}
+			continue
+		}
+
+		for chunk < len(chunks)-1 && chunks[chunk].end <= span.start {
+			chunk++
+		}
+		passage := highlightPassage{value: valueIndex, start: chunks[chunk].start, end: chunks[chunk].end}
+		for chunk < len(chunks)-1 && chunks[chunk+1].end-passage.start <= fragmentSize {
+			chunk++
+			passage.end = chunks[chunk].end
+		}
+		if span.end > passage.end {
+			passage.end = span.end
+		}
+		passage.spans = []highlightSpan{span}
+		passages = append(passages, passage)
+	}
+
+	return passages
+}
+
+// sentenceChunks splits a text in sentences, ending after a terminal punctuation followed by spaces or at a
+// line break, and splits the sentences longer than the fragment size.
+func sentenceChunks(text string, fragmentSize int) []highlightSpan {
+	sentences := make([]highlightSpan, 0)
+	start := 0
+	for position := 0; position < len(text); position++ {
+		character := text[position]
+		terminal := (character == '.' || character == '!' || character == '?') &&
+			(position+1 == len(text) || isSpaceByte(text[position+1]))
+		if !terminal && character != '\n' {
+			continue
+		}
+		end := position + 1
+		for end < len(text) && isSpaceByte(text[end]) {
+			end++
+		}
+		sentences = append(sentences, highlightSpan{start: start, end: end})
+		start, position = end, end-1
+	}
+	if start < len(text) || len(sentences) == 0 {
+		sentences = append(sentences, highlightSpan{start: start, end: len(text)})
+	}
+
+	chunks := make([]highlightSpan, 0, len(sentences))
+	for _, sentence := range sentences {
+		for fragmentSize > 0 && sentence.end-sentence.start > fragmentSize {
+			boundary := sentence.start + fragmentSize
+			for boundary < sentence.end && !isSpaceByte(text[boundary]) {
+				boundary++
+			}
+			for boundary < sentence.end && isSpaceByte(text[boundary]) {
+				boundary++
+			}
+			if boundary >= sentence.end {
+				break
+			}
+			chunks = append(chunks, highlightSpan{start: sentence.start, end: boundary})
+			sentence.start = boundary
+		}
+		chunks = append(chunks, sentence)
+	}
+
+	return chunks
+}
+
+// noMatchFragment returns the start of a text up to the size, cut at the last word boundary within it.
+func noMatchFragment(text string, size int) string {
+	if len(text) <= size {
+		return strings.TrimSpace(text)
+	}
+
+	end := size
+	for end > 0 && !isSpaceByte(text[end]) {
+		end--
+	}
+	if end == 0 {
+		end = size
+		for end < len(text) && text[end]&0xC0 == 0x80 {
+			end++
+		}
+	}
+
+	return strings.TrimSpace(text[:end])
+}
+
+func isSpaceByte(character byte) bool {
+	return character == ' ' || character == '\t' || character == '\n' || character == '\r'
+}
+
+// render returns the text of the passage with its spans wrapped in the tags.
+func (options highlightOptions) render(text string, passage highlightPassage) string {
+	var builder strings.Builder
+	position := passage.start
+	for _, span := range passage.spans {
+		builder.WriteString(options.encode(text[position:span.start]))
+		builder.WriteString(options.preTag)
+		builder.WriteString(options.encode(text[span.start:span.end]))
+		builder.WriteString(options.postTag)
+		position = span.end
+	}
+	builder.WriteString(options.encode(text[position:passage.end]))
+
+	return strings.TrimSpace(builder.String())
+}
+
+func (options highlightOptions) encode(text string) string {
+	if options.encodeHTML {
+		return html.EscapeString(text)
+	}
+
+	return text
+}
+
+// highlightTarget is what a query searched for in a field: terms, or a phrase whose terms are only
+// highlighted where the phrase is found.
+type highlightTarget struct {
+	field  string
+	term   func(term string) bool
+	phrase *phraseQuery
+}
+
+// match marks the tokens of a value the target searched for.
+func (target highlightTarget) match(tokens []analysisToken, matched []bool) {
+	if target.phrase == nil || target.phrase.slop > 0 {
+		for position, token := range tokens {
+			if target.matchesTerm(token.term) {
+				matched[position] = true
+			}
+		}
+		return
+	}
+
+	phrase := target.phrase
+	byPosition := make(map[int][]int)
+	for index, token := range tokens {
+		byPosition[token.position] = append(byPosition[token.position], index)
+	}
+	for _, token := range tokens {
+		if !containsString(phrase.terms[0], token.term) {
+			continue
+		}
+		start := token.position - phrase.offsets[0]
+		found := make([]int, 0, len(phrase.terms))
+		for slot, alternatives := range phrase.terms {
+			for _, index := range byPosition[start+phrase.offsets[slot]] {
+				if containsString(alternatives, tokens[index].term) {
+					found = append(found, index)
+					break
+				}
+			}
+			if len(found) != slot+1 {
+				break
+			}
+		}
+		if len(found) == len(phrase.terms) {
+			for _, index := range found {
+				matched[index] = true
+			}
+		}
+	}
+}
+
+func (target highlightTarget) matchesTerm(term string) bool {
+	if target.term != nil {
+		return target.term(term)
+	}
+	for _, alternatives := range target.phrase.terms {
+		if containsString(alternatives, term) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// highlightTargets returns what a query searched for, in the fields it searched. Clauses that must not
+// match are left out, they are never found in the hits.
+func highlightTargets(query searchQuery) []highlightTarget {
+	targets := make([]highlightTarget, 0)
+	switch typedQuery := query.(type) {
+	case *matchQuery:
+		terms := make(map[string]bool)
+		for _, term := range typedQuery.terms {
+			if text, isString := term.(string); isString {
+				terms[text] = true
+			}
+		}
+		fuzzy := typedQuery.fuzzy
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			if terms[term] || fuzzy == nil {
+				return terms[term]
+			}
+			for text := range terms {
+				if similar, _ := fuzzy.similarity(text, term); similar {
+					return true
+				}
+			}
+			return false
+		}})
+	case *termsQuery:
+		values := typedQuery.values
+		caseInsensitive := typedQuery.caseInsensitive
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			for _, value := range values {
+				text, isString := value.(string)
+				if isString && (text == term || (caseInsensitive && strings.EqualFold(text, term))) {
+					return true
+				}
+			}
+			return false
+		}})
+	case *termsSetQuery:
+		values := typedQuery.values
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			for _, value := range values {
+				if text, isString := value.(string); isString && text == term {
+					return true
+				}
+			}
+			return false
+		}})
+	case *prefixQuery:
+		prefix, caseInsensitive := typedQuery.prefix, typedQuery.caseInsensitive
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			if caseInsensitive {
+				return strings.HasPrefix(strings.ToLower(term), strings.ToLower(prefix))
+			}
+			return strings.HasPrefix(term, prefix)
+		}})
+	case *wildcardQuery:
+		pattern, caseInsensitive := typedQuery.pattern, typedQuery.caseInsensitive
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			if caseInsensitive {
+				return matchesWildcard([]rune(strings.ToLower(pattern)), []rune(strings.ToLower(term)))
+			}
+			return matchesWildcard([]rune(pattern), []rune(term))
+		}})
+	case *regexpQuery:
+		expression, caseInsensitive := typedQuery.expression, typedQuery.caseInsensitive
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			return expression.matches(term, caseInsensitive)
+		}})
+	case *fuzzyQuery:
+		value, matcher := typedQuery.value, typedQuery.matcher
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, term: func(term string) bool {
+			similar, _ := matcher.similarity(value, term)
+			return similar
+		}})
+	case *phraseQuery:
+		targets = append(targets, highlightTarget{field: typedQuery.field.name, phrase: typedQuery})
+	case *crossFieldsQuery:
+		terms := make(map[string]bool)
+		for _, term := range typedQuery.terms {
+			terms[term] = true
+		}
+		for _, field := range typedQuery.fields {
+			targets = append(targets, highlightTarget{field: field.field.name, term: func(term string) bool {
+				return terms[term]
+			}})
+		}
+	case *disMaxQuery:
+		for _, clause := range typedQuery.queries {
+			targets = append(targets, highlightTargets(clause)...)
+		}
+	case *boolQuery:
+		for _, clauses := range [][]searchQuery{typedQuery.must, typedQuery.filter, typedQuery.should} {
+			for _, clause := range clauses {
+				targets = append(targets, highlightTargets(clause)...)
+			}
+		}
+	case *boostQuery:
+		targets = append(targets, highlightTargets(typedQuery.query)...)
+	}
+
+	return targets
+}
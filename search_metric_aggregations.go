@@ -0,0 +1,451 @@
+package elasticfacker
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+var defaultPercents = []float64{1, 5, 25, 50, 75, 95, 99}
+
+const DefaultTopHitsSize = 3
+
+// metricField reads the values of a field for a metric aggregation, documents without the field use
+// the missing value when there is one.
+type metricField struct {
+	field   *mappedField
+	missing interface{}
+}
+
+func (parser *aggregationParser) parseMetricField(aggregationType string, options map[string]interface{}) (metricField, error) {
+	field, err := parser.aggregatedField(aggregationType, options)
+	if err != nil {
+		return metricField{}, err
+	}
+
+	return metricField{
+		field:   field,
+		missing: options["missing"],
+	}, nil
+}
+
+func (metric metricField) values(hits []searchHit) []interface{} {
+	values := make([]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		documentValues := metric.field.docValues(hit.document)
+		if len(documentValues) == 0 && metric.missing != nil {
+			documentValues = []interface{}{metric.missing}
+		}
+		values = append(values, documentValues...)
+	}
+
+	return values
+}
+
+// numbers returns the numeric values of the field. Dates are read as epoch milliseconds, the same way
+// Elasticsearch stores them, and isDate reports it so the results also get a value_as_string.
+func (metric metricField) numbers(hits []searchHit) (numbers []float64, isDate bool) {
+	numbers, isDate = make([]float64, 0, len(hits)), metric.field.isDateField()
+	for _, value := range metric.values(hits) {
+		if _, isBool := value.(bool); isBool {
+			continue
+		}
+
+		number, isNumber := toFloat(value)
+		if !isNumber {
+			date, err := parseDate(value, time.UTC)
+			if err != nil {
+				continue
+			}
+			number, isDate = float64(date.UnixMilli()), true
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, isDate
+}
+
+func metricValue(value float64, isDate bool) map[string]interface{} {
+	result := map[string]interface{}{"value": value}
+	if isDate {
+		result["value_as_string"] = time.UnixMilli(int64(value)).UTC().Format(namedDateFormats["strict_date_optional_time"])
+	}
+
+	return result
+}
+
+func (parser *aggregationParser) parseSingleValueMetric(aggregationType string, options map[string]interface{}) (aggregation, error) {
+	field, err := parser.parseMetricField(aggregationType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &singleValueMetricAggregation{
+		metricField: field,
+		metric:      aggregationType,
+	}, nil
+}
+
+// singleValueMetricAggregation is an avg, sum, min or max aggregation.
+type singleValueMetricAggregation struct {
+	metricField
+	metric string
+}
+
+func (agg *singleValueMetricAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	numbers, isDate := agg.numbers(hits)
+	if len(numbers) == 0 {
+		if agg.metric == "sum" {
+			return map[string]interface{}{"value": 0.0}, nil
+		}
+		return map[string]interface{}{"value": nil}, nil
+	}
+
+	stats := newNumberStats(numbers)
+	switch agg.metric {
+	case "avg":
+		return metricValue(stats.avg(), isDate), nil
+	case "sum":
+		return metricValue(stats.sum, isDate), nil
+	case "min":
+		return metricValue(stats.min, isDate), nil
+	default:
+		return metricValue(stats.max, isDate), nil
+	}
+}
+
+type numberStats struct {
+	count        int
+	min          float64
+	max          float64
+	sum          float64
+	sumOfSquares float64
+}
+
+func newNumberStats(numbers []float64) numberStats {
+	stats := numberStats{
+		count: len(numbers),
+		min:   math.Inf(1),
+		max:   math.Inf(-1),
+	}
+	for _, number := range numbers {
+		stats.min = math.Min(stats.min, number)
+		stats.max = math.Max(stats.max, number)
+		stats.sum += number
+		stats.sumOfSquares += number * number
+	}
+
+	return stats
+}
+
+func (stats numberStats) avg() float64 {
+	return stats.sum / float64(stats.count)
+}
+
+func (parser *aggregationParser) parseValueCount(options map[string]interface{}) (aggregation, error) {
+	field, err := parser.parseMetricField("value_count", options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueCountAggregation{metricField: field}, nil
+}
+
+type valueCountAggregation struct {
+	metricField
+}
+
+func (agg *valueCountAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	return map[string]interface{}{"value": len(agg.values(hits))}, nil
+}
+
+func (parser *aggregationParser) parseStats(aggregationType string, options map[string]interface{}) (aggregation, error) {
+	field, err := parser.parseMetricField(aggregationType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	sigma := 2.0
+	if options["sigma"] != nil {
+		var isNumber bool
+		sigma, isNumber = toFloat(options["sigma"])
+		if !isNumber || sigma < 0 {
+			return nil, newAggregationError("[sigma] must be greater than or equal to 0. Found [%v] in [%s]", options["sigma"], aggregationType)
+		}
+	}
+
+	return &statsAggregation{
+		metricField: field,
+		extended:    aggregationType == "extended_stats",
+		sigma:       sigma,
+	}, nil
+}
+
+// statsAggregation is a stats or an extended_stats aggregation.
+type statsAggregation struct {
+	metricField
+	extended bool
+	sigma    float64
+}
+
+func (agg *statsAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	numbers, isDate := agg.numbers(hits)
+	stats := newNumberStats(numbers)
+
+	result := map[string]interface{}{
+		"count": stats.count,
+		"min":   nil,
+		"max":   nil,
+		"avg":   nil,
+		"sum":   stats.sum,
+	}
+	if stats.count > 0 {
+		result["min"] = stats.min
+		result["max"] = stats.max
+		result["avg"] = stats.avg()
+		if isDate {
+			format := namedDateFormats["strict_date_optional_time"]
+			result["min_as_string"] = time.UnixMilli(int64(stats.min)).UTC().Format(format)
+			result["max_as_string"] = time.UnixMilli(int64(stats.max)).UTC().Format(format)
+		}
+	}
+
+	if !agg.extended {
+		return result, nil
+	}
+
+	result["sum_of_squares"] = nil
+	result["variance"] = nil
+	result["variance_population"] = nil
+	result["variance_sampling"] = nil
+	result["std_deviation"] = nil
+	result["std_deviation_population"] = nil
+	result["std_deviation_sampling"] = nil
+	result["std_deviation_bounds"] = map[string]interface{}{
+		"upper": nil, "lower": nil,
+		"upper_population": nil, "lower_population": nil,
+		"upper_sampling": nil, "lower_sampling": nil,
+	}
+	if stats.count == 0 {
+		return result, nil
+	}
+
+	average := stats.avg()
+	variancePopulation := math.Max(0, stats.sumOfSquares/float64(stats.count)-average*average)
+	varianceSampling := math.NaN()
+	if stats.count > 1 {
+		varianceSampling = math.Max(0, (stats.sumOfSquares-float64(stats.count)*average*average)/float64(stats.count-1))
+	}
+	stdDeviationPopulation := math.Sqrt(variancePopulation)
+	stdDeviationSampling := math.Sqrt(varianceSampling)
+
+	result["sum_of_squares"] = stats.sumOfSquares
+	result["variance"] = variancePopulation
+	result["variance_population"] = variancePopulation
+	result["variance_sampling"] = nullIfNaN(varianceSampling)
+	result["std_deviation"] = stdDeviationPopulation
+	result["std_deviation_population"] = stdDeviationPopulation
+	result["std_deviation_sampling"] = nullIfNaN(stdDeviationSampling)
+	result["std_deviation_bounds"] = map[string]interface{}{
+		"upper":            average + agg.sigma*stdDeviationPopulation,
+		"lower":            average - agg.sigma*stdDeviationPopulation,
+		"upper_population": average + agg.sigma*stdDeviationPopulation,
+		"lower_population": average - agg.sigma*stdDeviationPopulation,
+		"upper_sampling":   nullIfNaN(average + agg.sigma*stdDeviationSampling),
+		"lower_sampling":   nullIfNaN(average - agg.sigma*stdDeviationSampling),
+	}
+
+	return result, nil
+}
+
+// nullIfNaN returns nil for values that are not defined, like the sample variance of a single value,
+// as JSON has no NaN.
+func nullIfNaN(value float64) interface{} {
+	if math.IsNaN(value) {
+		return nil
+	}
+
+	return value
+}
+
+func (parser *aggregationParser) parsePercentiles(options map[string]interface{}) (aggregation, error) {
+	field, err := parser.parseMetricField("percentiles", options)
+	if err != nil {
+		return nil, err
+	}
+
+	percents := defaultPercents
+	if options["percents"] != nil {
+		percentValues, isArray := options["percents"].([]interface{})
+		if !isArray || len(percentValues) == 0 {
+			return nil, newAggregationError("[percents] must not be empty: [percentiles]")
+		}
+
+		percents = make([]float64, 0, len(percentValues))
+		for _, percentValue := range percentValues {
+			percent, isNumber := toFloat(percentValue)
+			if !isNumber || percent < 0 || percent > 100 {
+				return nil, newAggregationError("percent must be in [0,100], got [%v]: [percentiles]", percentValue)
+			}
+			percents = append(percents, percent)
+		}
+	}
+
+	keyed := true
+	if options["keyed"] != nil {
+		keyed, _ = options["keyed"].(bool)
+	}
+
+	return &percentilesAggregation{
+		metricField: field,
+		percents:    percents,
+		keyed:       keyed,
+	}, nil
+}
+
+type percentilesAggregation struct {
+	metricField
+	percents []float64
+	keyed    bool
+}
+
+func (agg *percentilesAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	numbers, _ := agg.numbers(hits)
+	sort.Float64s(numbers)
+
+	keyedValues := make(map[string]interface{}, len(agg.percents))
+	values := make([]interface{}, 0, len(agg.percents))
+	for _, percent := range agg.percents {
+		var value interface{}
+		if len(numbers) > 0 {
+			value = percentile(numbers, percent)
+		}
+		keyedValues[formatDouble(percent)] = value
+		values = append(values, map[string]interface{}{"key": percent, "value": value})
+	}
+
+	if agg.keyed {
+		return map[string]interface{}{"values": keyedValues}, nil
+	}
+
+	return map[string]interface{}{"values": values}, nil
+}
+
+// percentile interpolates linearly between the closest ranks of the sorted numbers.
+func percentile(sorted []float64, percent float64) float64 {
+	rank := percent / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func (parser *aggregationParser) parseCardinality(options map[string]interface{}) (aggregation, error) {
+	field, err := parser.parseMetricField("cardinality", options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cardinalityAggregation{metricField: field}, nil
+}
+
+type cardinalityAggregation struct {
+	metricField
+}
+
+func (agg *cardinalityAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	distinct := make(map[interface{}]bool)
+	for _, value := range agg.values(hits) {
+		distinct[value] = true
+	}
+
+	return map[string]interface{}{"value": len(distinct)}, nil
+}
+
+func (parser *aggregationParser) parseTopHits(options map[string]interface{}) (aggregation, error) {
+	size, err := intOption("top_hits", options, "size", DefaultTopHitsSize)
+	if err != nil {
+		return nil, err
+	}
+	from, err := intOption("top_hits", options, "from", 0)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || from < 0 {
+		return nil, newAggregationError("[from] and [size] must be positive in [top_hits]")
+	}
+	if from+size > 100 {
+		return nil, newAggregationError("Top hits result window is too large, the top hits aggregator [from + size] must be less "+
+			"than or equal to: [100] but was [%d]. This limit can be set by changing the [index.max_inner_result_window] index level setting.", from+size)
+	}
+
+	var sortFields []sortField
+	if options["sort"] != nil {
+		sortFields, err = parseSort(options["sort"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for option := range options {
+		switch option {
+		case "size", "from", "sort", "_source", "fields", "docvalue_fields", "stored_fields", "track_scores", "version",
+			"seq_no_primary_term", "explain":
+		default:
+			return nil, newParsingError("[top_hits] unknown field [%s]", option)
+		}
+	}
+
+	trackScores, _ := toBool(options["track_scores"])
+
+	format, err := parser.es.parseHitFormat(parser.indexName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topHitsAggregation{
+		es:         parser.es,
+		indexName:  parser.indexName,
+		size:       size,
+		from:       from,
+		sortFields: sortFields,
+		scored:     scoresTracked(sortFields, trackScores),
+		format:     format,
+	}, nil
+}
+
+type topHitsAggregation struct {
+	es         *InMemoryElasticsearch
+	indexName  string
+	size       int
+	from       int
+	sortFields []sortField
+	scored     bool
+	format     *hitFormat
+}
+
+func (agg *topHitsAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	sortedHits := make([]searchHit, len(hits))
+	copy(sortedHits, hits)
+
+	err := agg.es.sortHits(agg.indexName, sortedHits, agg.sortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := pageDocuments(sortedHits, agg.from, agg.size, agg.scored)
+	if agg.format != nil {
+		agg.format.apply(documents)
+	}
+
+	return map[string]interface{}{
+		"hits": map[string]interface{}{
+			"total": ElasticSearchResponseFakeHitsTotal{
+				Value:    len(sortedHits),
+				Relation: "eq",
+			},
+			"max_score": maxScore(sortedHits, agg.scored),
+			"hits":      documents,
+		},
+	}, nil
+}
@@ -0,0 +1,380 @@
+package elasticfacker
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	GapPolicySkip        = "skip"
+	GapPolicyInsertZeros = "insert_zeros"
+	GapPolicyKeepValues  = "keep_values"
+)
+
+// pipelineAggregation is a parent pipeline aggregation, it is declared as a sub-aggregation of a multi bucket
+// aggregation and works on the buckets of its parent once they are computed.
+type pipelineAggregation interface {
+	aggregation
+	reduce(name string, buckets []map[string]interface{}) ([]map[string]interface{}, error)
+	// phase orders the pipelines of a parent, the ones adding values to the buckets run before the ones
+	// selecting and sorting buckets, so these can use those values.
+	phase() int
+}
+
+type parentPipeline struct{}
+
+func (pipeline parentPipeline) aggregate(_ []searchHit) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// reduceBuckets runs the pipeline aggregations among the sub-aggregations over the buckets of their parent.
+func (aggs aggregations) reduceBuckets(buckets []map[string]interface{}) ([]map[string]interface{}, error) {
+	names := make([]string, 0)
+	for name, agg := range aggs {
+		if _, isPipeline := agg.(pipelineAggregation); isPipeline {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		iPhase, jPhase := aggs[names[i]].(pipelineAggregation).phase(), aggs[names[j]].(pipelineAggregation).phase()
+		if iPhase != jPhase {
+			return iPhase < jPhase
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		var err error
+		buckets, err = aggs[name].(pipelineAggregation).reduce(name, buckets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buckets, nil
+}
+
+func parseGapPolicy(aggregationType string, options map[string]interface{}) (string, error) {
+	gapPolicy, _ := options["gap_policy"].(string)
+	switch gapPolicy {
+	case "":
+		return GapPolicySkip, nil
+	case GapPolicySkip, GapPolicyInsertZeros, GapPolicyKeepValues:
+		return gapPolicy, nil
+	default:
+		return "", newParsingError("[%s] unknown gap_policy [%s]", aggregationType, gapPolicy)
+	}
+}
+
+// resolveBucketValue returns the number at the buckets path of the bucket, ok is false for a gap that
+// the gap policy does not fill.
+func resolveBucketValue(bucket map[string]interface{}, path string, gapPolicy string) (float64, bool, error) {
+	value, err := bucketPathValue(bucket, path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	number, isNumber := toFloat(value)
+	if isNumber && !math.IsNaN(number) && !math.IsInf(number, 0) {
+		return number, true, nil
+	}
+	if gapPolicy == GapPolicyInsertZeros {
+		return 0, true, nil
+	}
+
+	return 0, false, nil
+}
+
+func (parser *aggregationParser) parseSequencePipeline(aggregationType string, options map[string]interface{}) (aggregation, error) {
+	bucketsPath, _ := options["buckets_path"].(string)
+	if bucketsPath == "" {
+		return nil, newAggregationError("Required one of fields [buckets_path], but none were specified in [%s].", aggregationType)
+	}
+	gapPolicy, err := parseGapPolicy(aggregationType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if aggregationType == "cumulative_sum" {
+		return &cumulativeSumAggregation{bucketsPath: bucketsPath}, nil
+	}
+
+	return &derivativeAggregation{bucketsPath: bucketsPath, gapPolicy: gapPolicy}, nil
+}
+
+type cumulativeSumAggregation struct {
+	parentPipeline
+	bucketsPath string
+}
+
+func (agg *cumulativeSumAggregation) phase() int {
+	return 0
+}
+
+func (agg *cumulativeSumAggregation) reduce(name string, buckets []map[string]interface{}) ([]map[string]interface{}, error) {
+	sum := 0.0
+	for _, bucket := range buckets {
+		value, ok, err := resolveBucketValue(bucket, agg.bucketsPath, GapPolicyInsertZeros)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			sum += value
+		}
+		bucket[name] = map[string]interface{}{"value": sum}
+	}
+
+	return buckets, nil
+}
+
+// derivativeAggregation is the difference between the value of a bucket and the one of the previous bucket,
+// the first bucket has no derivative.
+type derivativeAggregation struct {
+	parentPipeline
+	bucketsPath string
+	gapPolicy   string
+}
+
+func (agg *derivativeAggregation) phase() int {
+	return 0
+}
+
+func (agg *derivativeAggregation) reduce(name string, buckets []map[string]interface{}) ([]map[string]interface{}, error) {
+	var previous *float64
+	for _, bucket := range buckets {
+		value, ok, err := resolveBucketValue(bucket, agg.bucketsPath, agg.gapPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if previous != nil {
+			bucket[name] = map[string]interface{}{"value": value - *previous}
+		}
+		current := value
+		previous = &current
+	}
+
+	return buckets, nil
+}
+
+func (parser *aggregationParser) parseBucketSelector(options map[string]interface{}) (aggregation, error) {
+	bucketsPaths, err := parseBucketsPathMap("bucket_selector", options)
+	if err != nil {
+		return nil, err
+	}
+	gapPolicy, err := parseGapPolicy("bucket_selector", options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options["script"] == nil {
+		return nil, newAggregationError("Required one of fields [script], but none were specified in [bucket_selector].")
+	}
+	source, params, err := parseScriptSource(options["script"])
+	if err != nil {
+		return nil, err
+	}
+	script, err := parseScriptExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bucketSelectorAggregation{
+		bucketsPaths: bucketsPaths,
+		script:       script,
+		params:       params,
+		gapPolicy:    gapPolicy,
+	}, nil
+}
+
+func parseBucketsPathMap(aggregationType string, options map[string]interface{}) (map[string]string, error) {
+	bucketsPaths := make(map[string]string)
+	switch typedPaths := options["buckets_path"].(type) {
+	case string:
+		bucketsPaths["_value"] = typedPaths
+	case map[string]interface{}:
+		for variable, path := range typedPaths {
+			pathString, isString := path.(string)
+			if !isString {
+				return nil, newParsingError("[%s] buckets_path [%s] must be a string", aggregationType, variable)
+			}
+			bucketsPaths[variable] = pathString
+		}
+	default:
+		return nil, newAggregationError("Required one of fields [buckets_path], but none were specified in [%s].", aggregationType)
+	}
+
+	return bucketsPaths, nil
+}
+
+// bucketSelectorAggregation keeps the buckets for which the script returns true, the values at the buckets
+// paths are available to the script as params.
+type bucketSelectorAggregation struct {
+	parentPipeline
+	bucketsPaths map[string]string
+	script       scriptExpression
+	params       map[string]interface{}
+	gapPolicy    string
+}
+
+func (agg *bucketSelectorAggregation) phase() int {
+	return 1
+}
+
+func (agg *bucketSelectorAggregation) reduce(name string, buckets []map[string]interface{}) ([]map[string]interface{}, error) {
+	selected := make([]map[string]interface{}, 0, len(buckets))
+	for _, bucket := range buckets {
+		params := make(map[string]interface{}, len(agg.params)+len(agg.bucketsPaths))
+		for key, value := range agg.params {
+			params[key] = value
+		}
+
+		skip := false
+		for variable, path := range agg.bucketsPaths {
+			value, ok, err := resolveBucketValue(bucket, path, agg.gapPolicy)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				skip = true
+				break
+			}
+			params[variable] = value
+		}
+		if skip {
+			continue
+		}
+
+		keep, err := agg.script(params)
+		if err != nil {
+			return nil, err
+		}
+		keepBucket, isBool := keep.(bool)
+		if !isBool {
+			return nil, newScriptError("bucket_selector aggregation [%s] script must return a boolean, found [%v]", name, keep)
+		}
+		if keepBucket {
+			selected = append(selected, bucket)
+		}
+	}
+
+	return selected, nil
+}
+
+func (parser *aggregationParser) parseBucketSort(options map[string]interface{}) (aggregation, error) {
+	from, err := intOption("bucket_sort", options, "from", 0)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 {
+		return nil, newAggregationError("[from] must be a non-negative integer: [%d]", from)
+	}
+	size, err := intOption("bucket_sort", options, "size", -1)
+	if err != nil {
+		return nil, err
+	}
+	if options["size"] != nil && size <= 0 {
+		return nil, newAggregationError("[size] must be a positive integer: [%d]", size)
+	}
+	gapPolicy, err := parseGapPolicy("bucket_sort", options)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []bucketOrder
+	if options["sort"] != nil {
+		sortFields, err := parseSort(options["sort"])
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range sortFields {
+			orders = append(orders, bucketOrder{path: field.field, descending: field.descending})
+		}
+	}
+	if len(orders) == 0 && from == 0 && size < 0 {
+		return nil, newAggregationError("[bucket_sort] is configured to perform nothing. Please set either of [sort, size, from] to use bucket_sort")
+	}
+
+	return &bucketSortAggregation{
+		orders:    orders,
+		from:      from,
+		size:      size,
+		gapPolicy: gapPolicy,
+	}, nil
+}
+
+// bucketSortAggregation sorts and truncates the buckets of its parent, buckets without a value to sort on
+// are dropped unless the gap policy fills them.
+type bucketSortAggregation struct {
+	parentPipeline
+	orders    []bucketOrder
+	from      int
+	size      int
+	gapPolicy string
+}
+
+func (agg *bucketSortAggregation) phase() int {
+	return 2
+}
+
+func (agg *bucketSortAggregation) reduce(name string, buckets []map[string]interface{}) ([]map[string]interface{}, error) {
+	sortable := make([]map[string]interface{}, 0, len(buckets))
+	values := make(map[int][]float64, len(buckets))
+	for _, bucket := range buckets {
+		bucketValues := make([]float64, 0, len(agg.orders))
+		skip := false
+		for _, order := range agg.orders {
+			if order.path == "_key" {
+				bucketValues = append(bucketValues, 0)
+				continue
+			}
+			value, ok, err := resolveBucketValue(bucket, order.path, agg.gapPolicy)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				skip = true
+				break
+			}
+			bucketValues = append(bucketValues, value)
+		}
+		if skip {
+			continue
+		}
+
+		values[len(sortable)] = bucketValues
+		sortable = append(sortable, bucket)
+	}
+
+	positions := make([]int, len(sortable))
+	for position := range positions {
+		positions[position] = position
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		for orderPosition, order := range agg.orders {
+			var comparison int
+			if order.path == "_key" {
+				comparison, _ = compareValues(sortable[positions[i]]["key"], sortable[positions[j]]["key"])
+			} else {
+				comparison, _ = compareValues(values[positions[i]][orderPosition], values[positions[j]][orderPosition])
+			}
+			if comparison != 0 {
+				return (comparison < 0) != order.descending
+			}
+		}
+		return false
+	})
+
+	sorted := make([]map[string]interface{}, 0, len(sortable))
+	for position := agg.from; position < len(positions); position++ {
+		if agg.size >= 0 && len(sorted) >= agg.size {
+			break
+		}
+		sorted = append(sorted, sortable[positions[position]])
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,358 @@
+package elasticfacker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SortOrderAsc  = "asc"
+	SortOrderDesc = "desc"
+
+	SortMissingLast  = "_last"
+	SortMissingFirst = "_first"
+)
+
+type sortField struct {
+	field        string
+	descending   bool
+	missing      interface{}
+	mode         string
+	unmappedType string
+}
+
+// parseSort reads the sort of a search request, a field name, an object with the field options or an array of both.
+func parseSort(sortSpecification interface{}) ([]sortField, error) {
+	specifications, isArray := sortSpecification.([]interface{})
+	if !isArray {
+		specifications = []interface{}{sortSpecification}
+	}
+
+	sortFields := make([]sortField, 0, len(specifications))
+	for _, specification := range specifications {
+		switch typedSpecification := specification.(type) {
+		case string:
+			sortFields = append(sortFields, newSortField(typedSpecification))
+		case map[string]interface{}:
+			if len(typedSpecification) != 1 {
+				return nil, newParsingError("[sort] expects a single field per sort object, found [%d]", len(typedSpecification))
+			}
+
+			for field, options := range typedSpecification {
+				parsedField, err := parseSortField(field, options)
+				if err != nil {
+					return nil, err
+				}
+				sortFields = append(sortFields, parsedField)
+			}
+		default:
+			return nil, newParsingError("[sort] malformed, expected a field name or an object but found [%v]", specification)
+		}
+	}
+
+	return sortFields, nil
+}
+
+// parseSortParam reads the sort query string parameter, a comma separated list of field or field:order.
+func parseSortParam(sortParam string) []interface{} {
+	specifications := make([]interface{}, 0)
+	for _, specification := range strings.Split(sortParam, ",") {
+		field, order, hasOrder := strings.Cut(strings.TrimSpace(specification), ":")
+		if hasOrder {
+			specifications = append(specifications, map[string]interface{}{field: order})
+		} else {
+			specifications = append(specifications, field)
+		}
+	}
+
+	return specifications
+}
+
+func newSortField(field string) sortField {
+	return sortField{
+		field:      field,
+		descending: field == "_score",
+		missing:    SortMissingLast,
+	}
+}
+
+func parseSortField(field string, options interface{}) (sortField, error) {
+	parsedField := newSortField(field)
+
+	optionsObject, isObject := options.(map[string]interface{})
+	if !isObject {
+		optionsObject = map[string]interface{}{"order": options}
+	}
+
+	for option, value := range optionsObject {
+		switch option {
+		case "order":
+			order, _ := value.(string)
+			switch strings.ToLower(order) {
+			case SortOrderAsc:
+				parsedField.descending = false
+			case SortOrderDesc:
+				parsedField.descending = true
+			default:
+				return sortField{}, newParsingError("[sort] unknown order [%v] for field [%s], expected [asc] or [desc]", value, field)
+			}
+		case "missing":
+			parsedField.missing = value
+		case "mode":
+			mode, _ := value.(string)
+			switch mode {
+			case "min", "max", "sum", "avg", "median":
+				parsedField.mode = mode
+			default:
+				return sortField{}, newParsingError("[sort] unknown mode [%v] for field [%s]", value, field)
+			}
+		case "unmapped_type":
+			parsedField.unmappedType, _ = value.(string)
+		case "numeric_type", "format":
+		default:
+			return sortField{}, newParsingError("[sort] unknown option [%s] for field [%s]", option, field)
+		}
+	}
+
+	return parsedField, nil
+}
+
+// sortHits orders the hits by the sort fields and sets the sort values of every hit. Without sort fields the hits
+// are ordered by score, ties keep the order the documents were indexed in.
+func (es *InMemoryElasticsearch) sortHits(indexName string, hits []searchHit, sortFields []sortField) error {
+	if len(sortFields) > 0 {
+		mappedFields, err := es.sortMappedFields(indexName, sortFields)
+		if err != nil {
+			return err
+		}
+
+		for position := range hits {
+			hits[position].sortValues = make([]interface{}, 0, len(sortFields))
+			for fieldPosition, field := range sortFields {
+				hits[position].sortValues = append(hits[position].sortValues, sortValue(hits[position], position, field, mappedFields[fieldPosition]))
+			}
+		}
+	}
+
+	orderHits(hits, sortFields)
+
+	return nil
+}
+
+// orderHits orders hits by their sort values, or by score when there are no sort fields, ties keep their order.
+func orderHits(hits []searchHit, sortFields []sortField) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if len(sortFields) == 0 {
+			return hits[i].score > hits[j].score
+		}
+		for position, field := range sortFields {
+			comparison := compareSortValues(hits[i].sortValues[position], hits[j].sortValues[position], field)
+			if comparison != 0 {
+				return comparison < 0
+			}
+		}
+		return false
+	})
+}
+
+// sortMappedFields returns the mapping of the fields hits are sorted on, nil for _score and _doc. Fields the
+// index does not map can only be sorted on with an unmapped_type, and text fields only with fielddata.
+func (es *InMemoryElasticsearch) sortMappedFields(indexName string, sortFields []sortField) ([]*mappedField, error) {
+	mappedFields := make([]*mappedField, len(sortFields))
+	for position, field := range sortFields {
+		switch field.field {
+		case "_score", "_doc", "_shard_doc":
+			continue
+		}
+
+		mapped := es.mappedField(indexName, field.field)
+		if mapped.unmapped || mapped.fieldType == FieldTypeObject || mapped.fieldType == FieldTypeNested {
+			if field.unmappedType != "" {
+				continue
+			}
+			return nil, newQueryShardError("No mapping found for [%s] in order to sort on", field.field)
+		}
+		if err := mapped.aggregatable(); err != nil {
+			return nil, err
+		}
+		if field.missing != SortMissingLast && field.missing != SortMissingFirst {
+			if _, err := sortTerm(field.missing, field, mapped); err != nil {
+				return nil, newParsingError("Failed to parse the missing value [%v] of the sort on [%s]: %s", field.missing, field.field, err.Error())
+			}
+		}
+		mappedFields[position] = mapped
+	}
+
+	return mappedFields, nil
+}
+
+// parseSearchAfter returns the search_after values as sort values of their fields, so they are compared with
+// the sort values of the hits: dates in their format or as epoch milliseconds, and numbers as numbers.
+func (es *InMemoryElasticsearch) parseSearchAfter(indexName string, searchAfter []interface{}, sortFields []sortField) ([]interface{}, error) {
+	mappedFields, err := es.sortMappedFields(indexName, sortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(searchAfter))
+	for position, value := range searchAfter {
+		values[position], err = sortTerm(value, sortFields[position], mappedFields[position])
+		if err != nil {
+			return nil, newParsingError("Failed to parse search_after value [%v] for the sort on [%s]: %s", value, sortFields[position].field, err.Error())
+		}
+	}
+
+	return values, nil
+}
+
+// sortTerm returns a value given for a sort field, a search_after or a missing value, as a sort value of the
+// field. Null stays null, it is the sort value of hits without the field.
+func sortTerm(value interface{}, field sortField, mapped *mappedField) (interface{}, error) {
+	switch {
+	case value == nil:
+		return nil, nil
+	case field.field == "_score" || field.field == "_doc" || field.field == "_shard_doc":
+		number, isNumber := toFloat(value)
+		if !isNumber {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return number, nil
+	case mapped == nil:
+		return value, nil
+	case mapped.isDateField():
+		// Sort values of dates are epoch milliseconds, whatever the format of the field.
+		if number, isNumber := value.(float64); isNumber {
+			return number, nil
+		}
+	}
+
+	term, err := mapped.indexedValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if term == nil {
+		return nil, fmt.Errorf("the value is longer than ignore_above")
+	}
+
+	return term, nil
+}
+
+// sortValue returns the value a hit is sorted by on a field, picked among the doc values of the field with
+// the sort mode. Dates are sorted by their epoch milliseconds.
+func sortValue(hit searchHit, position int, field sortField, mapped *mappedField) interface{} {
+	switch field.field {
+	case "_score":
+		return hit.score
+	case "_doc", "_shard_doc":
+		return position
+	}
+
+	var values []interface{}
+	if mapped != nil {
+		values = mapped.docValues(hit.document)
+	}
+	if len(values) == 0 {
+		if field.missing != SortMissingLast && field.missing != SortMissingFirst {
+			missing, _ := sortTerm(field.missing, field, mapped)
+			return missing
+		}
+		return nil
+	}
+
+	mode := field.mode
+	if mode == "" {
+		if field.descending {
+			mode = "max"
+		} else {
+			mode = "min"
+		}
+	}
+
+	switch mode {
+	case "sum", "avg", "median":
+		numbers := make([]float64, 0, len(values))
+		for _, value := range values {
+			number, ok := toFloat(value)
+			if ok {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) == 0 {
+			return nil
+		}
+		return aggregateNumbers(numbers, mode)
+	}
+
+	selected := values[0]
+	for _, value := range values[1:] {
+		comparison, comparable := compareValues(value, selected)
+		if comparable && ((mode == "min" && comparison < 0) || (mode == "max" && comparison > 0)) {
+			selected = value
+		}
+	}
+
+	return selected
+}
+
+func aggregateNumbers(numbers []float64, mode string) float64 {
+	sum := 0.0
+	for _, number := range numbers {
+		sum += number
+	}
+
+	switch mode {
+	case "avg":
+		return sum / float64(len(numbers))
+	case "median":
+		sorted := append([]float64(nil), numbers...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	}
+
+	return sum
+}
+
+// compareSortValues compares two sort values in the order of the field, missing values go first or last
+// whatever the order is.
+func compareSortValues(a interface{}, b interface{}, field sortField) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+		missingFirst := field.missing == SortMissingFirst
+		if (a == nil) == missingFirst {
+			return -1
+		}
+		return 1
+	}
+
+	// Sort values of a field have its type, search_after and missing values are converted to it.
+	comparison, _ := compareValues(a, b)
+	if field.descending {
+		return -comparison
+	}
+
+	return comparison
+}
+
+// hitsAfter returns the sorted hits that come after the search_after sort values.
+func hitsAfter(hits []searchHit, searchAfter []interface{}, sortFields []sortField) []searchHit {
+	for position, hit := range hits {
+		for field := range sortFields {
+			comparison := compareSortValues(hit.sortValues[field], searchAfter[field], sortFields[field])
+			if comparison > 0 {
+				return hits[position:]
+			}
+			if comparison < 0 {
+				break
+			}
+		}
+	}
+
+	return hits[:0]
+}
@@ -0,0 +1,253 @@
+package elasticfacker
+
+import (
+	"net/url"
+	"strings"
+)
+
+// sourceFilter is the _source option of a request: whether the source is returned and the fields it is
+// filtered to. Fields are paths with wildcards, a path takes the whole object under it, and excludes are
+// applied after includes.
+type sourceFilter struct {
+	disabled bool
+	includes []string
+	excludes []string
+}
+
+// parseSourceFilter reads a _source option, a boolean, a path, an array of paths or an object with the
+// includes and excludes paths. Without the option the source is returned whole.
+func parseSourceFilter(value interface{}) (*sourceFilter, error) {
+	switch typedValue := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &sourceFilter{disabled: !typedValue}, nil
+	case string:
+		return &sourceFilter{includes: []string{typedValue}}, nil
+	case []interface{}:
+		return &sourceFilter{includes: stringList(typedValue)}, nil
+	case map[string]interface{}:
+		filter := &sourceFilter{}
+		for key, paths := range typedValue {
+			switch key {
+			case "includes", "include":
+				filter.includes = stringList(paths)
+			case "excludes", "exclude":
+				filter.excludes = stringList(paths)
+			default:
+				return nil, newParsingError("Expected one of [includes, excludes] but found [%s]", key)
+			}
+		}
+		return filter, nil
+	}
+
+	return nil, newParsingError("Expected one of [BOOLEAN, VALUE_STRING, START_ARRAY, START_OBJECT] but found [%T] for [_source]", value)
+}
+
+// sourceParams reads the _source, _source_includes and _source_excludes query string params into the
+// _source option they stand for, the first one a boolean or a comma separated list of paths.
+func sourceParams(params url.Values) interface{} {
+	if !params.Has("_source") && !params.Has("_source_includes") && !params.Has("_source_excludes") {
+		return nil
+	}
+
+	source := params.Get("_source")
+	enabled, isBool := toBool(source)
+	if isBool && !params.Has("_source_includes") && !params.Has("_source_excludes") {
+		return enabled
+	}
+
+	option := map[string]interface{}{}
+	if source != "" && !isBool {
+		option["includes"] = strings.Split(source, ",")
+	}
+	if includes := params.Get("_source_includes"); includes != "" {
+		option["includes"] = strings.Split(includes, ",")
+	}
+	if excludes := params.Get("_source_excludes"); excludes != "" {
+		option["excludes"] = strings.Split(excludes, ",")
+	}
+
+	return option
+}
+
+// apply returns the filtered source, none when the source is disabled. The source is never changed, the
+// objects kept are copies.
+func (filter *sourceFilter) apply(source map[string]interface{}) map[string]interface{} {
+	if filter == nil || (len(filter.includes) == 0 && len(filter.excludes) == 0 && !filter.disabled) {
+		return source
+	}
+	if filter.disabled {
+		return nil
+	}
+
+	return filter.filterObject(source, "", len(filter.includes) == 0)
+}
+
+// filterObject keeps the fields of an object under the path that are included and not excluded. Once a
+// path is included every field under it is, but excludes still apply to them.
+func (filter *sourceFilter) filterObject(object map[string]interface{}, path string, included bool) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for key, value := range object {
+		fieldPath := joinFieldPath(path, key)
+		if filter.excluded(fieldPath) {
+			continue
+		}
+
+		fieldIncluded := included || filter.included(fieldPath)
+		if !fieldIncluded && !filter.includesUnder(fieldPath) {
+			continue
+		}
+
+		filteredValue, keep := filter.filterValue(value, fieldPath, fieldIncluded)
+		if keep {
+			filtered[key] = filteredValue
+		}
+	}
+
+	return filtered
+}
+
+func (filter *sourceFilter) filterValue(value interface{}, path string, included bool) (interface{}, bool) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		child := filter.filterObject(typedValue, path, included)
+		return child, included || len(child) > 0
+	case []interface{}:
+		items := make([]interface{}, 0, len(typedValue))
+		for _, item := range typedValue {
+			if filteredItem, keep := filter.filterValue(item, path, included); keep {
+				items = append(items, filteredItem)
+			}
+		}
+		return items, included || len(items) > 0
+	}
+
+	return value, included
+}
+
+func (filter *sourceFilter) included(path string) bool {
+	for _, pattern := range filter.includes {
+		if matchesWildcard([]rune(pattern), []rune(path)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// includesUnder reports whether an include path may take a field under the path.
+func (filter *sourceFilter) includesUnder(path string) bool {
+	for _, pattern := range filter.includes {
+		if matchesWildcardPrefix([]rune(pattern), []rune(path+".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (filter *sourceFilter) excluded(path string) bool {
+	for _, pattern := range filter.excludes {
+		if matchesWildcard([]rune(pattern), []rune(path)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesWildcardPrefix reports whether the wildcard pattern matches a value starting with the prefix.
+func matchesWildcardPrefix(pattern []rune, prefix []rune) bool {
+	for position, character := range prefix {
+		if position >= len(pattern) {
+			return false
+		}
+		if pattern[position] == '*' {
+			return true
+		}
+		if pattern[position] != '?' && pattern[position] != character {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hitFormat shapes the documents of the hits of a search the way the request asks: the source filtered or
+// left out, the values of the fields and docvalue_fields options, and with stored_fields _none_ no metadata
+// but the index.
+type hitFormat struct {
+	es             *InMemoryElasticsearch
+	source         *sourceFilter
+	fields         []fieldOption
+	docvalueFields []fieldOption
+	noMetadata     bool
+}
+
+// parseHitFormat reads the _source, fields, docvalue_fields and stored_fields options of a search or of a
+// top_hits aggregation. Without any of them the hits are returned whole and there is no format.
+func (es *InMemoryElasticsearch) parseHitFormat(indexName string, options map[string]interface{}) (*hitFormat, error) {
+	if options["_source"] == nil && options["fields"] == nil && options["docvalue_fields"] == nil && options["stored_fields"] == nil {
+		return nil, nil
+	}
+
+	source, err := parseSourceFilter(options["_source"])
+	if err != nil {
+		return nil, err
+	}
+	format := &hitFormat{es: es, source: source}
+
+	format.fields, err = es.parseFieldOptions(indexName, "fields", options["fields"])
+	if err != nil {
+		return nil, err
+	}
+	format.docvalueFields, err = es.parseFieldOptions(indexName, "docvalue_fields", options["docvalue_fields"])
+	if err != nil {
+		return nil, err
+	}
+
+	if options["stored_fields"] != nil {
+		storedFields := stringList(options["stored_fields"])
+		if containsString(storedFields, "_none_") {
+			switch {
+			case len(storedFields) > 1:
+				return nil, newAnalysisError("cannot combine _none_ with other fields")
+			case source != nil && !source.disabled:
+				return nil, newAnalysisError("[stored_fields] cannot be disabled if [_source] is requested")
+			case len(format.fields) > 0:
+				return nil, newAnalysisError("[stored_fields] cannot be disabled when using the [fields] option")
+			}
+			format.noMetadata = true
+		}
+		// No field is stored apart from the source, so asking for stored fields only leaves the source out.
+		if format.source == nil {
+			format.source = &sourceFilter{disabled: true}
+		}
+	}
+
+	return format, nil
+}
+
+// apply shapes the documents of a page of hits. Fields are read from the whole source, before it is filtered.
+func (format *hitFormat) apply(documents []Document) {
+	for position := range documents {
+		document := &documents[position]
+
+		fields := make(map[string][]interface{})
+		for _, option := range format.fields {
+			format.es.fetchFields(*document, option, false, fields)
+		}
+		for _, option := range format.docvalueFields {
+			format.es.fetchFields(*document, option, true, fields)
+		}
+		if len(fields) > 0 {
+			document.Fields = fields
+		}
+
+		document.Source = format.source.apply(document.Source)
+		if format.noMetadata {
+			document.Id = ""
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package elasticfacker
+
+import (
+	"sort"
+	"strings"
+)
+
+const DefaultOtherBucketKey = "_other_"
+
+func (parser *aggregationParser) parseFilter(body interface{}, subAggregations aggregations) (aggregation, error) {
+	query, err := parser.es.parseSearchQuery(parser.indexName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filterAggregation{
+		query:           query,
+		subAggregations: subAggregations,
+	}, nil
+}
+
+type filterAggregation struct {
+	query           searchQuery
+	subAggregations aggregations
+}
+
+func (agg *filterAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	return agg.subAggregations.bucket(nil, filterHits(hits, agg.query))
+}
+
+func filterHits(hits []searchHit, query searchQuery) []searchHit {
+	filtered := make([]searchHit, 0)
+	for _, hit := range hits {
+		matched, _ := query.evaluate(hit.document)
+		if matched {
+			filtered = append(filtered, hit)
+		}
+	}
+
+	return filtered
+}
+
+type namedFilter struct {
+	key   string
+	query searchQuery
+}
+
+func (parser *aggregationParser) parseFilters(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	agg := &filtersAggregation{subAggregations: subAggregations}
+
+	switch typedFilters := options["filters"].(type) {
+	case map[string]interface{}:
+		agg.keyed = true
+		for key, filter := range typedFilters {
+			query, err := parser.es.parseSearchQuery(parser.indexName, filter)
+			if err != nil {
+				return nil, err
+			}
+			agg.filters = append(agg.filters, namedFilter{key: key, query: query})
+		}
+		// Keyed buckets are returned in the order of their keys.
+		sort.Slice(agg.filters, func(i, j int) bool {
+			return agg.filters[i].key < agg.filters[j].key
+		})
+	case []interface{}:
+		for _, filter := range typedFilters {
+			query, err := parser.es.parseSearchQuery(parser.indexName, filter)
+			if err != nil {
+				return nil, err
+			}
+			agg.filters = append(agg.filters, namedFilter{query: query})
+		}
+	default:
+		return nil, newParsingError("[filters] cannot be empty.")
+	}
+
+	if keyed, isBool := options["keyed"].(bool); isBool {
+		agg.keyed = keyed && agg.filters[0].key != ""
+	}
+
+	agg.otherBucket, _ = options["other_bucket"].(bool)
+	agg.otherBucketKey, _ = options["other_bucket_key"].(string)
+	if agg.otherBucketKey != "" {
+		agg.otherBucket = true
+	} else {
+		agg.otherBucketKey = DefaultOtherBucketKey
+	}
+
+	return agg, nil
+}
+
+type filtersAggregation struct {
+	filters         []namedFilter
+	keyed           bool
+	otherBucket     bool
+	otherBucketKey  string
+	subAggregations aggregations
+}
+
+func (agg *filtersAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(agg.filters)+1)
+	buckets := make([]map[string]interface{}, 0, len(agg.filters)+1)
+	matchedAny := make([]bool, len(hits))
+	for _, filter := range agg.filters {
+		matchingHits := make([]searchHit, 0)
+		for position, hit := range hits {
+			matched, _ := filter.query.evaluate(hit.document)
+			if matched {
+				matchingHits = append(matchingHits, hit)
+				matchedAny[position] = true
+			}
+		}
+
+		bucket, err := agg.subAggregations.bucket(nil, matchingHits)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, filter.key)
+		buckets = append(buckets, bucket)
+	}
+
+	if agg.otherBucket {
+		otherHits := make([]searchHit, 0)
+		for position, hit := range hits {
+			if !matchedAny[position] {
+				otherHits = append(otherHits, hit)
+			}
+		}
+
+		bucket, err := agg.subAggregations.bucket(nil, otherHits)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, agg.otherBucketKey)
+		buckets = append(buckets, bucket)
+	}
+
+	if !agg.keyed {
+		for position, key := range keys {
+			if key != "" {
+				buckets[position]["key"] = key
+			}
+		}
+		return map[string]interface{}{"buckets": buckets}, nil
+	}
+
+	keyedBuckets := make(map[string]interface{}, len(buckets))
+	for position, key := range keys {
+		keyedBuckets[key] = buckets[position]
+	}
+
+	return map[string]interface{}{"buckets": keyedBuckets}, nil
+}
+
+// globalAggregation runs over every document of the index, whatever the query of the search is.
+type globalAggregation struct {
+	documents       []Document
+	subAggregations aggregations
+}
+
+func (agg *globalAggregation) aggregate(_ []searchHit) (map[string]interface{}, error) {
+	hits := make([]searchHit, 0, len(agg.documents))
+	for _, document := range agg.documents {
+		hits = append(hits, searchHit{document: document, score: 1})
+	}
+
+	return agg.subAggregations.bucket(nil, hits)
+}
+
+func (parser *aggregationParser) parseMissing(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	field, err := parser.aggregatedField("missing", options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &missingAggregation{
+		field:           field,
+		subAggregations: subAggregations,
+	}, nil
+}
+
+type missingAggregation struct {
+	field           *mappedField
+	subAggregations aggregations
+}
+
+func (agg *missingAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	missingHits := make([]searchHit, 0)
+	for _, hit := range hits {
+		if len(agg.field.docValues(hit.document)) == 0 {
+			missingHits = append(missingHits, hit)
+		}
+	}
+
+	return agg.subAggregations.bucket(nil, missingHits)
+}
+
+func (parser *aggregationParser) parseNested(name string, options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	path, _ := options["path"].(string)
+	if path == "" {
+		return nil, newParsingError("[nested] requires 'path' field in [%s]", name)
+	}
+
+	return &nestedAggregation{
+		path:            path,
+		subAggregations: subAggregations,
+	}, nil
+}
+
+// nestedAggregation runs its sub-aggregations over the objects at the path of every hit, as if each one was
+// a document of its own, the same way nested documents are aggregated.
+type nestedAggregation struct {
+	path            string
+	subAggregations aggregations
+}
+
+func (agg *nestedAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	path := strings.Split(agg.path, ".")
+
+	nestedHits := make([]searchHit, 0)
+	for position := range hits {
+		parent := &hits[position]
+
+		objects := make([]map[string]interface{}, 0)
+		collectNestedObjects(parent.document.Source, path, &objects)
+		for _, object := range objects {
+			document := parent.document
+			document.Source = nestedSource(path, object)
+			nestedHits = append(nestedHits, searchHit{
+				document:   document,
+				score:      parent.score,
+				nestedPath: agg.path,
+				parent:     parent,
+			})
+		}
+	}
+
+	return agg.subAggregations.bucket(nil, nestedHits)
+}
+
+func collectNestedObjects(value interface{}, path []string, objects *[]map[string]interface{}) {
+	switch typedValue := value.(type) {
+	case []interface{}:
+		for _, item := range typedValue {
+			collectNestedObjects(item, path, objects)
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			*objects = append(*objects, typedValue)
+			return
+		}
+
+		for length := len(path); length > 0; length-- {
+			child, exists := typedValue[strings.Join(path[:length], ".")]
+			if exists {
+				collectNestedObjects(child, path[length:], objects)
+			}
+		}
+	}
+}
+
+// nestedSource wraps a nested object in its path, so the fields of the nested hit keep their full names.
+func nestedSource(path []string, object map[string]interface{}) map[string]interface{} {
+	source := object
+	for position := len(path) - 1; position >= 0; position-- {
+		source = map[string]interface{}{path[position]: source}
+	}
+
+	return source
+}
+
+func (parser *aggregationParser) parseReverseNested(options map[string]interface{}, subAggregations aggregations) (aggregation, error) {
+	path, _ := options["path"].(string)
+	if parser.depth == 0 {
+		return nil, newAggregationError("Reverse nested aggregation can only be used inside a [nested] aggregation")
+	}
+
+	return &reverseNestedAggregation{
+		path:            path,
+		subAggregations: subAggregations,
+	}, nil
+}
+
+// reverseNestedAggregation goes back from nested hits to the hits holding them, the root documents or
+// the nested objects at the path.
+type reverseNestedAggregation struct {
+	path            string
+	subAggregations aggregations
+}
+
+func (agg *reverseNestedAggregation) aggregate(hits []searchHit) (map[string]interface{}, error) {
+	seen := make(map[*searchHit]bool)
+	parentHits := make([]searchHit, 0)
+	for _, hit := range hits {
+		parent := hit.parent
+		for parent != nil && parent.nestedPath != agg.path && parent.parent != nil {
+			parent = parent.parent
+		}
+		if parent == nil || parent.nestedPath != agg.path || seen[parent] {
+			continue
+		}
+
+		seen[parent] = true
+		parentHits = append(parentHits, *parent)
+	}
+
+	return agg.subAggregations.bucket(nil, parentHits)
+}
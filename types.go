@@ -1,6 +1,10 @@
 package elasticfacker
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
 
 type MockMethods struct {
 	StatusCode   int
@@ -10,9 +14,18 @@ type MockMethods struct {
 type InMemoryElasticsearch struct {
 	indicesAlias     map[string]map[string]interface{}
 	indicesDocuments map[string][]Document
+	indicesSeqNo     map[string]int64
+	indicesMappings  map[string]*fieldMapping
+	indicesSettings  map[string]map[string]interface{}
+	indicesAnalysis  map[string]*indexAnalysis
+	indicesInverted  map[string]*invertedIndex
 	aliases          map[string]interface{}
+	pointsInTime     map[string]*searchContext
+	scrollContexts   map[string]*scrollContext
+	storedScripts    map[string]*storedScript
 	mock             *MockMethods
 	server           *http.Server
+	mutex            sync.Mutex
 }
 
 type IndexFake struct {
@@ -27,15 +40,111 @@ type ProductIndexFake struct {
 
 type IndexMapFake map[string]ProductIndexFake
 
+type ElasticSearchCreateIndexRequestFake struct {
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Aliases  map[string]interface{} `json:"aliases,omitempty"`
+}
+
+type ElasticSearchCreateIndexResponseFake struct {
+	Acknowledged       bool   `json:"acknowledged"`
+	ShardsAcknowledged bool   `json:"shards_acknowledged"`
+	Index              string `json:"index"`
+}
+
+type ElasticSearchAcknowledgedResponseFake struct {
+	Acknowledged bool `json:"acknowledged"`
+}
+
+type ElasticSearchMappingsFake struct {
+	Mappings map[string]interface{} `json:"mappings"`
+}
+
+type ElasticSearchFieldMappingFake struct {
+	FullName string                 `json:"full_name"`
+	Mapping  map[string]interface{} `json:"mapping"`
+}
+
+type ElasticSearchAnalyzeRequestFake struct {
+	Analyzer   string        `json:"analyzer,omitempty"`
+	Text       interface{}   `json:"text"`
+	Tokenizer  interface{}   `json:"tokenizer,omitempty"`
+	Filter     []interface{} `json:"filter,omitempty"`
+	CharFilter []interface{} `json:"char_filter,omitempty"`
+	Field      string        `json:"field,omitempty"`
+}
+
+type ElasticSearchAnalyzeResponseFake struct {
+	Tokens []ElasticSearchAnalyzeTokenFake `json:"tokens"`
+}
+
+type ElasticSearchAnalyzeTokenFake struct {
+	Token       string `json:"token"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Type        string `json:"type"`
+	Position    int    `json:"position"`
+}
+
 type ElasticSearchRequest struct {
 	Id     string                     `json:"id"`
 	Params ElasticSearchRequestParams `json:"params"`
 }
 
+type ElasticSearchStoredScriptRequestFake struct {
+	Script *ElasticSearchStoredScriptFake `json:"script"`
+}
+
+type ElasticSearchStoredScriptFake struct {
+	Lang    string            `json:"lang"`
+	Source  interface{}       `json:"source"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type ElasticSearchRenderTemplateResponseFake struct {
+	TemplateOutput json.RawMessage `json:"template_output"`
+}
+
+type ElasticSearchGetScriptResponseFake struct {
+	Id     string                         `json:"_id"`
+	Found  bool                           `json:"found"`
+	Script *ElasticSearchStoredScriptFake `json:"script,omitempty"`
+}
+
 type ElasticSearchRequestScriptQuery struct {
-	Size   string      `json:"size"`
-	Source []string    `json:"_source,omitempty"`
-	Query  interface{} `json:"query"`
+	Size           string                        `json:"size"`
+	From           string                        `json:"from,omitempty"`
+	TrackTotalHits interface{}                   `json:"track_total_hits,omitempty"`
+	Sort           interface{}                   `json:"sort,omitempty"`
+	TrackScores    bool                          `json:"track_scores,omitempty"`
+	SearchAfter    []interface{}                 `json:"search_after,omitempty"`
+	Pit            *ElasticSearchPointInTimeFake `json:"pit,omitempty"`
+	Source         interface{}                   `json:"_source,omitempty"`
+	Fields         []interface{}                 `json:"fields,omitempty"`
+	DocvalueFields []interface{}                 `json:"docvalue_fields,omitempty"`
+	StoredFields   interface{}                   `json:"stored_fields,omitempty"`
+	Query          interface{}                   `json:"query"`
+	Aggregations   map[string]interface{}        `json:"aggregations,omitempty"`
+	Highlight      map[string]interface{}        `json:"highlight,omitempty"`
+}
+
+type ElasticSearchPointInTimeFake struct {
+	Id        string `json:"id"`
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+type ElasticSearchScrollRequestFake struct {
+	Scroll   string `json:"scroll,omitempty"`
+	ScrollId string `json:"scroll_id"`
+}
+
+type ElasticSearchClearScrollRequestFake struct {
+	ScrollId interface{} `json:"scroll_id"`
+}
+
+type ElasticSearchClearContextResponseFake struct {
+	Succeeded bool `json:"succeeded"`
+	NumFreed  int  `json:"num_freed"`
 }
 
 type ElasticSearchRequestParams struct {
@@ -44,16 +153,111 @@ type ElasticSearchRequestParams struct {
 }
 
 type Document struct {
-	Index  string                 `json:"_index"`
-	Id     string                 `json:"_id"`
-	Score  string                 `json:"_score"`
-	Source map[string]interface{} `json:"_source"`
+	Index       string                   `json:"_index"`
+	Id          string                   `json:"_id,omitempty"`
+	Score       *float64                 `json:"_score"`
+	Source      map[string]interface{}   `json:"_source,omitempty"`
+	Ignored     []string                 `json:"_ignored,omitempty"`
+	Sort        []interface{}            `json:"sort,omitempty"`
+	Fields      map[string][]interface{} `json:"fields,omitempty"`
+	Highlight   map[string][]string      `json:"highlight,omitempty"`
+	Version     int64                    `json:"-"`
+	SeqNo       int64                    `json:"-"`
+	PrimaryTerm int64                    `json:"-"`
+}
+
+type ElasticSearchDocumentResponseFake struct {
+	Index       string                          `json:"_index"`
+	Id          string                          `json:"_id"`
+	Version     int64                           `json:"_version"`
+	Result      string                          `json:"result"`
+	Shards      ElasticSearchResponseFakeShards `json:"_shards"`
+	SeqNo       int64                           `json:"_seq_no"`
+	PrimaryTerm int64                           `json:"_primary_term"`
+}
+
+type ElasticSearchGetResponseFake struct {
+	Index       string                  `json:"_index"`
+	Id          string                  `json:"_id"`
+	Version     int64                   `json:"_version,omitempty"`
+	SeqNo       *int64                  `json:"_seq_no,omitempty"`
+	PrimaryTerm int64                   `json:"_primary_term,omitempty"`
+	Found       bool                    `json:"found"`
+	Source      map[string]interface{}  `json:"_source,omitempty"`
+	Error       *ElasticSearchErrorFake `json:"error,omitempty"`
+}
+
+type ElasticSearchMultiGetResponseFake struct {
+	Docs []json.RawMessage `json:"docs"`
+}
+
+type ElasticSearchMultiGetFailureFake struct {
+	Index string                 `json:"_index"`
+	Id    string                 `json:"_id"`
+	Error ElasticSearchErrorFake `json:"error"`
+}
+
+type ElasticSearchUpdateRequestFake struct {
+	Doc         map[string]interface{} `json:"doc,omitempty"`
+	Upsert      map[string]interface{} `json:"upsert,omitempty"`
+	DocAsUpsert bool                   `json:"doc_as_upsert,omitempty"`
+	DetectNoop  *bool                  `json:"detect_noop,omitempty"`
+}
+
+type ElasticSearchBulkActionFake struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+}
+
+type ElasticSearchBulkResponseFake struct {
+	Took   int                                            `json:"took"`
+	Errors bool                                           `json:"errors"`
+	Items  []map[string]ElasticSearchBulkResponseItemFake `json:"items"`
+}
+
+type ElasticSearchBulkResponseItemFake struct {
+	Index       string                           `json:"_index"`
+	Id          *string                          `json:"_id"`
+	Version     int64                            `json:"_version,omitempty"`
+	Result      string                           `json:"result,omitempty"`
+	Shards      *ElasticSearchResponseFakeShards `json:"_shards,omitempty"`
+	SeqNo       *int64                           `json:"_seq_no,omitempty"`
+	PrimaryTerm int64                            `json:"_primary_term,omitempty"`
+	Status      int                              `json:"status"`
+	Error       *ElasticSearchErrorCauseFake     `json:"error,omitempty"`
+}
+
+type ElasticSearchErrorResponseFake struct {
+	Error  ElasticSearchErrorFake `json:"error"`
+	Status int                    `json:"status"`
+}
+
+type ElasticSearchErrorFake struct {
+	RootCause []ElasticSearchErrorCauseFake `json:"root_cause"`
+	Type      string                        `json:"type"`
+	Reason    string                        `json:"reason"`
+	Index     string                        `json:"index,omitempty"`
+}
+
+type ElasticSearchErrorCauseFake struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index,omitempty"`
 }
 
 type ElasticSearchResponseFake struct {
-	Took   int                             `json:"took"`
-	Shards ElasticSearchResponseFakeShards `json:"_shards"`
-	Hits   ElasticSearchResponseFakeHits   `json:"hits"`
+	Took         int                             `json:"took"`
+	TimedOut     bool                            `json:"timed_out"`
+	Shards       ElasticSearchResponseFakeShards `json:"_shards"`
+	Hits         ElasticSearchResponseFakeHits   `json:"hits"`
+	PitId        string                          `json:"pit_id,omitempty"`
+	ScrollId     string                          `json:"_scroll_id,omitempty"`
+	Aggregations map[string]interface{}          `json:"aggregations,omitempty"`
+}
+
+type ElasticSearchMultiSearchResponseFake struct {
+	Took      int               `json:"took"`
+	Responses []json.RawMessage `json:"responses"`
 }
 
 type ElasticSearchCountResponseFake struct {
@@ -69,8 +273,9 @@ type ElasticSearchResponseFakeShards struct {
 }
 
 type ElasticSearchResponseFakeHits struct {
-	Total ElasticSearchResponseFakeHitsTotal `json:"total"`
-	Hits  []Document                         `json:"hits"`
+	Total    *ElasticSearchResponseFakeHitsTotal `json:"total,omitempty"`
+	MaxScore *float64                            `json:"max_score"`
+	Hits     []Document                          `json:"hits"`
 }
 
 type ElasticSearchResponseFakeHitsTotal struct {